@@ -0,0 +1,237 @@
+package picard
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/skuid/picard/query"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/stringutil"
+	"github.com/skuid/picard/tags"
+)
+
+var aggregateAliasPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Aggregate describes one aggregate column of an AggregateRequest. Func is one of COUNT, SUM, AVG, MIN or
+// MAX, and Field is the name of a field on the filter model. Field may be left empty for COUNT to count rows.
+// Alias is the key the value is returned under.
+type Aggregate struct {
+	Func  string
+	Field string
+	Alias string
+}
+
+// Having compares an aggregate with Value in the HAVING clause of an AggregateRequest. The aggregate is either
+// one of the request's Aggregates named by Alias, or the aggregate described by Func and Field. Operator is
+// one of =, <>, <, <=, > or >=.
+type Having struct {
+	Alias    string
+	Func     string
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+/*
+AggregateRequest holds information about a request to aggregate the rows of a model. FilterModel and
+FieldFilters select rows the same way they do in a FilterRequest. The results are grouped by the GroupBy fields,
+and groups that don't match every Having comparison are left out.
+
+Example:
+
+	// Number of children and their total allowance per parent
+	results, err := p.AggregateModel(picard.AggregateRequest{
+		FilterModel: ChildModel{},
+		GroupBy:     []string{"ParentID"},
+		Aggregates: []picard.Aggregate{
+			{Func: "COUNT", Alias: "children"},
+			{Func: "SUM", Field: "Allowance", Alias: "total"},
+		},
+		Having: []picard.Having{
+			{Alias: "children", Operator: ">", Value: 5},
+		},
+	})
+
+	// SELECT t0.parent_id AS "ParentID", COUNT(*) AS "children", SUM(t0.allowance) AS "total"
+	// FROM childmodel AS t0 WHERE t0.organization_id = $1 GROUP BY t0.parent_id HAVING COUNT(*) > $2
+*/
+type AggregateRequest struct {
+	FilterModel    interface{}
+	FieldFilters   tags.Filterable
+	GroupBy        []string
+	Aggregates     []Aggregate
+	Having         []Having
+	Runner         sq.BaseRunner
+	IncludeDeleted bool
+	// Consistency decides whether a request without a Runner may read from the replica, like it does for FilterModel
+	Consistency ReadConsistency
+}
+
+// ChildAggregate selects an aggregate over each model's children into Into, a field of the filter model tagged
+// with aggregate. Aggregate describes the aggregate the same way it does when filtering, and models are only
+// filtered by it when its FilterOperator is set.
+type ChildAggregate struct {
+	Into      string
+	Aggregate tags.ChildAggregateFilter
+}
+
+// addChildAggregates selects each child aggregate into its field, and filters by the ones with an operator
+func addChildAggregates(builder sq.SelectBuilder, childAggregates []ChildAggregate, filterMetadata *tags.TableMetadata, tbl *qp.Table) (sq.SelectBuilder, error) {
+	for _, childAggregate := range childAggregates {
+		if !hasAggregateField(filterMetadata, childAggregate.Into) {
+			return builder, fmt.Errorf("field '%s' on table '%s' must be tagged with aggregate to hold a child aggregate", childAggregate.Into, filterMetadata.GetTableName())
+		}
+		subquery, err := childAggregate.Aggregate.Subquery(tbl, filterMetadata)
+		if err != nil {
+			return builder, err
+		}
+		tbl.AddComputedColumn(childAggregate.Into)
+		builder = builder.Column(sq.Alias(subquery, tbl.ComputedColumn(childAggregate.Into)))
+		if childAggregate.Aggregate.FilterOperator != "" {
+			builder = builder.Where(childAggregate.Aggregate.Apply(tbl, filterMetadata))
+		}
+	}
+	return builder, nil
+}
+
+func hasAggregateField(filterMetadata *tags.TableMetadata, fieldName string) bool {
+	for _, field := range filterMetadata.GetAggregateFields() {
+		if field.GetName() == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateExpression returns the SQL expression for an aggregate function applied to a field
+func aggregateExpression(fn string, fieldName string, filterMetadata *tags.TableMetadata, tableAlias string) (string, error) {
+	upperFn := strings.ToUpper(fn)
+	if !tags.IsAggregateFunction(upperFn) {
+		return "", fmt.Errorf("unsupported aggregate function '%s'", fn)
+	}
+
+	argument := "*"
+	if fieldName != "" {
+		columnName := filterMetadata.GetField(fieldName).GetColumnName()
+		if columnName == "" {
+			return "", fmt.Errorf("aggregate field '%s' is not a column on the filter model", fieldName)
+		}
+		argument = tableAlias + "." + columnName
+	} else if upperFn != "COUNT" {
+		return "", fmt.Errorf("aggregate function '%s' requires a field", upperFn)
+	}
+
+	return fmt.Sprintf("%s(%s)", upperFn, argument), nil
+}
+
+// aggregateColumn returns the select expression for an aggregate
+func aggregateColumn(aggregate Aggregate, filterMetadata *tags.TableMetadata, tableAlias string) (string, error) {
+	expression, err := aggregateExpression(aggregate.Func, aggregate.Field, filterMetadata, tableAlias)
+	if err != nil {
+		return "", err
+	}
+	if !aggregateAliasPattern.MatchString(aggregate.Alias) {
+		return "", fmt.Errorf("invalid alias '%s' for aggregate", aggregate.Alias)
+	}
+	return fmt.Sprintf(`%s AS "%s"`, expression, aggregate.Alias), nil
+}
+
+// havingCondition returns the HAVING condition for a comparison. Postgres does not allow output aliases in
+// HAVING, so comparisons against an alias repeat the aggregate expression.
+func havingCondition(having Having, aggregates []Aggregate, filterMetadata *tags.TableMetadata, tableAlias string) (sq.Sqlizer, error) {
+	if !tags.IsComparisonOperator(having.Operator) {
+		return nil, fmt.Errorf("unsupported Having operator '%s'", having.Operator)
+	}
+
+	fn, fieldName := having.Func, having.Field
+	if having.Alias != "" {
+		found := false
+		for _, aggregate := range aggregates {
+			if aggregate.Alias == having.Alias {
+				fn, fieldName = aggregate.Func, aggregate.Field
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Having alias '%s' does not match an aggregate", having.Alias)
+		}
+	}
+
+	expression, err := aggregateExpression(fn, fieldName, filterMetadata, tableAlias)
+	if err != nil {
+		return nil, err
+	}
+	return sq.Expr(fmt.Sprintf("%s %s ?", expression, having.Operator), having.Value), nil
+}
+
+// AggregateModel returns one row per distinct combination of the GroupBy fields, holding the GroupBy
+// values keyed by field name and the aggregate values keyed by alias.
+func (p PersistenceORM) AggregateModel(request AggregateRequest) ([]map[string]interface{}, error) {
+	if len(request.Aggregates) == 0 {
+		return nil, errors.New("at least one aggregate is required")
+	}
+	if request.Runner == nil {
+		request.Runner = p.readRunner(request.Consistency)
+	}
+
+	modelVal, err := stringutil.GetStructValue(request.FilterModel)
+	if err != nil {
+		return nil, err
+	}
+	filterMetadata := tags.TableMetadataFromType(modelVal.Type())
+
+	tbl, err := query.Build(p.readMultitenancyValue(), modelVal.Interface(), request.FieldFilters, nil, nil, filterMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{}
+	groupBys := []string{}
+	for _, fieldName := range request.GroupBy {
+		columnName := filterMetadata.GetField(fieldName).GetColumnName()
+		if columnName == "" {
+			return nil, fmt.Errorf("GroupBy field '%s' is not a column on the filter model", fieldName)
+		}
+		groupBy := tbl.Alias + "." + columnName
+		columns = append(columns, fmt.Sprintf(`%s AS "%s"`, groupBy, fieldName))
+		groupBys = append(groupBys, groupBy)
+	}
+	for _, aggregate := range request.Aggregates {
+		column, err := aggregateColumn(aggregate, filterMetadata, tbl.Alias)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+
+	sql := sq.Select(columns...).
+		PlaceholderFormat(sq.Dollar).
+		From(fmt.Sprintf("%s AS %s", tbl.Name, tbl.Alias))
+	if tbl.MultiTenancy != nil {
+		sql = sql.Where(tbl.MultiTenancyWhere())
+	}
+	for _, where := range tbl.Wheres {
+		sql = sql.Where(where)
+	}
+	sql = addSoftDeleteFilter(sql, request.IncludeDeleted, filterMetadata, tbl.Alias)
+	if len(groupBys) > 0 {
+		sql = sql.GroupBy(groupBys...)
+	}
+	for _, having := range request.Having {
+		condition, err := havingCondition(having, request.Aggregates, filterMetadata, tbl.Alias)
+		if err != nil {
+			return nil, err
+		}
+		sql = sql.Having(condition)
+	}
+
+	rows, err := sql.RunWith(request.Runner).Query()
+	if err != nil {
+		return nil, newReadQueryError(err, sql)
+	}
+	return getQueryResults(rows)
+}
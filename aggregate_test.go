@@ -0,0 +1,434 @@
+package picard
+
+import (
+	"fmt"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type lineItemModel struct {
+	metadata.Metadata `picard:"tablename=line_item"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	OrderID        string `picard:"column=order_id"`
+	Status         string `picard:"column=status"`
+	Amount         int    `picard:"column=amount"`
+}
+
+func TestAggregateModel(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description         string
+		giveRequest         AggregateRequest
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []map[string]interface{}
+		wantErr             string
+	}{
+		{
+			"counts and sums per group",
+			AggregateRequest{
+				FilterModel: lineItemModel{
+					Status: "open",
+				},
+				GroupBy: []string{"OrderID"},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: "items"},
+					{Func: "sum", Field: "Amount", Alias: "total"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.order_id AS "OrderID",
+						COUNT(*) AS "items",
+						SUM(t0.amount) AS "total"
+					FROM line_item AS t0
+					WHERE t0.organization_id = $1 AND t0.status = $2
+					GROUP BY t0.order_id
+				`)).
+					WithArgs(orgID, "open").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"OrderID", "items", "total"}).
+							AddRow("00000000-0000-0000-0000-000000000002", 2, 30).
+							AddRow("00000000-0000-0000-0000-000000000003", 1, 5),
+					)
+			},
+			[]map[string]interface{}{
+				{"OrderID": "00000000-0000-0000-0000-000000000002", "items": 2, "total": 30},
+				{"OrderID": "00000000-0000-0000-0000-000000000003", "items": 1, "total": 5},
+			},
+			"",
+		},
+		{
+			"aggregates every row without a group by",
+			AggregateRequest{
+				FilterModel: &lineItemModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Status",
+					FilterValue: "closed",
+				},
+				Aggregates: []Aggregate{
+					{Func: "MAX", Field: "Amount", Alias: "largest"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT MAX(t0.amount) AS "largest"
+					FROM line_item AS t0
+					WHERE t0.organization_id = $1 AND t0.status = $2
+				`)).
+					WithArgs(orgID, "closed").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"largest"}).AddRow(12),
+					)
+			},
+			[]map[string]interface{}{
+				{"largest": 12},
+			},
+			"",
+		},
+		{
+			"leaves out soft-deleted rows",
+			AggregateRequest{
+				FilterModel: softDeleteModel{},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: "rows"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT COUNT(*) AS "rows"
+					FROM test_tablename AS t0
+					WHERE t0.multitenancy_key_column = $1 AND t0.deleted_at IS NULL
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"rows"}).AddRow(3),
+					)
+			},
+			[]map[string]interface{}{
+				{"rows": 3},
+			},
+			"",
+		},
+		{
+			"filters groups with having after the where clause",
+			AggregateRequest{
+				FilterModel: lineItemModel{
+					Status: "open",
+				},
+				GroupBy: []string{"OrderID"},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: "items"},
+				},
+				Having: []Having{
+					{Alias: "items", Operator: ">", Value: 5},
+					{Func: "SUM", Field: "Amount", Operator: "<=", Value: 100},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.order_id AS "OrderID",
+						COUNT(*) AS "items"
+					FROM line_item AS t0
+					WHERE t0.organization_id = $1 AND t0.status = $2
+					GROUP BY t0.order_id
+					HAVING COUNT(*) > $3 AND SUM(t0.amount) <= $4
+				`)).
+					WithArgs(orgID, "open", 5, 100).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"OrderID", "items"}).
+							AddRow("00000000-0000-0000-0000-000000000002", 6),
+					)
+			},
+			[]map[string]interface{}{
+				{"OrderID": "00000000-0000-0000-0000-000000000002", "items": 6},
+			},
+			"",
+		},
+		{
+			"errors for an unsupported having operator",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: "items"},
+				},
+				Having: []Having{
+					{Alias: "items", Operator: "LIKE", Value: 5},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"unsupported Having operator 'LIKE'",
+		},
+		{
+			"errors for a having alias that doesn't match an aggregate",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: "items"},
+				},
+				Having: []Having{
+					{Alias: "total", Operator: ">", Value: 5},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"Having alias 'total' does not match an aggregate",
+		},
+		{
+			"errors for an unsupported function",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				Aggregates: []Aggregate{
+					{Func: "MEDIAN", Field: "Amount", Alias: "median"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"unsupported aggregate function 'MEDIAN'",
+		},
+		{
+			"errors for a field that isn't a column",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				Aggregates: []Aggregate{
+					{Func: "SUM", Field: "Price", Alias: "total"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"aggregate field 'Price' is not a column on the filter model",
+		},
+		{
+			"errors for a group by field that isn't a column",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				GroupBy:     []string{"Order"},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: "items"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"GroupBy field 'Order' is not a column on the filter model",
+		},
+		{
+			"errors for an alias that can't be quoted safely",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				Aggregates: []Aggregate{
+					{Func: "COUNT", Alias: `items"`},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			`invalid alias 'items"' for aggregate`,
+		},
+		{
+			"errors for a function other than count without a field",
+			AggregateRequest{
+				FilterModel: lineItemModel{},
+				Aggregates: []Aggregate{
+					{Func: "SUM", Alias: "total"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"aggregate function 'SUM' requires a field",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.AggregateModel(tc.giveRequest)
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantResults, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type parentWithCountsModel struct {
+	Metadata metadata.Metadata `picard:"tablename=parentmodel"`
+
+	ID             string                `picard:"primary_key,column=id"`
+	OrganizationID string                `picard:"multitenancy_key,column=organization_id"`
+	Name           string                `picard:"column=name"`
+	Children       []testdata.ChildModel `picard:"child,foreign_key=ParentID"`
+	ChildCount     int                   `picard:"aggregate"`
+	KiddoCount     *int                  `picard:"aggregate"`
+}
+
+func TestFilterModelChildAggregates(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	parentID := "00000000-0000-0000-0000-000000000002"
+	noKiddos := 0
+	childCountSQL := `(SELECT COUNT(*) FROM childmodel AS t0_children
+		WHERE t0_children.parent_id = t0.id AND t0_children.organization_id = $%d)`
+
+	testCases := []struct {
+		description         string
+		giveRequest         FilterRequest
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []interface{}
+		wantErr             string
+	}{
+		{
+			"selects, filters and orders by the same child count",
+			FilterRequest{
+				FilterModel: parentWithCountsModel{},
+				ChildAggregates: []ChildAggregate{
+					{
+						Into: "ChildCount",
+						Aggregate: tags.ChildAggregateFilter{
+							ChildName:      "Children",
+							FilterOperator: ">=",
+							FilterValue:    3,
+						},
+					},
+				},
+				OrderBy: []qp.OrderByRequest{{Field: "ChildCount", Descending: true}},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						`+fmt.Sprintf(childCountSQL, 1)+` AS "t0.ChildCount"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $2 AND `+fmt.Sprintf(childCountSQL, 3)+` >= $4
+					ORDER BY "t0.ChildCount" DESC
+				`)).
+					WithArgs(orgID, orgID, orgID, 3).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.ChildCount"}).
+							AddRow(parentID, orgID, "pops", int64(4)),
+					)
+			},
+			[]interface{}{
+				parentWithCountsModel{
+					ID:             parentID,
+					OrganizationID: orgID,
+					Name:           "pops",
+					ChildCount:     4,
+				},
+			},
+			"",
+		},
+		{
+			"selects a filtered child count without filtering the parents",
+			FilterRequest{
+				FilterModel: parentWithCountsModel{},
+				ChildAggregates: []ChildAggregate{
+					{
+						Into: "KiddoCount",
+						Aggregate: tags.ChildAggregateFilter{
+							ChildName: "Children",
+							FieldFilters: tags.FieldFilter{
+								FieldName:   "Name",
+								FilterValue: "kiddo",
+							},
+						},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						(SELECT COUNT(*) FROM childmodel AS t0_children
+						WHERE t0_children.parent_id = t0.id AND t0_children.organization_id = $1 AND
+							t0_children.name = $2) AS "t0.KiddoCount"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $3
+				`)).
+					WithArgs(orgID, "kiddo", orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.KiddoCount"}).
+							AddRow(parentID, orgID, "pops", int64(0)),
+					)
+			},
+			[]interface{}{
+				parentWithCountsModel{
+					ID:             parentID,
+					OrganizationID: orgID,
+					Name:           "pops",
+					KiddoCount:     &noKiddos,
+				},
+			},
+			"",
+		},
+		{
+			"errors for fields without the aggregate tag",
+			FilterRequest{
+				FilterModel: parentWithCountsModel{},
+				ChildAggregates: []ChildAggregate{
+					{Into: "Name", Aggregate: tags.ChildAggregateFilter{ChildName: "Children"}},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"field 'Name' on table 'parentmodel' must be tagged with aggregate to hold a child aggregate",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(tc.giveRequest)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantResults, results)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,655 @@
+package picard
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+)
+
+/*
+WithCheckValidation returns a copy of the ORM that validates the check tags of models before they are inserted
+or updated, so a violating model fails with a CheckConstraintError instead of a database error.
+
+Checks are evaluated against the values of the model, like Postgres would for the row. They may compare
+columns of the table, literals and NULL with =, <>, !=, <, <=, >, >=, IS [NOT] NULL and [NOT] BETWEEN, combine
+them with AND, OR and NOT, and use +, -, *, / and the length, char_length, lower, upper and trim functions.
+Like a CHECK constraint, a check that evaluates to NULL passes, as do checks of fields that aren't defined on
+the model. Other expressions can't be validated and return an error.
+*/
+func (p PersistenceORM) WithCheckValidation() ORM {
+	p.checkValidation = true
+	return &p
+}
+
+// validateChecks returns a CheckConstraintError for the first check tag that the values of the model violate
+func validateChecks(value reflect.Value, modelMetadata metadata.Metadata, tableMetadata *tags.TableMetadata) error {
+	columnNames := map[string]bool{}
+	row := map[string]interface{}{}
+	for _, field := range tableMetadata.GetFields() {
+		columnNames[field.GetColumnName()] = true
+		if !isFieldDefinedOnStruct(modelMetadata, field.GetName(), value) {
+			continue
+		}
+		columnValue, err := checkValue(value.FieldByName(field.GetName()))
+		if err != nil {
+			return err
+		}
+		row[field.GetColumnName()] = columnValue
+	}
+
+	for _, field := range tableMetadata.GetFields() {
+		check := field.GetCheck()
+		if check == "" {
+			continue
+		}
+		expression, err := parseCheck(check, columnNames)
+		if err != nil {
+			return fmt.Errorf("can't validate check '%s' of column '%s': %s", check, field.GetColumnName(), err)
+		}
+		result, err := expression.eval(row)
+		if err != nil {
+			return fmt.Errorf("can't validate check '%s' of column '%s': %s", check, field.GetColumnName(), err)
+		}
+		if result == false {
+			return &CheckConstraintError{
+				Table:  tableMetadata.GetTableName(),
+				Column: field.GetColumnName(),
+				Check:  check,
+			}
+		}
+		if _, isBool := result.(bool); !isBool && result != nil {
+			return fmt.Errorf("can't validate check '%s' of column '%s': it isn't a boolean expression", check, field.GetColumnName())
+		}
+	}
+	return nil
+}
+
+// checkValue converts the value of a field to the value a check sees: NULL for nil pointers, the driver value
+// of a driver.Valuer, and a float64 for every number
+func checkValue(value reflect.Value) (interface{}, error) {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+	fieldValue := value.Interface()
+	if valuer, ok := fieldValue.(driver.Valuer); ok {
+		driverValue, err := valuer.Value()
+		if err != nil {
+			return nil, err
+		}
+		if driverValue == nil {
+			return nil, nil
+		}
+		value = reflect.ValueOf(driverValue)
+		fieldValue = driverValue
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return value.Bool(), nil
+	}
+	return fieldValue, nil
+}
+
+// checkExpression is a parsed check, or a part of one. Evaluating it returns nil for NULL.
+type checkExpression interface {
+	eval(row map[string]interface{}) (interface{}, error)
+}
+
+type checkLiteral struct {
+	value interface{}
+}
+
+func (e checkLiteral) eval(row map[string]interface{}) (interface{}, error) {
+	return e.value, nil
+}
+
+type checkColumn struct {
+	name string
+}
+
+func (e checkColumn) eval(row map[string]interface{}) (interface{}, error) {
+	return row[e.name], nil
+}
+
+type checkNot struct {
+	operand checkExpression
+}
+
+func (e checkNot) eval(row map[string]interface{}) (interface{}, error) {
+	value, err := evalCheckBool(e.operand, row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return !*value, nil
+}
+
+// checkLogical is an AND or an OR, with the three-valued logic of SQL
+type checkLogical struct {
+	operator    string
+	left, right checkExpression
+}
+
+func (e checkLogical) eval(row map[string]interface{}) (interface{}, error) {
+	left, err := evalCheckBool(e.left, row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := evalCheckBool(e.right, row)
+	if err != nil {
+		return nil, err
+	}
+	// The operand that decides an AND is false, and the one that decides an OR is true
+	deciding := e.operator == "OR"
+	if (left != nil && *left == deciding) || (right != nil && *right == deciding) {
+		return deciding, nil
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	return !deciding, nil
+}
+
+type checkIsNull struct {
+	operand checkExpression
+	not     bool
+}
+
+func (e checkIsNull) eval(row map[string]interface{}) (interface{}, error) {
+	value, err := e.operand.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return (value == nil) != e.not, nil
+}
+
+type checkComparison struct {
+	operator    string
+	left, right checkExpression
+}
+
+func (e checkComparison) eval(row map[string]interface{}) (interface{}, error) {
+	left, err := e.left.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(row)
+	if err != nil || left == nil || right == nil {
+		return nil, err
+	}
+	order, err := compareCheckValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch e.operator {
+	case "=":
+		return order == 0, nil
+	case "<>", "!=":
+		return order != 0, nil
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	}
+	return order >= 0, nil
+}
+
+type checkArithmetic struct {
+	operator    string
+	left, right checkExpression
+}
+
+func (e checkArithmetic) eval(row map[string]interface{}) (interface{}, error) {
+	left, err := evalCheckNumber(e.left, row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := evalCheckNumber(e.right, row)
+	if err != nil || left == nil || right == nil {
+		return nil, err
+	}
+	switch e.operator {
+	case "+":
+		return *left + *right, nil
+	case "-":
+		return *left - *right, nil
+	case "*":
+		return *left * *right, nil
+	}
+	if *right == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return *left / *right, nil
+}
+
+type checkNegation struct {
+	operand checkExpression
+}
+
+func (e checkNegation) eval(row map[string]interface{}) (interface{}, error) {
+	value, err := evalCheckNumber(e.operand, row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return -*value, nil
+}
+
+// checkFunctions are the string functions a check can call
+var checkFunctions = map[string]func(string) interface{}{
+	"length":      func(s string) interface{} { return float64(utf8.RuneCountInString(s)) },
+	"char_length": func(s string) interface{} { return float64(utf8.RuneCountInString(s)) },
+	"lower":       func(s string) interface{} { return strings.ToLower(s) },
+	"upper":       func(s string) interface{} { return strings.ToUpper(s) },
+	"trim":        func(s string) interface{} { return strings.TrimSpace(s) },
+}
+
+type checkFunction struct {
+	name     string
+	argument checkExpression
+}
+
+func (e checkFunction) eval(row map[string]interface{}) (interface{}, error) {
+	value, err := e.argument.eval(row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	argument, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s needs a text argument, got %v", e.name, value)
+	}
+	return checkFunctions[e.name](argument), nil
+}
+
+// evalCheckBool evaluates an expression that must be a boolean, returning nil for NULL
+func evalCheckBool(expression checkExpression, row map[string]interface{}) (*bool, error) {
+	value, err := expression.eval(row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	boolValue, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%v isn't a boolean", value)
+	}
+	return &boolValue, nil
+}
+
+// evalCheckNumber evaluates an expression that must be a number, returning nil for NULL
+func evalCheckNumber(expression checkExpression, row map[string]interface{}) (*float64, error) {
+	value, err := expression.eval(row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%v isn't a number", value)
+	}
+	return &number, nil
+}
+
+// compareCheckValues returns -1, 0 or 1 as left is less than, equal to or greater than right
+func compareCheckValues(left, right interface{}) (int, error) {
+	switch left := left.(type) {
+	case float64:
+		if right, ok := right.(float64); ok {
+			return compareOrdered(left < right, left > right), nil
+		}
+	case string:
+		if right, ok := right.(string); ok {
+			return strings.Compare(left, right), nil
+		}
+	case bool:
+		if right, ok := right.(bool); ok {
+			return compareOrdered(!left && right, left && !right), nil
+		}
+	case time.Time:
+		if right, ok := right.(time.Time); ok {
+			return compareOrdered(left.Before(right), left.After(right)), nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %v to %v", left, right)
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+type checkTokenKind int
+
+const (
+	checkIdentifier checkTokenKind = iota
+	checkQuotedIdentifier
+	checkNumber
+	checkString
+	checkOperator
+)
+
+type checkToken struct {
+	kind checkTokenKind
+	text string
+}
+
+// checkOperators are the operators a check can use, with the two character ones first
+var checkOperators = []string{"<=", ">=", "<>", "!=", "=", "<", ">", "(", ")", "+", "-", "*", "/"}
+
+// tokenizeCheck splits a check expression into identifiers, numbers, strings and operators
+func tokenizeCheck(check string) ([]checkToken, error) {
+	tokens := []checkToken{}
+	for index := 0; index < len(check); {
+		char := rune(check[index])
+		switch {
+		case unicode.IsSpace(char):
+			index++
+		case char == '_' || unicode.IsLetter(char):
+			end := index + 1
+			for end < len(check) && (check[end] == '_' || unicode.IsLetter(rune(check[end])) || unicode.IsDigit(rune(check[end]))) {
+				end++
+			}
+			tokens = append(tokens, checkToken{checkIdentifier, check[index:end]})
+			index = end
+		case unicode.IsDigit(char) || char == '.':
+			end := index + 1
+			for end < len(check) && (unicode.IsDigit(rune(check[end])) || check[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, checkToken{checkNumber, check[index:end]})
+			index = end
+		case char == '"' || char == '\'':
+			// Quotes are escaped by doubling them
+			text := strings.Builder{}
+			end := index + 1
+			for {
+				if end >= len(check) {
+					return nil, fmt.Errorf("unterminated %c", char)
+				}
+				if rune(check[end]) == char {
+					if end+1 < len(check) && rune(check[end+1]) == char {
+						text.WriteByte(check[end])
+						end += 2
+						continue
+					}
+					break
+				}
+				text.WriteByte(check[end])
+				end++
+			}
+			kind := checkString
+			if char == '"' {
+				kind = checkQuotedIdentifier
+			}
+			tokens = append(tokens, checkToken{kind, text.String()})
+			index = end + 1
+		default:
+			operator := ""
+			for _, candidate := range checkOperators {
+				if strings.HasPrefix(check[index:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unsupported character %q", char)
+			}
+			tokens = append(tokens, checkToken{checkOperator, operator})
+			index += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+// checkParser parses the tokens of a check with recursive descent, from OR, which binds loosest, down to
+// literals and columns
+type checkParser struct {
+	tokens      []checkToken
+	position    int
+	columnNames map[string]bool
+}
+
+// parseCheck parses a check expression whose columns must be among the column names of its table
+func parseCheck(check string, columnNames map[string]bool) (checkExpression, error) {
+	tokens, err := tokenizeCheck(check)
+	if err != nil {
+		return nil, err
+	}
+	parser := &checkParser{tokens: tokens, columnNames: columnNames}
+	expression, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(tokens) {
+		return nil, fmt.Errorf("unexpected %s", tokens[parser.position].text)
+	}
+	return expression, nil
+}
+
+// peekKeyword reports whether the next token is the keyword, like AND, in any case
+func (p *checkParser) peekKeyword(keyword string) bool {
+	return p.position < len(p.tokens) &&
+		p.tokens[p.position].kind == checkIdentifier &&
+		strings.EqualFold(p.tokens[p.position].text, keyword)
+}
+
+// acceptKeyword consumes the next token if it's the keyword
+func (p *checkParser) acceptKeyword(keyword string) bool {
+	if p.peekKeyword(keyword) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+// acceptOperator consumes the next token if it's one of the operators, and returns it
+func (p *checkParser) acceptOperator(operators ...string) (string, bool) {
+	if p.position < len(p.tokens) && p.tokens[p.position].kind == checkOperator {
+		for _, operator := range operators {
+			if p.tokens[p.position].text == operator {
+				p.position++
+				return operator, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *checkParser) parseOr() (checkExpression, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.acceptKeyword("OR") {
+		var right checkExpression
+		right, err = p.parseAnd()
+		left = checkLogical{"OR", left, right}
+	}
+	return left, err
+}
+
+func (p *checkParser) parseAnd() (checkExpression, error) {
+	left, err := p.parseNot()
+	for err == nil && p.acceptKeyword("AND") {
+		var right checkExpression
+		right, err = p.parseNot()
+		left = checkLogical{"AND", left, right}
+	}
+	return left, err
+}
+
+func (p *checkParser) parseNot() (checkExpression, error) {
+	if p.acceptKeyword("NOT") {
+		operand, err := p.parseNot()
+		return checkNot{operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *checkParser) parseComparison() (checkExpression, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if operator, ok := p.acceptOperator("=", "<>", "!=", "<", "<=", ">", ">="); ok {
+		right, err := p.parseAdditive()
+		return checkComparison{operator, left, right}, err
+	}
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if !p.acceptKeyword("NULL") {
+			return nil, fmt.Errorf("only IS NULL and IS NOT NULL are supported")
+		}
+		return checkIsNull{left, not}, nil
+	}
+	not := false
+	if p.peekKeyword("NOT") && p.position+1 < len(p.tokens) && strings.EqualFold(p.tokens[p.position+1].text, "BETWEEN") {
+		p.position++
+		not = true
+	}
+	if p.acceptKeyword("BETWEEN") {
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("AND") {
+			return nil, fmt.Errorf("BETWEEN needs an AND")
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		var between checkExpression = checkLogical{
+			"AND",
+			checkComparison{">=", left, low},
+			checkComparison{"<=", left, high},
+		}
+		if not {
+			between = checkNot{between}
+		}
+		return between, nil
+	}
+	return left, nil
+}
+
+func (p *checkParser) parseAdditive() (checkExpression, error) {
+	left, err := p.parseMultiplicative()
+	for err == nil {
+		operator, ok := p.acceptOperator("+", "-")
+		if !ok {
+			break
+		}
+		var right checkExpression
+		right, err = p.parseMultiplicative()
+		left = checkArithmetic{operator, left, right}
+	}
+	return left, err
+}
+
+func (p *checkParser) parseMultiplicative() (checkExpression, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		operator, ok := p.acceptOperator("*", "/")
+		if !ok {
+			break
+		}
+		var right checkExpression
+		right, err = p.parseUnary()
+		left = checkArithmetic{operator, left, right}
+	}
+	return left, err
+}
+
+func (p *checkParser) parseUnary() (checkExpression, error) {
+	if _, ok := p.acceptOperator("-"); ok {
+		operand, err := p.parseUnary()
+		return checkNegation{operand}, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *checkParser) parsePrimary() (checkExpression, error) {
+	if p.position >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	token := p.tokens[p.position]
+	p.position++
+
+	switch token.kind {
+	case checkNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", token.text)
+		}
+		return checkLiteral{number}, nil
+	case checkString:
+		return checkLiteral{token.text}, nil
+	case checkQuotedIdentifier:
+		return p.column(token.text)
+	case checkOperator:
+		if token.text != "(" {
+			return nil, fmt.Errorf("unexpected %s", token.text)
+		}
+		expression, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.acceptOperator(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expression, nil
+	}
+
+	switch strings.ToUpper(token.text) {
+	case "NULL":
+		return checkLiteral{nil}, nil
+	case "TRUE":
+		return checkLiteral{true}, nil
+	case "FALSE":
+		return checkLiteral{false}, nil
+	}
+
+	if _, ok := p.acceptOperator("("); ok {
+		name := strings.ToLower(token.text)
+		if _, ok := checkFunctions[name]; !ok {
+			return nil, fmt.Errorf("unsupported function %s", token.text)
+		}
+		argument, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.acceptOperator(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return checkFunction{name, argument}, nil
+	}
+
+	// Unquoted identifiers are folded to lower case, like Postgres does
+	return p.column(strings.ToLower(token.text))
+}
+
+// column returns the expression for a column of the table
+func (p *checkParser) column(name string) (checkExpression, error) {
+	if !p.columnNames[name] {
+		return nil, fmt.Errorf("unknown column %s", name)
+	}
+	return checkColumn{name}, nil
+}
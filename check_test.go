@@ -0,0 +1,121 @@
+package picard
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type ledgerModel struct {
+	Metadata metadata.Metadata `picard:"tablename=ledger"`
+
+	ID             string   `picard:"primary_key,column=id"`
+	OrganizationID string   `picard:"multitenancy_key,column=organization_id"`
+	Name           string   `picard:"lookup,column=name,check=length(name) BETWEEN 1 AND 20"`
+	Amount         int      `picard:"column=amount,check=amount >= 0"`
+	CreditLimit    *float64 `picard:"column=credit_limit,check=credit_limit IS NULL OR credit_limit >= amount * 2"`
+}
+
+type unsupportedCheckModel struct {
+	Metadata metadata.Metadata `picard:"tablename=unsupported_check"`
+
+	ID     string `picard:"primary_key,column=id"`
+	Amount int    `picard:"column=amount,check=amount::numeric >= 0"`
+}
+
+func TestValidateChecks(t *testing.T) {
+	limit := func(value float64) *float64 {
+		return &value
+	}
+
+	testCases := []struct {
+		description string
+		model       interface{}
+		wantErr     string
+	}{
+		{
+			"passes a model that meets every check",
+			ledgerModel{Name: "rent", Amount: 100, CreditLimit: limit(200)},
+			"",
+		},
+		{
+			"rejects a value that violates its field's check",
+			ledgerModel{Name: "rent", Amount: -1},
+			"Check Constraint Violation: Table 'ledger', Column 'amount', Check 'amount >= 0'",
+		},
+		{
+			"rejects a value that violates a check comparing columns",
+			ledgerModel{Name: "rent", Amount: 100, CreditLimit: limit(150)},
+			"Check Constraint Violation: Table 'ledger', Column 'credit_limit', Check 'credit_limit IS NULL OR credit_limit >= amount * 2'",
+		},
+		{
+			"rejects a value outside a range of a function",
+			ledgerModel{Name: "a name much too long for the ledger"},
+			"Check Constraint Violation: Table 'ledger', Column 'name', Check 'length(name) BETWEEN 1 AND 20'",
+		},
+		{
+			"passes checks of fields that aren't defined on the model",
+			ledgerModel{
+				Metadata: metadata.Metadata{DefinedFields: []string{"Amount"}},
+				Amount:   5,
+			},
+			"",
+		},
+		{
+			"returns an error for a check that can't be validated",
+			unsupportedCheckModel{Amount: 1},
+			"can't validate check 'amount::numeric >= 0' of column 'amount': unsupported character ':'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			value := reflect.ValueOf(tc.model)
+			tableMetadata := tags.TableMetadataFromType(value.Type())
+			err := validateChecks(value, metadata.GetMetadataFromPicardStruct(value), tableMetadata)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeployCheckValidation(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	lookupSQL := testdata.FmtSQLRegex(`
+		SELECT ledger.id, ledger.name as ledger_name
+		FROM ledger
+		WHERE COALESCE(ledger.name::"varchar",'') = ANY($1) AND ledger.organization_id = $2
+	`)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lookupSQL).
+		WithArgs(pq.Array([]string{"rent", "refund"}), orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ledger_name"}))
+	mock.ExpectRollback()
+
+	err = New(orgID, sampleUserID).WithCheckValidation().Deploy([]ledgerModel{
+		{Name: "rent", Amount: 100},
+		{Name: "refund", Amount: -20},
+	})
+
+	assert.EqualError(t, err, "Check Constraint Violation: Table 'ledger', Column 'amount', Check 'amount >= 0'")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
@@ -0,0 +1,138 @@
+package picard
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type orderLine struct {
+	Metadata metadata.Metadata `picard:"tablename=order_line"`
+
+	OrderID        string `picard:"primary_key,column=order_id"`
+	LineNumber     string `picard:"primary_key,column=line_number"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Product        string `picard:"column=product"`
+}
+
+func TestCompositePrimaryKeySave(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	orderID := "00000000-0000-0000-0000-000000000002"
+
+	testCases := []struct {
+		description         string
+		giveModel           orderLine
+		alwaysInsert        bool
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"should update a model by all of its primary key columns",
+			orderLine{OrderID: orderID, LineNumber: "1", Product: "Widget"},
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT order_line.order_id, order_line.line_number FROM order_line
+					WHERE order_line.order_id = $1 AND order_line.line_number = $2 AND order_line.organization_id = $3
+				`)).
+					WithArgs(orderID, "1", orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"order_id", "line_number"}).AddRow(orderID, "1"))
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE order_line SET product = $1 WHERE organization_id = $2 AND (order_id = $3 AND line_number = $4)
+				`)).
+					WithArgs("Widget", orgID, orderID, "1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"should insert a model and return all of its primary key columns",
+			orderLine{OrderID: orderID, LineNumber: "2", Product: "Gadget"},
+			true,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO order_line (order_id,line_number,organization_id,product) VALUES ($1,$2,$3,$4)
+					RETURNING "order_id", "line_number"
+				`)).
+					WithArgs(orderID, "2", orgID, "Gadget").
+					WillReturnRows(sqlmock.NewRows([]string{"order_id", "line_number"}).AddRow(orderID, "2"))
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+				performedBy:       sampleUserID,
+			}
+			model := tc.giveModel
+			if tc.alwaysInsert {
+				err = p.CreateModel(&model)
+			} else {
+				err = p.SaveModel(&model)
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.giveModel.OrderID, model.OrderID)
+			assert.Equal(t, tc.giveModel.LineNumber, model.LineNumber)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestCompositePrimaryKeyDeletes(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	orderID := "00000000-0000-0000-0000-000000000002"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		DELETE FROM order_line
+		WHERE ((order_id = $1 AND line_number = $2) OR (order_id = $3 AND line_number = $4)) AND organization_id = $5
+	`)).
+		WithArgs(orderID, "1", orderID, "2", orgID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		transaction:       tx,
+	}
+
+	err = p.performDeletes([]dbchange.Change{
+		{Changes: map[string]interface{}{"order_id": orderID, "line_number": "1"}, Type: dbchange.Delete},
+		{Changes: map[string]interface{}{"order_id": orderID, "line_number": "2"}, Type: dbchange.Delete},
+	}, tags.TableMetadataFromType(reflect.TypeOf(orderLine{})))
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
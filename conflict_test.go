@@ -0,0 +1,167 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type productModel struct {
+	Metadata metadata.Metadata `picard:"tablename=product,conflict_target=organization_id&sku"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	SKU            string `picard:"column=sku"`
+	Name           string `picard:"column=name"`
+	CreatedByID    string `picard:"column=created_by_id,audit=created_by"`
+}
+
+type warehouseModel struct {
+	Metadata metadata.Metadata `picard:"tablename=warehouse,conflict_target"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Code           string `picard:"lookup,column=code"`
+	City           string `picard:"column=city"`
+}
+
+func TestConflictTarget(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	newID := "00000000-0000-0000-0000-000000000002"
+
+	testCases := []struct {
+		description         string
+		runFunction         func(ORM) error
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"creates a model with the declared conflict target",
+			func(p ORM) error {
+				return p.CreateModel(&productModel{SKU: "ABC-1", Name: "Widget"})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO product (organization_id,sku,name,created_by_id) VALUES ($1,$2,$3,$4)
+					ON CONFLICT (organization_id, sku) DO UPDATE SET "name" = EXCLUDED."name"
+					WHERE product."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id"
+				`)).
+					WithArgs(orgID, "ABC-1", "Widget", sampleUserID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(newID))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"deploys models with the conflict target implied by the lookups",
+			func(p ORM) error {
+				return p.Deploy([]warehouseModel{{Code: "BNA", City: "Nashville"}})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT warehouse.id, warehouse.code as warehouse_code
+					FROM warehouse
+					WHERE COALESCE(warehouse.code::"varchar",'') = ANY($1) AND warehouse.organization_id = $2
+				`)).
+					WithArgs(pq.Array([]string{"BNA"}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_code"}))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO warehouse (organization_id,code,city) VALUES ($1,$2,$3)
+					ON CONFLICT (organization_id, code) DO UPDATE SET "city" = EXCLUDED."city"
+					WHERE warehouse."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id"
+				`)).
+					WithArgs(orgID, "BNA", "Nashville").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(newID))
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			assert.NoError(t, tc.runFunction(New(orgID, sampleUserID)))
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestConflictTargetErrors(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description         string
+		runFunction         func(ORM) error
+		expectationFunction func(sqlmock.Sqlmock)
+		wantErr             string
+	}{
+		{
+			"fails when an insert conflicts with a row of another tenant",
+			func(p ORM) error {
+				return p.CreateModel(&productModel{SKU: "ABC-1", Name: "Widget"})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO product (organization_id,sku,name,created_by_id) VALUES ($1,$2,$3,$4)
+					ON CONFLICT (organization_id, sku) DO UPDATE SET "name" = EXCLUDED."name"
+					WHERE product."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id"
+				`)).
+					WithArgs(orgID, "ABC-1", "Widget", sampleUserID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			"1 of the models inserted into table 'product' conflicted with rows of another tenant",
+		},
+		{
+			"fails before inserting two models with the same conflict target values",
+			func(p ORM) error {
+				return p.WithNativeUpsert().Deploy([]warehouseModel{
+					{Code: "BNA", City: "Nashville"},
+					{Code: "ATL", City: "Atlanta"},
+					{Code: "BNA", City: "Franklin"},
+				})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			"more than one model inserted into table 'warehouse' has the conflict target values (" + orgID + ", BNA)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			assert.EqualError(t, tc.runFunction(New(orgID, sampleUserID)), tc.wantErr)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
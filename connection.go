@@ -9,6 +9,7 @@ import (
 )
 
 var conn *sql.DB
+var replicaConn *sql.DB
 
 type ConnectionProps struct {
 	ConnString   string
@@ -99,6 +100,18 @@ func SetConnection(db *sql.DB) {
 	conn = db
 }
 
+// SetReplicaConnection sets a connection to a read replica of the database, for reads with ReadEventual consistency.
+// Pass nil to send every read to the primary connection.
+func SetReplicaConnection(db *sql.DB) {
+	replicaConn = db
+	resetReplicaLag()
+}
+
+// GetReplicaConnection gets the read replica connection, or nil if none was set
+func GetReplicaConnection() *sql.DB {
+	return replicaConn
+}
+
 // CloseConnection closes the database connection
 func CloseConnection() {
 	if conn != nil {
@@ -0,0 +1,82 @@
+package picard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/skuid/picard/dbchange"
+)
+
+/*
+WithCopyInserts returns a copy of the ORM that writes a batch of at least threshold inserts with the Postgres
+COPY protocol instead of a multi-row INSERT. Inserts are counted per batch, so raise the batch size with
+WithBatchSize as well when seeding large tables.
+
+COPY can't return values generated by the database, so a batch is only copied when every model has its
+primary key set, the table has no generated or returning columns, and every model sets the same columns.
+Other batches are inserted as usual. Models whose children need the parent's generated key are therefore
+never copied.
+*/
+func (p PersistenceORM) WithCopyInserts(threshold int) (ORM, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("copy threshold must be positive, got %d", threshold)
+	}
+	p.copyThreshold = threshold
+	return &p, nil
+}
+
+// canCopyInserts reports whether a batch of inserts can be written with COPY
+func (p PersistenceORM) canCopyInserts(inserts []dbchange.Change, insertsHavePrimaryKey bool, returningColumnNames []string) bool {
+	if p.copyThreshold <= 0 || len(inserts) < p.copyThreshold || !insertsHavePrimaryKey || len(returningColumnNames) > 0 {
+		return false
+	}
+
+	// COPY writes NULL for a missing value instead of the column's DEFAULT, so every insert must set the same columns
+	for _, insert := range inserts[1:] {
+		if len(insert.Changes) != len(inserts[0].Changes) {
+			return false
+		}
+		for columnName := range inserts[0].Changes {
+			if _, ok := insert.Changes[columnName]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// copyInserts writes the inserts with COPY, using only the columns they set. COPY has no placeholders to cast,
+// so time values for timestamp columns are only converted to UTC.
+func (p PersistenceORM) copyInserts(inserts []dbchange.Change, tableName string, columnNames []string, timestampColumns map[string]string) error {
+	copyColumnNames := []string{}
+	for _, columnName := range columnNames {
+		if _, ok := inserts[0].Changes[columnName]; ok {
+			copyColumnNames = append(copyColumnNames, columnName)
+		}
+	}
+
+	copyStatement := pq.CopyIn(tableName, copyColumnNames...)
+	if parts := strings.SplitN(tableName, ".", 2); len(parts) == 2 {
+		copyStatement = pq.CopyInSchema(parts[0], parts[1], copyColumnNames...)
+	}
+	stmt, err := p.transaction.Prepare(copyStatement)
+	if err != nil {
+		return newQueryError(err, copyStatement)
+	}
+	defer stmt.Close()
+
+	for _, insert := range inserts {
+		columnValues := getColumnValues(copyColumnNames, insert.Changes)
+		for index, columnName := range copyColumnNames {
+			columnValues[index] = bindTimestamp(columnValues[index], timestampColumns[columnName])
+		}
+		if _, err := stmt.Exec(columnValues...); err != nil {
+			return newQueryError(err, copyStatement)
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return newQueryError(err, copyStatement)
+	}
+	return nil
+}
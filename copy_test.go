@@ -0,0 +1,91 @@
+package picard
+
+import (
+	"regexp"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployCopyInserts(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	firstID := "00000000-0000-0000-0000-000000000001"
+	secondID := "00000000-0000-0000-0000-000000000002"
+	columns := []string{"primary_key_column", "multitenancy_key_column", "test_column_one", "test_column_two"}
+	expectLookup := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`^SELECT test_tablename\.primary_key_column`).
+			WithArgs(pq.Array([]string{firstID, secondID}), orgID).
+			WillReturnRows(sqlmock.NewRows([]string{"primary_key_column", "test_tablename_primary_key_column"}))
+	}
+
+	testCases := []struct {
+		description         string
+		giveThreshold       int
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"copies a batch at the threshold",
+			2,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLookup(mock)
+				copyStatement := mock.ExpectPrepare("^" + regexp.QuoteMeta(pq.CopyIn("test_tablename", columns...)) + "$")
+				copyStatement.ExpectExec().
+					WithArgs(firstID, orgID, "ice", "sleet").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				copyStatement.ExpectExec().
+					WithArgs(secondID, orgID, "rain", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				copyStatement.ExpectExec().
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"inserts a batch below the threshold",
+			3,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLookup(mock)
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(primary_key_column,multitenancy_key_column,test_column_one,test_column_two\) VALUES \(\$1,\$2,\$3,\$4\),\(\$5,\$6,\$7,\$8\) RETURNING "primary_key_column"$`).
+					WithArgs(firstID, orgID, "ice", "sleet", secondID, orgID, "rain", "").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).
+							AddRow(firstID).
+							AddRow(secondID),
+					)
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p, err := New(orgID, sampleUserID).WithCopyInserts(tc.giveThreshold)
+			assert.NoError(t, err)
+
+			err = p.Deploy([]duplicateItem{
+				{PrimaryKeyField: firstID, TestFieldOne: "ice", TestFieldTwo: "sleet"},
+				{PrimaryKeyField: secondID, TestFieldOne: "rain"},
+			})
+			assert.NoError(t, err)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+
+	_, err := New(orgID, sampleUserID).WithCopyInserts(0)
+	assert.EqualError(t, err, "copy threshold must be positive, got 0")
+}
@@ -2,30 +2,130 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"sync"
 )
 
-var encryptionKey []byte
+// keyIDPrefix starts ciphertext encrypted with a key from the keyring, followed by the key's id and a colon
+const keyIDPrefix = "pkey:"
 
+// keyringLock guards the key from SetEncryptionKey as well as the keyring
+var (
+	keyringLock    sync.RWMutex
+	encryptionKey  []byte
+	encryptionKeys = map[string][]byte{}
+	currentKeyID   string
+)
+
+// SetEncryptionKey sets the key values are encrypted with, unless a current key is set with SetCurrentEncryptionKey
 func SetEncryptionKey(key []byte) error {
 	if len(key) != 32 {
 		return errors.New("encryption keys must be 32 bytes")
 	}
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
 	encryptionKey = key
 	return nil
 }
 
+// GetEncryptionKey returns the key set with SetEncryptionKey
 func GetEncryptionKey() ([]byte, error) {
+	keyringLock.RLock()
+	defer keyringLock.RUnlock()
 	if encryptionKey == nil {
 		return nil, errors.New("no encryption key set for picard")
 	}
 	return encryptionKey, nil
 }
 
+// AddEncryptionKey registers a key that values can be decrypted with, under the id that prefixes their
+// ciphertext. Keys that values were encrypted with before a rotation must stay registered until those
+// values are re-encrypted.
+func AddEncryptionKey(id string, key []byte) error {
+	if len(key) != 32 {
+		return errors.New("encryption keys must be 32 bytes")
+	}
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("encryption key id '%s' must be set and can't contain ':'", id)
+	}
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	encryptionKeys[id] = key
+	return nil
+}
+
+// SetCurrentEncryptionKey registers a key and encrypts every new value with it, prefixing the ciphertext
+// with the key's id. Values encrypted with other registered keys, or with the key from SetEncryptionKey,
+// can still be decrypted, so keys can be rotated without re-encrypting existing values first.
+func SetCurrentEncryptionKey(id string, key []byte) error {
+	if err := AddEncryptionKey(id, key); err != nil {
+		return err
+	}
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	currentKeyID = id
+	return nil
+}
+
+// currentKey returns the key to encrypt with and its id, which is empty for the key from SetEncryptionKey
+func currentKey() (string, []byte, error) {
+	keyringLock.RLock()
+	defer keyringLock.RUnlock()
+	if currentKeyID != "" {
+		return currentKeyID, encryptionKeys[currentKeyID], nil
+	}
+	if encryptionKey == nil {
+		return "", nil, errors.New("no encryption key set for picard")
+	}
+	return "", encryptionKey, nil
+}
+
+// ValidateEncryptionKey checks that a key to encrypt new values with is set and is 32 bytes, so a missing key
+// can be reported when the program starts instead of at the first write of an encrypted value
+func ValidateEncryptionKey() error {
+	id, key, err := currentKey()
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		if id != "" {
+			return fmt.Errorf("encryption key '%s' must be 32 bytes, got %d", id, len(key))
+		}
+		return fmt.Errorf("encryption keys must be 32 bytes, got %d", len(key))
+	}
+	return nil
+}
+
+// withKeyID prefixes ciphertext with the id of the key it was encrypted with
+func withKeyID(id string, ciphertext []byte) []byte {
+	if id == "" {
+		return ciphertext
+	}
+	return append([]byte(keyIDPrefix+id+":"), ciphertext...)
+}
+
+// splitKeyID returns the key id and ciphertext of a value encrypted with a key from the keyring
+func splitKeyID(v []byte) (string, []byte, bool) {
+	if !bytes.HasPrefix(v, []byte(keyIDPrefix)) {
+		return "", nil, false
+	}
+	rest := v[len(keyIDPrefix):]
+	end := bytes.IndexByte(rest, ':')
+	if end <= 0 {
+		return "", nil, false
+	}
+	return string(rest[:end]), rest[end+1:], true
+}
+
 func GenerateNewEncryptionKey() ([]byte, error) {
 	key := make([]byte, 32)
 	_, err := rand.Read(key)
@@ -35,11 +135,18 @@ func GenerateNewEncryptionKey() ([]byte, error) {
 	return key, nil
 }
 
+// EncryptBytes encrypts a value with the current key from SetCurrentEncryptionKey, prefixed with its id, or
+// else with the key from SetEncryptionKey
 func EncryptBytes(v []byte) ([]byte, error) {
-	if encryptionKey == nil {
-		return nil, errors.New("no encryption key set for picard")
+	id, key, err := currentKey()
+	if err != nil {
+		return nil, err
 	}
-	return encrypt(v, encryptionKey)
+	ciphertext, err := encrypt(v, key)
+	if err != nil {
+		return nil, err
+	}
+	return withKeyID(id, ciphertext), nil
 }
 
 func encrypt(plaintext []byte, key []byte) ([]byte, error) {
@@ -61,13 +168,89 @@ func encrypt(plaintext []byte, key []byte) ([]byte, error) {
 	return gcm.Seal(nonce, nonce, plaintext, nil), nil
 }
 
+// EncryptBytesDeterministic encrypts a value so the same plaintext always encrypts to the same ciphertext, which
+// lets an encrypted column be compared for equality in a query. The nonce is derived from the plaintext with
+// HMAC-SHA256 instead of being random, so anyone who can read the column can tell which rows hold the same value,
+// though not what it is. The result decrypts with DecryptBytes. Values only match values encrypted with the
+// same key, so rows encrypted before a key rotation aren't found by filters until they are re-encrypted.
+func EncryptBytesDeterministic(v []byte) ([]byte, error) {
+	id, key, err := currentKey()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptDeterministic(v, key)
+	if err != nil {
+		return nil, err
+	}
+	return withKeyID(id, ciphertext), nil
+}
+
+func encryptDeterministic(plaintext []byte, key []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := deterministicNonce(plaintext, key)[:gcm.NonceSize()]
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// deterministicNonce derives a nonce from the plaintext, keyed with a subkey of the encryption key so the key
+// itself is only used for AES
+func deterministicNonce(plaintext []byte, key []byte) []byte {
+	subkey := hmac.New(sha256.New, key)
+	subkey.Write([]byte("picard deterministic nonce"))
+	mac := hmac.New(sha256.New, subkey.Sum(nil))
+	mac.Write(plaintext)
+	return mac.Sum(nil)
+}
+
+// DecryptBytes decrypts a value with the registered key whose id prefixes it, or else with the key from
+// SetEncryptionKey
 func DecryptBytes(v []byte) ([]byte, error) {
+	keyringLock.RLock()
+	defer keyringLock.RUnlock()
+
+	if id, ciphertext, ok := splitKeyID(v); ok {
+		key, found := encryptionKeys[id]
+		if !found && encryptionKey == nil {
+			return nil, fmt.Errorf("no encryption key registered with id '%s'", id)
+		}
+		if found {
+			plaintext, err := decrypt(ciphertext, key)
+			// Ciphertext without a key id can start with the prefix by chance, so it's also tried with the key
+			// from SetEncryptionKey
+			if err == nil || encryptionKey == nil {
+				return plaintext, err
+			}
+		}
+	}
+
 	if encryptionKey == nil {
 		return nil, errors.New("no encryption key set for picard")
 	}
 	return decrypt(v, encryptionKey)
 }
 
+// DecryptBytesWithKey decrypts a value with the provided key instead of the key set for picard,
+// which allows values encrypted under a previous key to be read during key rotation
+func DecryptBytesWithKey(v []byte, key []byte) ([]byte, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption keys must be 32 bytes")
+	}
+	if _, ciphertext, ok := splitKeyID(v); ok {
+		if plaintext, err := decrypt(ciphertext, key); err == nil {
+			return plaintext, nil
+		}
+	}
+	return decrypt(v, key)
+}
+
 func decrypt(ciphertext []byte, key []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
 	if err != nil {
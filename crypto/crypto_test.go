@@ -3,6 +3,7 @@ package crypto
 import (
 	"crypto/rand"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -87,6 +88,34 @@ func TestGetEncryptionKey(t *testing.T) {
 		})
 	}
 }
+func TestEncryptionKeyConcurrency(t *testing.T) {
+	keys := [][]byte{
+		[]byte("the-key-has-to-be-32-bytes-long!"),
+		[]byte("the-key-really-is-32-bytes-long!"),
+	}
+	defer func() {
+		encryptionKey = nil
+	}()
+
+	// Run with -race to catch unguarded access to the key
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(key []byte) {
+			defer wg.Done()
+			assert.NoError(t, SetEncryptionKey(key))
+		}(keys[i%2])
+		go func() {
+			defer wg.Done()
+			if key, err := GetEncryptionKey(); err == nil {
+				assert.Len(t, key, 32)
+			}
+			ValidateEncryptionKey()
+		}()
+	}
+	wg.Wait()
+}
+
 func TestGenerateNewEncryptionKey(t *testing.T) {
 	testCases := []struct {
 		description string
@@ -192,6 +221,30 @@ func TestEncrypt(t *testing.T) {
 		})
 	}
 }
+func TestEncryptDeterministic(t *testing.T) {
+	key := []byte("the-key-has-to-be-32-bytes-long!")
+
+	first, err := encryptDeterministic([]byte("fred@example.com"), key)
+	assert.NoError(t, err)
+	second, err := encryptDeterministic([]byte("fred@example.com"), key)
+	assert.NoError(t, err)
+	other, err := encryptDeterministic([]byte("george@example.com"), key)
+	assert.NoError(t, err)
+	otherKey, err := encryptDeterministic([]byte("fred@example.com"), []byte("the-key-really-is-32-bytes-long!"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, first, second, "the same plaintext should encrypt the same way")
+	assert.NotEqual(t, first, other, "different plaintexts should encrypt differently")
+	assert.NotEqual(t, first, otherKey, "different keys should encrypt differently")
+
+	plaintext, err := decrypt(first, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("fred@example.com"), plaintext)
+
+	_, err = encryptDeterministic([]byte("fred@example.com"), []byte("short-key"))
+	assert.EqualError(t, err, "crypto/aes: invalid key size 9")
+}
+
 func TestDecrypt(t *testing.T) {
 	testCases := []struct {
 		description    string
@@ -252,3 +305,91 @@ func TestDecrypt(t *testing.T) {
 		})
 	}
 }
+
+func TestDecryptBytesWithKey(t *testing.T) {
+	encryptedValue := []byte{0x31, 0x32, 0x33, 0x34, 0x31, 0x32, 0x33, 0x34, 0x31, 0x32, 0x33, 0x34, 0x89, 0xb7, 0x60, 0x68, 0x88, 0x29, 0xc2, 0x35, 0xe9, 0x21, 0xb, 0x3a, 0xe3, 0x9b, 0xd9, 0xf1, 0xf5, 0xc7, 0xb, 0xce, 0x67, 0x0, 0xa9, 0xaf, 0xa2, 0x1e, 0xcc, 0x84, 0x5f, 0xbd, 0x6, 0x4f, 0xe6, 0x2c, 0x54, 0xc7, 0xdc, 0x57, 0x4, 0xe2, 0xa4, 0xca, 0x2, 0x2e, 0x5e}
+
+	SetEncryptionKey([]byte("the-key-really-is-32-bytes-long!"))
+
+	result, err := DecryptBytesWithKey(encryptedValue, []byte("the-key-has-to-be-32-bytes-long!"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("some plaintext for encryption"), result)
+
+	_, err = DecryptBytesWithKey(encryptedValue, []byte("short-key"))
+	assert.EqualError(t, err, "encryption keys must be 32 bytes")
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := []byte("the-key-has-to-be-32-bytes-long!")
+	newKey := []byte("the-key-really-is-32-bytes-long!")
+	defer func() {
+		encryptionKey = nil
+		encryptionKeys = map[string][]byte{}
+		currentKeyID = ""
+	}()
+
+	assert.NoError(t, SetEncryptionKey(oldKey))
+	oldReader := rand.Reader
+	rand.Reader = strings.NewReader("123412341234")
+	unversioned, err := EncryptBytes([]byte("some plaintext for encryption"))
+	rand.Reader = oldReader
+	assert.NoError(t, err)
+	assert.False(t, strings.HasPrefix(string(unversioned), keyIDPrefix), "values encrypted without a keyring should not have a key id")
+
+	assert.NoError(t, SetCurrentEncryptionKey("2021-06", newKey))
+	rand.Reader = strings.NewReader("432143214321")
+	versioned, err := EncryptBytes([]byte("A different plaintext for test"))
+	rand.Reader = oldReader
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(versioned), "pkey:2021-06:"))
+
+	deterministic, err := EncryptBytesDeterministic([]byte("fred@example.com"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(deterministic), "pkey:2021-06:"))
+
+	for description, testCase := range map[string]struct {
+		ciphertext []byte
+		plaintext  string
+	}{
+		"values encrypted before the rotation":  {unversioned, "some plaintext for encryption"},
+		"values encrypted with the current key": {versioned, "A different plaintext for test"},
+		"deterministic values":                  {deterministic, "fred@example.com"},
+	} {
+		plaintext, err := DecryptBytes(testCase.ciphertext)
+		assert.NoError(t, err, description)
+		assert.Equal(t, testCase.plaintext, string(plaintext), description)
+	}
+
+	plaintext, err := DecryptBytesWithKey(versioned, newKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "A different plaintext for test", string(plaintext))
+
+	encryptionKey = nil
+	_, err = DecryptBytes([]byte("pkey:2020-01:ciphertext"))
+	assert.EqualError(t, err, "no encryption key registered with id '2020-01'")
+
+	assert.EqualError(t, AddEncryptionKey("2021:06", newKey), "encryption key id '2021:06' must be set and can't contain ':'")
+	assert.EqualError(t, SetCurrentEncryptionKey("2022-01", []byte("short-key")), "encryption keys must be 32 bytes")
+	assert.Equal(t, "2021-06", currentKeyID)
+}
+
+func TestValidateEncryptionKey(t *testing.T) {
+	defer func() {
+		encryptionKey = nil
+		encryptionKeys = map[string][]byte{}
+		currentKeyID = ""
+	}()
+
+	encryptionKey = nil
+	assert.EqualError(t, ValidateEncryptionKey(), "no encryption key set for picard")
+
+	encryptionKey = []byte("short-key")
+	assert.EqualError(t, ValidateEncryptionKey(), "encryption keys must be 32 bytes, got 9")
+
+	encryptionKey = []byte("the-key-has-to-be-32-bytes-long!")
+	assert.NoError(t, ValidateEncryptionKey())
+
+	encryptionKeys["2021-06"] = []byte("short-key")
+	currentKeyID = "2021-06"
+	assert.EqualError(t, ValidateEncryptionKey(), "encryption key '2021-06' must be 32 bytes, got 9")
+}
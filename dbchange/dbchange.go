@@ -27,13 +27,23 @@ type Change struct {
 	OriginalValue reflect.Value
 	Key           string
 	Type          Type
+	// Conflict is set on an update that wasn't made because the row's version no longer matched the model's
+	Conflict bool
 }
 
 // ChangeSet structure
 type ChangeSet struct {
+	// TableName is the table the changes are made to
+	TableName             string
 	Inserts               []Change
 	Updates               []Change
 	Deletes               []Change
 	InsertsHavePrimaryKey bool
 	LookupsUsed           []tags.Lookup
+	// PrimaryKeyLookupsUsed are the lookups used for models that set their primary key, when the models of the
+	// change set were matched by primary key or lookup fields each. Otherwise it is nil and LookupsUsed applies to
+	// every model.
+	PrimaryKeyLookupsUsed []tags.Lookup
+	// SkippedKeys are the keys of the models that were skipped for an unresolvable reference
+	SkippedKeys []string
 }
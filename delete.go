@@ -1,63 +1,85 @@
 package picard
 
 import (
+	"database/sql"
 	"fmt"
 	"reflect"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/skuid/picard/reflectutil"
 
 	"github.com/skuid/picard/query"
+	qp "github.com/skuid/picard/queryparts"
 	"github.com/skuid/picard/stringutil"
 	"github.com/skuid/picard/tags"
 )
 
 // DeleteModel will delete models that match the provided struct, ignoring zero values.
-// Returns the number of rows affected or an error.
+// Models with a soft_delete field are soft-deleted by setting that column to the current time.
+// Returns the number of rows affected or an error. See WithChunkedDeletes for deleting large sets of
+// models in several statements.
 func (porm PersistenceORM) DeleteModel(model interface{}) (int64, error) {
+	return porm.DeleteModels(FilterRequest{
+		FilterModel: model,
+	})
+}
+
+// DeleteModels works like DeleteModel, deleting every model that matches the provided filter request,
+// ignoring zero values on the filter model. FieldFilters may be used for more complex conditions, like
+// ranges or lists of values, and WhereRaw for conditions written in SQL. Deletes are always limited to the
+// ORM's tenant. Returns the number of rows affected or an error.
+//
+// A request with a Limit deletes at most that many matching models, so large deletes can be throttled by
+// calling DeleteModels until it returns zero. Postgres has no DELETE ... LIMIT, so the models are chosen
+// by a subquery on their primary keys.
+func (porm PersistenceORM) DeleteModels(request FilterRequest) (int64, error) {
+	model := request.FilterModel
 
 	metadata, err := tags.GetTableMetadata(model)
 	if err != nil {
 		return 0, err
 	}
 
+	if err := checkWritable(metadata); err != nil {
+		return 0, err
+	}
+
 	hasAssociations, err := hasAssociations(model, metadata)
 	if err != nil {
 		return 0, err
 	}
 
-	pkField := metadata.GetPrimaryKeyFieldName()
-	pkColumn := metadata.GetPrimaryKeyColumnName()
+	tbl, err := query.Build(porm.multitenancyValue, model, request.FieldFilters, nil, nil, metadata)
 
-	tbl, err := query.Build(porm.multitenancyValue, model, nil, nil, nil, metadata)
+	if err != nil {
+		return 0, err
+	}
 
+	raw, err := rawWheres(request.WhereRaw)
 	if err != nil {
 		return 0, err
 	}
+	tbl.Wheres = append(tbl.Wheres, raw...)
 
-	dSQL := tbl.DeleteSQL()
+	var pkWhere sq.Sqlizer
 
-	lookupPks := make([]interface{}, 0)
-	if hasAssociations {
-		results, err := porm.FilterModel(FilterRequest{
-			FilterModel:  model,
-			SelectFields: []string{pkField},
-		})
+	lookupPks := make([]map[string]interface{}, 0)
+	if hasAssociations || porm.deleteChunkSize > 0 {
+		lookupPks, err = porm.lookupDeleteKeys(request, metadata)
 		if err != nil {
 			return 0, err
 		}
-
-		for _, result := range results {
-			val := getValueFromLookupString(reflect.ValueOf(result), pkField)
-			if val.IsValid() {
-				lookupPks = append(lookupPks, val.Interface())
-			}
+		pkWhere = deletePrimaryKeysWhere(tbl, metadata, lookupPks)
+	} else if request.Limit > 0 {
+		pkWhere, err = limitedDeleteWhere(tbl, metadata, request.Limit)
+		if err != nil {
+			return 0, err
 		}
-		dSQL = dSQL.Where(
-			sq.Eq{
-				fmt.Sprintf("%s.%s", tbl.Alias, pkColumn): lookupPks,
-			},
-		)
+	}
+
+	if porm.deleteChunkSize > 0 {
+		return porm.deleteInChunks(tbl, metadata, lookupPks)
 	}
 
 	if porm.transaction == nil {
@@ -70,7 +92,7 @@ func (porm PersistenceORM) DeleteModel(model interface{}) (int64, error) {
 		defer porm.Commit()
 	}
 
-	results, err := dSQL.RunWith(porm.transaction).Exec()
+	results, err := porm.execDelete(tbl, metadata, pkWhere)
 	if err != nil {
 		porm.Rollback()
 		return 0, err
@@ -79,6 +101,162 @@ func (porm PersistenceORM) DeleteModel(model interface{}) (int64, error) {
 	return results.RowsAffected()
 }
 
+// lookupDeleteKeys returns the primary keys of the models matching the delete request, as the value of each
+// primary key column
+func (porm PersistenceORM) lookupDeleteKeys(request FilterRequest, metadata *tags.TableMetadata) ([]map[string]interface{}, error) {
+	pkFields := metadata.GetPrimaryKeyFieldNames()
+	pkColumns := metadata.GetPrimaryKeyColumnNames()
+	results, err := porm.exactTenant().FilterModel(FilterRequest{
+		FilterModel:  request.FilterModel,
+		FieldFilters: request.FieldFilters,
+		WhereRaw:     request.WhereRaw,
+		SelectFields: pkFields,
+		Limit:        request.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lookupPks := make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		pk := map[string]interface{}{}
+		for index, pkField := range pkFields {
+			val := getValueFromLookupString(reflect.ValueOf(result), pkField)
+			if val.IsValid() {
+				pk[pkColumns[index]] = val.Interface()
+			}
+		}
+		if len(pk) == len(pkFields) {
+			lookupPks = append(lookupPks, pk)
+		}
+	}
+	return lookupPks, nil
+}
+
+// deletePrimaryKeysWhere matches the rows of the table with the primary keys. Tables with a composite primary key
+// match each row on all of its key columns, and no row at all without keys.
+func deletePrimaryKeysWhere(tbl *qp.Table, metadata *tags.TableMetadata, pks []map[string]interface{}) sq.Sqlizer {
+	if !metadata.HasCompositePrimaryKey() {
+		pkColumn := metadata.GetPrimaryKeyColumnName()
+		keys := make([]interface{}, 0, len(pks))
+		for _, pk := range pks {
+			keys = append(keys, pk[pkColumn])
+		}
+		return sq.Eq{fmt.Sprintf("%s.%s", tbl.Alias, pkColumn): keys}
+	}
+
+	if len(pks) == 0 {
+		return sq.Expr("(1=0)")
+	}
+	keysWhere := sq.Or{}
+	for _, pk := range pks {
+		keyWhere := sq.And{}
+		for _, pkColumn := range metadata.GetPrimaryKeyColumnNames() {
+			keyWhere = append(keyWhere, sq.Eq{fmt.Sprintf("%s.%s", tbl.Alias, pkColumn): pk[pkColumn]})
+		}
+		keysWhere = append(keysWhere, keyWhere)
+	}
+	return keysWhere
+}
+
+/*
+deleteInChunks deletes the models with the given primary keys, deleteChunkSize keys per statement, and
+returns the total number of rows affected. With separate transactions, each chunk is committed on its own
+unless a transaction was started with StartTransaction, so a failure leaves earlier chunks deleted.
+*/
+func (porm PersistenceORM) deleteInChunks(tbl *qp.Table, metadata *tags.TableMetadata, pks []map[string]interface{}) (int64, error) {
+	ownsTransaction := porm.transaction == nil
+	var total int64
+	for start := 0; start < len(pks); start += porm.deleteChunkSize {
+		end := start + porm.deleteChunkSize
+		if end > len(pks) {
+			end = len(pks)
+		}
+
+		if porm.transaction == nil {
+			tx, err := GetConnection().Begin()
+			if err != nil {
+				return total, err
+			}
+			porm.transaction = tx
+		}
+
+		results, err := porm.execDelete(tbl, metadata, deletePrimaryKeysWhere(tbl, metadata, pks[start:end]))
+		if err != nil {
+			porm.Rollback()
+			return total, err
+		}
+		affected, err := results.RowsAffected()
+		if err != nil {
+			porm.Rollback()
+			return total, err
+		}
+
+		if ownsTransaction && porm.separateDeleteTransactions {
+			if err := porm.Commit(); err != nil {
+				return total, err
+			}
+		}
+		total += affected
+	}
+
+	if ownsTransaction && porm.transaction != nil {
+		if err := porm.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// execDelete deletes, or soft-deletes, the rows matching the table's filters and pkWhere
+func (porm PersistenceORM) execDelete(tbl *qp.Table, metadata *tags.TableMetadata, pkWhere sq.Sqlizer) (sql.Result, error) {
+	if softDeleteColumn := metadata.GetSoftDeleteColumnName(); softDeleteColumn != "" {
+		uSQL := tbl.UpdateSQL().
+			Set(softDeleteColumn, sq.Expr("now()")).
+			Where(sq.Eq{fmt.Sprintf("%s.%s", tbl.Alias, softDeleteColumn): nil})
+		if pkWhere != nil {
+			uSQL = uSQL.Where(pkWhere)
+		}
+		return uSQL.RunWith(porm.transaction).Exec()
+	}
+
+	dSQL := tbl.DeleteSQL()
+	if pkWhere != nil {
+		dSQL = dSQL.Where(pkWhere)
+	}
+	return dSQL.RunWith(porm.transaction).Exec()
+}
+
+// limitedDeleteWhere matches the primary keys of at most limit rows matching the table's filters
+func limitedDeleteWhere(tbl *qp.Table, metadata *tags.TableMetadata, limit uint64) (sq.Sqlizer, error) {
+	pkColumns := []string{}
+	for _, columnName := range metadata.GetPrimaryKeyColumnNames() {
+		pkColumns = append(pkColumns, fmt.Sprintf(qp.AliasedField, tbl.Alias, columnName))
+	}
+
+	subquery := sq.Select(pkColumns...).From(fmt.Sprintf("%s AS %s", tbl.Name, tbl.Alias))
+	if tbl.MultiTenancy != nil {
+		subquery = subquery.Where(tbl.MultiTenancyWhere())
+	}
+	for _, where := range tbl.Wheres {
+		subquery = subquery.Where(where)
+	}
+	// Rows that are already soft-deleted would use up the limit without being deleted again
+	if softDeleteColumn := metadata.GetSoftDeleteColumnName(); softDeleteColumn != "" {
+		subquery = subquery.Where(sq.Eq{fmt.Sprintf(qp.AliasedField, tbl.Alias, softDeleteColumn): nil})
+	}
+	subquerySQL, args, err := subquery.Suffix("LIMIT ?", limit).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	pkExpr := strings.Join(pkColumns, ", ")
+	if len(pkColumns) > 1 {
+		pkExpr = "(" + pkExpr + ")"
+	}
+	return sq.Expr(fmt.Sprintf("%s IN (%s)", pkExpr, subquerySQL), args...), nil
+}
+
 func hasAssociations(model interface{}, metadata *tags.TableMetadata) (bool, error) {
 	val, err := stringutil.GetStructValue(model)
 	if err != nil {
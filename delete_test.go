@@ -1,11 +1,17 @@
 package picard
 
 import (
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/dbchange"
 	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
 	"github.com/skuid/picard/testdata"
 	"github.com/stretchr/testify/assert"
 )
@@ -199,6 +205,32 @@ func TestDeleteModel(t *testing.T) {
 			20,
 			"some test error 2",
 		},
+		// Soft delete
+		{
+			"Sets the soft delete column instead of deleting",
+			softDeleteModel{
+				PrimaryKeyField: "00000000-0000-0000-0000-000000000555",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE test_tablename AS t0
+					SET deleted_at = now()
+					WHERE
+						t0.multitenancy_key_column = $1 AND
+						t0.primary_key_column = $2 AND
+						t0.deleted_at IS NULL
+				`)).
+					WithArgs(
+						testMultitenancyValue,
+						"00000000-0000-0000-0000-000000000555",
+					).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			1,
+			"",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -235,3 +267,357 @@ func TestDeleteModel(t *testing.T) {
 		})
 	}
 }
+
+func TestPerformDeletesSoftDelete(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000001"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE test_tablename SET deleted_at = now\(\) WHERE primary_key_column IN \(\$1,\$2\) AND multitenancy_key_column = \$3$`).
+		WithArgs(
+			"00000000-0000-0000-0000-000000000555",
+			"00000000-0000-0000-0000-000000000556",
+			testMultitenancyValue,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := PersistenceORM{
+		multitenancyValue: testMultitenancyValue,
+		transaction:       tx,
+	}
+
+	err = p.performDeletes([]dbchange.Change{
+		{
+			Changes: map[string]interface{}{"primary_key_column": "00000000-0000-0000-0000-000000000555"},
+			Type:    dbchange.Delete,
+		},
+		{
+			Changes: map[string]interface{}{"primary_key_column": "00000000-0000-0000-0000-000000000556"},
+			Type:    dbchange.Delete,
+		},
+	}, tags.TableMetadataFromType(reflect.TypeOf(softDeleteModel{})))
+
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestDeleteModelChunked(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	keys := []string{
+		"00000000-0000-0000-0000-000000000002",
+		"00000000-0000-0000-0000-000000000003",
+		"00000000-0000-0000-0000-000000000004",
+		"00000000-0000-0000-0000-000000000005",
+		"00000000-0000-0000-0000-000000000006",
+	}
+	expectLookup := func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"t0.id", "t0.name"})
+		for _, key := range keys {
+			rows.AddRow(key, "Weasley")
+		}
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			SELECT t0.id AS "t0.id", t0.name AS "t0.name"
+			FROM personmodel AS t0
+			WHERE t0.organization_id = $1 AND t0.name = $2
+		`)).
+			WithArgs(orgID, "Weasley").
+			WillReturnRows(rows)
+	}
+	expectChunk := func(mock sqlmock.Sqlmock, chunk []string) {
+		placeholders := "$3"
+		args := []driver.Value{orgID, "Weasley", chunk[0]}
+		for i, key := range chunk[1:] {
+			placeholders += fmt.Sprintf(",$%d", i+4)
+			args = append(args, key)
+		}
+		mock.ExpectExec(testdata.FmtSQLRegex(`
+			DELETE FROM personmodel AS t0
+			WHERE t0.organization_id = $1 AND t0.name = $2 AND t0.id IN (` + placeholders + `)
+		`)).
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, int64(len(chunk))))
+	}
+
+	testCases := []struct {
+		description          string
+		separateTransactions bool
+		expectationFunction  func(sqlmock.Sqlmock)
+	}{
+		{
+			"deletes every chunk in one transaction",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				expectLookup(mock)
+				mock.ExpectBegin()
+				expectChunk(mock, keys[0:2])
+				expectChunk(mock, keys[2:4])
+				expectChunk(mock, keys[4:])
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"commits each chunk in its own transaction",
+			true,
+			func(mock sqlmock.Sqlmock) {
+				expectLookup(mock)
+				mock.ExpectBegin()
+				expectChunk(mock, keys[0:2])
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				expectChunk(mock, keys[2:4])
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				expectChunk(mock, keys[4:])
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p, err := PersistenceORM{
+				multitenancyValue: orgID,
+			}.WithChunkedDeletes(2, tc.separateTransactions)
+			assert.NoError(t, err)
+
+			rowsAffected, err := p.DeleteModel(testdata.PersonModel{
+				Name: "Weasley",
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(keys)), rowsAffected)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+
+	_, err := PersistenceORM{}.WithChunkedDeletes(0, false)
+	assert.EqualError(t, err, "delete chunk size must be positive, got 0")
+}
+
+type membershipModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=membership"`
+	GroupID        string            `picard:"primary_key,column=group_id"`
+	UserID         string            `picard:"primary_key,column=user_id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Role           string            `picard:"column=role"`
+}
+
+func TestDeleteModelChunkedCompositeKey(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT t0.group_id AS "t0.group_id", t0.user_id AS "t0.user_id", t0.role AS "t0.role"
+		FROM membership AS t0
+		WHERE t0.organization_id = $1 AND t0.role = $2
+	`)).
+		WithArgs(orgID, "guest").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.group_id", "t0.user_id", "t0.role"}).
+				AddRow("group1", "user1", "guest").
+				AddRow("group1", "user2", "guest").
+				AddRow("group2", "user1", "guest"),
+		)
+	mock.ExpectBegin()
+	// Each chunk matches the rows on both primary key columns
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		DELETE FROM membership AS t0
+		WHERE t0.organization_id = $1 AND t0.role = $2
+		AND ((t0.group_id = $3 AND t0.user_id = $4) OR (t0.group_id = $5 AND t0.user_id = $6))
+	`)).
+		WithArgs(orgID, "guest", "group1", "user1", "group1", "user2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		DELETE FROM membership AS t0
+		WHERE t0.organization_id = $1 AND t0.role = $2
+		AND ((t0.group_id = $3 AND t0.user_id = $4))
+	`)).
+		WithArgs(orgID, "guest", "group2", "user1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	p, err := PersistenceORM{
+		multitenancyValue: orgID,
+	}.WithChunkedDeletes(2, false)
+	assert.NoError(t, err)
+
+	rowsAffected, err := p.DeleteModel(membershipModel{
+		Role: "guest",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), rowsAffected)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestDeleteModels(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	cutoff := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type deleteFiltersModel struct {
+		metadata.Metadata `picard:"tablename=test_tablename"`
+
+		PrimaryKeyField        string    `picard:"primary_key,column=primary_key_column"`
+		TestMultitenancyColumn string    `picard:"multitenancy_key,column=multitenancy_key_column"`
+		Status                 string    `picard:"column=status"`
+		CreatedDate            time.Time `picard:"column=created_at"`
+	}
+
+	testCases := []struct {
+		description string
+		giveRequest FilterRequest
+		wantSQL     string
+		wantArgs    []driver.Value
+	}{
+		{
+			"should delete with an operator filter",
+			FilterRequest{
+				FilterModel: deleteFiltersModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:      "CreatedDate",
+					FilterValue:    cutoff,
+					FilterOperator: "<",
+				},
+			},
+			`
+				DELETE FROM test_tablename AS t0
+				WHERE t0.multitenancy_key_column = $1 AND t0.created_at < $2
+			`,
+			[]driver.Value{orgID, cutoff},
+		},
+		{
+			"should delete with a list of values and the filter model's fields",
+			FilterRequest{
+				FilterModel: deleteFiltersModel{
+					Status: "archived",
+				},
+				FieldFilters: tags.OrFilterGroup{
+					tags.FieldFilter{
+						FieldName:   "PrimaryKeyField",
+						FilterValue: []string{"00000000-0000-0000-0000-000000000002", "00000000-0000-0000-0000-000000000003"},
+					},
+					tags.FieldFilter{
+						FieldName:      "CreatedDate",
+						FilterValue:    cutoff,
+						FilterOperator: "<",
+					},
+				},
+			},
+			`
+				DELETE FROM test_tablename AS t0
+				WHERE t0.multitenancy_key_column = $1 AND t0.status = $2 AND
+					(t0.primary_key_column IN ($3,$4) OR t0.created_at < $5)
+			`,
+			[]driver.Value{
+				orgID,
+				"archived",
+				"00000000-0000-0000-0000-000000000002",
+				"00000000-0000-0000-0000-000000000003",
+				cutoff,
+			},
+		},
+		{
+			"should delete at most the limit with a subquery",
+			FilterRequest{
+				FilterModel: deleteFiltersModel{
+					Status: "archived",
+				},
+				Limit: 500,
+			},
+			`
+				DELETE FROM test_tablename AS t0
+				WHERE t0.multitenancy_key_column = $1 AND t0.status = $2 AND
+					t0.primary_key_column IN (SELECT t0.primary_key_column FROM test_tablename AS t0
+						WHERE t0.multitenancy_key_column = $3 AND t0.status = $4 LIMIT $5)
+			`,
+			[]driver.Value{orgID, "archived", orgID, "archived", uint64(500)},
+		},
+		{
+			"should soft-delete at most the limit of models that aren't deleted yet",
+			FilterRequest{
+				FilterModel: softDeleteModel{
+					TestFieldOne: "stale",
+				},
+				Limit: 500,
+			},
+			`
+				UPDATE test_tablename AS t0 SET deleted_at = now()
+				WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2 AND t0.deleted_at IS NULL AND
+					t0.primary_key_column IN (SELECT t0.primary_key_column FROM test_tablename AS t0
+						WHERE t0.multitenancy_key_column = $3 AND t0.test_column_one = $4 AND t0.deleted_at IS NULL LIMIT $5)
+			`,
+			[]driver.Value{orgID, "stale", orgID, "stale", uint64(500)},
+		},
+		{
+			"should delete with raw clauses after the field filters",
+			FilterRequest{
+				FilterModel: deleteFiltersModel{
+					Status: "archived",
+				},
+				WhereRaw: []RawClause{
+					{SQL: "date_part('year', t0.created_at) < ?", Args: []interface{}{2020}},
+				},
+			},
+			`
+				DELETE FROM test_tablename AS t0
+				WHERE t0.multitenancy_key_column = $1 AND t0.status = $2 AND (date_part('year', t0.created_at) < $3)
+			`,
+			[]driver.Value{orgID, "archived", 2020},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectBegin()
+			mock.ExpectExec(testdata.FmtSQLRegex(tc.wantSQL)).
+				WithArgs(tc.wantArgs...).
+				WillReturnResult(sqlmock.NewResult(0, 3))
+			mock.ExpectCommit()
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			rowsAffected, err := p.DeleteModels(tc.giveRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), rowsAffected)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type folderModel struct {
+	Metadata metadata.Metadata `picard:"tablename=folder"`
+
+	ID             string       `picard:"primary_key,column=id"`
+	OrganizationID string       `picard:"multitenancy_key,column=organization_id"`
+	Name           string       `picard:"column=name"`
+	ParentID       string       `picard:"foreign_key,related=Parent,column=parent_id"`
+	Parent         *folderModel `validate:"-"`
+}
+
+func TestFilterModelMaxDepth(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	rootID := "00000000-0000-0000-0000-000000000002"
+	docsID := "00000000-0000-0000-0000-000000000003"
+	specsID := "00000000-0000-0000-0000-000000000004"
+	draftsID := "00000000-0000-0000-0000-000000000005"
+	topID := "00000000-0000-0000-0000-000000000006"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name",
+			t0.parent_id AS "t0.parent_id",
+			t1.id AS "t1.id",
+			t1.organization_id AS "t1.organization_id",
+			t1.name AS "t1.name",
+			t1.parent_id AS "t1.parent_id",
+			t2.id AS "t2.id",
+			t2.organization_id AS "t2.organization_id",
+			t2.name AS "t2.name",
+			t2.parent_id AS "t2.parent_id",
+			t3.id AS "t3.id",
+			t3.organization_id AS "t3.organization_id",
+			t3.name AS "t3.name",
+			t3.parent_id AS "t3.parent_id"
+		FROM folder AS t0
+		LEFT JOIN folder AS t1 ON (t1.id = t0.parent_id AND t1.organization_id = $1)
+		LEFT JOIN folder AS t2 ON (t2.id = t1.parent_id AND t2.organization_id = $2)
+		LEFT JOIN folder AS t3 ON (t3.id = t2.parent_id AND t3.organization_id = $3)
+		WHERE t0.organization_id = $4 AND t0.name = $5
+	`)).
+		WithArgs(orgID, orgID, orgID, orgID, "drafts").
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"t0.id", "t0.organization_id", "t0.name", "t0.parent_id",
+				"t1.id", "t1.organization_id", "t1.name", "t1.parent_id",
+				"t2.id", "t2.organization_id", "t2.name", "t2.parent_id",
+				"t3.id", "t3.organization_id", "t3.name", "t3.parent_id",
+			}).
+				AddRow(
+					draftsID, orgID, "drafts", specsID,
+					specsID, orgID, "specs", docsID,
+					docsID, orgID, "docs", rootID,
+					rootID, orgID, "root", topID,
+				).
+				AddRow(
+					draftsID, orgID, "drafts", docsID,
+					docsID, orgID, "docs", rootID,
+					rootID, orgID, "root", nil,
+					nil, nil, nil, nil,
+				),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: folderModel{Name: "drafts"},
+		Associations: []tags.Association{
+			{
+				Name:     "Parent",
+				MaxDepth: 3,
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []interface{}{
+		folderModel{
+			ID:             draftsID,
+			OrganizationID: orgID,
+			Name:           "drafts",
+			ParentID:       specsID,
+			Parent: &folderModel{
+				ID:             specsID,
+				OrganizationID: orgID,
+				Name:           "specs",
+				ParentID:       docsID,
+				Parent: &folderModel{
+					ID:             docsID,
+					OrganizationID: orgID,
+					Name:           "docs",
+					ParentID:       rootID,
+					// The root's own parent is past the depth limit, so only its key is loaded
+					Parent: &folderModel{ID: rootID, OrganizationID: orgID, Name: "root", ParentID: topID},
+				},
+			},
+		},
+		folderModel{
+			ID:             draftsID,
+			OrganizationID: orgID,
+			Name:           "drafts",
+			ParentID:       docsID,
+			Parent: &folderModel{
+				ID:             docsID,
+				OrganizationID: orgID,
+				Name:           "docs",
+				ParentID:       rootID,
+				Parent:         &folderModel{ID: rootID, OrganizationID: orgID, Name: "root"},
+			},
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+type sectionModel struct {
+	Metadata metadata.Metadata `picard:"tablename=section"`
+
+	ID             string        `picard:"primary_key,column=id"`
+	OrganizationID string        `picard:"multitenancy_key,column=organization_id"`
+	Name           string        `picard:"lookup,column=name"`
+	ParentID       string        `picard:"foreign_key,lookup,related=Parent,column=parent_id"`
+	Parent         *sectionModel `validate:"-"`
+}
+
+func TestDeploySelfReferentialLookups(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	guideID := "00000000-0000-0000-0000-000000000002"
+	introID := "00000000-0000-0000-0000-000000000003"
+	sectionLookupSQL := testdata.FmtSQLRegex(`
+		SELECT section.id, section.name as section_name
+		FROM section
+		WHERE COALESCE(section.name::"varchar",'') = ANY($1) AND section.organization_id = $2
+	`)
+
+	testCases := []struct {
+		description         string
+		giveSections        []sectionModel
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"looks up a section by its parent's name without following the parent's own parent",
+			[]sectionModel{
+				{Name: "intro", Parent: &sectionModel{Name: "guide"}},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT section.id, section.name as section_name, t1.name as t1_name
+					FROM section
+					JOIN section as t1 on t1.id::"varchar" = section.parent_id::"varchar"
+					WHERE COALESCE(section.name::"varchar",'') || '|' || COALESCE(t1.name::"varchar",'') = ANY($1)
+					AND section.organization_id = $2
+				`)).
+					WithArgs(pq.Array([]string{"intro|guide"}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "section_name", "t1_name"}))
+				mock.ExpectQuery(sectionLookupSQL).
+					WithArgs(pq.Array([]string{"guide"}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "section_name"}).AddRow(guideID, "guide"))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO section (organization_id,name,parent_id) VALUES ($1,$2,$3) RETURNING "id"
+				`)).
+					WithArgs(orgID, "intro", guideID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(introID))
+			},
+		},
+		{
+			"treats a nil parent as having no lookup values",
+			[]sectionModel{
+				{Name: "intro", Parent: &sectionModel{Name: "guide"}},
+				{Name: "guide"},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sectionLookupSQL).
+					WithArgs(pq.Array([]string{"intro", "guide"}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "section_name"}))
+				mock.ExpectQuery(sectionLookupSQL).
+					WithArgs(pq.Array([]string{"guide"}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "section_name"}).AddRow(guideID, "guide"))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO section (organization_id,name,parent_id) VALUES ($1,$2,$3),($4,$5,$6) RETURNING "id"
+				`)).
+					WithArgs(orgID, "intro", guideID, orgID, "guide", "").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(introID).AddRow(guideID))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectBegin()
+			tc.expectationFunction(mock)
+			mock.ExpectCommit()
+
+			assert.NoError(t, New(orgID, sampleUserID).Deploy(tc.giveSections))
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
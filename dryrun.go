@@ -0,0 +1,58 @@
+package picard
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/skuid/picard/dbchange"
+)
+
+/*
+DryRun plans a deployment of data like Deploy does, and returns the change sets it would perform without
+writing anything. There is a change set for each batch of each table, in the order Deploy would run them,
+including the children of the deployed models and the orphans it would delete. The existing rows are looked
+up in a read-only transaction that is rolled back afterwards, unless the ORM already has a transaction, which
+is used as is.
+
+Primary keys generated by the database aren't known until a model is inserted, so the children of models
+that would be inserted without a primary key are left out of the plan. BeforeSave hooks still run, since
+they can change what would be written, but AfterSave hooks don't.
+
+Example:
+
+	changeSets, err := p.DryRun(models)
+	if err != nil {
+		return err
+	}
+	for _, changeSet := range changeSets {
+		log.Printf("%s: %d inserts, %d updates, %d deletes",
+			changeSet.TableName, len(changeSet.Inserts), len(changeSet.Updates), len(changeSet.Deletes))
+	}
+*/
+func (p PersistenceORM) DryRun(data interface{}) ([]*dbchange.ChangeSet, error) {
+	if p.transaction == nil {
+		tx, err := GetConnection().BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		p.transaction = tx
+		defer p.Rollback()
+	}
+
+	plannedChanges := []*dbchange.ChangeSet{}
+	p.plannedChanges = &plannedChanges
+	if _, err := p.upsert(data, nil); err != nil {
+		return nil, err
+	}
+	return plannedChanges, nil
+}
+
+// isDryRun reports whether the ORM is planning changes for DryRun instead of performing them
+func (p PersistenceORM) isDryRun() bool {
+	return p.plannedChanges != nil
+}
+
+// planChanges records a change set that DryRun would perform
+func (p PersistenceORM) planChanges(changeSet *dbchange.ChangeSet) {
+	*p.plannedChanges = append(*p.plannedChanges, changeSet)
+}
@@ -0,0 +1,85 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type plannedParent struct {
+	Metadata metadata.Metadata `picard:"tablename=planned_parent"`
+
+	ID             string         `picard:"primary_key,column=id"`
+	OrganizationID string         `picard:"multitenancy_key,column=organization_id"`
+	Name           string         `picard:"lookup,column=name"`
+	Children       []plannedChild `picard:"child,foreign_key=ParentID"`
+}
+
+type plannedChild struct {
+	Metadata metadata.Metadata `picard:"tablename=planned_child"`
+
+	ID             string        `picard:"primary_key,column=id"`
+	OrganizationID string        `picard:"multitenancy_key,column=organization_id"`
+	Name           string        `picard:"lookup,column=name"`
+	ParentID       string        `picard:"foreign_key,lookup,required,related=Parent,column=parent_id"`
+	Parent         plannedParent `validate:"-"`
+}
+
+func TestDryRun(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	parentID := "00000000-0000-0000-0000-000000000002"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT planned_parent.id, planned_parent.name as planned_parent_name
+		FROM planned_parent
+		WHERE COALESCE(planned_parent.name::"varchar",'') = ANY($1) AND planned_parent.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"kept", "new"}), orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "planned_parent_name"}).AddRow(parentID, "kept"))
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT planned_child.id, planned_child.name as planned_child_name, planned_child.parent_id as planned_child_parent_id
+		FROM planned_child
+		WHERE COALESCE(planned_child.name::"varchar",'') || '|' || COALESCE(planned_child.parent_id::"varchar",'') = ANY($1)
+		AND planned_child.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"celery|" + parentID}), orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "planned_child_name", "planned_child_parent_id"}))
+	mock.ExpectRollback()
+
+	models := []plannedParent{
+		{Name: "kept", Children: []plannedChild{{Name: "celery"}}},
+		{Name: "new", Children: []plannedChild{{Name: "carrot"}}},
+	}
+	changeSets, err := New(orgID, sampleUserID).DryRun(models)
+	assert.NoError(t, err)
+	if assert.Len(t, changeSets, 2) {
+		assert.Equal(t, "planned_parent", changeSets[0].TableName)
+		assert.Len(t, changeSets[0].Updates, 1)
+		assert.Len(t, changeSets[0].Inserts, 1)
+		assert.Equal(t, parentID, changeSets[0].Updates[0].Changes["id"])
+		assert.Equal(t, dbchange.Update, changeSets[0].Updates[0].Type)
+
+		assert.Equal(t, "planned_child", changeSets[1].TableName)
+		assert.Len(t, changeSets[1].Updates, 0)
+		if assert.Len(t, changeSets[1].Inserts, 1) {
+			assert.Equal(t, parentID, changeSets[1].Inserts[0].Changes["parent_id"])
+		}
+	}
+	assert.Empty(t, models[1].ID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
@@ -0,0 +1,165 @@
+package picard
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/reflectutil"
+	"github.com/skuid/picard/tags"
+)
+
+// DuplicatePrimaryKeyPolicy decides what a deployment does with models that share an explicit primary key
+type DuplicatePrimaryKeyPolicy int
+
+const (
+	// DuplicatePrimaryKeyAllow deploys every model as it is, like deployments did before the policy could be
+	// set. This is the default policy.
+	DuplicatePrimaryKeyAllow DuplicatePrimaryKeyPolicy = iota
+	// DuplicatePrimaryKeyError fails the deployment
+	DuplicatePrimaryKeyError
+	// DuplicatePrimaryKeyMerge deploys one model in place of the first duplicate. Fields set on later
+	// duplicates override the same fields on earlier ones.
+	DuplicatePrimaryKeyMerge
+	// DuplicatePrimaryKeyLastWins deploys only the last of the duplicates
+	DuplicatePrimaryKeyLastWins
+)
+
+/*
+WithDuplicatePrimaryKeyPolicy returns a copy of the ORM that handles models sharing a primary key
+according to the policy when deploying.
+
+Models without a primary key are never considered duplicates. The policy applies to each level of a
+deployment separately, so duplicate children of the same parent are handled the same way.
+*/
+func (p PersistenceORM) WithDuplicatePrimaryKeyPolicy(policy DuplicatePrimaryKeyPolicy) ORM {
+	p.duplicatePolicy = policy
+	return &p
+}
+
+/*
+resolveDuplicatePrimaryKeys returns the data with duplicate primary keys handled by the ORM's policy. The data is
+returned as is when it has no duplicates. Otherwise the resolved models are copies, and the indexes of the
+models each one was resolved from are returned with them, for writeBackResolved.
+*/
+func (p PersistenceORM) resolveDuplicatePrimaryKeys(data interface{}, tableMetadata *tags.TableMetadata) (interface{}, [][]int, error) {
+	if len(tableMetadata.GetPrimaryKeyFieldNames()) == 0 || p.duplicatePolicy == DuplicatePrimaryKeyAllow {
+		return data, nil, nil
+	}
+
+	dataValue := reflect.ValueOf(data)
+	indexesByKey := map[string][]int{}
+	keys := []string{}
+	hasDuplicates := false
+	for i := 0; i < dataValue.Len(); i++ {
+		key := getPrimaryKeyValue(dataValue.Index(i), tableMetadata)
+		if key == "" {
+			continue
+		}
+		if _, ok := indexesByKey[key]; ok {
+			if p.duplicatePolicy == DuplicatePrimaryKeyError {
+				return nil, nil, fmt.Errorf("duplicate primary key '%s' in deployment to table '%s'", key, tableMetadata.GetTableName())
+			}
+			hasDuplicates = true
+		} else {
+			keys = append(keys, key)
+		}
+		indexesByKey[key] = append(indexesByKey[key], i)
+	}
+
+	if !hasDuplicates {
+		return data, nil, nil
+	}
+
+	// Each model is kept at the position of the first duplicate for a merge, or the last one otherwise
+	keptValues := map[int]reflect.Value{}
+	for _, key := range keys {
+		indexes := indexesByKey[key]
+		if p.duplicatePolicy == DuplicatePrimaryKeyMerge {
+			merged := reflect.New(dataValue.Type().Elem()).Elem()
+			merged.Set(dataValue.Index(indexes[0]))
+			for _, index := range indexes[1:] {
+				mergeModel(merged, dataValue.Index(index))
+			}
+			keptValues[indexes[0]] = merged
+		} else {
+			last := indexes[len(indexes)-1]
+			keptValues[last] = dataValue.Index(last)
+		}
+	}
+
+	resolved := reflect.MakeSlice(dataValue.Type(), 0, dataValue.Len())
+	sources := [][]int{}
+	for i := 0; i < dataValue.Len(); i++ {
+		key := getPrimaryKeyValue(dataValue.Index(i), tableMetadata)
+		if key == "" {
+			resolved = reflect.Append(resolved, dataValue.Index(i))
+			sources = append(sources, []int{i})
+		} else if kept, ok := keptValues[i]; ok {
+			resolved = reflect.Append(resolved, kept)
+			sources = append(sources, indexesByKey[key])
+		}
+	}
+	return resolved.Interface(), sources, nil
+}
+
+// writeBackResolved copies the primary keys and returned values of deployed models that were resolved from
+// duplicates onto the models of the original data they were resolved from
+func writeBackResolved(data interface{}, resolved interface{}, sources [][]int, tableMetadata *tags.TableMetadata) {
+	dataValue := reflect.ValueOf(data)
+	resolvedValue := reflect.ValueOf(resolved)
+	for resolvedIndex, indexes := range sources {
+		deployed := resolvedValue.Index(resolvedIndex)
+		for _, index := range indexes {
+			original := dataValue.Index(index)
+			if !original.CanSet() {
+				continue
+			}
+			for _, fieldName := range tableMetadata.GetPrimaryKeyFieldNames() {
+				original.FieldByName(fieldName).Set(deployed.FieldByName(fieldName))
+			}
+			for _, field := range tableMetadata.GetFields() {
+				if field.IsReturning() {
+					original.FieldByName(field.GetName()).Set(deployed.FieldByName(field.GetName()))
+				}
+			}
+		}
+	}
+}
+
+// mergeModel copies the fields that are set on the source model onto the target model, and records them
+// as defined on the target when it tracks defined fields
+func mergeModel(target reflect.Value, source reflect.Value) {
+	sourceMetadata := metadata.GetMetadataFromPicardStruct(source)
+	targetMetadataValue := metadata.GetMetadataValue(target)
+	targetMetadata := metadata.GetMetadataFromPicardStruct(target)
+	metadataType := reflect.TypeOf(metadata.Metadata{})
+	if targetMetadata.DefinedFields != nil {
+		// Copy the defined fields so adding to them doesn't change the model the target was copied from
+		definedFields := append([]string{}, targetMetadata.DefinedFields...)
+		targetMetadataValue.FieldByName("DefinedFields").Set(reflect.ValueOf(definedFields))
+	}
+
+	for i := 0; i < source.NumField(); i++ {
+		field := source.Type().Field(i)
+		if field.Type == metadataType || field.PkgPath != "" {
+			continue
+		}
+		if !isFieldSetOnStruct(sourceMetadata, field.Name, source) {
+			continue
+		}
+		if targetMetadata.DefinedFields != nil && !isFieldDefinedOnStruct(targetMetadata, field.Name, target) {
+			metadata.AddDefinedField(targetMetadataValue, field.Name)
+		}
+		target.Field(i).Set(source.Field(i))
+	}
+}
+
+// isFieldSetOnStruct reports whether a field was given a value. Unlike isFieldDefinedOnStruct, a model
+// that doesn't track defined fields only sets its non-zero fields.
+func isFieldSetOnStruct(modelMetadata metadata.Metadata, fieldName string, data reflect.Value) bool {
+	if modelMetadata.DefinedFields == nil {
+		return !reflectutil.IsZeroValue(data.FieldByName(fieldName))
+	}
+	return isFieldDefinedOnStruct(modelMetadata, fieldName, data)
+}
@@ -0,0 +1,62 @@
+package picard
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/skuid/picard/crypto"
+	"github.com/skuid/picard/tags"
+)
+
+// validateEncryptionKey checks the encryption key, and is replaced in tests since the key is set globally
+var validateEncryptionKey = crypto.ValidateEncryptionKey
+
+/*
+ValidateEncryptionConfig checks that an encryption key is set and is 32 bytes if any of the models, or their
+children, have encrypted columns. Call it when the program starts with every model it reads or writes, so a
+missing key fails fast instead of at the first write of an encrypted value. Models may be structs, pointers to
+structs, or slices of either.
+
+Example:
+
+	if err := crypto.SetEncryptionKey(key); err != nil {
+		return err
+	}
+	if err := picard.ValidateEncryptionConfig(User{}, Credential{}); err != nil {
+		return err
+	}
+*/
+func ValidateEncryptionConfig(models ...interface{}) error {
+	for _, model := range models {
+		tableName, ok := encryptedTable(reflect.TypeOf(model), map[reflect.Type]bool{})
+		if !ok {
+			continue
+		}
+		if err := validateEncryptionKey(); err != nil {
+			return fmt.Errorf("table '%s' has encrypted columns: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+// encryptedTable returns the name of the first table with encrypted columns among a model type and its children
+func encryptedTable(modelType reflect.Type, visited map[reflect.Type]bool) (string, bool) {
+	for modelType != nil && (modelType.Kind() == reflect.Ptr || modelType.Kind() == reflect.Slice || modelType.Kind() == reflect.Map) {
+		modelType = modelType.Elem()
+	}
+	if modelType == nil || modelType.Kind() != reflect.Struct || visited[modelType] {
+		return "", false
+	}
+	visited[modelType] = true
+
+	tableMetadata := tags.TableMetadataFromType(modelType)
+	if tableMetadata.HasEncryptedColumns() {
+		return tableMetadata.GetTableName(), true
+	}
+	for _, child := range tableMetadata.GetChildren() {
+		if tableName, ok := encryptedTable(child.FieldType, visited); ok {
+			return tableName, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,86 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type credentialModel struct {
+	Metadata metadata.Metadata `picard:"tablename=credential"`
+
+	ID       string `picard:"primary_key,column=id"`
+	UserID   string `picard:"column=user_id"`
+	Password string `picard:"encrypted,column=password"`
+}
+
+type accountModel struct {
+	Metadata metadata.Metadata `picard:"tablename=account"`
+
+	ID          string            `picard:"primary_key,column=id"`
+	Name        string            `picard:"column=name"`
+	Credentials []credentialModel `picard:"child,foreign_key=UserID"`
+}
+
+func TestValidateEncryptionConfig(t *testing.T) {
+	testCases := []struct {
+		description string
+		giveModels  []interface{}
+		giveKeyErr  error
+		wantErr     string
+	}{
+		{
+			"should pass models with encrypted columns when the key is valid",
+			[]interface{}{credentialModel{}},
+			nil,
+			"",
+		},
+		{
+			"should fail models with encrypted columns when the key is missing",
+			[]interface{}{testdata.ToyModel{}, &credentialModel{}},
+			errors.New("no encryption key set for picard"),
+			"table 'credential' has encrypted columns: no encryption key set for picard",
+		},
+		{
+			"should fail models with encrypted columns when the key is the wrong length",
+			[]interface{}{[]credentialModel{}},
+			errors.New("encryption keys must be 32 bytes, got 9"),
+			"table 'credential' has encrypted columns: encryption keys must be 32 bytes, got 9",
+		},
+		{
+			"should fail models whose children have encrypted columns",
+			[]interface{}{accountModel{}},
+			errors.New("no encryption key set for picard"),
+			"table 'credential' has encrypted columns: no encryption key set for picard",
+		},
+		{
+			"should pass models without encrypted columns when the key is missing",
+			[]interface{}{testdata.ToyModel{}},
+			errors.New("no encryption key set for picard"),
+			"",
+		},
+	}
+
+	originalValidate := validateEncryptionKey
+	defer func() {
+		validateEncryptionKey = originalValidate
+	}()
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			validateEncryptionKey = func() error {
+				return tc.giveKeyErr
+			}
+
+			err := ValidateEncryptionConfig(tc.giveModels...)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
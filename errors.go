@@ -3,15 +3,22 @@ package picard
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
+	"github.com/Masterminds/squirrel"
 	multierror "github.com/hashicorp/go-multierror"
+	"github.com/lib/pq"
 )
 
 // ModelNotFoundError is returned when functions that expect to return an
 // existing model cannot find one
 const ModelNotFoundError Error = "Model Not Found"
 
+// MultipleModelsFoundError is returned when functions that expect to return a
+// single model find more than one
+const MultipleModelsFoundError Error = "Multiple Models Found"
+
 // Error is a type of error that picard will return
 type Error string
 
@@ -80,10 +87,51 @@ func (e *ForeignKeyError) SplitKey() []string {
 	return strings.Split(e.Key, separator)
 }
 
+// VersionConflictError is returned when updates aren't made because the version column of their rows changed
+// since the models were read. PrimaryKeys are the keys of those rows.
+type VersionConflictError struct {
+	Table       string
+	PrimaryKeys []interface{}
+}
+
+func (e *VersionConflictError) Error() string {
+	keys := make([]string, 0, len(e.PrimaryKeys))
+	for _, key := range e.PrimaryKeys {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	return fmt.Sprintf("Version Conflict: Table '%s', Primary Keys '%s'", e.Table, strings.Join(keys, ", "))
+}
+
+// CheckConstraintError is returned when the values of a model violate the check tag of one of its fields
+type CheckConstraintError struct {
+	Table  string
+	Column string
+	Check  string
+}
+
+func (e *CheckConstraintError) Error() string {
+	return fmt.Sprintf("Check Constraint Violation: Table '%s', Column '%s', Check '%s'", e.Table, e.Column, e.Check)
+}
+
+// DeployValidationError describes a problem ValidateDeploy found with one model of a deploy payload. Path
+// locates the model in the payload, like "[2].Children[0]".
+type DeployValidationError struct {
+	Err   error
+	Table string
+	Path  string
+}
+
+func (e *DeployValidationError) Error() string {
+	return fmt.Sprintf("%s: Table '%s', Model '%s'", e.Err, e.Table, e.Path)
+}
+
 // QueryError holds additional information about an SQL query failure
 type QueryError struct {
 	Err   error
 	Query string
+	// Args holds the Go type of each argument of a failed read, like "string" or "uint64". The values are
+	// masked, since they may hold another tenant's data or secrets.
+	Args []string
 }
 
 /*
@@ -100,3 +148,122 @@ func NewQueryError(err error, query string) *QueryError {
 func (e *QueryError) Error() string {
 	return fmt.Sprintf("%s: Query: %s", e.Err, e.Query)
 }
+
+// Unwrap returns the error the query failed with
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// newReadQueryError returns a QueryError for a failed read, with the query's SQL and masked arguments. If the
+// query can't be built, the SQL isn't known and the error is returned as is.
+func newReadQueryError(err error, query squirrel.Sqlizer) error {
+	q, args, sqlErr := query.ToSql()
+	if sqlErr != nil {
+		return err
+	}
+	queryErr := NewQueryError(err, q)
+	queryErr.Args = maskArgs(args)
+	return queryErr
+}
+
+// maskArgs returns the type of each query argument in place of its value
+func maskArgs(args []interface{}) []string {
+	masked := make([]string, 0, len(args))
+	for _, arg := range args {
+		masked = append(masked, fmt.Sprintf("%T", arg))
+	}
+	return masked
+}
+
+// Postgres error codes for constraint violations
+const (
+	uniqueViolationCode     pq.ErrorCode = "23505"
+	foreignKeyViolationCode pq.ErrorCode = "23503"
+)
+
+// constraintKeyPattern matches the columns in the detail of a constraint violation, like
+// `Key (organization_id, name)=(1, foo) already exists.`
+var constraintKeyPattern = regexp.MustCompile(`^Key \((.+?)\)=`)
+
+// UniqueConstraintError is returned when a write fails because it would duplicate a value in a unique
+// constraint or index
+type UniqueConstraintError struct {
+	Err        error
+	Query      string
+	Table      string
+	Constraint string
+	Columns    []string
+}
+
+func (e *UniqueConstraintError) Error() string {
+	return fmt.Sprintf("%s: Table '%s', Constraint '%s', Columns '%s'", e.Err, e.Table, e.Constraint, strings.Join(e.Columns, ", "))
+}
+
+// Unwrap returns the error the query failed with
+func (e *UniqueConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// ForeignKeyConstraintError is returned when a write fails because it would leave a foreign key column
+// referencing a row that doesn't exist, either by setting it or by deleting the referenced row
+type ForeignKeyConstraintError struct {
+	Err        error
+	Query      string
+	Table      string
+	Constraint string
+	Columns    []string
+}
+
+func (e *ForeignKeyConstraintError) Error() string {
+	return fmt.Sprintf("%s: Table '%s', Constraint '%s', Columns '%s'", e.Err, e.Table, e.Constraint, strings.Join(e.Columns, ", "))
+}
+
+// Unwrap returns the error the query failed with
+func (e *ForeignKeyConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// newQueryError returns a UniqueConstraintError or ForeignKeyConstraintError for constraint violations, so
+// callers don't need to check Postgres error codes, and a QueryError for anything else
+func newQueryError(err error, query string) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return NewQueryError(err, query)
+	}
+
+	switch pqErr.Code {
+	case uniqueViolationCode:
+		return &UniqueConstraintError{
+			Err:        err,
+			Query:      query,
+			Table:      pqErr.Table,
+			Constraint: pqErr.Constraint,
+			Columns:    constraintColumns(pqErr),
+		}
+	case foreignKeyViolationCode:
+		return &ForeignKeyConstraintError{
+			Err:        err,
+			Query:      query,
+			Table:      pqErr.Table,
+			Constraint: pqErr.Constraint,
+			Columns:    constraintColumns(pqErr),
+		}
+	}
+	return NewQueryError(err, query)
+}
+
+// constraintColumns returns the columns of a violated constraint, read from the detail of the error
+func constraintColumns(pqErr *pq.Error) []string {
+	matches := constraintKeyPattern.FindStringSubmatch(pqErr.Detail)
+	if matches == nil {
+		if pqErr.Column != "" {
+			return []string{pqErr.Column}
+		}
+		return nil
+	}
+	columns := strings.Split(matches[1], ",")
+	for i, column := range columns {
+		columns[i] = strings.Trim(strings.TrimSpace(column), `"`)
+	}
+	return columns
+}
@@ -0,0 +1,159 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewQueryError(t *testing.T) {
+	uniqueErr := &pq.Error{
+		Code:       "23505",
+		Message:    `duplicate key value violates unique constraint "user_email_key"`,
+		Detail:     "Key (organization_id, email)=(1, fred@example.com) already exists.",
+		Table:      "user",
+		Constraint: "user_email_key",
+	}
+	foreignKeyErr := &pq.Error{
+		Code:       "23503",
+		Message:    `insert or update on table "child" violates foreign key constraint "child_parent_id_fkey"`,
+		Detail:     `Key (parent_id)=(2) is not present in table "parent".`,
+		Table:      "child",
+		Constraint: "child_parent_id_fkey",
+	}
+	otherErr := &pq.Error{Code: "42P01", Message: `relation "nope" does not exist`}
+
+	testCases := []struct {
+		description string
+		giveErr     error
+		wantErr     error
+	}{
+		{
+			"should return unique violations as a UniqueConstraintError",
+			uniqueErr,
+			&UniqueConstraintError{
+				Err:        uniqueErr,
+				Query:      "INSERT",
+				Table:      "user",
+				Constraint: "user_email_key",
+				Columns:    []string{"organization_id", "email"},
+			},
+		},
+		{
+			"should return foreign key violations as a ForeignKeyConstraintError",
+			foreignKeyErr,
+			&ForeignKeyConstraintError{
+				Err:        foreignKeyErr,
+				Query:      "INSERT",
+				Table:      "child",
+				Constraint: "child_parent_id_fkey",
+				Columns:    []string{"parent_id"},
+			},
+		},
+		{
+			"should return other database errors as a QueryError",
+			otherErr,
+			&QueryError{Err: otherErr, Query: "INSERT"},
+		},
+		{
+			"should return other errors as a QueryError",
+			errors.New("connection reset"),
+			&QueryError{Err: errors.New("connection reset"), Query: "INSERT"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := newQueryError(tc.giveErr, "INSERT")
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.giveErr, errors.Unwrap(err))
+		})
+	}
+}
+
+func TestCreateModelUniqueConstraintError(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO serial_key_model (organization_id,name) VALUES ($1,$2) RETURNING "id"
+	`)).
+		WithArgs(orgID, "Fred").
+		WillReturnError(&pq.Error{
+			Code:       "23505",
+			Detail:     "Key (organization_id, name)=(1, Fred) already exists.",
+			Table:      "serial_key_model",
+			Constraint: "serial_key_model_name_key",
+		})
+	mock.ExpectRollback()
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		performedBy:       sampleUserID,
+	}
+	err = p.CreateModel(&serialKeyModel{Name: "Fred"})
+
+	var uniqueErr *UniqueConstraintError
+	if assert.True(t, errors.As(err, &uniqueErr)) {
+		assert.Equal(t, "serial_key_model_name_key", uniqueErr.Constraint)
+		assert.Equal(t, []string{"organization_id", "name"}, uniqueErr.Columns)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestFilterModelQueryError(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	query := testdata.FmtSQL(`
+		SELECT
+			t0.primary_key_column AS "t0.primary_key_column",
+			t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+			t0.test_column_one AS "t0.test_column_one"
+		FROM test_tablename AS t0
+		WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2
+		LIMIT $3
+	`)
+	relationErr := &pq.Error{Code: "42P01", Message: `relation "test_tablename" does not exist`}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(query)).
+		WithArgs(orgID, "kayak", 5).
+		WillReturnError(relationErr)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	_, err = p.FilterModel(FilterRequest{
+		FilterModel: Item{TestFieldOne: "kayak"},
+		Limit:       5,
+	})
+
+	var queryErr *QueryError
+	if assert.True(t, errors.As(err, &queryErr)) {
+		assert.Equal(t, query, queryErr.Query)
+		assert.Equal(t, []string{"string", "string", "uint64"}, queryErr.Args)
+		assert.Equal(t, relationErr, errors.Unwrap(queryErr))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
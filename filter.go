@@ -1,9 +1,12 @@
 package picard
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/skuid/picard/query"
@@ -86,7 +89,108 @@ Associations lets you define parent and child relationships that neeed to be eag
 
 Runner lets the filter request execute in a transaction.
 
+Consistency set to ReadEventual runs a request without a Runner on the replica connection set with
+SetReplicaConnection, as long as the replica lags the primary by no more than the ORM's maximum replica lag, and
+on the primary otherwise. See WithReplicaMaxLag. Requests that leave it unset use the ORM's consistency, set with
+WithReadConsistency.
+
+ForUpdate locks the rows returned by the top-level query with `FOR UPDATE`, and SkipLocked adds `SKIP LOCKED` so
+rows locked by another transaction are left out. Rows from joined or eager loaded associations are not locked.
+ForUpdate requires the Runner to be a transaction.
+
+SkipDecryption returns encrypted fields as the base64 ciphertext stored in the database instead of decrypting them,
+for tooling that re-encrypts values under a new key. It also applies to eager loaded associations.
+
+DecryptFields decrypts only the encrypted fields it lists, and returns the others as ciphertext, to save decrypting
+large values that aren't needed. Fields of eager loaded associations are listed by their dot separated paths, like
+`Parent.Secret` or `Children.Secret`. Without DecryptFields, every encrypted field is decrypted, and SkipDecryption
+takes precedence over it.
+
+	picard.FilterRequest{
+		FilterModel:   tableA{},
+		DecryptFields: []string{"APIKey"},
+	}
+
+Models with a `soft_delete` field only return rows that have not been soft-deleted. Set IncludeDeleted to return
+soft-deleted rows as well. It also applies to eager loaded associations.
+
+ModifiedSinceXmin returns only the rows written by transactions after the given Postgres transaction id, read from
+the `xmin` system column. Unlike filtering on an `updated_at` audit field, it also catches rows changed without
+going through picard or a trigger. Tag an int64 field with `xmin` to read the id of the transaction that last
+wrote each row, and pass the highest one seen to the next request. Transaction ids wrap around, so this only
+suits tables whose rows are read again well before that happens.
+
+	type TableA struct {
+		Metadata      metadata.Metadata `picard:"tablename=table_a"`
+		ID            string            `picard:"primary_key,column=id"`
+		TransactionID int64             `picard:"xmin"`
+	}
+
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel:       TableA{},
+		ModifiedSinceXmin: lastSeenTransactionID,
+	})
+
+	// SELECT t0.id AS "t0.id", t0.xmin::text::bigint AS "t0.xmin" FROM table_a AS t0
+	// WHERE t0.xmin::text::bigint > $1
+
+DistinctOn returns only the first row for each distinct combination of the named fields, using `SELECT DISTINCT ON`.
+Which row comes first is decided by OrderBy, and when OrderBy is set its leading fields must be the DistinctOn fields.
+
+	// Latest TableA per FieldA
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel: TableA{},
+		DistinctOn:  []string{"FieldA"},
+		OrderBy: []qp.OrderByRequest{
+			{Field: "FieldA"},
+			{Field: "UpdatedAt", Descending: true},
+		},
+	})
+
+	// SELECT DISTINCT ON (t0.field_a) ... ORDER BY t0.field_a, t0.updated_at DESC
+
+Distinct removes duplicate rows, for example when a filter on a joined association matches several times. It
+applies to every selected column, including the columns of eager loaded associations, or only to SelectFields
+when they are set. Postgres requires OrderBy fields to be selected when Distinct is used.
+
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel:  TableA{},
+		SelectFields: []string{"FieldA"},
+		Distinct:     true,
+	})
+
+	// SELECT DISTINCT t0.field_a AS "t0.field_a" FROM table_a AS t0 ...
+
+Limit and Offset page through the results. They are usually combined with OrderBy so pages are stable.
+
+	// Third page of 20 TableA models
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel: TableA{},
+		OrderBy:     []qp.OrderByRequest{{Field: "FieldA"}},
+		Limit:       20,
+		Offset:      40,
+	})
+
+	// SELECT ... ORDER BY t0.field_a LIMIT $2 OFFSET $3
+
+Limit and Offset are sent as query parameters, so every page shares a prepared statement and plan. Set
+InlinePaging to write them into the query as constants instead, which lets Postgres plan for the exact number of
+rows, at the cost of a different statement for every page.
+
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel:  TableA{},
+		OrderBy:      []qp.OrderByRequest{{Field: "FieldA"}},
+		Limit:        20,
+		Offset:       40,
+		InlinePaging: true,
+	})
+
+	// SELECT ... ORDER BY t0.field_a LIMIT 20 OFFSET 40
+
 SelectFields is set to define the exact columns to query for. Without `SelectFields`, all the columns defined in the table will be included in the query.
+When associated children are loaded, the primary key, foreign key and `grouping_criteria` fields used to attach them
+are queried even if they aren't listed, so the query may select more columns than requested. Those fields are only
+used to attach the children and are left empty on the returned models.
 
 Example:
 
@@ -101,53 +205,384 @@ Example:
 	})
 
 	// SELECT t0.id, t0.field_b FROM table_a ...
+
+ChildAggregates selects an aggregate over each model's children into a field tagged with aggregate. When the
+aggregate's FilterOperator is set, the models are filtered by it as well, and an OrderBy on the field orders by
+the selected value, so a list with counts takes a single query.
+
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel: ParentModel{},
+		ChildAggregates: []picard.ChildAggregate{
+			{
+				Into: "ChildCount",
+				Aggregate: tags.ChildAggregateFilter{
+					ChildName:      "Children",
+					FilterOperator: ">=",
+					FilterValue:    3,
+				},
+			},
+		},
+		OrderBy: []qp.OrderByRequest{{Field: "ChildCount", Descending: true}},
+	})
+
+	// SELECT ..., (SELECT COUNT(*) FROM child AS t0_children WHERE ...) AS "t0.ChildCount"
+	// FROM parent AS t0 WHERE ... AND (SELECT COUNT(*) FROM child AS t0_children WHERE ...) >= $3
+	// ORDER BY "t0.ChildCount" DESC
 */
 type FilterRequest struct {
-	FilterModel  interface{}
-	FieldFilters tags.Filterable
-	Associations []tags.Association
-	OrderBy      []qp.OrderByRequest
-	Runner       sq.BaseRunner
-	SelectFields []string
+	FilterModel    interface{}
+	FieldFilters   tags.Filterable
+	Associations   []tags.Association
+	OrderBy        []qp.OrderByRequest
+	Runner         sq.BaseRunner
+	SelectFields   []string
+	ForUpdate      bool
+	SkipLocked     bool
+	SkipDecryption bool
+	DecryptFields  []string
+	IncludeDeleted bool
+	DistinctOn     []string
+	Distinct       bool
+	Limit          uint64
+	Offset         uint64
+	// InlinePaging writes Limit and Offset into the query as constants instead of parameters
+	InlinePaging bool
+	// ModifiedSinceXmin returns only rows last written by a transaction with a later id
+	ModifiedSinceXmin int64
+	// Consistency decides whether a request without a Runner may read from the replica
+	Consistency ReadConsistency
+	// ChildAggregates selects aggregates over each model's children into fields tagged with aggregate
+	ChildAggregates []ChildAggregate
+	// SelectFunctions selects the results of SQL function calls into fields tagged with computed
+	SelectFunctions []SelectFunction
+	// WithTotalCount makes FilterModelPaginated count every match with a window function in the page's query
+	WithTotalCount bool
+	// CursorBatchSize makes FilterModelStream fetch its results from a server-side cursor, this many rows at a time
+	CursorBatchSize uint64
+	// WhereRaw adds trusted SQL conditions that FieldFilters can't express
+	WhereRaw []RawClause
+	// groupingTuples matches the children of a composite grouping_criteria to their parents
+	groupingTuples *groupingTuples
+	// limitPerParent keeps the first children of each parent of an association with a Limit
+	limitPerParent *limitPerParent
+}
+
+// limitPerParent holds the child fields that attach children to their parents, and how many to keep for each
+type limitPerParent struct {
+	fields []string
+	limit  uint64
+}
+
+// groupingTuples holds the child fields of a composite grouping_criteria and a row of values for each parent
+type groupingTuples struct {
+	fields []string
+	values [][]interface{}
+}
+
+/*
+RawClause is a condition written in SQL, for predicates that FieldFilters can't express. Its SQL uses `?`
+placeholders for Args, which are numbered along with the rest of the query's arguments, and `??` for a literal
+question mark, like the jsonb `??` operator. The filter model's table is aliased t0.
+
+The SQL is added to the query as it is, so it must never be built from user input. Values from users belong in
+Args.
+
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel: TableA{},
+		WhereRaw: []picard.RawClause{
+			{SQL: "age_years(t0.birthdate) > ?", Args: []interface{}{18}},
+		},
+	})
+
+	// SELECT ... WHERE t0.organization_id = $1 AND (age_years(t0.birthdate) > $2)
+*/
+type RawClause struct {
+	SQL  string
+	Args []interface{}
 }
 
-func addOrderBy(builder sq.SelectBuilder, orderBy []qp.OrderByRequest, filterMetadata *tags.TableMetadata, tableAlias string) sq.SelectBuilder {
+// rawWheres returns the conditions of raw clauses, each in parentheses so an OR inside one can't escape it
+func rawWheres(clauses []RawClause) ([]sq.Sqlizer, error) {
+	wheres := make([]sq.Sqlizer, 0, len(clauses))
+	for _, clause := range clauses {
+		if strings.TrimSpace(clause.SQL) == "" {
+			return nil, errors.New("raw clauses must have SQL")
+		}
+		placeholders := strings.Count(clause.SQL, "?") - 2*strings.Count(clause.SQL, "??")
+		if placeholders != len(clause.Args) {
+			return nil, fmt.Errorf("raw clause '%s' has %d placeholders but %d args", clause.SQL, placeholders, len(clause.Args))
+		}
+		wheres = append(wheres, sq.Expr("("+clause.SQL+")", clause.Args...))
+	}
+	return wheres, nil
+}
+
+// groupingTupleWhere matches the child fields of a composite grouping_criteria to the rows of their parents' values.
+// Fields of a parent of the child are read from its joined table, so it must be loaded through Associations.
+func groupingTupleWhere(tuples *groupingTuples, filterMetadata *tags.TableMetadata, tbl *qp.Table) (sq.Sqlizer, error) {
+	columns, err := groupingColumns(tuples.fields, filterMetadata, tbl)
+	if err != nil {
+		return nil, err
+	}
+
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	rows := make([]string, 0, len(tuples.values))
+	args := make([]interface{}, 0, len(columns)*len(tuples.values))
+	for _, values := range tuples.values {
+		rows = append(rows, row)
+		args = append(args, values...)
+	}
+	return sq.Expr("("+strings.Join(columns, ", ")+") IN ("+strings.Join(rows, ", ")+")", args...), nil
+}
+
+// groupingColumns returns the aliased columns of the fields a child is attached to its parent by
+func groupingColumns(fieldPaths []string, filterMetadata *tags.TableMetadata, tbl *qp.Table) ([]string, error) {
+	columns := make([]string, 0, len(fieldPaths))
+	for _, fieldPath := range fieldPaths {
+		fieldMetadata, fieldTable, fieldName := filterMetadata, tbl, fieldPath
+		if dot := strings.LastIndex(fieldPath, "."); dot != -1 {
+			fieldMetadata, fieldTable = getAssociationTable(fieldPath[:dot], filterMetadata, tbl)
+			if fieldTable == nil {
+				return nil, fmt.Errorf("'grouping_criteria' field '%s' requires the association '%s' to be loaded", fieldPath, fieldPath[:dot])
+			}
+			fieldName = fieldPath[dot+1:]
+		}
+		columnName := fieldMetadata.GetField(fieldName).GetColumnName()
+		if columnName == "" {
+			return nil, fmt.Errorf("'grouping_criteria' field '%s' is not a column on type '%s'", fieldPath, fieldMetadata.GetTableName())
+		}
+		columns = append(columns, fieldTable.Alias+"."+columnName)
+	}
+	return columns, nil
+}
+
+// rowNumberColumn is the alias of the window function that numbers the children of each parent of an association
+// with a Limit
+const rowNumberColumn = "picard_row_number"
+
+// addLimitPerParent numbers the rows of each parent in the request's order and keeps the first ones, for the
+// children of an association with a Limit. The numbered select is wrapped in another, since Postgres doesn't
+// allow window functions in WHERE. The row number is selected with the other columns, and ignored when the rows
+// are hydrated.
+func addLimitPerParent(builder sq.SelectBuilder, orderBy []qp.OrderByRequest, perParent *limitPerParent, filterMetadata *tags.TableMetadata, tbl *qp.Table) (sq.SelectBuilder, error) {
+	partitionColumns, err := groupingColumns(perParent.fields, filterMetadata, tbl)
+	if err != nil {
+		return builder, err
+	}
+	window := "ROW_NUMBER() OVER (PARTITION BY " + strings.Join(partitionColumns, ", ")
+	orderStatements, orderArgs := orderByStatements(orderBy, filterMetadata, tbl)
+	if len(orderStatements) > 0 {
+		window += " ORDER BY " + strings.Join(orderStatements, ", ")
+	}
+	window += ") AS " + rowNumberColumn
+
+	// The numbered select's placeholders are numbered along with the wrapping select's
+	numbered := builder.Column(sq.Expr(window, orderArgs...)).PlaceholderFormat(sq.Question)
+	return sq.Select("*").
+		PlaceholderFormat(sq.Dollar).
+		FromSelect(numbered, "picard_ranked").
+		Where("picard_ranked."+rowNumberColumn+" <= ?", perParent.limit).
+		OrderBy("picard_ranked." + rowNumberColumn), nil
+}
+
+// addOrderBy adds the request's ORDER BY clause. It must be called before addPaging, since the clause is added as
+// a suffix to parameterize the values of explicit orders.
+func addOrderBy(builder sq.SelectBuilder, orderBy []qp.OrderByRequest, filterMetadata *tags.TableMetadata, tbl *qp.Table) sq.SelectBuilder {
+	orderStatements, orderArgs := orderByStatements(orderBy, filterMetadata, tbl)
+	if len(orderStatements) == 0 {
+		return builder
+	}
+	return builder.Suffix("ORDER BY "+strings.Join(orderStatements, ", "), orderArgs...)
+}
+
+// orderByStatements returns the statements of an ORDER BY clause, and the values of explicit orders for their
+// placeholders
+func orderByStatements(orderBy []qp.OrderByRequest, filterMetadata *tags.TableMetadata, tbl *qp.Table) ([]string, []interface{}) {
 	orderStatements := []string{}
+	orderArgs := []interface{}{}
 	for _, order := range orderBy {
-		columnName := filterMetadata.GetField(order.Field).GetColumnName()
-		if columnName != "" {
-			orderStatement := tableAlias + "." + columnName
-			if order.Descending {
-				orderStatement += " DESC"
+		orderStatement := order.Expression
+		if orderStatement == "" {
+			orderStatement = orderByColumn(order, filterMetadata, tbl)
+			if orderStatement == "" {
+				continue
+			}
+		}
+		if len(order.Values) > 0 {
+			orderStatement = orderByValues(orderStatement, len(order.Values))
+			orderArgs = append(orderArgs, order.Values...)
+		}
+		if order.Descending {
+			orderStatement += " DESC"
+		}
+		if order.NullsFirst != nil {
+			if *order.NullsFirst {
+				orderStatement += " NULLS FIRST"
+			} else {
+				orderStatement += " NULLS LAST"
 			}
-			orderStatements = append(orderStatements, orderStatement)
 		}
+		orderStatements = append(orderStatements, orderStatement)
 	}
-	return builder.OrderBy(orderStatements...)
+	return orderStatements, orderArgs
 }
 
-func (p PersistenceORM) getSingleFilterResults(request FilterRequest, filterMetadata *tags.TableMetadata) ([]*reflect.Value, error) {
-	filterModel := request.FilterModel
-	tbl, err := query.Build(p.multitenancyValue, filterModel, request.FieldFilters, request.Associations, request.SelectFields, filterMetadata)
-	if err != nil {
-		return nil, err
+// orderByValues returns a CASE expression that sorts rows in the order of a list of values, and rows with other
+// values after them
+func orderByValues(subject string, count int) string {
+	var expression strings.Builder
+	expression.WriteString("CASE " + subject)
+	for i := 0; i < count; i++ {
+		expression.WriteString(" WHEN ? THEN " + strconv.Itoa(i))
 	}
-	sql := tbl.BuildSQL()
-	sql = addOrderBy(sql, request.OrderBy, filterMetadata, tbl.Alias)
-	rows, err := sql.RunWith(request.Runner).Query()
+	expression.WriteString(" ELSE " + strconv.Itoa(count) + " END")
+	return expression.String()
+}
+
+// orderByColumn returns the aliased column to order by for a field, or an empty string if the field isn't
+// a column of the filter model or of a joined association
+func orderByColumn(order qp.OrderByRequest, filterMetadata *tags.TableMetadata, tbl *qp.Table) string {
+	orderMetadata, orderTable := filterMetadata, tbl
+	if order.Association != "" {
+		orderMetadata, orderTable = getAssociationTable(order.Association, filterMetadata, tbl)
+		if orderTable == nil {
+			return ""
+		}
+	}
+	columnName := orderMetadata.GetField(order.Field).GetColumnName()
+	if columnName == "" {
+		// Child aggregates are ordered by the value they were selected as
+		return orderTable.ComputedColumn(order.Field)
+	}
+	return orderTable.Alias + "." + columnName
+}
+
+// getAssociationTable returns the metadata and the joined table of an eager loaded parent, given the path of
+// related field names leading to it. The table is nil when the parent wasn't joined.
+func getAssociationTable(associationPath string, filterMetadata *tags.TableMetadata, tbl *qp.Table) (*tags.TableMetadata, *qp.Table) {
+	metadata := filterMetadata
+	refPath := ""
+	for _, relatedName := range strings.Split(associationPath, ".") {
+		foreignKey := metadata.GetForeignKeyFieldFromRelation(relatedName)
+		if foreignKey == nil {
+			return nil, nil
+		}
+		if refPath == "" {
+			refPath = foreignKey.FieldName
+		} else {
+			refPath = refPath + "." + foreignKey.FieldName
+		}
+		metadata = foreignKey.TableMetadata
+	}
+	return metadata, tbl.JoinedTable(refPath)
+}
+
+func addRowLocking(builder sq.SelectBuilder, request FilterRequest, tableAlias string) sq.SelectBuilder {
+	if !request.ForUpdate {
+		return builder
+	}
+	lock := "FOR UPDATE OF " + tableAlias
+	if request.SkipLocked {
+		lock += " SKIP LOCKED"
+	}
+	return builder.Suffix(lock)
+}
+
+func addDistinctOn(builder sq.SelectBuilder, distinctOn []string, filterMetadata *tags.TableMetadata, tableAlias string) sq.SelectBuilder {
+	if len(distinctOn) == 0 {
+		return builder
+	}
+	columns := make([]string, 0, len(distinctOn))
+	for _, fieldName := range distinctOn {
+		columns = append(columns, tableAlias+"."+filterMetadata.GetField(fieldName).GetColumnName())
+	}
+	return builder.Options(fmt.Sprintf("DISTINCT ON (%s)", strings.Join(columns, ", ")))
+}
+
+func addDistinct(builder sq.SelectBuilder, request FilterRequest) sq.SelectBuilder {
+	if !request.Distinct {
+		return builder
+	}
+	return builder.Distinct()
+}
+
+// validateDistinctOn checks that the DistinctOn fields are columns on the model, and that they lead the OrderBy
+func validateDistinctOn(request FilterRequest, filterMetadata *tags.TableMetadata) error {
+	if len(request.DistinctOn) == 0 {
+		return nil
+	}
+	if request.Distinct {
+		return errors.New("Distinct and DistinctOn cannot be used together")
+	}
+	for _, fieldName := range request.DistinctOn {
+		if filterMetadata.GetField(fieldName).GetColumnName() == "" {
+			return fmt.Errorf("DistinctOn field '%s' is not a column on the filter model", fieldName)
+		}
+	}
+	if len(request.OrderBy) == 0 {
+		return nil
+	}
+	if len(request.OrderBy) < len(request.DistinctOn) {
+		return errors.New("the leading OrderBy fields must match the DistinctOn fields")
+	}
+	for _, order := range request.OrderBy[:len(request.DistinctOn)] {
+		if !stringutil.StringSliceContainsKey(request.DistinctOn, order.Field) {
+			return errors.New("the leading OrderBy fields must match the DistinctOn fields")
+		}
+	}
+	return nil
+}
+
+func addSoftDeleteFilter(builder sq.SelectBuilder, includeDeleted bool, filterMetadata *tags.TableMetadata, tableAlias string) sq.SelectBuilder {
+	softDeleteColumn := filterMetadata.GetSoftDeleteColumnName()
+	if softDeleteColumn == "" || includeDeleted {
+		return builder
+	}
+	return builder.Where(sq.Eq{tableAlias + "." + softDeleteColumn: nil})
+}
+
+// addXminFilter limits the results to rows written by transactions after modifiedSinceXmin
+func addXminFilter(builder sq.SelectBuilder, modifiedSinceXmin int64, tableAlias string) sq.SelectBuilder {
+	if modifiedSinceXmin <= 0 {
+		return builder
+	}
+	return builder.Where(sq.Expr(fmt.Sprintf(qp.XminExpression, tableAlias)+" > ?", modifiedSinceXmin))
+}
+
+// addPaging adds the request's LIMIT and OFFSET as query parameters, or as constants with InlinePaging. It must
+// be called after addOrderBy and before addRowLocking, since paging is added as a suffix.
+func addPaging(builder sq.SelectBuilder, request FilterRequest) sq.SelectBuilder {
+	if request.InlinePaging {
+		if request.Limit > 0 {
+			builder = builder.Suffix("LIMIT " + strconv.FormatUint(request.Limit, 10))
+		}
+		if request.Offset > 0 {
+			builder = builder.Suffix("OFFSET " + strconv.FormatUint(request.Offset, 10))
+		}
+		return builder
+	}
+	if request.Limit > 0 {
+		builder = builder.Suffix("LIMIT ?", request.Limit)
+	}
+	if request.Offset > 0 {
+		builder = builder.Suffix("OFFSET ?", request.Offset)
+	}
+	return builder
+}
+
+func (p PersistenceORM) buildSingleFilterSQL(request FilterRequest, filterMetadata *tags.TableMetadata) (sq.SelectBuilder, *qp.Table, error) {
+	tbl, err := query.Build(p.readMultitenancyValue(), request.FilterModel, request.FieldFilters, request.Associations, request.SelectFields, filterMetadata)
 	if err != nil {
-		return nil, err
+		return sq.SelectBuilder{}, nil, err
 	}
-	tblAlias := tbl.Alias
-	aliasMap := tbl.FieldAliases()
-	return query.Hydrate(filterModel, tblAlias, aliasMap, rows, filterMetadata)
+	return tbl.BuildSQL(), tbl, nil
 }
 
-func (p PersistenceORM) getMultiFilterResults(request FilterRequest, filterMetadata *tags.TableMetadata) ([]*reflect.Value, error) {
+func (p PersistenceORM) buildMultiFilterSQL(request FilterRequest, filterMetadata *tags.TableMetadata) (sq.SelectBuilder, *qp.Table, interface{}, error) {
 	modelVal := reflect.ValueOf(request.FilterModel)
-	mtVal := p.multitenancyValue
+	mtVal := p.readMultitenancyValue()
 	if modelVal.Len() <= 0 {
-		return []*reflect.Value{}, nil
+		return sq.SelectBuilder{}, nil, nil, nil
 	}
 
 	ors := sq.Or{}
@@ -159,7 +594,7 @@ func (p PersistenceORM) getMultiFilterResults(request FilterRequest, filterMetad
 
 		ftbl, err := query.Build(mtVal, val.Interface(), request.FieldFilters, request.Associations, request.SelectFields, filterMetadata)
 		if err != nil {
-			return nil, err
+			return sq.SelectBuilder{}, nil, nil, err
 		}
 
 		if tbl == nil {
@@ -187,78 +622,343 @@ func (p PersistenceORM) getMultiFilterResults(request FilterRequest, filterMetad
 
 	sql := tbl.BuildSQL()
 	sql = sql.Where(ors)
-	sql = addOrderBy(sql, request.OrderBy, filterMetadata, tbl.Alias)
+	return sql, tbl, filterModel, nil
+}
+
+// buildFilterSQL builds the filtered select for the request without ordering, paging or row locking. It also
+// returns the table the select was built from and the model to hydrate results into. The table is nil when
+// the request filters on an empty slice, so there is nothing to query.
+func (p PersistenceORM) buildFilterSQL(request FilterRequest, filterMetadata *tags.TableMetadata) (sq.SelectBuilder, *qp.Table, interface{}, error) {
+	var sql sq.SelectBuilder
+	var tbl *qp.Table
+	var filterModel interface{}
+	var err error
+
+	if request.groupingTuples != nil && len(request.groupingTuples.values) == 0 {
+		return sql, nil, nil, nil
+	}
+
+	modelVal := reflect.ValueOf(request.FilterModel)
+	switch modelVal.Kind() {
+	case reflect.Struct:
+		filterModel = request.FilterModel
+		sql, tbl, err = p.buildSingleFilterSQL(request, filterMetadata)
+	case reflect.Slice:
+		sql, tbl, filterModel, err = p.buildMultiFilterSQL(request, filterMetadata)
+	case reflect.Ptr:
+		request.FilterModel = modelVal.Elem().Interface()
+		return p.buildFilterSQL(request, filterMetadata)
+	default:
+		return sql, nil, nil, fmt.Errorf("filter must be a struct, a slice of structs, or a pointer to a struct or slice of structs")
+	}
+	if err != nil || tbl == nil {
+		return sql, nil, nil, err
+	}
+
+	sql = addDistinct(sql, request)
+	sql = addDistinctOn(sql, request.DistinctOn, filterMetadata, tbl.Alias)
+	sql = addSoftDeleteFilter(sql, request.IncludeDeleted, filterMetadata, tbl.Alias)
+	sql = addXminFilter(sql, request.ModifiedSinceXmin, tbl.Alias)
+	raw, err := rawWheres(request.WhereRaw)
+	if err != nil {
+		return sql, nil, nil, err
+	}
+	for _, where := range raw {
+		sql = sql.Where(where)
+	}
+	if request.groupingTuples != nil {
+		where, err := groupingTupleWhere(request.groupingTuples, filterMetadata, tbl)
+		if err != nil {
+			return sql, nil, nil, err
+		}
+		sql = sql.Where(where)
+	}
+	sql, err = addChildAggregates(sql, request.ChildAggregates, filterMetadata, tbl)
+	if err != nil {
+		return sql, nil, nil, err
+	}
+	sql, err = addSelectFunctions(sql, request.SelectFunctions, filterMetadata, tbl)
+	if err != nil {
+		return sql, nil, nil, err
+	}
+	return sql, tbl, filterModel, nil
+}
+
+func hydrateFilterResults(request FilterRequest, filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, filterMetadata *tags.TableMetadata) ([]*reflect.Value, error) {
+	return query.HydrateDecrypting(filterModel, tblAlias, aliasMap, rows, filterMetadata, requestDecryption(request))
+}
+
+// requestDecryption picks the encrypted fields that a request's results decrypt
+func requestDecryption(request FilterRequest) query.Decryption {
+	if request.SkipDecryption {
+		return query.DecryptNone
+	}
+	if request.DecryptFields != nil {
+		return query.DecryptFields(request.DecryptFields)
+	}
+	return query.DecryptAll
+}
+
+// associationDecryptFields returns the DecryptFields of the request that loads an association's children, with
+// the association's name trimmed from the paths. It's nil, decrypting every field, when the request's is.
+func associationDecryptFields(decryptFields []string, name string) []string {
+	if decryptFields == nil {
+		return nil
+	}
+	prefix := name + "."
+	childFields := []string{}
+	for _, path := range decryptFields {
+		if strings.HasPrefix(path, prefix) {
+			childFields = append(childFields, strings.TrimPrefix(path, prefix))
+		}
+	}
+	return childFields
+}
+
+// buildFilterSelect builds the complete select for the top-level models in the request
+func (p PersistenceORM) buildFilterSelect(request FilterRequest, filterMetadata *tags.TableMetadata) (sq.SelectBuilder, *qp.Table, interface{}, error) {
+	sql, tbl, filterModel, err := p.buildFilterSQL(request, filterMetadata)
+	if err != nil || tbl == nil {
+		return sql, tbl, filterModel, err
+	}
+	if request.limitPerParent != nil {
+		sql, err = addLimitPerParent(sql, request.OrderBy, request.limitPerParent, filterMetadata, tbl)
+		return sql, tbl, filterModel, err
+	}
+	sql = addOrderBy(sql, request.OrderBy, filterMetadata, tbl)
+	sql = addPaging(sql, request)
+	sql = addRowLocking(sql, request, tbl.Alias)
+	return sql, tbl, filterModel, nil
+}
+
+// totalCountColumn is the alias of the window function that counts every match of a request with WithTotalCount
+const totalCountColumn = "picard_total_count"
+
+// getFilterResults returns the top-level models of the request, and the total number of matches when the request
+// counts them in a window
+func (p PersistenceORM) getFilterResults(request FilterRequest, filterMetadata *tags.TableMetadata) ([]*reflect.Value, uint64, error) {
+	sql, tbl, filterModel, err := p.buildFilterSelect(request, filterMetadata)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tbl == nil {
+		return []*reflect.Value{}, 0, nil
+	}
+	if !countsInWindow(request) {
+		rows, err := sql.RunWith(request.Runner).Query()
+		if err != nil {
+			return nil, 0, newReadQueryError(err, sql)
+		}
+		results, err := hydrateFilterResults(request, filterModel, tbl.Alias, tbl.FieldAliases(), rows, filterMetadata)
+		return results, 0, err
+	}
+
+	// The window is computed before LIMIT and OFFSET, so every row of the page holds the count of every match
+	sql = sql.Column("COUNT(*) OVER () AS " + totalCountColumn)
 	rows, err := sql.RunWith(request.Runner).Query()
 	if err != nil {
+		return nil, 0, newReadQueryError(err, sql)
+	}
+	return query.HydrateDecryptingWithTotal(filterModel, tbl.Alias, tbl.FieldAliases(), rows, filterMetadata, totalCountColumn, requestDecryption(request))
+}
+
+// countsInWindow reports whether the request's total count can be read with a window function in the same query
+// as its results. Postgres doesn't allow window functions with FOR UPDATE, and DISTINCT removes rows after the
+// window counts them.
+func countsInWindow(request FilterRequest) bool {
+	return request.WithTotalCount && !request.ForUpdate && !request.Distinct && len(request.DistinctOn) == 0
+}
+
+// countFilterResults counts the rows that match the filter request, ignoring OrderBy, Limit and Offset
+func (p PersistenceORM) countFilterResults(request FilterRequest, filterMetadata *tags.TableMetadata) (uint64, error) {
+	sql, tbl, _, err := p.buildFilterSQL(request, filterMetadata)
+	if err != nil {
+		return 0, err
+	}
+	if tbl == nil {
+		return 0, nil
+	}
+	var count uint64
+	countSQL := sq.Select("COUNT(*)").
+		PlaceholderFormat(sq.Dollar).
+		FromSelect(sql, "filtered")
+	err = countSQL.
+		RunWith(request.Runner).
+		QueryRow().
+		Scan(&count)
+	if err != nil {
+		return 0, newReadQueryError(err, countSQL)
+	}
+	return count, nil
+}
+
+// getFilterMetadata returns the metadata of the filter model after validating the request against it
+func getFilterMetadata(request FilterRequest) (*tags.TableMetadata, error) {
+	filterModelType, err := stringutil.GetFilterType(request.FilterModel)
+	if err != nil {
+		return nil, err
+	}
+
+	if filterModelType.Kind() != reflect.Struct {
+		return nil, errors.New("filter type is not a struct")
+	}
+
+	filterMetadata := tags.TableMetadataFromType(filterModelType)
+
+	if err := validateDistinctOn(request, filterMetadata); err != nil {
 		return nil, err
 	}
-	tblAlias := tbl.Alias
-	aliasMap := tbl.FieldAliases()
-	return query.Hydrate(filterModel, tblAlias, aliasMap, rows, filterMetadata)
+	return filterMetadata, nil
 }
 
-func (p PersistenceORM) getFilterResults(request FilterRequest, filterMetadata *tags.TableMetadata) ([]*reflect.Value, error) {
-	filterModel := request.FilterModel
-	modelVal := reflect.ValueOf(filterModel)
-	modelKind := modelVal.Kind()
-	if modelKind == reflect.Struct {
-		return p.getSingleFilterResults(request, filterMetadata)
-	} else if modelKind == reflect.Slice {
-		return p.getMultiFilterResults(request, filterMetadata)
-	} else if modelKind == reflect.Ptr {
-		request.FilterModel = modelVal.Elem().Interface()
-		return p.getFilterResults(request, filterMetadata)
+/*
+FilterModelSQL returns the SQL and arguments FilterModel would run to find the top-level models of the
+request, without running it. Associated children are loaded with separate queries based on the results, so
+they are not included. An empty string is returned when the request filters on an empty slice, since
+FilterModel runs no query for it.
+*/
+func (p PersistenceORM) FilterModelSQL(request FilterRequest) (string, []interface{}, error) {
+	filterMetadata, err := getFilterMetadata(request)
+	if err != nil {
+		return "", nil, err
+	}
+
+	associations, err := sortAssociations(request.Associations)
+	if err != nil {
+		return "", nil, err
+	}
+	request.SelectFields, _ = withGroupingFields(request.SelectFields, parentGroupingFields(associations, filterMetadata))
+
+	sql, tbl, _, err := p.buildFilterSelect(request, filterMetadata)
+	if err != nil || tbl == nil {
+		return "", nil, err
+	}
+	return sql.ToSql()
+}
+
+/*
+FilterModelOne returns the one model that matches the request. It returns ModelNotFoundError when no model
+matches, and MultipleModelsFoundError when more than one does. Requests without a Limit only read the first
+two matches.
+
+Example:
+
+	result, err := p.FilterModelOne(picard.FilterRequest{
+		FilterModel: TableA{
+			FieldA: "foo",
+		},
+	})
+	if err == picard.ModelNotFoundError {
+		// Handle the missing model
+	}
+	model := result.(TableA)
+*/
+func (p PersistenceORM) FilterModelOne(request FilterRequest) (interface{}, error) {
+	if request.Limit == 0 {
+		request.Limit = 2
+	}
+	results, err := p.FilterModel(request)
+	if err != nil {
+		return nil, err
+	}
+	switch len(results) {
+	case 0:
+		return nil, ModelNotFoundError
+	case 1:
+		return results[0], nil
+	default:
+		return nil, MultipleModelsFoundError
 	}
-	return nil, fmt.Errorf("filter must be a struct, a slice of structs, or a pointer to a struct or slice of structs")
 }
 
 // FilterModel returns models that match the provided struct, ignoring zero values.
 func (p PersistenceORM) FilterModel(request FilterRequest) ([]interface{}, error) {
-	filterModel := request.FilterModel
+	// Only FilterModelPaginated has somewhere to return the total
+	request.WithTotalCount = false
+	results, _, err := p.filterModelWithTotal(request)
+	return results, err
+}
+
+// filterModelWithTotal returns the models that match the request, and the total number of matches if the request
+// counts them with a window function
+func (p PersistenceORM) filterModelWithTotal(request FilterRequest) ([]interface{}, uint64, error) {
 	associations := request.Associations
+	if request.ForUpdate {
+		if _, ok := request.Runner.(*sql.Tx); !ok {
+			return nil, 0, errors.New("ForUpdate requires a transaction Runner")
+		}
+	}
 	if request.Runner == nil {
-		request.Runner = GetConnection()
+		request.Runner = p.readRunner(request.Consistency)
 	}
 
-	filterModelType, err := stringutil.GetFilterType(filterModel)
+	filterMetadata, err := getFilterMetadata(request)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	if filterModelType.Kind() != reflect.Struct {
-		return nil, errors.New("filter type is not a struct")
+	associations, err = sortAssociations(associations)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	filterMetadata := tags.TableMetadataFromType(filterModelType)
+	// Columns needed to attach children are queried even when they weren't selected, and cleared afterwards
+	var groupingFields []string
+	request.SelectFields, groupingFields = withGroupingFields(request.SelectFields, parentGroupingFields(associations, filterMetadata))
 
-	results, err := p.getFilterResults(request, filterMetadata)
+	results, total, err := p.getFilterResults(request, filterMetadata)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	for _, association := range associations {
 		child := filterMetadata.GetChildField(association.Name)
-		if child != nil {
+		if child != nil && child.Junction != nil {
+			if association.Limit > 0 {
+				return nil, 0, fmt.Errorf("association '%s' can't have a Limit, since its children are loaded through a junction table", association.Name)
+			}
+			if err := p.populateJunctionChildren(request, results, association, child, filterMetadata); err != nil {
+				return nil, 0, err
+			}
+		} else if child != nil {
+			if err := checkOrderedField(request.FilterModel, child); err != nil {
+				return nil, 0, err
+			}
 			childType := child.FieldType.Elem()
 			childMetadata := tags.TableMetadataFromType(childType)
 			foreignKey := childMetadata.GetForeignKeyField(child.ForeignKey)
 			newFilterList := reflect.Indirect(reflect.New(reflect.SliceOf(childType)))
+			var tuples *groupingTuples
 			if foreignKey != nil {
 				for _, result := range results {
 					newFilter := reflect.Indirect(reflect.New(childType))
 					pkval := getValueFromLookupString(*result, childMetadata.GetPrimaryKeyFieldName())
 
 					if !pkval.IsValid() {
-						return nil, fmt.Errorf("missing 'primary_key' tag on type '%v'", result.Type().Name())
+						return nil, 0, fmt.Errorf("missing 'primary_key' tag on type '%v'", result.Type().Name())
 					}
 
 					if fmf := newFilter.FieldByName(foreignKey.FieldName); fmf.CanSet() {
 						fmf.Set(pkval)
 					} else {
-						return nil, fmt.Errorf("'foreign_key' field '%s' on 'child' type '%v' is not settable", foreignKey.FieldName, newFilter.Type())
+						return nil, 0, fmt.Errorf("'foreign_key' field '%s' on 'child' type '%v' is not settable", foreignKey.FieldName, newFilter.Type())
 					}
 					newFilterList = reflect.Append(newFilterList, newFilter)
 				}
+			} else if len(child.GroupingCriteria) > 1 {
+				// A composite grouping_criteria is matched as a tuple, with a row of values for each parent
+				tuples = &groupingTuples{fields: child.GroupingFields}
+				for _, result := range results {
+					row := make([]interface{}, 0, len(tuples.fields))
+					for _, childMatchKey := range tuples.fields {
+						parentValue := getValueFromLookupString(*result, child.GroupingCriteria[childMatchKey])
+						if !parentValue.IsValid() {
+							return nil, 0, fmt.Errorf("missing 'grouping_criteria' value on type '%v'", result.Type().Name())
+						}
+						row = append(row, parentValue.Interface())
+					}
+					tuples.values = append(tuples.values, row)
+				}
 			} else if child.GroupingCriteria != nil {
 				// By default, we take the primary key from the parent and add it as a filter condition on the
 				// foreign key field from the child. However, this adds special funcitonality that maps a set
@@ -269,47 +969,213 @@ func (p PersistenceORM) FilterModel(request FilterRequest) ([]interface{}, error
 					for childMatchKey, parentMatchKey := range child.GroupingCriteria {
 						parentValue := getValueFromLookupString(*result, parentMatchKey)
 						if !parentValue.IsValid() {
-							return nil, fmt.Errorf("missing 'grouping_criteria' value on type '%v'", result.Type().Name())
+							return nil, 0, fmt.Errorf("missing 'grouping_criteria' value on type '%v'", result.Type().Name())
 						}
 
 						childValue := getValueFromLookupString(newFilter, childMatchKey)
 						if fmf := childValue; fmf.CanSet() {
 							fmf.Set(parentValue)
 						} else {
-							return nil, fmt.Errorf("'grouping_criteria' field '%s' on 'child' type '%v' is not settable", childMatchKey, newFilter.Type())
+							return nil, 0, fmt.Errorf("'grouping_criteria' field '%s' on 'child' type '%v' is not settable", childMatchKey, newFilter.Type())
 						}
 					}
 
 					newFilterList = reflect.Append(newFilterList, newFilter)
 				}
 			} else {
-				return nil, fmt.Errorf("missing 'foreign_key' tag or 'grouping_criteria' on child '%s' of type '%v'", association.Name, childType.Name())
+				return nil, 0, fmt.Errorf("missing 'foreign_key' tag or 'grouping_criteria' on child '%s' of type '%v'", association.Name, childType.Name())
 			}
 
+			childFilterModel := newFilterList.Interface()
+			if tuples != nil {
+				childFilterModel = reflect.Zero(childType).Interface()
+			}
+
+			childSelectFields, childGroupingFields := withGroupingFields(association.SelectFields, childGroupingFieldNames(child))
 			childResults, err := p.FilterModel(FilterRequest{
-				FilterModel:  newFilterList.Interface(),
-				Associations: association.Associations,
-				OrderBy:      association.OrderBy,
-				Runner:       request.Runner,
-				FieldFilters: association.FieldFilters,
-				SelectFields: association.SelectFields,
+				FilterModel:    childFilterModel,
+				Associations:   association.Associations,
+				OrderBy:        association.OrderBy,
+				Runner:         request.Runner,
+				FieldFilters:   association.FieldFilters,
+				SelectFields:   childSelectFields,
+				SkipDecryption: request.SkipDecryption,
+				DecryptFields:  associationDecryptFields(request.DecryptFields, association.Name),
+				IncludeDeleted: request.IncludeDeleted,
+				groupingTuples: tuples,
+				limitPerParent: childLimitPerParent(association, child),
 			})
 			if err != nil {
-				return nil, err
+				return nil, 0, err
 			}
-			populateChildResults(results, childResults, child, filterMetadata)
+			populateChildResults(results, childResults, child, filterMetadata, childGroupingFields)
 		}
 	}
 
 	ir := make([]interface{}, 0, len(results))
 	for _, r := range results {
-		ir = append(ir, r.Interface())
+		ir = append(ir, clearFields(*r, groupingFields).Interface())
+	}
+
+	return ir, total, nil
+}
+
+// checkOrderedField makes sure the field named by a child's ordered_field tag can hold its children in order
+func checkOrderedField(filterModel interface{}, child *tags.Child) error {
+	if child.OrderedField == "" {
+		return nil
+	}
+	if child.FieldKind != reflect.Map {
+		return fmt.Errorf("child '%s' can't have an ordered_field, since it isn't a map", child.FieldName)
+	}
+	filterModelType, err := stringutil.GetFilterType(filterModel)
+	if err != nil {
+		return err
+	}
+	orderedType := reflect.SliceOf(child.FieldType.Elem())
+	field, ok := filterModelType.FieldByName(child.OrderedField)
+	if !ok || field.Type != orderedType {
+		return fmt.Errorf("ordered_field '%s' of child '%s' must be a field of type %v", child.OrderedField, child.FieldName, orderedType)
+	}
+	return nil
+}
+
+// sortAssociations orders associations so that each one comes after the associations named in its
+// DependsOn. Associations without dependencies keep the order they were provided in.
+func sortAssociations(associations []tags.Association) ([]tags.Association, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	hasDependencies := false
+	byName := make(map[string]tags.Association, len(associations))
+	for _, association := range associations {
+		byName[association.Name] = association
+		hasDependencies = hasDependencies || len(association.DependsOn) > 0
+	}
+
+	if !hasDependencies {
+		return associations, nil
+	}
+
+	sorted := make([]tags.Association, 0, len(associations))
+	state := make(map[string]int, len(associations))
+
+	var visit func(association tags.Association) error
+	visit = func(association tags.Association) error {
+		switch state[association.Name] {
+		case visiting:
+			return fmt.Errorf("circular dependency in associations involving '%s'", association.Name)
+		case visited:
+			return nil
+		}
+
+		state[association.Name] = visiting
+		for _, dependencyName := range association.DependsOn {
+			dependency, ok := byName[dependencyName]
+			if !ok {
+				return fmt.Errorf("association '%s' depends on '%s', which is not in the request", association.Name, dependencyName)
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[association.Name] = visited
+
+		sorted = append(sorted, association)
+		return nil
+	}
+
+	for _, association := range associations {
+		if err := visit(association); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// withGroupingFields returns the select fields with the grouping fields they are missing, along with the
+// grouping fields that were added. Nil select fields already select every field, so they are left as is.
+func withGroupingFields(selectFields []string, groupingFields []string) ([]string, []string) {
+	if selectFields == nil {
+		return nil, nil
+	}
+	var added []string
+	for _, fieldName := range groupingFields {
+		// Grouping criteria on related models can't be selected on this model
+		if fieldName == "" || strings.Contains(fieldName, ".") {
+			continue
+		}
+		if stringutil.StringSliceContainsKey(selectFields, fieldName) || stringutil.StringSliceContainsKey(added, fieldName) {
+			continue
+		}
+		added = append(added, fieldName)
+	}
+	if len(added) == 0 {
+		return selectFields, nil
 	}
+	return append(append([]string{}, selectFields...), added...), added
+}
+
+// parentGroupingFields returns the fields of the filter model that children are attached by
+func parentGroupingFields(associations []tags.Association, filterMetadata *tags.TableMetadata) []string {
+	fieldNames := []string{}
+	for _, association := range associations {
+		child := filterMetadata.GetChildField(association.Name)
+		if child == nil {
+			continue
+		}
+		if child.GroupingCriteria != nil && child.Junction == nil {
+			for _, parentMatchKey := range child.GroupingCriteria {
+				fieldNames = append(fieldNames, parentMatchKey)
+			}
+			continue
+		}
+		fieldNames = append(fieldNames, filterMetadata.GetPrimaryKeyFieldName())
+	}
+	return fieldNames
+}
+
+// childGroupingFieldNames returns the fields of a child model that it is attached to its parent by
+func childGroupingFieldNames(child *tags.Child) []string {
+	if child.GroupingCriteria == nil {
+		return []string{child.ForeignKey}
+	}
+	fieldNames := []string{}
+	for childMatchKey := range child.GroupingCriteria {
+		fieldNames = append(fieldNames, childMatchKey)
+	}
+	return fieldNames
+}
+
+// childLimitPerParent returns how many children of each parent to load for an association with a Limit
+func childLimitPerParent(association tags.Association, child *tags.Child) *limitPerParent {
+	if association.Limit == 0 {
+		return nil
+	}
+	if child.GroupingCriteria == nil {
+		return &limitPerParent{fields: []string{child.ForeignKey}, limit: association.Limit}
+	}
+	return &limitPerParent{fields: child.GroupingFields, limit: association.Limit}
+}
 
-	return ir, nil
+// clearFields returns a copy of a model with the fields set to their zero values
+func clearFields(value reflect.Value, fieldNames []string) reflect.Value {
+	if len(fieldNames) == 0 {
+		return value
+	}
+	cleared := reflect.New(value.Type()).Elem()
+	cleared.Set(value)
+	for _, fieldName := range fieldNames {
+		field := cleared.FieldByName(fieldName)
+		field.Set(reflect.Zero(field.Type()))
+	}
+	return cleared
 }
 
-func populateChildResults(results []*reflect.Value, childResults []interface{}, child *tags.Child, filterMetadata *tags.TableMetadata) {
+func populateChildResults(results []*reflect.Value, childResults []interface{}, child *tags.Child, filterMetadata *tags.TableMetadata, groupingFields []string) {
 	var parentGroupingCriteria []string
 	var childGroupingCriteria []string
 	if child.GroupingCriteria != nil {
@@ -352,6 +1218,7 @@ func populateChildResults(results []*reflect.Value, childResults []interface{},
 				parentMatchValues = append(parentMatchValues, parentValue.FieldByName(filterMetadata.GetPrimaryKeyFieldName()))
 			}
 			if parentMatchesChild(childMatchValues, parentMatchValues) {
+				childValue := clearFields(childValue, groupingFields)
 				parentChildRelField := parentValue.FieldByName(child.FieldName)
 				if child.FieldKind == reflect.Slice {
 					parentChildRelField.Set(reflect.Append(parentChildRelField, childValue))
@@ -361,6 +1228,11 @@ func populateChildResults(results []*reflect.Value, childResults []interface{},
 					}
 					keyMappingValue := getValueFromLookupString(childValue, child.KeyMapping)
 					parentChildRelField.SetMapIndex(keyMappingValue, childValue)
+					// The child results come in the association's order, which the map can't keep
+					if child.OrderedField != "" {
+						orderedField := parentValue.FieldByName(child.OrderedField)
+						orderedField.Set(reflect.Append(orderedField, childValue))
+					}
 				}
 				break
 			}
@@ -1,9 +1,17 @@
 package picard
 
 import (
+	"database/sql/driver"
+	"encoding/base64"
+	"reflect"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/crypto"
+	"github.com/skuid/picard/metadata"
 	qp "github.com/skuid/picard/queryparts"
 	"github.com/skuid/picard/tags"
 	"github.com/skuid/picard/testdata"
@@ -1555,48 +1563,173 @@ func TestFilterModel(t *testing.T) {
 			},
 		},
 		{
-			"filter request with additional field filters item",
+			"associations load after the associations they depend on",
 			FilterRequest{
-				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.FieldFilter{
-					FieldName:   "Name",
-					FilterValue: "Lego",
+				FilterModel: testdata.ParentModel{},
+				Associations: []tags.Association{
+					{
+						Name:      "Children",
+						DependsOn: []string{"Animals"},
+						OrderBy: []qp.OrderByRequest{
+							{
+								Field:      "Name",
+								Descending: true,
+							},
+						},
+					},
+					{
+						Name: "Animals",
+						OrderBy: []qp.OrderByRequest{
+							{
+								Field: "Name",
+							},
+						},
+					},
+				},
+				OrderBy: []qp.OrderByRequest{
+					{
+						Field: "Name",
+					},
+				},
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					ID:             parentID,
+					OrganizationID: orgID,
+					Name:           "pops",
+					ParentID:       "00000000-0000-0000-0000-000000000004",
+					Children: []testdata.ChildModel{
+						{
+							ID:             "00000000-0000-0000-0000-000000000012",
+							OrganizationID: orgID,
+							Name:           "Betty",
+							ParentID:       parentID,
+						},
+						{
+							ID:             "00000000-0000-0000-0000-000000000011",
+							OrganizationID: orgID,
+							Name:           "Alex",
+							ParentID:       parentID,
+						},
+					},
+					Animals: []testdata.PetModel{
+						{
+							ID:             "00000000-0000-0000-0000-000000000031",
+							OrganizationID: orgID,
+							Name:           "Cheerios",
+							ParentID:       parentID,
+						},
+						{
+							ID:             "00000000-0000-0000-0000-000000000032",
+							OrganizationID: orgID,
+							Name:           "Pinkerton",
+							ParentID:       parentID,
+						},
+					},
 				},
 			},
-			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				// parent query
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
 					SELECT
 						t0.id AS "t0.id",
 						t0.organization_id AS "t0.organization_id",
 						t0.name AS "t0.name",
-						t0.parent_id AS "t0.parent_id"
-					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND t0.name = $2
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1
+					ORDER BY t0.name
 				`)).
-					WithArgs(orgID, "Lego").
+					WithArgs(orgID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
-						}),
+						}).AddRow(
+							parentID,
+							orgID,
+							"pops",
+							"00000000-0000-0000-0000-000000000004",
+						),
+					)
+				// Pets/Animals
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+						SELECT
+							t0.id AS "t0.id",
+							t0.organization_id AS "t0.organization_id",
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id"
+						FROM petmodel AS t0
+						WHERE t0.organization_id = $1 AND ((t0.parent_id = $2))
+						ORDER BY t0.name
+					`)).
+					WithArgs(orgID, parentID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000031",
+								orgID,
+								"Cheerios",
+								parentID,
+							).
+							AddRow(
+								"00000000-0000-0000-0000-000000000032",
+								orgID,
+								"Pinkerton",
+								parentID,
+							),
+					)
+				// children
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+						SELECT
+							t0.id AS "t0.id",
+							t0.organization_id AS "t0.organization_id",
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id"
+						FROM childmodel AS t0
+						WHERE t0.organization_id = $1 AND ((t0.parent_id = $2))
+						ORDER BY t0.name DESC
+					`)).
+					WithArgs(orgID, parentID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000012",
+								orgID,
+								"Betty",
+								parentID,
+							).
+							AddRow(
+								"00000000-0000-0000-0000-000000000011",
+								orgID,
+								"Alex",
+								parentID,
+							),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"filter request with additional field filters item - or group - single item",
+			"filter request with row locking",
 			FilterRequest{
-				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.OrFilterGroup{
-					tags.FieldFilter{
-						FieldName:   "Name",
-						FilterValue: "Lego",
-					},
+				FilterModel: testdata.ToyModel{
+					ParentID: parentID,
 				},
+				ForUpdate: true,
 			},
 			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
@@ -1608,9 +1741,10 @@ func TestFilterModel(t *testing.T) {
 						t0.name AS "t0.name",
 						t0.parent_id AS "t0.parent_id"
 					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND (t0.name = $2)
+					WHERE t0.organization_id = $1 AND t0.parent_id = $2
+					FOR UPDATE OF t0
 				`)).
-					WithArgs(orgID, "Lego").
+					WithArgs(orgID, parentID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
@@ -1623,132 +1757,197 @@ func TestFilterModel(t *testing.T) {
 			},
 		},
 		{
-			"filter request with additional field filters item - and group - single item",
+			"filter request with row locking skips locked rows and does not lock associations",
 			FilterRequest{
-				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.AndFilterGroup{
-					tags.FieldFilter{
-						FieldName:   "Name",
-						FilterValue: "Lego",
+				FilterModel: testdata.ParentModel{},
+				Associations: []tags.Association{
+					{
+						Name: "Children",
+					},
+				},
+				ForUpdate:  true,
+				SkipLocked: true,
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					ID:             parentID,
+					OrganizationID: orgID,
+					Name:           "pops",
+					Children: []testdata.ChildModel{
+						{
+							ID:             "00000000-0000-0000-0000-000000000011",
+							OrganizationID: orgID,
+							Name:           "kiddo",
+							ParentID:       parentID,
+						},
 					},
 				},
 			},
-			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1
+					FOR UPDATE OF t0 SKIP LOCKED
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+						}).AddRow(
+							parentID,
+							orgID,
+							"pops",
+						),
+					)
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
 					SELECT
 						t0.id AS "t0.id",
 						t0.organization_id AS "t0.organization_id",
 						t0.name AS "t0.name",
 						t0.parent_id AS "t0.parent_id"
-					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND (t0.name = $2)
+					FROM childmodel AS t0
+					WHERE t0.organization_id = $1 AND ((t0.parent_id = $2))
 				`)).
-					WithArgs(orgID, "Lego").
+					WithArgs(orgID, parentID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
-						}),
+						}).AddRow(
+							"00000000-0000-0000-0000-000000000011",
+							orgID,
+							"kiddo",
+							parentID,
+						),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"filter request with additional field filters item - or group - multi item",
+			"distinct on a field with a tie-break order by",
 			FilterRequest{
 				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.OrFilterGroup{
-					tags.FieldFilter{
-						FieldName:   "Name",
-						FilterValue: "Lego",
+				DistinctOn:  []string{"ParentID"},
+				OrderBy: []qp.OrderByRequest{
+					{
+						Field: "ParentID",
 					},
-					tags.FieldFilter{
-						FieldName:   "Name",
-						FilterValue: "Lego2",
+					{
+						Field:      "Name",
+						Descending: true,
 					},
 				},
 			},
-			[]interface{}{},
+			[]interface{}{
+				testdata.ToyModel{
+					ID:             "00000000-0000-0000-0000-000000000011",
+					OrganizationID: orgID,
+					Name:           "yoyo",
+					ParentID:       parentID,
+				},
+				testdata.ToyModel{
+					ID:             "00000000-0000-0000-0000-000000000012",
+					OrganizationID: orgID,
+					Name:           "kite",
+					ParentID:       "00000000-0000-0000-0000-000000000003",
+				},
+			},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
-					SELECT
+					SELECT DISTINCT ON (t0.parent_id)
 						t0.id AS "t0.id",
 						t0.organization_id AS "t0.organization_id",
 						t0.name AS "t0.name",
 						t0.parent_id AS "t0.parent_id"
 					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND (t0.name = $2 OR t0.name = $3)
+					WHERE t0.organization_id = $1
+					ORDER BY t0.parent_id, t0.name DESC
 				`)).
-					WithArgs(orgID, "Lego", "Lego2").
+					WithArgs(orgID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
-						}),
+						}).
+							AddRow("00000000-0000-0000-0000-000000000011", orgID, "yoyo", parentID).
+							AddRow("00000000-0000-0000-0000-000000000012", orgID, "kite", "00000000-0000-0000-0000-000000000003"),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"filter request with additional field filters item - and group - multi item",
+			"distinct across all model columns",
 			FilterRequest{
-				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.AndFilterGroup{
-					tags.FieldFilter{
-						FieldName:   "Name",
-						FilterValue: "Lego",
-					},
-					tags.FieldFilter{
-						FieldName:   "Name",
-						FilterValue: "Lego2",
-					},
+				FilterModel: testdata.ToyModel{
+					Name: "yoyo",
+				},
+				Distinct: true,
+			},
+			[]interface{}{
+				testdata.ToyModel{
+					ID:             "00000000-0000-0000-0000-000000000011",
+					OrganizationID: orgID,
+					Name:           "yoyo",
+					ParentID:       parentID,
 				},
 			},
-			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
-					SELECT
+					SELECT DISTINCT
 						t0.id AS "t0.id",
 						t0.organization_id AS "t0.organization_id",
 						t0.name AS "t0.name",
 						t0.parent_id AS "t0.parent_id"
 					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND (t0.name = $2 AND t0.name = $3)
+					WHERE t0.organization_id = $1 AND t0.name = $2
 				`)).
-					WithArgs(orgID, "Lego", "Lego2").
+					WithArgs(orgID, "yoyo").
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
-						}),
+						}).
+							AddRow("00000000-0000-0000-0000-000000000011", orgID, "yoyo", parentID),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"filter request with zero value filter should not bomb",
+			"distinct across select fields",
 			FilterRequest{
 				FilterModel:  testdata.ToyModel{},
-				FieldFilters: tags.FieldFilter{},
+				SelectFields: []string{"ParentID"},
+				Distinct:     true,
+			},
+			[]interface{}{
+				testdata.ToyModel{
+					ParentID: parentID,
+				},
+				testdata.ToyModel{
+					ParentID: "00000000-0000-0000-0000-000000000003",
+				},
 			},
-			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
-					SELECT
-						t0.id AS "t0.id",
-						t0.organization_id AS "t0.organization_id",
-						t0.name AS "t0.name",
+					SELECT DISTINCT
 						t0.parent_id AS "t0.parent_id"
 					FROM toymodel AS t0
 					WHERE t0.organization_id = $1
@@ -1756,25 +1955,47 @@ func TestFilterModel(t *testing.T) {
 					WithArgs(orgID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
-							"t0.id",
-							"t0.organization_id",
-							"t0.name",
 							"t0.parent_id",
-						}),
+						}).
+							AddRow(parentID).
+							AddRow("00000000-0000-0000-0000-000000000003"),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"filter request with additional field filters array",
+			"order by a field of an eager loaded parent",
 			FilterRequest{
-				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.FieldFilter{
-					FieldName:   "Name",
-					FilterValue: []string{"Lego", "Matchbox Car", "Nintendo"},
+				FilterModel: testdata.ParentModel{},
+				Associations: []tags.Association{
+					{
+						Name:         "GrandParent",
+						SelectFields: []string{"ID", "Name"},
+					},
+				},
+				OrderBy: []qp.OrderByRequest{
+					{
+						Association: "GrandParent",
+						Field:       "Name",
+						Descending:  true,
+					},
+					{
+						Field: "Name",
+					},
+				},
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					Name:           "pops",
+					ParentID:       "00000000-0000-0000-0000-000000000023",
+					GrandParent: testdata.GrandParentModel{
+						ID:   "00000000-0000-0000-0000-000000000023",
+						Name: "grandpops",
+					},
 				},
 			},
-			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
@@ -1782,60 +2003,109 @@ func TestFilterModel(t *testing.T) {
 						t0.id AS "t0.id",
 						t0.organization_id AS "t0.organization_id",
 						t0.name AS "t0.name",
-						t0.parent_id AS "t0.parent_id"
-					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND t0.name IN ($2,$3,$4)
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id",
+						t1.id AS "t1.id",
+						t1.name AS "t1.name"
+					FROM parentmodel AS t0
+					LEFT JOIN grandparentmodel AS t1 ON
+						(t1.id = t0.parent_id AND t1.organization_id = $1)
+					WHERE
+						t0.organization_id = $2
+					ORDER BY t1.name DESC, t0.name
 				`)).
-					WithArgs(orgID, "Lego", "Matchbox Car", "Nintendo").
+					WithArgs(orgID, orgID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
-						}),
+							"t1.id",
+							"t1.name",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000002",
+								orgID,
+								"pops",
+								"00000000-0000-0000-0000-000000000023",
+								"00000000-0000-0000-0000-000000000023",
+								"grandpops",
+							),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"filter request with additional field filters array and select fields specified",
+			"distinct with an eager loaded parent",
 			FilterRequest{
-				FilterModel: testdata.ToyModel{},
-				FieldFilters: tags.FieldFilter{
-					FieldName:   "Name",
-					FilterValue: []string{"Lego", "Matchbox Car", "Nintendo"},
+				FilterModel: testdata.ParentModel{
+					Name: "pops",
+				},
+				Associations: []tags.Association{
+					{
+						Name:         "GrandParent",
+						SelectFields: []string{"ID", "Name"},
+					},
+				},
+				Distinct: true,
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					Name:           "pops",
+					ParentID:       "00000000-0000-0000-0000-000000000023",
+					GrandParent: testdata.GrandParentModel{
+						ID:   "00000000-0000-0000-0000-000000000023",
+						Name: "grandpops",
+					},
 				},
-				SelectFields: []string{"ID", "Name"},
 			},
-			[]interface{}{},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
-					SELECT
+					SELECT DISTINCT
 						t0.id AS "t0.id",
-						t0.name AS "t0.name"
-					FROM toymodel AS t0
-					WHERE t0.organization_id = $1 AND t0.name IN ($2,$3,$4)
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id",
+						t1.id AS "t1.id",
+						t1.name AS "t1.name"
+					FROM parentmodel AS t0
+					LEFT JOIN grandparentmodel AS t1 ON
+						(t1.id = t0.parent_id AND t1.organization_id = $1)
+					WHERE
+						t0.organization_id = $2 AND
+						t0.name = $3
 				`)).
-					WithArgs(orgID, "Lego", "Matchbox Car", "Nintendo").
+					WithArgs(orgID, orgID, "pops").
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
-						}),
+							"t1.id",
+							"t1.name",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000002",
+								orgID,
+								"pops",
+								"00000000-0000-0000-0000-000000000023",
+								"00000000-0000-0000-0000-000000000023",
+								"grandpops",
+							),
 					)
 				mock.ExpectCommit()
 			},
 		},
 		{
-			"happy path for single parent filter with eager loading parent - also add selectfields and field filter on association",
+			"eager loaded parent filtered in the where clause leaves out rows without a match",
 			FilterRequest{
-				FilterModel: testdata.ParentModel{
-					Name: "pops",
-				},
+				FilterModel: testdata.ParentModel{},
 				Associations: []tags.Association{
 					{
 						Name:         "GrandParent",
@@ -1875,10 +2145,9 @@ func TestFilterModel(t *testing.T) {
 						(t1.id = t0.parent_id AND t1.organization_id = $1)
 					WHERE
 						t0.organization_id = $2 AND
-						t0.name = $3 AND
-						t1.name = $4
+						t1.name = $3
 				`)).
-					WithArgs(orgID, orgID, "pops", "grandpops").
+					WithArgs(orgID, orgID, "grandpops").
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
@@ -1901,18 +2170,17 @@ func TestFilterModel(t *testing.T) {
 			},
 		},
 		{
-			"happy path for filtering children with selectfields and fieldfilters",
+			"eager loaded parent filtered in the join keeps rows without a match",
 			FilterRequest{
-				FilterModel: testdata.ParentModel{
-					Name: "pops",
-				},
+				FilterModel: testdata.ParentModel{},
 				Associations: []tags.Association{
 					{
-						Name:         "Children",
-						SelectFields: []string{"ID", "Name", "ParentID"},
+						Name:            "GrandParent",
+						SelectFields:    []string{"ID", "Name"},
+						FilterPlacement: tags.FilterInJoin,
 						FieldFilters: tags.FieldFilter{
 							FieldName:   "Name",
-							FilterValue: []string{"kiddo", "another_kid"},
+							FilterValue: "grandpops",
 						},
 					},
 				},
@@ -1922,114 +2190,2952 @@ func TestFilterModel(t *testing.T) {
 					ID:             "00000000-0000-0000-0000-000000000002",
 					OrganizationID: orgID,
 					Name:           "pops",
-					ParentID:       "00000000-0000-0000-0000-000000000004",
-					Children: []testdata.ChildModel{
-						{
-							ID:       "00000000-0000-0000-0000-000000000011",
-							Name:     "kiddo",
-							ParentID: "00000000-0000-0000-0000-000000000002",
-						},
-						{
-							ID:       "00000000-0000-0000-0000-000000000012",
-							Name:     "another_kid",
-							ParentID: "00000000-0000-0000-0000-000000000002",
-						},
+					ParentID:       "00000000-0000-0000-0000-000000000023",
+					GrandParent: testdata.GrandParentModel{
+						ID:   "00000000-0000-0000-0000-000000000023",
+						Name: "grandpops",
 					},
 				},
+				testdata.ParentModel{
+					ID:             "00000000-0000-0000-0000-000000000003",
+					OrganizationID: orgID,
+					Name:           "mom",
+					ParentID:       "00000000-0000-0000-0000-000000000024",
+				},
 			},
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(testdata.FmtSQLRegex(`
-						SELECT
-							t0.id AS "t0.id",
-							t0.organization_id AS "t0.organization_id",
-							t0.name AS "t0.name",
-							t0.parent_id AS "t0.parent_id",
-							t0.other_parent_id AS "t0.other_parent_id"
-						FROM parentmodel AS t0
-						WHERE t0.organization_id = $1 AND t0.name = $2
-					`)).
-					WithArgs(orgID, "pops").
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id",
+						t1.id AS "t1.id",
+						t1.name AS "t1.name"
+					FROM parentmodel AS t0
+					LEFT JOIN grandparentmodel AS t1 ON
+						(t1.id = t0.parent_id AND t1.organization_id = $1 AND t1.name = $2)
+					WHERE
+						t0.organization_id = $3
+				`)).
+					WithArgs(orgID, "grandpops", orgID).
 					WillReturnRows(
 						sqlmock.NewRows([]string{
 							"t0.id",
 							"t0.organization_id",
 							"t0.name",
 							"t0.parent_id",
+							"t1.id",
+							"t1.name",
 						}).
 							AddRow(
 								"00000000-0000-0000-0000-000000000002",
 								orgID,
 								"pops",
-								"00000000-0000-0000-0000-000000000004",
-							),
-					)
-
-				// parent is vtestdata.ParentModel
-				mock.ExpectQuery(testdata.FmtSQLRegex(`
-						SELECT
-							t0.id AS "t0.id",
-							t0.name AS "t0.name",
-							t0.parent_id AS "t0.parent_id"
-						FROM childmodel AS t0
-						WHERE
-							t0.organization_id = $1 AND ((t0.parent_id = $2 AND t0.name IN ($3,$4)))
-					`)).
-					WithArgs(orgID, "00000000-0000-0000-0000-000000000002", "kiddo", "another_kid").
-					WillReturnRows(
-						sqlmock.NewRows([]string{
-							"t0.id",
-							"t0.name",
-							"t0.parent_id",
-						}).
-							AddRow(
-								"00000000-0000-0000-0000-000000000011",
-								"kiddo",
-								"00000000-0000-0000-0000-000000000002",
+								"00000000-0000-0000-0000-000000000023",
+								"00000000-0000-0000-0000-000000000023",
+								"grandpops",
 							).
 							AddRow(
-								"00000000-0000-0000-0000-000000000012",
-								"another_kid",
-								"00000000-0000-0000-0000-000000000002",
+								"00000000-0000-0000-0000-000000000003",
+								orgID,
+								"mom",
+								"00000000-0000-0000-0000-000000000024",
+								nil,
+								nil,
 							),
 					)
 				mock.ExpectCommit()
 			},
 		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.description, func(t *testing.T) {
-			db, mock, err := sqlmock.New()
-			if err != nil {
+		{
+			"filter request with additional field filters item",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Name",
+					FilterValue: "Lego",
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND t0.name = $2
+				`)).
+					WithArgs(orgID, "Lego").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with a child aggregate filter",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{},
+				FieldFilters: tags.ChildAggregateFilter{
+					ChildName: "Children",
+					FieldFilters: tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "kiddo",
+					},
+					FilterOperator: ">=",
+					FilterValue:    3,
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1 AND
+						(SELECT COUNT(*) FROM childmodel AS t0_children
+						WHERE t0_children.parent_id = t0.id AND
+							t0_children.organization_id = $2 AND
+							t0_children.name = $3) >= $4
+				`)).
+					WithArgs(orgID, orgID, "kiddo", 3).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with a child exists filter",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{},
+				FieldFilters: tags.ChildExistsFilter{
+					ChildName: "Animals",
+					FieldFilters: tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "spot",
+					},
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1 AND
+						EXISTS (SELECT 1 FROM petmodel AS t0_animals
+						WHERE t0_animals.parent_id = t0.id AND
+							t0_animals.organization_id = $2 AND
+							t0_animals.name = $3)
+				`)).
+					WithArgs(orgID, orgID, "spot").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with a child exists filter for any child",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{},
+				FieldFilters: tags.ChildExistsFilter{
+					ChildName: "Animals",
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1 AND
+						EXISTS (SELECT 1 FROM petmodel AS t0_animals
+						WHERE t0_animals.parent_id = t0.id AND
+							t0_animals.organization_id = $2)
+				`)).
+					WithArgs(orgID, orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with a junction exists filter",
+			FilterRequest{
+				FilterModel: testdata.PersonModel{},
+				FieldFilters: tags.JunctionExistsFilter{
+					JunctionModel:  testdata.SiblingJunctionModel{},
+					ParentKeyField: "ChildID",
+					ChildKeyField:  "SiblingID",
+					FilterValue:    "00000000-0000-0000-0000-000000000002",
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name"
+					FROM personmodel AS t0
+					WHERE t0.organization_id = $1 AND
+						EXISTS (SELECT 1 FROM siblingjunction AS t0_siblingjunction
+						WHERE t0_siblingjunction.child_id = t0.id AND
+							t0_siblingjunction.sibling_id = $2 AND
+							t0_siblingjunction.organization_id = $3)
+				`)).
+					WithArgs(orgID, "00000000-0000-0000-0000-000000000002", orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with additional field filters item - or group - single item",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.OrFilterGroup{
+					tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "Lego",
+					},
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND (t0.name = $2)
+				`)).
+					WithArgs(orgID, "Lego").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with additional field filters item - and group - single item",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.AndFilterGroup{
+					tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "Lego",
+					},
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND (t0.name = $2)
+				`)).
+					WithArgs(orgID, "Lego").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with additional field filters item - or group - multi item",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.OrFilterGroup{
+					tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "Lego",
+					},
+					tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "Lego2",
+					},
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND (t0.name = $2 OR t0.name = $3)
+				`)).
+					WithArgs(orgID, "Lego", "Lego2").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with additional field filters item - and group - multi item",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.AndFilterGroup{
+					tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "Lego",
+					},
+					tags.FieldFilter{
+						FieldName:   "Name",
+						FilterValue: "Lego2",
+					},
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND (t0.name = $2 AND t0.name = $3)
+				`)).
+					WithArgs(orgID, "Lego", "Lego2").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with zero value filter should not bomb",
+			FilterRequest{
+				FilterModel:  testdata.ToyModel{},
+				FieldFilters: tags.FieldFilter{},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with additional field filters array",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Name",
+					FilterValue: []string{"Lego", "Matchbox Car", "Nintendo"},
+				},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND t0.name IN ($2,$3,$4)
+				`)).
+					WithArgs(orgID, "Lego", "Matchbox Car", "Nintendo").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"filter request with additional field filters array and select fields specified",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Name",
+					FilterValue: []string{"Lego", "Matchbox Car", "Nintendo"},
+				},
+				SelectFields: []string{"ID", "Name"},
+			},
+			[]interface{}{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.name AS "t0.name"
+					FROM toymodel AS t0
+					WHERE t0.organization_id = $1 AND t0.name IN ($2,$3,$4)
+				`)).
+					WithArgs(orgID, "Lego", "Matchbox Car", "Nintendo").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"happy path for single parent filter with eager loading parent - also add selectfields and field filter on association",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{
+					Name: "pops",
+				},
+				Associations: []tags.Association{
+					{
+						Name:         "GrandParent",
+						SelectFields: []string{"ID", "Name"},
+						FieldFilters: tags.FieldFilter{
+							FieldName:   "Name",
+							FilterValue: "grandpops",
+						},
+					},
+				},
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					Name:           "pops",
+					ParentID:       "00000000-0000-0000-0000-000000000023",
+					GrandParent: testdata.GrandParentModel{
+						ID:   "00000000-0000-0000-0000-000000000023",
+						Name: "grandpops",
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id",
+						t1.id AS "t1.id",
+						t1.name AS "t1.name"
+					FROM parentmodel AS t0
+					LEFT JOIN grandparentmodel AS t1 ON
+						(t1.id = t0.parent_id AND t1.organization_id = $1)
+					WHERE
+						t0.organization_id = $2 AND
+						t0.name = $3 AND
+						t1.name = $4
+				`)).
+					WithArgs(orgID, orgID, "pops", "grandpops").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+							"t1.id",
+							"t1.name",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000002",
+								orgID,
+								"pops",
+								"00000000-0000-0000-0000-000000000023",
+								"00000000-0000-0000-0000-000000000023",
+								"grandpops",
+							),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"happy path for filtering children with selectfields and fieldfilters",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{
+					Name: "pops",
+				},
+				Associations: []tags.Association{
+					{
+						Name:         "Children",
+						SelectFields: []string{"ID", "Name", "ParentID"},
+						FieldFilters: tags.FieldFilter{
+							FieldName:   "Name",
+							FilterValue: []string{"kiddo", "another_kid"},
+						},
+					},
+				},
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					Name:           "pops",
+					ParentID:       "00000000-0000-0000-0000-000000000004",
+					Children: []testdata.ChildModel{
+						{
+							ID:       "00000000-0000-0000-0000-000000000011",
+							Name:     "kiddo",
+							ParentID: "00000000-0000-0000-0000-000000000002",
+						},
+						{
+							ID:       "00000000-0000-0000-0000-000000000012",
+							Name:     "another_kid",
+							ParentID: "00000000-0000-0000-0000-000000000002",
+						},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+						SELECT
+							t0.id AS "t0.id",
+							t0.organization_id AS "t0.organization_id",
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id",
+							t0.other_parent_id AS "t0.other_parent_id"
+						FROM parentmodel AS t0
+						WHERE t0.organization_id = $1 AND t0.name = $2
+					`)).
+					WithArgs(orgID, "pops").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.organization_id",
+							"t0.name",
+							"t0.parent_id",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000002",
+								orgID,
+								"pops",
+								"00000000-0000-0000-0000-000000000004",
+							),
+					)
+
+				// parent is vtestdata.ParentModel
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+						SELECT
+							t0.id AS "t0.id",
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id"
+						FROM childmodel AS t0
+						WHERE
+							t0.organization_id = $1 AND ((t0.parent_id = $2 AND t0.name IN ($3,$4)))
+					`)).
+					WithArgs(orgID, "00000000-0000-0000-0000-000000000002", "kiddo", "another_kid").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.name",
+							"t0.parent_id",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000011",
+								"kiddo",
+								"00000000-0000-0000-0000-000000000002",
+							).
+							AddRow(
+								"00000000-0000-0000-0000-000000000012",
+								"another_kid",
+								"00000000-0000-0000-0000-000000000002",
+							),
+					)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"select fields without the keys used to attach children",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{
+					Name: "pops",
+				},
+				SelectFields: []string{"Name"},
+				Associations: []tags.Association{
+					{
+						Name:         "Children",
+						SelectFields: []string{"Name"},
+					},
+				},
+			},
+			[]interface{}{
+				testdata.ParentModel{
+					Name: "pops",
+					Children: []testdata.ChildModel{
+						{
+							Name: "kiddo",
+						},
+						{
+							Name: "another_kid",
+						},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+						SELECT
+							t0.id AS "t0.id",
+							t0.name AS "t0.name"
+						FROM parentmodel AS t0
+						WHERE t0.organization_id = $1 AND t0.name = $2
+					`)).
+					WithArgs(orgID, "pops").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.id",
+							"t0.name",
+						}).
+							AddRow(
+								"00000000-0000-0000-0000-000000000002",
+								"pops",
+							),
+					)
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+						SELECT
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id"
+						FROM childmodel AS t0
+						WHERE
+							t0.organization_id = $1 AND ((t0.parent_id = $2))
+					`)).
+					WithArgs(orgID, "00000000-0000-0000-0000-000000000002").
+					WillReturnRows(
+						sqlmock.NewRows([]string{
+							"t0.name",
+							"t0.parent_id",
+						}).
+							AddRow(
+								"kiddo",
+								"00000000-0000-0000-0000-000000000002",
+							).
+							AddRow(
+								"another_kid",
+								"00000000-0000-0000-0000-000000000002",
+							),
+					)
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			tc.expectationFunction(mock)
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Create the Picard instance
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			tc.filterRequest.Runner = tx
+			results, err := p.FilterModel(tc.filterRequest)
+
+			tx.Commit()
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantReturnInterfaces, results)
+
+			// sqlmock expectations
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterModelForUpdateWithoutTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	p := PersistenceORM{
+		multitenancyValue: "00000000-0000-0000-0000-000000000001",
+	}
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: testdata.ToyModel{},
+		Runner:      db,
+		ForUpdate:   true,
+	})
+
+	assert.EqualError(t, err, "ForUpdate requires a transaction Runner")
+	assert.Nil(t, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestSortAssociations(t *testing.T) {
+	testCases := []struct {
+		description      string
+		giveAssociations []tags.Association
+		wantNames        []string
+		wantErr          string
+	}{
+		{
+			"keeps the provided order without dependencies",
+			[]tags.Association{
+				{Name: "Children"},
+				{Name: "Animals"},
+			},
+			[]string{"Children", "Animals"},
+			"",
+		},
+		{
+			"moves dependencies ahead of the associations that need them",
+			[]tags.Association{
+				{Name: "Children", DependsOn: []string{"ChildrenMap"}},
+				{Name: "Animals"},
+				{Name: "ChildrenMap", DependsOn: []string{"Animals"}},
+			},
+			[]string{"Animals", "ChildrenMap", "Children"},
+			"",
+		},
+		{
+			"returns an error for a dependency that was not requested",
+			[]tags.Association{
+				{Name: "Children", DependsOn: []string{"Animals"}},
+			},
+			nil,
+			"association 'Children' depends on 'Animals', which is not in the request",
+		},
+		{
+			"returns an error for circular dependencies",
+			[]tags.Association{
+				{Name: "Children", DependsOn: []string{"Animals"}},
+				{Name: "Animals", DependsOn: []string{"Children"}},
+			},
+			nil,
+			"circular dependency in associations involving 'Children'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			sorted, err := sortAssociations(tc.giveAssociations)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			names := []string{}
+			for _, association := range sorted {
+				names = append(names, association.Name)
+			}
+			assert.Equal(t, tc.wantNames, names)
+		})
+	}
+}
+
+type secretModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=secretmodel"`
+	ID             string            `picard:"primary_key,column=id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Secret         string            `picard:"encrypted,column=secret"`
+}
+
+func TestFilterModelSkipDecryption(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	crypto.SetEncryptionKey([]byte("the-key-has-to-be-32-bytes-long!"))
+	encryptedSecret, err := crypto.EncryptBytes([]byte("This is a secret!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := base64.StdEncoding.EncodeToString(encryptedSecret)
+
+	testCases := []struct {
+		description        string
+		giveSkipDecryption bool
+		wantSecret         string
+	}{
+		{
+			"decrypts encrypted fields by default",
+			false,
+			"This is a secret!",
+		},
+		{
+			"returns the stored ciphertext with SkipDecryption",
+			true,
+			ciphertext,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.secret AS "t0.secret"
+				FROM secretmodel AS t0
+				WHERE t0.organization_id = $1
+			`)).
+				WithArgs(orgID).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.secret"}).
+						AddRow("00000000-0000-0000-0000-000000000002", orgID, ciphertext),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:    secretModel{},
+				SkipDecryption: tc.giveSkipDecryption,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				secretModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					Secret:         tc.wantSecret,
+				},
+			}, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterModelDecryptFields(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	noteID := "00000000-0000-0000-0000-000000000002"
+	crypto.SetEncryptionKey([]byte("the-key-has-to-be-32-bytes-long!"))
+	encrypt := func(plaintext string) string {
+		encrypted, err := crypto.EncryptBytes([]byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(encrypted)
+	}
+	bodyCiphertext := encrypt("The whole note")
+	summaryCiphertext := encrypt("A short note")
+	summary := "A short note"
+
+	testCases := []struct {
+		description       string
+		giveDecryptFields []string
+		wantBody          string
+		wantSummary       *string
+	}{
+		{
+			"decrypts only the listed field",
+			[]string{"Body"},
+			"The whole note",
+			&summaryCiphertext,
+		},
+		{
+			"decrypts no fields with an empty list",
+			[]string{},
+			bodyCiphertext,
+			&summaryCiphertext,
+		},
+		{
+			"decrypts every field without a list",
+			nil,
+			"The whole note",
+			&summary,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.body AS "t0.body",
+					t0.summary AS "t0.summary"
+				FROM note AS t0
+				WHERE t0.organization_id = $1
+			`)).
+				WithArgs(orgID).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.body", "t0.summary"}).
+						AddRow(noteID, orgID, bodyCiphertext, summaryCiphertext),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:   noteModel{},
+				DecryptFields: tc.giveDecryptFields,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				noteModel{
+					ID:             noteID,
+					OrganizationID: orgID,
+					Body:           tc.wantBody,
+					Summary:        tc.wantSummary,
+				},
+			}, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type noteModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=note"`
+	ID             string            `picard:"primary_key,column=id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Body           string            `picard:"encrypted,column=body"`
+	Summary        *string           `picard:"encrypted,column=summary"`
+}
+
+func TestEncryptedFilterModel(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	noteID := "00000000-0000-0000-0000-000000000002"
+	crypto.SetEncryptionKey([]byte("the-key-has-to-be-32-bytes-long!"))
+	encryptedBody, err := crypto.EncryptBytes([]byte("This is a secret!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedSummary, err := crypto.EncryptBytes([]byte("A secret summary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyCiphertext := base64.StdEncoding.EncodeToString(encryptedBody)
+	summaryCiphertext := base64.StdEncoding.EncodeToString(encryptedSummary)
+	summary := "A secret summary"
+
+	testCases := []struct {
+		description string
+		giveBody    driver.Value
+		giveSummary driver.Value
+		wantNote    noteModel
+		wantErr     string
+	}{
+		{
+			"decrypts string and pointer fields",
+			bodyCiphertext,
+			summaryCiphertext,
+			noteModel{ID: noteID, OrganizationID: orgID, Body: "This is a secret!", Summary: &summary},
+			"",
+		},
+		{
+			"decrypts ciphertext read as bytes",
+			[]byte(bodyCiphertext),
+			[]byte(summaryCiphertext),
+			noteModel{ID: noteID, OrganizationID: orgID, Body: "This is a secret!", Summary: &summary},
+			"",
+		},
+		{
+			"leaves empty strings empty",
+			"",
+			"",
+			noteModel{ID: noteID, OrganizationID: orgID},
+			"",
+		},
+		{
+			"leaves nulls empty",
+			nil,
+			nil,
+			noteModel{ID: noteID, OrganizationID: orgID},
+			"",
+		},
+		{
+			"errors for values that aren't base64",
+			"not base64!",
+			nil,
+			noteModel{},
+			"base64 decoding of value failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.body AS "t0.body",
+					t0.summary AS "t0.summary"
+				FROM note AS t0
+				WHERE t0.organization_id = $1
+			`)).
+				WithArgs(orgID).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.body", "t0.summary"}).
+						AddRow(noteID, orgID, tc.giveBody, tc.giveSummary),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel: noteModel{},
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, []interface{}{tc.wantNote}, results)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type contactModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=contact"`
+	ID             string            `picard:"primary_key,column=id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Email          string            `picard:"encrypted=deterministic,column=email"`
+}
+
+func TestDeterministicEncryption(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	crypto.SetEncryptionKey([]byte("the-key-has-to-be-32-bytes-long!"))
+	ciphertext, err := tags.EncryptDeterministic("fred@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filterSQL := testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.email AS "t0.email"
+		FROM contact AS t0
+		WHERE t0.organization_id = $1 AND t0.email = $2
+	`)
+
+	testCases := []struct {
+		description string
+		giveRequest FilterRequest
+	}{
+		{
+			"filters by the ciphertext of a filter model value",
+			FilterRequest{
+				FilterModel: contactModel{Email: "fred@example.com"},
+			},
+		},
+		{
+			"filters by the ciphertext of a field filter value",
+			FilterRequest{
+				FilterModel: contactModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Email",
+					FilterValue: "fred@example.com",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(filterSQL).
+				WithArgs(orgID, ciphertext).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.email"}).
+						AddRow("00000000-0000-0000-0000-000000000002", orgID, ciphertext),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(tc.giveRequest)
+
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				contactModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					Email:          "fred@example.com",
+				},
+			}, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+
+	t.Run("writes the same ciphertext it filters by", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			INSERT INTO contact (organization_id,email) VALUES ($1,$2) RETURNING "id"
+		`)).
+			WithArgs(orgID, ciphertext).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("00000000-0000-0000-0000-000000000002"))
+		mock.ExpectCommit()
+
+		p := PersistenceORM{
+			multitenancyValue: orgID,
+			performedBy:       sampleUserID,
+		}
+
+		assert.NoError(t, p.CreateModel(&contactModel{Email: "fred@example.com"}))
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+}
+
+type toyNamesModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=toymodel"`
+	ID             string            `picard:"primary_key,column=id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Names          []string          `picard:"column=name"`
+}
+
+func TestFilterModelSliceField(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM toymodel AS t0
+		WHERE t0.organization_id = $1 AND t0.name = ANY($2)
+	`)).
+		WithArgs(orgID, pq.Array([]string{"Lego", "Duplo"})).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow("00000000-0000-0000-0000-000000000002", orgID, "Lego").
+				AddRow("00000000-0000-0000-0000-000000000003", orgID, "Duplo"),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: toyNamesModel{
+			Names: []string{"Lego", "Duplo"},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		toyNamesModel{
+			ID:             "00000000-0000-0000-0000-000000000002",
+			OrganizationID: orgID,
+		},
+		toyNamesModel{
+			ID:             "00000000-0000-0000-0000-000000000003",
+			OrganizationID: orgID,
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestFilterModelSoftDelete(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description        string
+		giveIncludeDeleted bool
+		wantSQL            string
+	}{
+		{
+			"leaves out soft-deleted rows by default",
+			false,
+			`
+				SELECT
+					t0.primary_key_column AS "t0.primary_key_column",
+					t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+					t0.test_column_one AS "t0.test_column_one",
+					t0.deleted_at AS "t0.deleted_at"
+				FROM test_tablename AS t0
+				WHERE t0.multitenancy_key_column = $1 AND t0.deleted_at IS NULL
+			`,
+		},
+		{
+			"returns soft-deleted rows with IncludeDeleted",
+			true,
+			`
+				SELECT
+					t0.primary_key_column AS "t0.primary_key_column",
+					t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+					t0.test_column_one AS "t0.test_column_one",
+					t0.deleted_at AS "t0.deleted_at"
+				FROM test_tablename AS t0
+				WHERE t0.multitenancy_key_column = $1
+			`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(tc.wantSQL)).
+				WithArgs(orgID).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one"}).
+						AddRow("00000000-0000-0000-0000-000000000002", orgID, "one"),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:    softDeleteModel{},
+				IncludeDeleted: tc.giveIncludeDeleted,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				softDeleteModel{
+					PrimaryKeyField:        "00000000-0000-0000-0000-000000000002",
+					TestMultitenancyColumn: orgID,
+					TestFieldOne:           "one",
+				},
+			}, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type softDeleteOwnerModel struct {
+	metadata.Metadata `picard:"tablename=ownermodel"`
+
+	ID             string               `picard:"primary_key,column=id"`
+	OrganizationID string               `picard:"multitenancy_key,column=organization_id"`
+	Pets           []softDeletePetModel `picard:"child,foreign_key=OwnerID"`
+}
+
+type softDeletePetModel struct {
+	metadata.Metadata `picard:"tablename=softpetmodel"`
+
+	ID             string    `picard:"primary_key,column=id"`
+	OrganizationID string    `picard:"multitenancy_key,column=organization_id"`
+	OwnerID        string    `picard:"foreign_key,column=owner_id"`
+	DeletedAt      time.Time `picard:"soft_delete,column=deleted_at"`
+}
+
+func TestFilterModelChildFiltersSoftDelete(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description string
+		giveFilter  tags.Filterable
+		wantSQL     string
+		wantArgs    []driver.Value
+	}{
+		{
+			"leaves soft-deleted children out of a child exists filter",
+			tags.ChildExistsFilter{ChildName: "Pets"},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id"
+				FROM ownermodel AS t0
+				WHERE t0.organization_id = $1 AND
+					EXISTS (SELECT 1 FROM softpetmodel AS t0_pets
+					WHERE t0_pets.owner_id = t0.id AND
+						t0_pets.organization_id = $2 AND
+						t0_pets.deleted_at IS NULL)
+			`,
+			[]driver.Value{orgID, orgID},
+		},
+		{
+			"counts soft-deleted children in a child exists filter with IncludeDeleted",
+			tags.ChildExistsFilter{ChildName: "Pets", IncludeDeleted: true},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id"
+				FROM ownermodel AS t0
+				WHERE t0.organization_id = $1 AND
+					EXISTS (SELECT 1 FROM softpetmodel AS t0_pets
+					WHERE t0_pets.owner_id = t0.id AND
+						t0_pets.organization_id = $2)
+			`,
+			[]driver.Value{orgID, orgID},
+		},
+		{
+			"leaves soft-deleted children out of a child aggregate filter",
+			tags.ChildAggregateFilter{ChildName: "Pets", FilterOperator: ">", FilterValue: 1},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id"
+				FROM ownermodel AS t0
+				WHERE t0.organization_id = $1 AND
+					(SELECT COUNT(*) FROM softpetmodel AS t0_pets
+					WHERE t0_pets.owner_id = t0.id AND
+						t0_pets.organization_id = $2 AND
+						t0_pets.deleted_at IS NULL) > $3
+			`,
+			[]driver.Value{orgID, orgID, 1},
+		},
+		{
+			"counts soft-deleted children in a child aggregate filter with IncludeDeleted",
+			tags.ChildAggregateFilter{ChildName: "Pets", FilterOperator: ">", FilterValue: 1, IncludeDeleted: true},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id"
+				FROM ownermodel AS t0
+				WHERE t0.organization_id = $1 AND
+					(SELECT COUNT(*) FROM softpetmodel AS t0_pets
+					WHERE t0_pets.owner_id = t0.id AND
+						t0_pets.organization_id = $2) > $3
+			`,
+			[]driver.Value{orgID, orgID, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(tc.wantSQL)).
+				WithArgs(tc.wantArgs...).
+				WillReturnRows(sqlmock.NewRows([]string{"t0.id", "t0.organization_id"}))
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:  softDeleteOwnerModel{},
+				FieldFilters: tc.giveFilter,
+			})
+
+			assert.NoError(t, err)
+			assert.Empty(t, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterModelDistinctOnValidation(t *testing.T) {
+	testCases := []struct {
+		description string
+		giveRequest FilterRequest
+		wantErr     string
+	}{
+		{
+			"errors when the leading order by doesn't match",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				DistinctOn:  []string{"ParentID"},
+				OrderBy: []qp.OrderByRequest{
+					{Field: "Name"},
+					{Field: "ParentID"},
+				},
+			},
+			"the leading OrderBy fields must match the DistinctOn fields",
+		},
+		{
+			"errors when there are fewer order by fields than distinct on fields",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				DistinctOn:  []string{"ParentID", "Name"},
+				OrderBy: []qp.OrderByRequest{
+					{Field: "ParentID"},
+				},
+			},
+			"the leading OrderBy fields must match the DistinctOn fields",
+		},
+		{
+			"errors when combined with distinct",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				DistinctOn:  []string{"ParentID"},
+				Distinct:    true,
+			},
+			"Distinct and DistinctOn cannot be used together",
+		},
+		{
+			"errors for a field that isn't a column",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				DistinctOn:  []string{"Parent"},
+			},
+			"DistinctOn field 'Parent' is not a column on the filter model",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			p := PersistenceORM{
+				multitenancyValue: "00000000-0000-0000-0000-000000000001",
+			}
+
+			results, err := p.FilterModel(tc.giveRequest)
+
+			assert.EqualError(t, err, tc.wantErr)
+			assert.Nil(t, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestAddOrderBy(t *testing.T) {
+	nullsFirst := true
+	nullsLast := false
+	testCases := []struct {
+		description string
+		giveOrderBy qp.OrderByRequest
+		wantSQL     string
+	}{
+		{
+			"keeps the default without NullsFirst",
+			qp.OrderByRequest{Field: "Name"},
+			"SELECT * FROM toymodel AS t0 ORDER BY t0.name",
+		},
+		{
+			"ascending with nulls first",
+			qp.OrderByRequest{Field: "Name", NullsFirst: &nullsFirst},
+			"SELECT * FROM toymodel AS t0 ORDER BY t0.name NULLS FIRST",
+		},
+		{
+			"ascending with nulls last",
+			qp.OrderByRequest{Field: "Name", NullsFirst: &nullsLast},
+			"SELECT * FROM toymodel AS t0 ORDER BY t0.name NULLS LAST",
+		},
+		{
+			"descending with nulls first",
+			qp.OrderByRequest{Field: "Name", Descending: true, NullsFirst: &nullsFirst},
+			"SELECT * FROM toymodel AS t0 ORDER BY t0.name DESC NULLS FIRST",
+		},
+		{
+			"descending with nulls last",
+			qp.OrderByRequest{Field: "Name", Descending: true, NullsFirst: &nullsLast},
+			"SELECT * FROM toymodel AS t0 ORDER BY t0.name DESC NULLS LAST",
+		},
+		{
+			"expression instead of a field",
+			qp.OrderByRequest{Field: "Name", Expression: "lower(t0.name)"},
+			"SELECT * FROM toymodel AS t0 ORDER BY lower(t0.name)",
+		},
+		{
+			"descending expression with nulls last",
+			qp.OrderByRequest{Expression: "array_position(ARRAY['b','a'], t0.name)", Descending: true, NullsFirst: &nullsLast},
+			"SELECT * FROM toymodel AS t0 ORDER BY array_position(ARRAY['b','a'], t0.name) DESC NULLS LAST",
+		},
+		{
+			"explicit list of values",
+			qp.OrderByRequest{Field: "Name", Values: []interface{}{"b", "a"}},
+			"SELECT * FROM toymodel AS t0 ORDER BY CASE t0.name WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END",
+		},
+		{
+			"descending explicit list of values of an expression",
+			qp.OrderByRequest{Expression: "lower(t0.name)", Values: []interface{}{"b"}, Descending: true},
+			"SELECT * FROM toymodel AS t0 ORDER BY CASE lower(t0.name) WHEN ? THEN 0 ELSE 1 END DESC",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			filterMetadata := tags.TableMetadataFromType(reflect.TypeOf(testdata.ToyModel{}))
+			tbl := qp.NewAliased("toymodel", "t0", "")
+			sql, _, err := addOrderBy(sq.Select("*").From("toymodel AS t0"), []qp.OrderByRequest{tc.giveOrderBy}, filterMetadata, tbl).ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantSQL, sql)
+		})
+	}
+}
+
+func TestChildAggregateFilterValidation(t *testing.T) {
+	type compositeParent struct {
+		Metadata metadata.Metadata     `picard:"tablename=composite_parent"`
+		Region   string                `picard:"primary_key,column=region"`
+		ID       string                `picard:"primary_key,column=id"`
+		Children []testdata.ChildModel `picard:"child,foreign_key=ParentID"`
+	}
+
+	testCases := []struct {
+		description string
+		giveModel   interface{}
+		giveFilter  tags.ChildAggregateFilter
+		wantErr     string
+	}{
+		{
+			"errors for an unsupported operator",
+			testdata.ParentModel{},
+			tags.ChildAggregateFilter{ChildName: "Children", FilterOperator: "!=", FilterValue: 3},
+			"unsupported FilterOperator '!=' for child aggregate filter",
+		},
+		{
+			"errors for an unsupported aggregate function",
+			testdata.ParentModel{},
+			tags.ChildAggregateFilter{ChildName: "Children", Aggregate: "COUNT(*); DROP TABLE childmodel; --", FilterValue: 3},
+			"unsupported aggregate function 'COUNT(*); DROP TABLE childmodel; --'",
+		},
+		{
+			"errors for a field that isn't a column of the child",
+			testdata.ParentModel{},
+			tags.ChildAggregateFilter{ChildName: "Children", Aggregate: "max", FieldName: "Missing", FilterValue: 3},
+			"aggregate field 'Missing' is not a column on table 'childmodel'",
+		},
+		{
+			"errors for an aggregate other than COUNT without a field",
+			testdata.ParentModel{},
+			tags.ChildAggregateFilter{ChildName: "Children", Aggregate: "SUM", FilterValue: 3},
+			"aggregate function 'SUM' requires a field",
+		},
+		{
+			"errors for a table with a composite primary key",
+			compositeParent{},
+			tags.ChildAggregateFilter{ChildName: "Children", FilterValue: 3},
+			"child filters aren't supported on table 'composite_parent', which has a composite primary key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: "00000000-0000-0000-0000-000000000001",
+			}
+
+			sql, _, err := p.FilterModelSQL(FilterRequest{
+				FilterModel:  tc.giveModel,
+				FieldFilters: tc.giveFilter,
+			})
+
+			assert.EqualError(t, err, tc.wantErr)
+			assert.Equal(t, "", sql)
+		})
+	}
+}
+
+func TestFilterModelSQL(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description string
+		giveRequest FilterRequest
+		wantSQL     string
+		wantArgs    []interface{}
+		wantErr     string
+	}{
+		{
+			"returns the top-level query with joins, ordering and paging",
+			FilterRequest{
+				FilterModel: testdata.ParentModel{
+					Name: "pops",
+				},
+				Associations: []tags.Association{
+					{
+						Name:         "GrandParent",
+						SelectFields: []string{"ID", "Name"},
+						FieldFilters: tags.FieldFilter{
+							FieldName:   "Name",
+							FilterValue: "grandpops",
+						},
+					},
+					{
+						Name: "Children",
+					},
+				},
+				OrderBy: []qp.OrderByRequest{{Field: "Name"}},
+				Limit:   10,
+			},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name",
+					t0.parent_id AS "t0.parent_id",
+					t0.other_parent_id AS "t0.other_parent_id",
+					t1.id AS "t1.id",
+					t1.name AS "t1.name"
+				FROM parentmodel AS t0
+				LEFT JOIN grandparentmodel AS t1 ON
+					(t1.id = t0.parent_id AND t1.organization_id = $1)
+				WHERE
+					t0.organization_id = $2 AND
+					t0.name = $3 AND
+					t1.name = $4
+				ORDER BY t0.name
+				LIMIT $5
+			`,
+			[]interface{}{orgID, orgID, "pops", "grandpops", uint64(10)},
+			"",
+		},
+		{
+			"returns paging as query parameters by default",
+			FilterRequest{
+				FilterModel: testdata.PersonModel{Name: "Fred"},
+				Limit:       20,
+				Offset:      40,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				LIMIT $3 OFFSET $4
+			`,
+			[]interface{}{orgID, "Fred", uint64(20), uint64(40)},
+			"",
+		},
+		{
+			"returns inline paging constants",
+			FilterRequest{
+				FilterModel:  testdata.PersonModel{Name: "Fred"},
+				Limit:        20,
+				Offset:       40,
+				InlinePaging: true,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				LIMIT 20 OFFSET 40
+			`,
+			[]interface{}{orgID, "Fred"},
+			"",
+		},
+		{
+			"returns the values of explicit orders as query parameters before paging",
+			FilterRequest{
+				FilterModel: testdata.PersonModel{Name: "Fred"},
+				OrderBy: []qp.OrderByRequest{
+					{Field: "ID", Values: []interface{}{"x", "y"}},
+					{Field: "Name"},
+				},
+				Limit:  20,
+				Offset: 40,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				ORDER BY CASE t0.id WHEN $3 THEN 0 WHEN $4 THEN 1 ELSE 2 END, t0.name
+				LIMIT $5 OFFSET $6
+			`,
+			[]interface{}{orgID, "Fred", "x", "y", uint64(20), uint64(40)},
+			"",
+		},
+		{
+			"returns inline paging constants after explicit orders",
+			FilterRequest{
+				FilterModel:  testdata.PersonModel{Name: "Fred"},
+				OrderBy:      []qp.OrderByRequest{{Field: "ID", Values: []interface{}{"x"}}},
+				Limit:        20,
+				InlinePaging: true,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				ORDER BY CASE t0.id WHEN $3 THEN 0 ELSE 1 END
+				LIMIT 20
+			`,
+			[]interface{}{orgID, "Fred", "x"},
+			"",
+		},
+		{
+			"returns no query for an empty slice",
+			FilterRequest{
+				FilterModel: []testdata.ToyModel{},
+			},
+			"",
+			nil,
+			"",
+		},
+		{
+			"errors for an invalid request",
+			FilterRequest{
+				FilterModel: testdata.ToyModel{},
+				DistinctOn:  []string{"Parent"},
+			},
+			"",
+			nil,
+			"DistinctOn field 'Parent' is not a column on the filter model",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(tc.giveRequest)
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testdata.FmtSQL(tc.wantSQL), sql)
+			assert.Equal(t, tc.wantArgs, args)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type xminModel struct {
+	Metadata metadata.Metadata `picard:"tablename=xmin_model"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"column=name"`
+	TransactionID  int64  `picard:"xmin"`
+}
+
+func TestFilterModelXmin(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description string
+		giveRequest FilterRequest
+		wantSQL     string
+		wantArgs    []driver.Value
+		wantResults []interface{}
+	}{
+		{
+			"should select xmin as a bigint",
+			FilterRequest{
+				FilterModel: xminModel{},
+			},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name",
+					t0.xmin::text::bigint AS "t0.xmin"
+				FROM xmin_model AS t0
+				WHERE t0.organization_id = $1
+			`,
+			[]driver.Value{orgID},
+			[]interface{}{
+				xminModel{ID: "00000000-0000-0000-0000-000000000002", OrganizationID: orgID, Name: "Fred", TransactionID: 1234},
+			},
+		},
+		{
+			"should only return rows modified since the xmin",
+			FilterRequest{
+				FilterModel:       xminModel{Name: "Fred"},
+				ModifiedSinceXmin: 1200,
+			},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name",
+					t0.xmin::text::bigint AS "t0.xmin"
+				FROM xmin_model AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2 AND t0.xmin::text::bigint > $3
+			`,
+			[]driver.Value{orgID, "Fred", int64(1200)},
+			[]interface{}{
+				xminModel{ID: "00000000-0000-0000-0000-000000000002", OrganizationID: orgID, Name: "Fred", TransactionID: 1234},
+			},
+		},
+		{
+			"should not filter on a set xmin field",
+			FilterRequest{
+				FilterModel: xminModel{TransactionID: 1200},
+			},
+			`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name",
+					t0.xmin::text::bigint AS "t0.xmin"
+				FROM xmin_model AS t0
+				WHERE t0.organization_id = $1
+			`,
+			[]driver.Value{orgID},
+			[]interface{}{
+				xminModel{ID: "00000000-0000-0000-0000-000000000002", OrganizationID: orgID, Name: "Fred", TransactionID: 1234},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(tc.wantSQL)).
+				WithArgs(tc.wantArgs...).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.xmin"}).
+						AddRow("00000000-0000-0000-0000-000000000002", orgID, "Fred", int64(1234)),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(tc.giveRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantResults, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterModelOne(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	fredID := "00000000-0000-0000-0000-000000000002"
+	georgeID := "00000000-0000-0000-0000-000000000003"
+
+	testCases := []struct {
+		description string
+		giveRequest FilterRequest
+		wantSQL     string
+		giveRows    *sqlmock.Rows
+		wantResult  interface{}
+		wantErr     error
+	}{
+		{
+			"should return the one matching model",
+			FilterRequest{
+				FilterModel: testdata.PersonModel{Name: "Fred"},
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				LIMIT $3
+			`,
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(fredID, orgID, "Fred"),
+			testdata.PersonModel{ID: fredID, OrganizationID: orgID, Name: "Fred"},
+			nil,
+		},
+		{
+			"should return ModelNotFoundError when no model matches",
+			FilterRequest{
+				FilterModel: testdata.PersonModel{Name: "Fred"},
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				LIMIT $3
+			`,
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}),
+			nil,
+			ModelNotFoundError,
+		},
+		{
+			"should return MultipleModelsFoundError when more than one model matches",
+			FilterRequest{
+				FilterModel: testdata.PersonModel{Name: "Fred"},
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+				LIMIT $3
+			`,
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(fredID, orgID, "Fred").
+				AddRow(georgeID, orgID, "Fred"),
+			nil,
+			MultipleModelsFoundError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(tc.wantSQL)).
+				WithArgs(orgID, "Fred", 2).
+				WillReturnRows(tc.giveRows)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			result, err := p.FilterModelOne(tc.giveRequest)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantResult, result)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type childReport struct {
+	Metadata       metadata.Metadata    `picard:"tablename=childmodel"`
+	ID             string               `picard:"primary_key,column=id"`
+	OrganizationID string               `picard:"multitenancy_key,column=organization_id"`
+	Name           string               `picard:"column=name"`
+	ParentID       string               `picard:"foreign_key,related=Parent,column=parent_id"`
+	Parent         testdata.ParentModel `validate:"-"`
+	ParentName     string               `picard:"column=name,join=Parent"`
+	ParentNickname *string              `picard:"column=nickname,join=Parent"`
+}
+
+func TestFilterModelJoinedFields(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	childID := "00000000-0000-0000-0000-000000000002"
+	parentID := "00000000-0000-0000-0000-000000000003"
+	nickname := "Pops"
+
+	type joinedModel struct {
+		Metadata   metadata.Metadata `picard:"tablename=childmodel"`
+		ID         string            `picard:"primary_key,column=id"`
+		Name       string            `picard:"column=name"`
+		ParentName string            `picard:"column=name,join=Parent"`
+	}
+
+	testCases := []struct {
+		description         string
+		giveRequest         FilterRequest
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []interface{}
+		wantErr             string
+	}{
+		{
+			"selects joined columns through a left join to the parent",
+			FilterRequest{
+				FilterModel:  childReport{},
+				SelectFields: []string{"ID", "Name", "ParentName", "ParentNickname"},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.name AS "t0.name",
+						t1.name AS "t0.Parent.name",
+						t1.nickname AS "t0.Parent.nickname"
+					FROM childmodel AS t0
+					LEFT JOIN parentmodel AS t1 ON (t1.id = t0.parent_id AND t1.organization_id = $1)
+					WHERE t0.organization_id = $2
+				`)).
+					WithArgs(orgID, orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.name", "t0.Parent.name", "t0.Parent.nickname"}).
+							AddRow(childID, "Child", "Parent", nickname).
+							AddRow("00000000-0000-0000-0000-000000000004", "Orphan", nil, nil),
+					)
+			},
+			[]interface{}{
+				childReport{ID: childID, Name: "Child", ParentName: "Parent", ParentNickname: &nickname},
+				childReport{ID: "00000000-0000-0000-0000-000000000004", Name: "Orphan"},
+			},
+			"",
+		},
+		{
+			"reads joined columns from the join of an eager loaded parent",
+			FilterRequest{
+				FilterModel:  childReport{},
+				SelectFields: []string{"ID", "ParentID", "ParentName"},
+				Associations: []tags.Association{
+					{
+						Name:         "Parent",
+						SelectFields: []string{"ID"},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.parent_id AS "t0.parent_id",
+						t1.name AS "t0.Parent.name",
+						t1.id AS "t1.id"
+					FROM childmodel AS t0
+					LEFT JOIN parentmodel AS t1 ON (t1.id = t0.parent_id AND t1.organization_id = $1)
+					WHERE t0.organization_id = $2
+				`)).
+					WithArgs(orgID, orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.parent_id", "t0.Parent.name", "t1.id"}).
+							AddRow(childID, parentID, "Parent", parentID),
+					)
+			},
+			[]interface{}{
+				childReport{
+					ID:         childID,
+					ParentID:   parentID,
+					Parent:     testdata.ParentModel{ID: parentID},
+					ParentName: "Parent",
+				},
+			},
+			"",
+		},
+		{
+			"errors when the join isn't the related field of a foreign key",
+			FilterRequest{
+				FilterModel: joinedModel{},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"field 'ParentName' on table 'childmodel' joins 'Parent', which is not the related field of a foreign key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
 				t.Fatal(err)
 			}
-			defer db.Close()
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(tc.giveRequest)
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantResults, results)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type integrationModel struct {
+	Metadata metadata.Metadata `picard:"tablename=integration"`
+
+	ID             string                 `picard:"primary_key,column=id"`
+	OrganizationID string                 `picard:"multitenancy_key,column=organization_id"`
+	Name           string                 `picard:"column=name"`
+	Config         map[string]interface{} `picard:"jsonb,column=config"`
+}
+
+func TestFilterModelJSONPath(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveFilter  tags.Filterable
+		wantWhere   string
+		wantArgs    []interface{}
+		wantErr     string
+	}{
+		{
+			"should filter by a key inside a jsonb column",
+			tags.FieldFilter{FieldName: "Config", JSONPath: "status", FilterValue: "active"},
+			"t0.config->>'status' = $2",
+			[]interface{}{orgID, "active"},
+			"",
+		},
+		{
+			"should filter by a key inside nested objects",
+			tags.FieldFilter{FieldName: "Config", JSONPath: "auth.type", FilterValue: "oauth"},
+			"t0.config->'auth'->>'type' = $2",
+			[]interface{}{orgID, "oauth"},
+			"",
+		},
+		{
+			"should escape quotes in keys",
+			tags.FieldFilter{FieldName: "Config", JSONPath: "owner's", FilterValue: "ops"},
+			"t0.config->>'owner''s' = $2",
+			[]interface{}{orgID, "ops"},
+			"",
+		},
+		{
+			"should filter by keys inside filter groups",
+			tags.OrFilterGroup{
+				tags.FieldFilter{FieldName: "Config", JSONPath: "status", FilterValue: "active"},
+				tags.AndFilterGroup{
+					tags.FieldFilter{FieldName: "Config", JSONPath: "status", FilterValue: "paused"},
+					tags.FieldFilter{FieldName: "Name", FilterValue: "Sync"},
+				},
+			},
+			"(t0.config->>'status' = $2 OR (t0.config->>'status' = $3 AND t0.name = $4))",
+			[]interface{}{orgID, "active", "paused", "Sync"},
+			"",
+		},
+		{
+			"should error for fields without the jsonb tag",
+			tags.FieldFilter{FieldName: "Name", JSONPath: "status", FilterValue: "active"},
+			"",
+			nil,
+			"field 'Name' on table 'integration' must be tagged with jsonb to filter by a JSON path",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel:  integrationModel{},
+				FieldFilters: tc.giveFilter,
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name",
+					t0.config AS "t0.config"
+				FROM integration AS t0
+				WHERE t0.organization_id = $1 AND `+tc.wantWhere+`
+			`), sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
+
+type featureFlagModel struct {
+	Metadata metadata.Metadata `picard:"tablename=feature_flag"`
+
+	ID             string                 `picard:"primary_key,column=id"`
+	OrganizationID string                 `picard:"multitenancy_key,column=organization_id"`
+	Config         map[string]interface{} `picard:"jsonb,column=config"`
+	Audiences      []string               `picard:"column=audiences"`
+}
+
+func TestFilterModelContainment(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveFilter  tags.Filterable
+		wantWhere   string
+		wantArgs    []interface{}
+		wantErr     string
+	}{
+		{
+			"should marshal values compared to jsonb columns",
+			tags.FieldFilter{FieldName: "Config", FilterOperator: "@>", FilterValue: map[string]interface{}{"feature": "x"}},
+			"t0.config @> $2::jsonb",
+			[]interface{}{orgID, `{"feature":"x"}`},
+			"",
+		},
+		{
+			"should pass JSON documents to jsonb columns as they are",
+			tags.FieldFilter{FieldName: "Config", FilterOperator: "@>", FilterValue: `{"feature":"x"}`},
+			"t0.config @> $2::jsonb",
+			[]interface{}{orgID, `{"feature":"x"}`},
+			"",
+		},
+		{
+			"should bind slices compared to array columns as arrays",
+			tags.FieldFilter{FieldName: "Audiences", FilterOperator: "@>", FilterValue: []string{"beta"}},
+			"t0.audiences @> $2",
+			[]interface{}{orgID, pq.Array([]string{"beta"})},
+			"",
+		},
+		{
+			"should bind slices checked for overlap with array columns as arrays",
+			tags.FieldFilter{FieldName: "Audiences", FilterOperator: "&&", FilterValue: []string{"beta", "staff"}},
+			"t0.audiences && $2",
+			[]interface{}{orgID, pq.Array([]string{"beta", "staff"})},
+			"",
+		},
+		{
+			"should fail for values that can't be marshaled",
+			tags.FieldFilter{FieldName: "Config", FilterOperator: "@>", FilterValue: func() {}},
+			"",
+			nil,
+			"json: unsupported type: func()",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel:  featureFlagModel{},
+				FieldFilters: tc.giveFilter,
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.config AS "t0.config",
+					t0.audiences AS "t0.audiences"
+				FROM feature_flag AS t0
+				WHERE t0.organization_id = $1 AND `+tc.wantWhere+`
+			`), sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
+
+func TestFilterModelWhereRaw(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveRaw     []RawClause
+		wantWhere   string
+		wantArgs    []interface{}
+		wantErr     string
+	}{
+		{
+			"should number raw args after the multitenancy and field filter args",
+			[]RawClause{
+				{SQL: "length(t0.name) > ?", Args: []interface{}{3}},
+				{SQL: "t0.id = ? OR length(t0.name) < ?", Args: []interface{}{"00000000-0000-0000-0000-000000000002", 10}},
+			},
+			"t0.organization_id = $1 AND t0.name = $2 AND (length(t0.name) > $3) AND (t0.id = $4 OR length(t0.name) < $5)",
+			[]interface{}{orgID, "George", 3, "00000000-0000-0000-0000-000000000002", 10},
+			"",
+		},
+		{
+			"should leave escaped question marks out of the placeholders",
+			[]RawClause{
+				{SQL: "to_jsonb(t0.name) ?? ?", Args: []interface{}{"Fred"}},
+			},
+			"t0.organization_id = $1 AND t0.name = $2 AND (to_jsonb(t0.name) ? $3)",
+			[]interface{}{orgID, "George", "Fred"},
+			"",
+		},
+		{
+			"should error when the args don't match the placeholders",
+			[]RawClause{
+				{SQL: "length(t0.name) BETWEEN ? AND ?", Args: []interface{}{3}},
+			},
+			"",
+			nil,
+			"raw clause 'length(t0.name) BETWEEN ? AND ?' has 2 placeholders but 1 args",
+		},
+		{
+			"should error for a clause without SQL",
+			[]RawClause{{}},
+			"",
+			nil,
+			"raw clauses must have SQL",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
 
-			tc.expectationFunction(mock)
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel: testdata.PersonModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Name",
+					FilterValue: "George",
+				},
+				WhereRaw: tc.giveRaw,
+			})
 
-			tx, err := db.Begin()
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE `+tc.wantWhere+`
+			`), sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
+
+func TestFilterModelOrderedMapAssociation(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	parentID := "00000000-0000-0000-0000-000000000002"
+
+	type orderedParentModel struct {
+		Metadata metadata.Metadata `picard:"tablename=parentmodel"`
+
+		ID             string                         `picard:"primary_key,column=id"`
+		OrganizationID string                         `picard:"multitenancy_key,column=organization_id"`
+		Children       map[string]testdata.ChildModel `picard:"child,foreign_key=ParentID,key_mapping=Name,ordered_field=ChildOrder"`
+		ChildOrder     []testdata.ChildModel
+	}
+
+	type badOrderedParentModel struct {
+		Metadata metadata.Metadata `picard:"tablename=parentmodel"`
+
+		ID             string                         `picard:"primary_key,column=id"`
+		OrganizationID string                         `picard:"multitenancy_key,column=organization_id"`
+		Children       map[string]testdata.ChildModel `picard:"child,foreign_key=ParentID,key_mapping=Name,ordered_field=ChildOrder"`
+		ChildOrder     []string
+	}
+
+	kiddo := testdata.ChildModel{
+		ID:             "00000000-0000-0000-0000-000000000021",
+		OrganizationID: orgID,
+		Name:           "kiddo",
+		ParentID:       parentID,
+	}
+	coz := testdata.ChildModel{
+		ID:             "00000000-0000-0000-0000-000000000022",
+		OrganizationID: orgID,
+		Name:           "coz",
+		ParentID:       parentID,
+	}
+
+	testCases := []struct {
+		description         string
+		giveModel           interface{}
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []interface{}
+		wantErr             string
+	}{
+		{
+			"loads a map child into its ordered field in the association's order",
+			orderedParentModel{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id"}).
+							AddRow(parentID, orgID),
+					)
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id"
+					FROM childmodel AS t0
+					WHERE t0.organization_id = $1 AND ((t0.parent_id = $2))
+					ORDER BY t0.name DESC
+				`)).
+					WithArgs(orgID, parentID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.parent_id"}).
+							AddRow(kiddo.ID, orgID, kiddo.Name, parentID).
+							AddRow(coz.ID, orgID, coz.Name, parentID),
+					)
+			},
+			[]interface{}{
+				orderedParentModel{
+					ID:             parentID,
+					OrganizationID: orgID,
+					Children: map[string]testdata.ChildModel{
+						"kiddo": kiddo,
+						"coz":   coz,
+					},
+					ChildOrder: []testdata.ChildModel{kiddo, coz},
+				},
+			},
+			"",
+		},
+		{
+			"errors when the ordered field can't hold the children",
+			badOrderedParentModel{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id"}).
+							AddRow(parentID, orgID),
+					)
+			},
+			nil,
+			"ordered_field 'ChildOrder' of child 'Children' must be a field of type []testdata.ChildModel",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
 			if err != nil {
 				t.Fatal(err)
 			}
+			conn = db
+
+			tc.expectationFunction(mock)
 
-			// Create the Picard instance
 			p := PersistenceORM{
 				multitenancyValue: orgID,
 			}
 
-			tc.filterRequest.Runner = tx
-			results, err := p.FilterModel(tc.filterRequest)
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel: tc.giveModel,
+				Associations: []tags.Association{
+					{
+						Name:    "Children",
+						OrderBy: []qp.OrderByRequest{{Field: "Name", Descending: true}},
+					},
+				},
+			})
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantResults, results)
+			}
 
-			tx.Commit()
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
 
-			assert.NoError(t, err)
-			assert.Equal(t, tc.wantReturnInterfaces, results)
+func TestFilterModelAssociationLimit(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	popsID := "00000000-0000-0000-0000-000000000002"
+	uncleID := "00000000-0000-0000-0000-000000000003"
+
+	kiddo := testdata.ChildModel{ID: "00000000-0000-0000-0000-000000000021", OrganizationID: orgID, Name: "kiddo", ParentID: popsID}
+	coz := testdata.ChildModel{ID: "00000000-0000-0000-0000-000000000022", OrganizationID: orgID, Name: "coz", ParentID: uncleID}
+
+	testCases := []struct {
+		description         string
+		giveModel           interface{}
+		giveAssociation     tags.Association
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []interface{}
+		wantErr             string
+	}{
+		{
+			"loads the first children of each parent in the association's order",
+			testdata.ParentModel{},
+			tags.Association{
+				Name:    "Children",
+				OrderBy: []qp.OrderByRequest{{Field: "Name", Descending: true}},
+				Limit:   1,
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+							AddRow(popsID, orgID, "pops").
+							AddRow(uncleID, orgID, "uncle"),
+					)
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT * FROM (SELECT
+							t0.id AS "t0.id",
+							t0.organization_id AS "t0.organization_id",
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id",
+							ROW_NUMBER() OVER (PARTITION BY t0.parent_id ORDER BY t0.name DESC) AS picard_row_number
+						FROM childmodel AS t0
+						WHERE t0.organization_id = $1 AND ((t0.parent_id = $2) OR (t0.parent_id = $3))) AS picard_ranked
+					WHERE picard_ranked.picard_row_number <= $4
+					ORDER BY picard_ranked.picard_row_number
+				`)).
+					WithArgs(orgID, popsID, uncleID, uint64(1)).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.parent_id", "picard_row_number"}).
+							AddRow(kiddo.ID, orgID, kiddo.Name, popsID, 1).
+							AddRow(coz.ID, orgID, coz.Name, uncleID, 1),
+					)
+			},
+			[]interface{}{
+				testdata.ParentModel{ID: popsID, OrganizationID: orgID, Name: "pops", Children: []testdata.ChildModel{kiddo}},
+				testdata.ParentModel{ID: uncleID, OrganizationID: orgID, Name: "uncle", Children: []testdata.ChildModel{coz}},
+			},
+			"",
+		},
+		{
+			"numbers explicit orders' values along with the rest of the query",
+			testdata.ParentModel{},
+			tags.Association{
+				Name:    "Children",
+				OrderBy: []qp.OrderByRequest{{Field: "Name", Values: []interface{}{"coz"}}},
+				Limit:   2,
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name",
+						t0.parent_id AS "t0.parent_id",
+						t0.other_parent_id AS "t0.other_parent_id"
+					FROM parentmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+							AddRow(popsID, orgID, "pops"),
+					)
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT * FROM (SELECT
+							t0.id AS "t0.id",
+							t0.organization_id AS "t0.organization_id",
+							t0.name AS "t0.name",
+							t0.parent_id AS "t0.parent_id",
+							ROW_NUMBER() OVER (PARTITION BY t0.parent_id ORDER BY CASE t0.name WHEN $1 THEN 0 ELSE 1 END) AS picard_row_number
+						FROM childmodel AS t0
+						WHERE t0.organization_id = $2 AND ((t0.parent_id = $3))) AS picard_ranked
+					WHERE picard_ranked.picard_row_number <= $4
+					ORDER BY picard_ranked.picard_row_number
+				`)).
+					WithArgs("coz", orgID, popsID, uint64(2)).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.parent_id", "picard_row_number"}).
+							AddRow(kiddo.ID, orgID, kiddo.Name, popsID, 1),
+					)
+			},
+			[]interface{}{
+				testdata.ParentModel{ID: popsID, OrganizationID: orgID, Name: "pops", Children: []testdata.ChildModel{kiddo}},
+			},
+			"",
+		},
+		{
+			"errors for children loaded through a junction table",
+			siblingPersonModel{},
+			tags.Association{
+				Name:  "Siblings",
+				Limit: 1,
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name"
+					FROM personmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+							AddRow(popsID, orgID, "fred"),
+					)
+			},
+			nil,
+			"association 'Siblings' can't have a Limit, since its children are loaded through a junction table",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:  tc.giveModel,
+				Associations: []tags.Association{tc.giveAssociation},
+			})
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantResults, results)
+			}
 
-			// sqlmock expectations
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unmet sqlmock expectations: %s", err)
 			}
 		})
 	}
 }
+
+func TestFilterModelNullAsEmpty(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveFilter  tags.FieldFilter
+		wantSQL     string
+		wantArgs    []interface{}
+	}{
+		{
+			"matches empty and NULL values when filtering by an empty string",
+			tags.FieldFilter{
+				FieldName:   "Name",
+				FilterValue: "",
+				NullAsEmpty: true,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name", t0.parent_id AS "t0.parent_id"
+				FROM toymodel AS t0
+				WHERE t0.organization_id = $1 AND COALESCE(t0.name,'') = $2
+			`,
+			[]interface{}{orgID, ""},
+		},
+		{
+			"matches empty and NULL values when filtering by nil",
+			tags.FieldFilter{
+				FieldName:   "Name",
+				FilterValue: nil,
+				NullAsEmpty: true,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name", t0.parent_id AS "t0.parent_id"
+				FROM toymodel AS t0
+				WHERE t0.organization_id = $1 AND COALESCE(t0.name,'') = $2
+			`,
+			[]interface{}{orgID, ""},
+		},
+		{
+			"matches a list of values",
+			tags.FieldFilter{
+				FieldName:   "Name",
+				FilterValue: []string{"", "Lego"},
+				NullAsEmpty: true,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name", t0.parent_id AS "t0.parent_id"
+				FROM toymodel AS t0
+				WHERE t0.organization_id = $1 AND COALESCE(t0.name,'') IN ($2,$3)
+			`,
+			[]interface{}{orgID, "", "Lego"},
+		},
+		{
+			"compares NULL to nil without the option",
+			tags.FieldFilter{
+				FieldName:   "Name",
+				FilterValue: nil,
+			},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name", t0.parent_id AS "t0.parent_id"
+				FROM toymodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name IS NULL
+			`,
+			[]interface{}{orgID},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel:  testdata.ToyModel{},
+				FieldFilters: tc.giveFilter,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(tc.wantSQL), sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+
+	t.Run("hydrates both the empty and NULL rows", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			SELECT
+				t0.id AS "t0.id",
+				t0.organization_id AS "t0.organization_id",
+				t0.name AS "t0.name",
+				t0.parent_id AS "t0.parent_id"
+			FROM toymodel AS t0
+			WHERE t0.organization_id = $1 AND COALESCE(t0.name,'') = $2
+		`)).
+			WithArgs(orgID, "").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.parent_id"}).
+					AddRow("00000000-0000-0000-0000-000000000002", orgID, "", "00000000-0000-0000-0000-000000000004").
+					AddRow("00000000-0000-0000-0000-000000000003", orgID, nil, "00000000-0000-0000-0000-000000000004"),
+			)
+
+		p := PersistenceORM{
+			multitenancyValue: orgID,
+		}
+
+		results, err := p.FilterModel(FilterRequest{
+			FilterModel: testdata.ToyModel{},
+			FieldFilters: tags.FieldFilter{
+				FieldName:   "Name",
+				FilterValue: "",
+				NullAsEmpty: true,
+			},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{
+			testdata.ToyModel{
+				ID:             "00000000-0000-0000-0000-000000000002",
+				OrganizationID: orgID,
+				ParentID:       "00000000-0000-0000-0000-000000000004",
+			},
+			testdata.ToyModel{
+				ID:             "00000000-0000-0000-0000-000000000003",
+				OrganizationID: orgID,
+				ParentID:       "00000000-0000-0000-0000-000000000004",
+			},
+		}, results)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+
+	t.Run("errors for columns that aren't text", func(t *testing.T) {
+		p := PersistenceORM{
+			multitenancyValue: orgID,
+		}
+
+		_, _, err := p.FilterModelSQL(FilterRequest{
+			FilterModel: globalFlagModel{},
+			FieldFilters: tags.FieldFilter{
+				FieldName:   "Enabled",
+				FilterValue: false,
+				NullAsEmpty: true,
+			},
+		})
+
+		assert.EqualError(t, err, "field 'Enabled' on table 'feature_flag' must be a text column to compare NULL as an empty string")
+	})
+}
@@ -0,0 +1,132 @@
+package picard
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/skuid/picard/reflectutil"
+	"github.com/skuid/picard/tags"
+)
+
+// FlatResults holds FilterModelFlat results. Associations maps each requested association to its models,
+// with nested associations keyed by their dotted path, like "Children.Toys".
+type FlatResults struct {
+	Data         []interface{}            `json:"data"`
+	Associations map[string][]interface{} `json:"associations"`
+}
+
+/*
+FilterModelFlat runs FilterModel, but returns the models of each association in a separate list instead of
+assigning them into the struct fields of the models that loaded them. Models that were loaded more than
+once, like a parent shared by several children, are listed once. Children still hold the foreign keys that
+relate them to their parents.
+
+Example:
+
+	results, err := p.FilterModelFlat(picard.FilterRequest{
+		FilterModel: ParentModel{},
+		Associations: []tags.Association{
+			{
+				Name: "Children",
+				Associations: []tags.Association{
+					{Name: "Toys"},
+				},
+			},
+		},
+	})
+
+	// results.Data holds the ParentModels, with empty Children fields
+	// results.Associations["Children"] holds every ChildModel
+	// results.Associations["Children.Toys"] holds every ToyModel
+*/
+func (p PersistenceORM) FilterModelFlat(request FilterRequest) (*FlatResults, error) {
+	filterMetadata, err := getFilterMetadata(request)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := p.FilterModel(request)
+	if err != nil {
+		return nil, err
+	}
+
+	flat := &FlatResults{
+		Data:         make([]interface{}, 0, len(results)),
+		Associations: map[string][]interface{}{},
+	}
+	seen := map[string]map[string]bool{}
+	for _, result := range results {
+		value := copyValue(reflect.ValueOf(result))
+		flattenAssociations(value, request.Associations, filterMetadata, "", flat.Associations, seen)
+		flat.Data = append(flat.Data, value.Interface())
+	}
+	return flat, nil
+}
+
+// flattenAssociations moves the associated models out of value's fields and into the flat lists
+func flattenAssociations(value reflect.Value, associations []tags.Association, filterMetadata *tags.TableMetadata, prefix string, flat map[string][]interface{}, seen map[string]map[string]bool) {
+	for _, association := range associations {
+		path := prefix + association.Name
+		if flat[path] == nil {
+			flat[path] = []interface{}{}
+			seen[path] = map[string]bool{}
+		}
+
+		var field reflect.Value
+		var associationMetadata *tags.TableMetadata
+		if child := filterMetadata.GetChildField(association.Name); child != nil {
+			field = value.FieldByName(child.FieldName)
+			associationMetadata = tags.TableMetadataFromType(child.FieldType.Elem())
+		} else if foreignKey := filterMetadata.GetForeignKeyFieldFromRelation(association.Name); foreignKey != nil {
+			field = value.FieldByName(association.Name)
+			associationMetadata = foreignKey.TableMetadata
+		} else {
+			continue
+		}
+
+		for _, model := range associatedModels(field) {
+			key := getPrimaryKeyValue(model, associationMetadata)
+			if key != "" && seen[path][key] {
+				continue
+			}
+			seen[path][key] = true
+
+			model = copyValue(model)
+			flattenAssociations(model, association.Associations, associationMetadata, path+".", flat, seen)
+			flat[path] = append(flat[path], model.Interface())
+		}
+		field.Set(reflect.Zero(field.Type()))
+	}
+}
+
+// associatedModels returns the models held by a child slice or map, or by an eager loaded parent field
+func associatedModels(field reflect.Value) []reflect.Value {
+	models := []reflect.Value{}
+	switch field.Kind() {
+	case reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			models = append(models, field.Index(i))
+		}
+	case reflect.Map:
+		keys := field.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			models = append(models, field.MapIndex(key))
+		}
+	case reflect.Struct:
+		if !reflectutil.IsZeroValue(field) {
+			models = append(models, field)
+		}
+	}
+	return models
+}
+
+// copyValue returns a settable copy of value
+func copyValue(value reflect.Value) reflect.Value {
+	copied := reflect.New(value.Type()).Elem()
+	copied.Set(value)
+	return copied
+}
@@ -0,0 +1,97 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterModelFlat(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	parentID := "00000000-0000-0000-0000-000000000002"
+	kiddoID := "00000000-0000-0000-0000-000000000011"
+	anotherKidID := "00000000-0000-0000-0000-000000000012"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name",
+			t0.parent_id AS "t0.parent_id",
+			t1.id AS "t1.id",
+			t1.organization_id AS "t1.organization_id",
+			t1.name AS "t1.name",
+			t1.parent_id AS "t1.parent_id",
+			t1.other_parent_id AS "t1.other_parent_id"
+		FROM childmodel AS t0
+		LEFT JOIN parentmodel AS t1 ON
+			(t1.id = t0.parent_id AND t1.organization_id = $1)
+		WHERE t0.organization_id = $2
+	`)).
+		WithArgs(orgID, orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"t0.id", "t0.organization_id", "t0.name", "t0.parent_id",
+				"t1.id", "t1.organization_id", "t1.name",
+			}).
+				AddRow(kiddoID, orgID, "kiddo", parentID, parentID, orgID, "pops").
+				AddRow(anotherKidID, orgID, "another_kid", parentID, parentID, orgID, "pops"),
+		)
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name",
+			t0.parent_id AS "t0.parent_id"
+		FROM toymodel AS t0
+		WHERE t0.organization_id = $1 AND ((t0.parent_id = $2) OR (t0.parent_id = $3))
+	`)).
+		WithArgs(orgID, kiddoID, anotherKidID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.parent_id"}).
+				AddRow("00000000-0000-0000-0000-000000000022", orgID, "lego", kiddoID).
+				AddRow("00000000-0000-0000-0000-000000000023", orgID, "Woody", anotherKidID),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	results, err := p.FilterModelFlat(FilterRequest{
+		FilterModel: testdata.ChildModel{},
+		Associations: []tags.Association{
+			{Name: "Parent"},
+			{Name: "Toys"},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &FlatResults{
+		Data: []interface{}{
+			testdata.ChildModel{ID: kiddoID, OrganizationID: orgID, Name: "kiddo", ParentID: parentID},
+			testdata.ChildModel{ID: anotherKidID, OrganizationID: orgID, Name: "another_kid", ParentID: parentID},
+		},
+		Associations: map[string][]interface{}{
+			"Parent": {
+				testdata.ParentModel{ID: parentID, OrganizationID: orgID, Name: "pops"},
+			},
+			"Toys": {
+				testdata.ToyModel{ID: "00000000-0000-0000-0000-000000000022", OrganizationID: orgID, Name: "lego", ParentID: kiddoID},
+				testdata.ToyModel{ID: "00000000-0000-0000-0000-000000000023", OrganizationID: orgID, Name: "Woody", ParentID: anotherKidID},
+			},
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
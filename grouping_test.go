@@ -0,0 +1,188 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type dispatchModel struct {
+	Metadata metadata.Metadata `picard:"tablename=dispatch"`
+
+	ID             string      `picard:"primary_key,column=id"`
+	OrganizationID string      `picard:"multitenancy_key,column=organization_id"`
+	WarehouseCode  string      `picard:"column=warehouse_code"`
+	OrderNumber    string      `picard:"column=order_number"`
+	Picks          []pickModel `picard:"child,grouping_criteria=Bin.WarehouseCode->WarehouseCode&OrderNumber->OrderNumber"`
+}
+
+type binModel struct {
+	Metadata metadata.Metadata `picard:"tablename=bin"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	WarehouseCode  string `picard:"column=warehouse_code"`
+}
+
+type pickModel struct {
+	Metadata metadata.Metadata `picard:"tablename=pick"`
+
+	ID             string   `picard:"primary_key,column=id"`
+	OrganizationID string   `picard:"multitenancy_key,column=organization_id"`
+	OrderNumber    string   `picard:"column=order_number"`
+	BinID          string   `picard:"foreign_key,related=Bin,column=bin_id"`
+	Bin            binModel `validate:"-"`
+}
+
+func TestFilterModelCompositeGrouping(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	expectDispatches := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			SELECT
+				t0.id AS "t0.id",
+				t0.organization_id AS "t0.organization_id",
+				t0.warehouse_code AS "t0.warehouse_code",
+				t0.order_number AS "t0.order_number"
+			FROM dispatch AS t0
+			WHERE t0.organization_id = $1
+		`)).
+			WithArgs(orgID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.warehouse_code", "t0.order_number"}).
+					AddRow("00000000-0000-0000-0000-000000000002", orgID, "BNA", "1001").
+					AddRow("00000000-0000-0000-0000-000000000003", orgID, "ATL", "1001"),
+			)
+	}
+
+	testCases := []struct {
+		description          string
+		giveAssociations     []tags.Association
+		expectationFunction  func(sqlmock.Sqlmock)
+		wantReturnInterfaces []interface{}
+		wantErr              string
+	}{
+		{
+			"attaches children matched to their parents by a tuple of fields",
+			[]tags.Association{
+				{
+					Name: "Picks",
+					Associations: []tags.Association{
+						{
+							Name: "Bin",
+						},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				expectDispatches(mock)
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.order_number AS "t0.order_number",
+						t0.bin_id AS "t0.bin_id",
+						t1.id AS "t1.id",
+						t1.organization_id AS "t1.organization_id",
+						t1.warehouse_code AS "t1.warehouse_code"
+					FROM pick AS t0
+					LEFT JOIN bin AS t1 ON
+						(t1.id = t0.bin_id AND t1.organization_id = $1)
+					WHERE
+						t0.organization_id = $2 AND
+						(t1.warehouse_code, t0.order_number) IN (($3, $4), ($5, $6))
+				`)).
+					WithArgs(orgID, orgID, "BNA", "1001", "ATL", "1001").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.order_number", "t0.bin_id", "t1.id", "t1.warehouse_code"}).
+							AddRow("00000000-0000-0000-0000-000000000021", "1001", "00000000-0000-0000-0000-000000000031", "00000000-0000-0000-0000-000000000031", "ATL").
+							AddRow("00000000-0000-0000-0000-000000000022", "1001", "00000000-0000-0000-0000-000000000032", "00000000-0000-0000-0000-000000000032", "BNA"),
+					)
+			},
+			[]interface{}{
+				dispatchModel{
+					ID:             "00000000-0000-0000-0000-000000000002",
+					OrganizationID: orgID,
+					WarehouseCode:  "BNA",
+					OrderNumber:    "1001",
+					Picks: []pickModel{
+						{
+							ID:          "00000000-0000-0000-0000-000000000022",
+							OrderNumber: "1001",
+							BinID:       "00000000-0000-0000-0000-000000000032",
+							Bin: binModel{
+								ID:            "00000000-0000-0000-0000-000000000032",
+								WarehouseCode: "BNA",
+							},
+						},
+					},
+				},
+				dispatchModel{
+					ID:             "00000000-0000-0000-0000-000000000003",
+					OrganizationID: orgID,
+					WarehouseCode:  "ATL",
+					OrderNumber:    "1001",
+					Picks: []pickModel{
+						{
+							ID:          "00000000-0000-0000-0000-000000000021",
+							OrderNumber: "1001",
+							BinID:       "00000000-0000-0000-0000-000000000031",
+							Bin: binModel{
+								ID:            "00000000-0000-0000-0000-000000000031",
+								WarehouseCode: "ATL",
+							},
+						},
+					},
+				},
+			},
+			"",
+		},
+		{
+			"errors when a grouping field's association isn't loaded",
+			[]tags.Association{
+				{
+					Name: "Picks",
+				},
+			},
+			expectDispatches,
+			nil,
+			"'grouping_criteria' field 'Bin.WarehouseCode' requires the association 'Bin' to be loaded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:  dispatchModel{},
+				Associations: tc.giveAssociations,
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantReturnInterfaces, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package picard
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/skuid/picard/dbchange"
+)
+
+// BeforeSaver is implemented by models that need to run logic right before they are inserted or
+// updated. Changes made to the model in BeforeSave are persisted. Returning an error rolls back the
+// whole operation.
+type BeforeSaver interface {
+	BeforeSave() error
+}
+
+// AfterSaver is implemented by models that need to run logic right after they are inserted or
+// updated. AfterSave runs inside the transaction, after the primary key has been set on the model.
+// Returning an error rolls back the whole operation.
+type AfterSaver interface {
+	AfterSave() error
+}
+
+// hookTarget returns the model as a pointer when possible, so hooks with pointer receivers are found.
+// A model passed by value can't be addressed, so its pointer receiver hooks can't be called. Rather than
+// skipping them silently, that returns an error.
+func hookTarget(value reflect.Value, hookType reflect.Type) (interface{}, error) {
+	if value.CanAddr() {
+		return value.Addr().Interface(), nil
+	}
+	if !value.Type().Implements(hookType) && reflect.PtrTo(value.Type()).Implements(hookType) {
+		return nil, fmt.Errorf("type '%v' has a %s hook with a pointer receiver, so the model must be passed as a pointer", value.Type(), hookType.Method(0).Name)
+	}
+	return value.Interface(), nil
+}
+
+func callBeforeSave(value reflect.Value) error {
+	target, err := hookTarget(value, reflect.TypeOf((*BeforeSaver)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+	if hook, ok := target.(BeforeSaver); ok {
+		return hook.BeforeSave()
+	}
+	return nil
+}
+
+func callAfterSave(value reflect.Value) error {
+	target, err := hookTarget(value, reflect.TypeOf((*AfterSaver)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+	if hook, ok := target.(AfterSaver); ok {
+		return hook.AfterSave()
+	}
+	return nil
+}
+
+// callAfterSaveForChangeSet calls AfterSave on every model that was updated or inserted by the change set
+func callAfterSaveForChangeSet(changeSet *dbchange.ChangeSet) error {
+	for _, changes := range [][]dbchange.Change{changeSet.Updates, changeSet.Inserts} {
+		for _, change := range changes {
+			if !change.OriginalValue.IsValid() || change.Conflict {
+				continue
+			}
+			if err := callAfterSave(change.OriginalValue); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
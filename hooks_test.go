@@ -0,0 +1,186 @@
+package picard
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+type hookedModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField        string `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string `picard:"column=test_column_one"`
+
+	events        *[]string
+	beforeSaveErr error
+	afterSaveErr  error
+}
+
+func (m *hookedModel) BeforeSave() error {
+	m.TestFieldOne = strings.ToLower(m.TestFieldOne)
+	*m.events = append(*m.events, "before "+m.TestFieldOne)
+	return m.beforeSaveErr
+}
+
+func (m *hookedModel) AfterSave() error {
+	*m.events = append(*m.events, "after "+m.PrimaryKeyField)
+	return m.afterSaveErr
+}
+
+func TestSaveModelHooks(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	testPrimaryKeyValue := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description         string
+		giveBeforeSaveErr   error
+		giveAfterSaveErr    error
+		expectationFunction func(sqlmock.Sqlmock)
+		wantEvents          []string
+		wantErr             error
+	}{
+		{
+			"calls BeforeSave before the insert and AfterSave with the new primary key",
+			nil,
+			nil,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column"$`).
+					WithArgs(testMultitenancyValue, "kayak").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+					)
+				mock.ExpectCommit()
+			},
+			[]string{"before kayak", "after " + testPrimaryKeyValue},
+			nil,
+		},
+		{
+			"rolls back without writing when BeforeSave fails",
+			errors.New("before save error"),
+			nil,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			[]string{"before kayak"},
+			errors.New("before save error"),
+		},
+		{
+			"rolls back the insert when AfterSave fails",
+			nil,
+			errors.New("after save error"),
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column"$`).
+					WithArgs(testMultitenancyValue, "kayak").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+					)
+				mock.ExpectRollback()
+			},
+			[]string{"before kayak", "after " + testPrimaryKeyValue},
+			errors.New("after save error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+			tc.expectationFunction(mock)
+
+			events := []string{}
+			model := &hookedModel{
+				TestFieldOne:  "KAYAK",
+				events:        &events,
+				beforeSaveErr: tc.giveBeforeSaveErr,
+				afterSaveErr:  tc.giveAfterSaveErr,
+			}
+
+			p := PersistenceORM{
+				multitenancyValue: testMultitenancyValue,
+			}
+			err = p.SaveModel(model)
+
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantEvents, events)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestSaveModelHooksByValue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	events := []string{}
+	p := PersistenceORM{
+		multitenancyValue: "00000000-0000-0000-0000-000000000005",
+	}
+	err = p.SaveModel(hookedModel{TestFieldOne: "KAYAK", events: &events})
+
+	assert.EqualError(t, err, "type 'picard.hookedModel' has a BeforeSave hook with a pointer receiver, so the model must be passed as a pointer")
+	assert.Empty(t, events)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestDeployHooks(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\),\(\$3,\$4\) RETURNING "primary_key_column"$`).
+		WithArgs(testMultitenancyValue, "ice", testMultitenancyValue, "snow").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"primary_key_column"}).
+				AddRow("00000000-0000-0000-0000-000000000001").
+				AddRow("00000000-0000-0000-0000-000000000002"),
+		)
+	mock.ExpectCommit()
+
+	events := []string{}
+	p := PersistenceORM{
+		multitenancyValue: testMultitenancyValue,
+		batchSize:         100,
+	}
+	err = p.Deploy([]hookedModel{
+		{TestFieldOne: "ICE", events: &events},
+		{TestFieldOne: "Snow", events: &events},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"before ice",
+		"before snow",
+		"after 00000000-0000-0000-0000-000000000001",
+		"after 00000000-0000-0000-0000-000000000002",
+	}, events)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
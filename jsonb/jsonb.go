@@ -0,0 +1,126 @@
+/*
+Package jsonb serializes the values of fields tagged with `jsonb`
+
+Values are marshaled with encoding/json unless a codec is registered for them. A codec registered with
+RegisterCodec is used by fields that name it in their tag, like `jsonb=ordered`, and a codec registered with
+RegisterTypeCodec is used by every jsonb field of that type. Codecs are registered once, when the program
+starts, like the encryption key.
+
+A jsonb field with an interface type holds one of several concrete types, picked by the value of another field
+named in its tag, like `jsonb,discriminator=Type`. Register the concrete type for each value of the
+discriminator with RegisterVariant.
+*/
+package jsonb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Codec marshals values of jsonb fields to JSON and unmarshals them back
+type Codec struct {
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{}
+	typeCodecs = map[reflect.Type]Codec{}
+	variants   = map[reflect.Type]map[string]reflect.Type{}
+)
+
+// RegisterCodec registers a codec that fields use by naming it in their jsonb tag
+func RegisterCodec(name string, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[name] = codec
+}
+
+// RegisterTypeCodec registers a codec for every jsonb field of a type that doesn't name a codec in its tag
+func RegisterTypeCodec(typ reflect.Type, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	typeCodecs[typ] = codec
+}
+
+// RegisterVariant registers the concrete type of variant as the type that jsonb fields of the interface type
+// iface are unmarshaled into when their discriminator is discriminator. It panics if the variant doesn't
+// implement the interface, like a mistake in registering a database driver does.
+func RegisterVariant(iface reflect.Type, discriminator string, variant interface{}) {
+	variantType := reflect.TypeOf(variant)
+	if iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("jsonb: variants can only be registered for interface types, not %s", iface))
+	}
+	if variantType == nil || !variantType.Implements(iface) {
+		panic(fmt.Sprintf("jsonb: variant %v for discriminator '%s' doesn't implement %s", variantType, discriminator, iface))
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	if variants[iface] == nil {
+		variants[iface] = map[string]reflect.Type{}
+	}
+	variants[iface][discriminator] = variantType
+}
+
+// VariantType returns the concrete type registered for a discriminator of the interface type iface
+func VariantType(iface reflect.Type, discriminator string) (reflect.Type, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	variantType, ok := variants[iface][discriminator]
+	return variantType, ok
+}
+
+// Marshal serializes the value of a jsonb field with the named codec, the codec registered for the value's type,
+// or else encoding/json
+func Marshal(codecName string, v interface{}) ([]byte, error) {
+	codec, ok, err := lookup(codecName, reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return json.Marshal(v)
+	}
+	return codec.Marshal(v)
+}
+
+// Unmarshal deserializes the value of a jsonb field into v, a pointer to the field's type, with the named codec,
+// the codec registered for the field's type, or else encoding/json
+func Unmarshal(codecName string, data []byte, v interface{}) error {
+	codec, ok, err := lookup(codecName, reflect.TypeOf(v).Elem())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return json.Unmarshal(data, v)
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// HasCodec reports whether a jsonb field of the type, naming codecName in its tag, is serialized with a codec
+// instead of encoding/json
+func HasCodec(codecName string, typ reflect.Type) bool {
+	if codecName != "" {
+		return true
+	}
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	_, ok := typeCodecs[typ]
+	return ok
+}
+
+func lookup(codecName string, typ reflect.Type) (Codec, bool, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	if codecName != "" {
+		codec, ok := codecs[codecName]
+		if !ok {
+			return Codec{}, false, fmt.Errorf("no jsonb codec registered with the name '%s'", codecName)
+		}
+		return codec, true, nil
+	}
+	codec, ok := typeCodecs[typ]
+	return codec, ok, nil
+}
@@ -0,0 +1,127 @@
+package jsonb
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type labels []string
+
+func TestCodecs(t *testing.T) {
+	RegisterCodec("csv", Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			return json.Marshal(strings.Join(v.([]string), ","))
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			var joined string
+			if err := json.Unmarshal(data, &joined); err != nil {
+				return err
+			}
+			*v.(*[]string) = strings.Split(joined, ",")
+			return nil
+		},
+	})
+	RegisterTypeCodec(reflect.TypeOf(labels{}), Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			return json.Marshal(map[string][]string{"labels": v.(labels)})
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			var wrapped map[string][]string
+			if err := json.Unmarshal(data, &wrapped); err != nil {
+				return err
+			}
+			*v.(*labels) = wrapped["labels"]
+			return nil
+		},
+	})
+
+	testCases := []struct {
+		description   string
+		giveCodecName string
+		giveValue     interface{}
+		wantJSON      string
+		wantErr       string
+	}{
+		{
+			"should use encoding/json without a codec",
+			"",
+			[]string{"a", "b"},
+			`["a","b"]`,
+			"",
+		},
+		{
+			"should use the codec named in the tag",
+			"csv",
+			[]string{"a", "b"},
+			`"a,b"`,
+			"",
+		},
+		{
+			"should use the codec registered for the type",
+			"",
+			labels{"a", "b"},
+			`{"labels":["a","b"]}`,
+			"",
+		},
+		{
+			"should fail for a codec that isn't registered",
+			"missing",
+			[]string{"a", "b"},
+			"",
+			"no jsonb codec registered with the name 'missing'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			serialized, err := Marshal(tc.giveCodecName, tc.giveValue)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantJSON, string(serialized))
+
+			destination := reflect.New(reflect.TypeOf(tc.giveValue))
+			assert.NoError(t, Unmarshal(tc.giveCodecName, serialized, destination.Interface()))
+			assert.Equal(t, tc.giveValue, destination.Elem().Interface())
+		})
+	}
+
+	assert.True(t, HasCodec("csv", reflect.TypeOf([]string{})))
+	assert.True(t, HasCodec("", reflect.TypeOf(labels{})))
+	assert.False(t, HasCodec("", reflect.TypeOf([]string{})))
+}
+
+type shape interface {
+	Area() float64
+}
+
+type square struct {
+	Side float64 `json:"side"`
+}
+
+func (s square) Area() float64 { return s.Side * s.Side }
+
+func TestVariants(t *testing.T) {
+	shapeType := reflect.TypeOf((*shape)(nil)).Elem()
+	RegisterVariant(shapeType, "square", square{})
+
+	variantType, ok := VariantType(shapeType, "square")
+	assert.True(t, ok)
+	assert.Equal(t, reflect.TypeOf(square{}), variantType)
+
+	_, ok = VariantType(shapeType, "circle")
+	assert.False(t, ok)
+
+	assert.PanicsWithValue(t, "jsonb: variant jsonb.labels for discriminator 'labels' doesn't implement jsonb.shape", func() {
+		RegisterVariant(shapeType, "labels", labels{})
+	})
+	assert.PanicsWithValue(t, "jsonb: variants can only be registered for interface types, not jsonb.square", func() {
+		RegisterVariant(reflect.TypeOf(square{}), "square", square{})
+	})
+}
@@ -0,0 +1,201 @@
+package picard
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/jsonb"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type attributesModel struct {
+	Metadata metadata.Metadata `picard:"tablename=attributes_model"`
+
+	ID             string                 `picard:"primary_key,column=id"`
+	OrganizationID string                 `picard:"multitenancy_key,column=organization_id"`
+	Name           string                 `picard:"lookup,column=name"`
+	Attributes     map[string]interface{} `picard:"jsonb,column=attributes"`
+}
+
+func TestJSONBMapRoundTrip(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO attributes_model (organization_id,name,attributes) VALUES ($1,$2,$3) RETURNING "id"
+	`)).
+		WithArgs(orgID, "widget", []byte(`{"color":"red","sizes":[1,2]}`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("00000000-0000-0000-0000-000000000002"))
+	mock.ExpectCommit()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name",
+			t0.attributes AS "t0.attributes"
+		FROM attributes_model AS t0
+		WHERE t0.organization_id = $1 AND t0.name = $2
+	`)).
+		WithArgs(orgID, "widget").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name", "t0.attributes"}).
+				AddRow("00000000-0000-0000-0000-000000000002", orgID, "widget", []byte(`{"color":"red","sizes":[1,2]}`)),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		performedBy:       sampleUserID,
+	}
+	assert.NoError(t, p.CreateModel(&attributesModel{
+		Name:       "widget",
+		Attributes: map[string]interface{}{"color": "red", "sizes": []interface{}{1, 2}},
+	}))
+
+	results, err := p.FilterModel(FilterRequest{FilterModel: attributesModel{Name: "widget"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		attributesModel{
+			ID:             "00000000-0000-0000-0000-000000000002",
+			OrganizationID: orgID,
+			Name:           "widget",
+			Attributes:     map[string]interface{}{"color": "red", "sizes": []interface{}{float64(1), float64(2)}},
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestDeployJSONBMap(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT attributes_model.id, attributes_model.name as attributes_model_name
+		FROM attributes_model
+		WHERE COALESCE(attributes_model.name::"varchar",'') = ANY($1) AND attributes_model.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"widget"}), orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "attributes_model_name"}).AddRow("00000000-0000-0000-0000-000000000002", "widget"),
+		)
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		UPDATE attributes_model SET name = $1, attributes = $2 WHERE organization_id = $3 AND id = $4
+	`)).
+		WithArgs("widget", []byte(`{"color":"blue"}`), orgID, "00000000-0000-0000-0000-000000000002").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	p := New(orgID, sampleUserID)
+	assert.NoError(t, p.Deploy([]attributesModel{
+		{Name: "widget", Attributes: map[string]interface{}{"color": "blue"}},
+	}))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+// versionedConfig is stored wrapped in an envelope with its version by a jsonb codec
+type versionedConfig struct {
+	Theme string
+}
+
+type configModel struct {
+	Metadata metadata.Metadata `picard:"tablename=config_model"`
+
+	ID             string          `picard:"primary_key,column=id"`
+	OrganizationID string          `picard:"multitenancy_key,column=organization_id"`
+	Config         versionedConfig `picard:"jsonb,column=config"`
+}
+
+func TestJSONBTypeCodec(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	modelID := "00000000-0000-0000-0000-000000000002"
+	jsonb.RegisterTypeCodec(reflect.TypeOf(versionedConfig{}), jsonb.Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			return json.Marshal(map[string]interface{}{"version": 2, "theme": v.(versionedConfig).Theme})
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			envelope := map[string]interface{}{}
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				return err
+			}
+			if envelope["version"] != float64(2) {
+				return fmt.Errorf("unsupported config version %v", envelope["version"])
+			}
+			v.(*versionedConfig).Theme = envelope["theme"].(string)
+			return nil
+		},
+	})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO config_model (organization_id,config) VALUES ($1,$2) RETURNING "id"
+	`)).
+		WithArgs(orgID, []byte(`{"theme":"dark","version":2}`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(modelID))
+	mock.ExpectCommit()
+	filterSQL := testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.config AS "t0.config"
+		FROM config_model AS t0
+		WHERE t0.organization_id = $1
+	`)
+	mock.ExpectQuery(filterSQL).
+		WithArgs(orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.config"}).
+				AddRow(modelID, orgID, []byte(`{"theme":"dark","version":2}`)),
+		)
+	mock.ExpectQuery(filterSQL).
+		WithArgs(orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.config"}).
+				AddRow(modelID, orgID, []byte(`{"theme":"dark","version":1}`)),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		performedBy:       sampleUserID,
+	}
+	assert.NoError(t, p.CreateModel(&configModel{Config: versionedConfig{Theme: "dark"}}))
+
+	results, err := p.FilterModel(FilterRequest{FilterModel: configModel{}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		configModel{ID: modelID, OrganizationID: orgID, Config: versionedConfig{Theme: "dark"}},
+	}, results)
+
+	_, err = p.FilterModel(FilterRequest{FilterModel: configModel{}})
+	assert.EqualError(t, err, "unsupported config version 1")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
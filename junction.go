@@ -0,0 +1,129 @@
+package picard
+
+import (
+	"fmt"
+	"reflect"
+
+	sq "github.com/Masterminds/squirrel"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/tags"
+)
+
+/*
+populateJunctionChildren loads the children of a many-to-many relationship and attaches them to the parent
+results. The links are read from the junction table first, and the linked children are then filtered like
+any other association, so they keep their own OrderBy, FieldFilters and nested associations. Both the
+junction table and the child table are filtered by the multitenancy value.
+*/
+func (p PersistenceORM) populateJunctionChildren(request FilterRequest, results []*reflect.Value, association tags.Association, child *tags.Child, filterMetadata *tags.TableMetadata) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	junction := child.Junction
+	childType := child.FieldType.Elem()
+	childMetadata := tags.TableMetadataFromType(childType)
+	childPrimaryKeyFieldName := childMetadata.GetPrimaryKeyFieldName()
+	parentPrimaryKeyFieldName := filterMetadata.GetPrimaryKeyFieldName()
+	if childPrimaryKeyFieldName == "" {
+		return fmt.Errorf("missing 'primary_key' tag on type '%v'", childType)
+	}
+	// A junction table links the parent and child through one key column each
+	if childMetadata.HasCompositePrimaryKey() || filterMetadata.HasCompositePrimaryKey() {
+		return fmt.Errorf("junction children aren't supported between '%s' and '%s', which need a primary key of one column", filterMetadata.GetTableName(), childMetadata.GetTableName())
+	}
+
+	parentKeys := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		parentKeys = append(parentKeys, result.FieldByName(parentPrimaryKeyFieldName).Interface())
+	}
+
+	multitenancyKeyColumnName := junction.MultitenancyKeyColumn
+	if multitenancyKeyColumnName == "" {
+		multitenancyKeyColumnName = childMetadata.GetMultitenancyKeyColumnName()
+	}
+
+	query := sq.Select(junction.ParentKeyColumn, junction.ChildKeyColumn).
+		PlaceholderFormat(sq.Dollar).
+		From(junction.TableName)
+	if multitenancyKeyColumnName != "" {
+		query = query.Where(qp.MultitenancyWhere(multitenancyKeyColumnName, p.readMultitenancyValue()))
+	}
+	query = query.Where(sq.Eq{junction.ParentKeyColumn: parentKeys})
+
+	rows, err := query.RunWith(request.Runner).Query()
+	if err != nil {
+		q, _, _ := query.ToSql()
+		return newQueryError(err, q)
+	}
+	links, err := getQueryResults(rows)
+	if err != nil {
+		return err
+	}
+
+	linkedChildren := map[string]map[string]bool{}
+	filteredChildren := map[string]bool{}
+	newFilterList := reflect.Indirect(reflect.New(reflect.SliceOf(childType)))
+	for _, link := range links {
+		parentKey := junctionKey(link[junction.ParentKeyColumn])
+		childKey := junctionKey(link[junction.ChildKeyColumn])
+		if linkedChildren[parentKey] == nil {
+			linkedChildren[parentKey] = map[string]bool{}
+		}
+		linkedChildren[parentKey][childKey] = true
+		if filteredChildren[childKey] {
+			continue
+		}
+		filteredChildren[childKey] = true
+
+		newFilter := reflect.Indirect(reflect.New(childType))
+		primaryKeyField := newFilter.FieldByName(childPrimaryKeyFieldName)
+		if primaryKeyField.Kind() != reflect.String {
+			return fmt.Errorf("'primary_key' field '%s' on junction child type '%v' must be a string", childPrimaryKeyFieldName, childType)
+		}
+		primaryKeyField.SetString(childKey)
+		newFilterList = reflect.Append(newFilterList, newFilter)
+	}
+
+	if newFilterList.Len() == 0 {
+		return nil
+	}
+
+	selectFields, groupingFields := withGroupingFields(association.SelectFields, []string{childPrimaryKeyFieldName})
+
+	childResults, err := p.FilterModel(FilterRequest{
+		FilterModel:    newFilterList.Interface(),
+		Associations:   association.Associations,
+		OrderBy:        association.OrderBy,
+		Runner:         request.Runner,
+		FieldFilters:   association.FieldFilters,
+		SelectFields:   selectFields,
+		SkipDecryption: request.SkipDecryption,
+		DecryptFields:  associationDecryptFields(request.DecryptFields, association.Name),
+		IncludeDeleted: request.IncludeDeleted,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		parentKey := junctionKey(result.FieldByName(parentPrimaryKeyFieldName).Interface())
+		parentChildRelField := result.FieldByName(child.FieldName)
+		for _, childResult := range childResults {
+			childValue := reflect.ValueOf(childResult)
+			if linkedChildren[parentKey][junctionKey(childValue.FieldByName(childPrimaryKeyFieldName).Interface())] {
+				parentChildRelField.Set(reflect.Append(parentChildRelField, clearFields(childValue, groupingFields)))
+			}
+		}
+	}
+
+	return nil
+}
+
+// junctionKey returns a key value read from the database or a model as a string, so keys can be compared
+func junctionKey(value interface{}) string {
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,159 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type siblingPersonModel struct {
+	Metadata metadata.Metadata `picard:"tablename=personmodel"`
+
+	ID             string                 `picard:"primary_key,column=id"`
+	OrganizationID string                 `picard:"multitenancy_key,column=organization_id"`
+	Name           string                 `picard:"lookup,column=name"`
+	Siblings       []testdata.PersonModel `picard:"child,junction=siblingjunction,junction_parent=child_id,junction_child=sibling_id"`
+}
+
+func TestFilterModelJunctionChildren(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	fredID := "00000000-0000-0000-0000-000000000002"
+	georgeID := "00000000-0000-0000-0000-000000000003"
+	ginnyID := "00000000-0000-0000-0000-000000000004"
+	ronID := "00000000-0000-0000-0000-000000000005"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id = $1
+	`)).
+		WithArgs(orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(fredID, orgID, "Fred").
+				AddRow(georgeID, orgID, "George"),
+		)
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT child_id, sibling_id FROM siblingjunction
+		WHERE organization_id = $1 AND child_id IN ($2,$3)
+	`)).
+		WithArgs(orgID, fredID, georgeID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"child_id", "sibling_id"}).
+				AddRow(fredID, georgeID).
+				AddRow(fredID, ginnyID).
+				AddRow(georgeID, ginnyID).
+				AddRow(georgeID, fredID),
+		)
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id = $1 AND ((t0.id = $2) OR (t0.id = $3) OR (t0.id = $4))
+		ORDER BY t0.name
+	`)).
+		WithArgs(orgID, georgeID, ginnyID, fredID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(fredID, orgID, "Fred").
+				AddRow(georgeID, orgID, "George").
+				AddRow(ginnyID, orgID, "Ginny"),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: siblingPersonModel{},
+		Associations: []tags.Association{
+			{
+				Name:    "Siblings",
+				OrderBy: []qp.OrderByRequest{{Field: "Name"}},
+			},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		siblingPersonModel{
+			ID:             fredID,
+			OrganizationID: orgID,
+			Name:           "Fred",
+			Siblings: []testdata.PersonModel{
+				{ID: georgeID, OrganizationID: orgID, Name: "George"},
+				{ID: ginnyID, OrganizationID: orgID, Name: "Ginny"},
+			},
+		},
+		siblingPersonModel{
+			ID:             georgeID,
+			OrganizationID: orgID,
+			Name:           "George",
+			Siblings: []testdata.PersonModel{
+				{ID: fredID, OrganizationID: orgID, Name: "Fred"},
+				{ID: ginnyID, OrganizationID: orgID, Name: "Ginny"},
+			},
+		},
+	}, results)
+
+	// Ron isn't linked to anyone, so he has no siblings and no junction children query is run
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id = $1 AND t0.name = $2
+	`)).
+		WithArgs(orgID, "Ron").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(ronID, orgID, "Ron"),
+		)
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT child_id, sibling_id FROM siblingjunction
+		WHERE organization_id = $1 AND child_id IN ($2)
+	`)).
+		WithArgs(orgID, ronID).
+		WillReturnRows(sqlmock.NewRows([]string{"child_id", "sibling_id"}))
+
+	results, err = p.FilterModel(FilterRequest{
+		FilterModel: siblingPersonModel{
+			Name: "Ron",
+		},
+		Associations: []tags.Association{
+			{
+				Name: "Siblings",
+			},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		siblingPersonModel{
+			ID:             ronID,
+			OrganizationID: orgID,
+			Name:           "Ron",
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
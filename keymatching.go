@@ -0,0 +1,84 @@
+package picard
+
+import (
+	"reflect"
+
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/tags"
+)
+
+/*
+WithPerRowKeyMatching returns a copy of the ORM that matches each deployed model to an existing row by its
+primary key when it sets one, and by its lookup fields otherwise.
+
+By default, a batch that contains any model with a primary key is matched by primary key only, so models
+without one are always inserted, even when a row with the same lookup values exists. That fails on tables
+with a unique constraint over the lookup columns. With per-row matching, a batch that mixes both kinds of
+models runs one existence query for each, which costs an extra query per batch. A model that sets a
+primary key that doesn't exist yet is still inserted, even if its lookup values match another row.
+*/
+func (p PersistenceORM) WithPerRowKeyMatching() ORM {
+	p.perRowKeyMatching = true
+	return &p
+}
+
+/*
+checkForExistingByKey looks up the existing rows for the models being deployed. When the ORM matches keys per
+row and the models mix set and unset primary keys, the models with a primary key are looked up separately and
+their results and lookups are returned second. Otherwise those are nil.
+*/
+func (p PersistenceORM) checkForExistingByKey(data interface{}, tableMetadata *tags.TableMetadata) (
+	map[string]interface{},
+	[]tags.Lookup,
+	map[string]interface{},
+	[]tags.Lookup,
+	error,
+) {
+	// Native upserts match rows with ON CONFLICT, so only the lookups that key the models are needed
+	if p.usesNativeUpsertForBatch(data, tableMetadata) {
+		return map[string]interface{}{}, getLookupsForDeploy(data, tableMetadata, nil, map[string]string{}), nil, nil, nil
+	}
+
+	withPrimaryKey, withoutPrimaryKey := splitByPrimaryKey(data, tableMetadata)
+	if !p.perRowKeyMatching || withPrimaryKey.Len() == 0 || withoutPrimaryKey.Len() == 0 {
+		lookupResults, lookups, err := p.checkForExisting(data, tableMetadata, nil)
+		return lookupResults, lookups, nil, nil, err
+	}
+
+	primaryKeyLookupResults, primaryKeyLookups, err := p.checkForExisting(withPrimaryKey.Interface(), tableMetadata, nil)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	lookupResults, lookups, err := p.checkForExisting(withoutPrimaryKey.Interface(), tableMetadata, nil)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return lookupResults, lookups, primaryKeyLookupResults, primaryKeyLookups, nil
+}
+
+// splitByPrimaryKey splits a slice of models into the models that set their primary key and the ones that don't
+func splitByPrimaryKey(data interface{}, tableMetadata *tags.TableMetadata) (reflect.Value, reflect.Value) {
+	dataValue := reflect.ValueOf(data)
+	withPrimaryKey := reflect.MakeSlice(dataValue.Type(), 0, 0)
+	withoutPrimaryKey := reflect.MakeSlice(dataValue.Type(), 0, 0)
+	primaryKeyFieldName := tableMetadata.GetPrimaryKeyFieldName()
+	for i := 0; i < dataValue.Len(); i++ {
+		if primaryKeyFieldName != "" && getObjectProperty(dataValue.Index(i), primaryKeyFieldName) != "" {
+			withPrimaryKey = reflect.Append(withPrimaryKey, dataValue.Index(i))
+		} else {
+			withoutPrimaryKey = reflect.Append(withoutPrimaryKey, dataValue.Index(i))
+		}
+	}
+	return withPrimaryKey, withoutPrimaryKey
+}
+
+// changeSetKeys returns every key a model could have been matched with in a change set, starting with its
+// primary key lookup when the change set has one. Deploying sets the primary keys of inserted models, so a
+// model's primary key can't tell which lookups were used for it.
+func changeSetKeys(value reflect.Value, changeSet *dbchange.ChangeSet) []string {
+	keys := []string{}
+	if changeSet.PrimaryKeyLookupsUsed != nil {
+		keys = append(keys, getObjectKeyReflect(value, changeSet.PrimaryKeyLookupsUsed))
+	}
+	return append(keys, getObjectKeyReflect(value, changeSet.LookupsUsed))
+}
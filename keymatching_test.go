@@ -0,0 +1,116 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployPerRowKeyMatching(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	fredID := "00000000-0000-0000-0000-000000000002"
+	georgeID := "00000000-0000-0000-0000-000000000003"
+	primaryKeyLookupSQL := testdata.FmtSQLRegex(`
+		SELECT personmodel.id, personmodel.id as personmodel_id
+		FROM personmodel
+		WHERE COALESCE(personmodel.id::"varchar",'') = ANY($1) AND personmodel.organization_id = $2
+	`)
+	naturalKeyLookupSQL := testdata.FmtSQLRegex(`
+		SELECT personmodel.id, personmodel.name as personmodel_name
+		FROM personmodel
+		WHERE COALESCE(personmodel.name::"varchar",'') = ANY($1) AND personmodel.organization_id = $2
+	`)
+	updateSQL := testdata.FmtSQLRegex(`UPDATE personmodel SET name = $1 WHERE organization_id = $2 AND id = $3`)
+
+	testCases := []struct {
+		description         string
+		givePerRow          bool
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []DeployResult
+	}{
+		{
+			"matches each model by its primary key or its lookup fields",
+			true,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(primaryKeyLookupSQL).
+					WithArgs(pq.Array([]string{fredID}), orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "personmodel_id"}).
+							AddRow(fredID, fredID),
+					)
+				mock.ExpectQuery(naturalKeyLookupSQL).
+					WithArgs(pq.Array([]string{"George"}), orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "personmodel_name"}).
+							AddRow(georgeID, "George"),
+					)
+				mock.ExpectExec(updateSQL).
+					WithArgs("Fred", orgID, fredID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(updateSQL).
+					WithArgs("George", orgID, georgeID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			[]DeployResult{
+				{Key: fredID, PrimaryKey: fredID, Type: dbchange.Update},
+				{Key: "George", PrimaryKey: georgeID, Type: dbchange.Update},
+			},
+		},
+		{
+			"matches the whole batch by primary key by default",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(primaryKeyLookupSQL).
+					WithArgs(pq.Array([]string{fredID}), orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "personmodel_id"}).
+							AddRow(fredID, fredID),
+					)
+				mock.ExpectExec(updateSQL).
+					WithArgs("Fred", orgID, fredID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(`^INSERT INTO personmodel \(organization_id,name\) VALUES \(\$1,\$2\) RETURNING "id"$`).
+					WithArgs(orgID, "George").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(georgeID))
+				mock.ExpectCommit()
+			},
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := New(orgID, sampleUserID)
+			if tc.givePerRow {
+				p = p.WithPerRowKeyMatching()
+			}
+			results, err := p.DeployWithResults([]testdata.PersonModel{
+				{ID: fredID, Name: "Fred"},
+				{Name: "George"},
+			})
+
+			assert.NoError(t, err)
+			if tc.wantResults != nil {
+				assert.Equal(t, tc.wantResults, results)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
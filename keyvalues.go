@@ -0,0 +1,130 @@
+package picard
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/skuid/picard/tags"
+)
+
+// formatKeyValue returns the string form of a key or lookup field, as compared against values cast to varchar
+// by the database. Zero values and nil pointers are returned as an empty string, since picard treats them as
+// unset, so a zero integer or nil UUID primary key is the same as an empty string one.
+func formatKeyValue(val reflect.Value) string {
+	if !val.IsValid() {
+		return ""
+	}
+	if val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return ""
+		}
+		return formatKeyValue(val.Elem())
+	}
+	if val.IsZero() {
+		return ""
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		return val.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(val.Uint(), 10)
+	}
+	if val.CanInterface() {
+		if stringer, ok := val.Interface().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+		return fmt.Sprint(val.Interface())
+	}
+	return ""
+}
+
+// formatDBKeyValue returns the string form of a key or lookup value read from the database, matching
+// formatKeyValue for the struct field it was read for
+func formatDBKeyValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// getPrimaryKeyValue returns the string form of a model's primary key. The values of a composite primary key are
+// joined with the lookup separator, and a key missing any of them is returned as an empty string, since it's unset.
+func getPrimaryKeyValue(value reflect.Value, tableMetadata *tags.TableMetadata) string {
+	keyParts := []string{}
+	for _, fieldName := range tableMetadata.GetPrimaryKeyFieldNames() {
+		keyPart := getObjectProperty(value, fieldName)
+		if keyPart == "" {
+			return ""
+		}
+		keyParts = append(keyParts, keyPart)
+	}
+	return strings.Join(keyParts, separator)
+}
+
+// isEmptyKey reports whether a primary key value is unset
+func isEmptyKey(value interface{}) bool {
+	return formatKeyValue(reflect.ValueOf(value)) == ""
+}
+
+// setKeyValue sets a primary or foreign key field to a key value, either read from the database or copied
+// from another model. String, integer and pointer fields are converted to, and fields that implement
+// sql.Scanner, like uuid.UUID, scan the value. It reports whether the field was set.
+func setKeyValue(field reflect.Value, value interface{}) bool {
+	if !field.CanSet() || value == nil {
+		return false
+	}
+
+	val := reflect.ValueOf(value)
+	if val.Type().AssignableTo(field.Type()) {
+		field.Set(val)
+		return true
+	}
+
+	if field.Kind() == reflect.Pointer {
+		elem := reflect.New(field.Type().Elem())
+		if !setKeyValue(elem.Elem(), value) {
+			return false
+		}
+		field.Set(elem)
+		return true
+	}
+
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value) == nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(formatDBKeyValue(value))
+		return true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(formatDBKeyValue(value), 10, 64)
+		if err != nil {
+			return false
+		}
+		field.SetInt(parsed)
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(formatDBKeyValue(value), 10, 64)
+		if err != nil {
+			return false
+		}
+		field.SetUint(parsed)
+		return true
+	}
+	return false
+}
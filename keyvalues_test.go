@@ -0,0 +1,211 @@
+package picard
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type serialKeyModel struct {
+	Metadata metadata.Metadata `picard:"tablename=serial_key_model"`
+
+	ID             int64  `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"lookup,column=name"`
+}
+
+type uuidKeyModel struct {
+	Metadata metadata.Metadata `picard:"tablename=uuid_key_model"`
+
+	ID             uuid.UUID `picard:"primary_key,column=id"`
+	OrganizationID string    `picard:"multitenancy_key,column=organization_id"`
+	Name           string    `picard:"lookup,column=name"`
+}
+
+func TestFormatKeyValue(t *testing.T) {
+	id := uuid.FromStringOrNil("00000000-0000-0000-0000-000000000002")
+	name := "Fred"
+	var noName *string
+
+	testCases := []struct {
+		description string
+		giveValue   interface{}
+		wantKey     string
+	}{
+		{"should return strings", "Fred", "Fred"},
+		{"should dereference pointers", &name, "Fred"},
+		{"should return nil pointers as empty", noName, ""},
+		{"should format integers", int64(42), "42"},
+		{"should return zero integers as empty", int64(0), ""},
+		{"should format unsigned integers", uint32(7), "7"},
+		{"should format UUIDs", id, "00000000-0000-0000-0000-000000000002"},
+		{"should return nil UUIDs as empty", uuid.Nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.wantKey, formatKeyValue(reflect.ValueOf(tc.giveValue)))
+		})
+	}
+}
+
+func TestSetKeyValue(t *testing.T) {
+	id := uuid.FromStringOrNil("00000000-0000-0000-0000-000000000002")
+
+	testCases := []struct {
+		description string
+		giveField   interface{}
+		giveValue   interface{}
+		wantField   interface{}
+	}{
+		{"should set strings", new(string), "Fred", "Fred"},
+		{"should set integers", new(int64), int64(42), int64(42)},
+		{"should convert integers", new(int), int64(42), 42},
+		{"should scan UUIDs from strings", new(uuid.UUID), "00000000-0000-0000-0000-000000000002", id},
+		{"should format UUIDs into strings", new(string), id, "00000000-0000-0000-0000-000000000002"},
+		{"should set pointers", new(*string), "Fred", func() *string { s := "Fred"; return &s }()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			field := reflect.ValueOf(tc.giveField).Elem()
+			assert.True(t, setKeyValue(field, tc.giveValue))
+			assert.Equal(t, tc.wantField, field.Interface())
+		})
+	}
+}
+
+func TestSaveModelIntegerPrimaryKey(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO serial_key_model (organization_id,name) VALUES ($1,$2) RETURNING "id"
+	`)).
+		WithArgs(orgID, "Fred").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(42)))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT serial_key_model.id FROM serial_key_model
+		WHERE serial_key_model.id = $1 AND serial_key_model.organization_id = $2
+	`)).
+		WithArgs(int64(42), orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(42)))
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		UPDATE serial_key_model SET name = $1 WHERE organization_id = $2 AND id = $3
+	`)).
+		WithArgs("George", orgID, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		performedBy:       sampleUserID,
+	}
+
+	model := serialKeyModel{Name: "Fred"}
+	assert.NoError(t, p.SaveModel(&model))
+	assert.Equal(t, int64(42), model.ID)
+
+	model.Name = "George"
+	assert.NoError(t, p.SaveModel(&model))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestDeployUUIDPrimaryKey(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	id := uuid.FromStringOrNil("00000000-0000-0000-0000-000000000002")
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT uuid_key_model.id, uuid_key_model.id as uuid_key_model_id
+		FROM uuid_key_model
+		WHERE COALESCE(uuid_key_model.id::"varchar",'') = ANY($1) AND uuid_key_model.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{id.String()}), orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "uuid_key_model_id"}).AddRow(id.String(), id.String()),
+		)
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		UPDATE uuid_key_model SET name = $1 WHERE organization_id = $2 AND id = $3
+	`)).
+		WithArgs("Fred", orgID, id.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	p := New(orgID, sampleUserID)
+	models := []uuidKeyModel{{ID: id, Name: "Fred"}}
+	assert.NoError(t, p.Deploy(models))
+	assert.Equal(t, id, models[0].ID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+type uuidReferenceModel struct {
+	Metadata metadata.Metadata `picard:"tablename=uuid_reference_model"`
+
+	ID       string    `picard:"primary_key,column=id"`
+	ParentID uuid.UUID `picard:"foreign_key,related=Parent,column=parent_id"`
+	Parent   uuidKeyModel
+}
+
+func TestLookupForeignKeyEmptyKeys(t *testing.T) {
+	parentID := "00000000-0000-0000-0000-000000000002"
+	foreignKey := tags.ForeignKey{
+		FieldName:        "ParentID",
+		KeyColumn:        "parent_id",
+		RelatedFieldName: "Parent",
+		LookupsUsed:      []tags.Lookup{{MatchDBColumn: "name", MatchObjectProperty: "Name"}},
+		LookupResults: map[string]interface{}{
+			"Fred": map[string]interface{}{"id": parentID},
+		},
+	}
+
+	testCases := []struct {
+		description     string
+		giveKeyValue    interface{}
+		wantNeedsLookup bool
+	}{
+		{"looks up a reference whose key is a nil uuid", uuid.Nil, true},
+		{"looks up a reference whose key is a nil pointer", (*string)(nil), true},
+		{"looks up a reference whose key is an empty string", "", true},
+		{"doesn't look up a reference whose key is set", uuid.FromStringOrNil(parentID), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			model := reflect.ValueOf(uuidReferenceModel{Parent: uuidKeyModel{Name: "Fred"}})
+			lookup, needsLookup := lookupForeignKey(model, foreignKey, tc.giveKeyValue, true)
+			assert.Equal(t, tc.wantNeedsLookup, needsLookup)
+			if tc.wantNeedsLookup {
+				assert.Equal(t, "Fred", lookup.key)
+				assert.Equal(t, map[string]interface{}{"id": parentID}, lookup.result)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package picard
+
+import (
+	qp "github.com/skuid/picard/queryparts"
+)
+
+// MultitenancyMatch decides how reads compare the multitenancy key column to the ORM's multitenancy value
+type MultitenancyMatch int
+
+const (
+	// MultitenancyMatchEqual reads only the rows of the tenant with exactly the multitenancy value. This is
+	// the default.
+	MultitenancyMatchEqual MultitenancyMatch = iota
+	// MultitenancyMatchPrefix reads the rows of every tenant whose id starts with the multitenancy value, for
+	// hierarchical tenant ids where a parent tenant's id is a prefix of its children's
+	MultitenancyMatchPrefix
+)
+
+/*
+WithMultitenancyMatch returns a copy of the ORM that compares the multitenancy key with the given match when
+reading. With MultitenancyMatchPrefix, FilterModel and the other reads return the rows of a whole subtree of
+tenants, using `organization_id LIKE $1 || '%'`. The tenant id is escaped, so `%` and `_` in it match only
+themselves.
+
+Writes always use the exact multitenancy value: inserts set it, and updates and deletes, including the
+existing rows Deploy and DeleteModel look up, only touch the tenant with exactly that id.
+
+Example:
+
+	admin := picard.New("acme", userID).WithMultitenancyMatch(picard.MultitenancyMatchPrefix)
+	results, err := admin.FilterModel(picard.FilterRequest{
+		FilterModel: TableA{},
+	})
+
+	// SELECT ... WHERE t0.organization_id LIKE $1 || '%'
+*/
+func (p PersistenceORM) WithMultitenancyMatch(match MultitenancyMatch) ORM {
+	p.multitenancyMatch = match
+	return &p
+}
+
+// readMultitenancyValue returns the multitenancy value to build read queries with
+func (p PersistenceORM) readMultitenancyValue() interface{} {
+	if p.multitenancyMatch == MultitenancyMatchPrefix {
+		return qp.MultitenancyPrefix(p.multitenancyValue)
+	}
+	return p.multitenancyValue
+}
+
+// exactTenant returns a copy of the ORM that reads only the exact tenant from the primary, for reads that decide
+// what to write. A replica could be missing rows that were just written.
+func (p PersistenceORM) exactTenant() PersistenceORM {
+	p.multitenancyMatch = MultitenancyMatchEqual
+	p.readConsistency = ReadStrong
+	return p
+}
@@ -0,0 +1,164 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultitenancyMatchPrefix(t *testing.T) {
+	parentID := "00000000-0000-0000-0000-000000000002"
+	kiddoID := "00000000-0000-0000-0000-000000000011"
+
+	testCases := []struct {
+		description       string
+		giveTenant        string
+		wantTenantPattern string
+	}{
+		{
+			"should match every tenant in the subtree",
+			"acme/",
+			"acme/",
+		},
+		{
+			"should escape LIKE wildcards in the tenant id",
+			"acme_100%/",
+			`acme\_100\%/`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name",
+					t0.parent_id AS "t0.parent_id",
+					t1.id AS "t1.id",
+					t1.organization_id AS "t1.organization_id",
+					t1.name AS "t1.name",
+					t1.parent_id AS "t1.parent_id",
+					t1.other_parent_id AS "t1.other_parent_id"
+				FROM childmodel AS t0
+				LEFT JOIN parentmodel AS t1 ON
+					(t1.id = t0.parent_id AND t1.organization_id LIKE $1 || '%')
+				WHERE t0.organization_id LIKE $2 || '%'
+			`)).
+				WithArgs(tc.wantTenantPattern, tc.wantTenantPattern).
+				WillReturnRows(
+					sqlmock.NewRows([]string{
+						"t0.id", "t0.organization_id", "t0.name", "t0.parent_id",
+						"t1.id", "t1.organization_id", "t1.name",
+					}).
+						AddRow(kiddoID, tc.giveTenant+"west", "kiddo", parentID, parentID, tc.giveTenant+"west", "pops"),
+				)
+
+			p := New(tc.giveTenant, sampleUserID).WithMultitenancyMatch(MultitenancyMatchPrefix)
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel: testdata.ChildModel{},
+				Associations: []tags.Association{
+					{Name: "Parent"},
+				},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				testdata.ChildModel{
+					ID:             kiddoID,
+					OrganizationID: tc.giveTenant + "west",
+					Name:           "kiddo",
+					ParentID:       parentID,
+					Parent: testdata.ParentModel{
+						ID:             parentID,
+						OrganizationID: tc.giveTenant + "west",
+						Name:           "pops",
+					},
+				},
+			}, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMultitenancyMatchPrefixJunction(t *testing.T) {
+	fredID := "00000000-0000-0000-0000-000000000002"
+	georgeID := "00000000-0000-0000-0000-000000000003"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id LIKE $1 || '%'
+	`)).
+		WithArgs("acme/").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(fredID, "acme/west", "Fred"),
+		)
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT child_id, sibling_id FROM siblingjunction
+		WHERE organization_id LIKE $1 || '%' AND child_id IN ($2)
+	`)).
+		WithArgs("acme/", fredID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"child_id", "sibling_id"}).
+				AddRow(fredID, georgeID),
+		)
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id LIKE $1 || '%' AND ((t0.id = $2))
+	`)).
+		WithArgs("acme/", georgeID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(georgeID, "acme/east", "George"),
+		)
+
+	p := New("acme/", sampleUserID).WithMultitenancyMatch(MultitenancyMatchPrefix)
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: siblingPersonModel{},
+		Associations: []tags.Association{
+			{Name: "Siblings"},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		siblingPersonModel{
+			ID:             fredID,
+			OrganizationID: "acme/west",
+			Name:           "Fred",
+			Siblings: []testdata.PersonModel{
+				{ID: georgeID, OrganizationID: "acme/east", Name: "George"},
+			},
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
@@ -0,0 +1,62 @@
+package picard
+
+import (
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/tags"
+)
+
+// insertedColumn is the alias of the flag a native upsert returns for each row, true when the row was inserted
+// and false when it conflicted with an existing row and updated it
+const insertedColumn = "picard_inserted"
+
+/*
+WithNativeUpsert returns a copy of the ORM that deploys models to tables with a conflict_target in a single
+INSERT ... ON CONFLICT DO UPDATE statement per batch, instead of looking up the existing rows before inserting or
+updating them. That saves the lookup query, and closes the race where another transaction inserts a row with the
+same key between the lookup and the insert.
+
+Rows are still matched by the conflict target, so it should be a unique index over the lookup columns. Columns
+that every model in a batch sets are updated on a conflict, and the others keep their existing values.
+
+Deploys that need the existing rows fall back to looking them up: tables without a conflict target, tables with
+children, which are matched to the keys of existing parents, tables with a delete_flag field, and tables with a
+version column, whose updates are guarded by each model's version, as well as dry runs and ORMs with change
+tracking. So do batches with a model that sets its primary key. Models of a native upsert may update existing
+rows, so they aren't validated like inserts.
+*/
+func (p PersistenceORM) WithNativeUpsert() ORM {
+	p.nativeUpsert = true
+	return &p
+}
+
+// usesNativeUpsert reports whether models of the table are deployed with ON CONFLICT instead of looking up the
+// existing rows first
+func (p PersistenceORM) usesNativeUpsert(tableMetadata *tags.TableMetadata) bool {
+	return p.nativeUpsert &&
+		len(tableMetadata.GetConflictTarget()) > 0 &&
+		len(tableMetadata.GetChildren()) == 0 &&
+		tableMetadata.GetDeleteFlagFieldName() == "" &&
+		tableMetadata.GetVersionColumnName() == "" &&
+		p.changeListener == nil &&
+		!p.isDryRun()
+}
+
+// usesNativeUpsertForBatch reports whether a batch of models is deployed with ON CONFLICT. A batch with a model
+// that sets its primary key looks up the existing rows instead, since the model may match its row only by that key.
+func (p PersistenceORM) usesNativeUpsertForBatch(data interface{}, tableMetadata *tags.TableMetadata) bool {
+	if !p.usesNativeUpsert(tableMetadata) {
+		return false
+	}
+	withPrimaryKey, _ := splitByPrimaryKey(data, tableMetadata)
+	return withPrimaryKey.Len() == 0
+}
+
+// setUpsertTypes marks the inserts of a native upsert that updated an existing row as updates, from the flag
+// returned for each row
+func setUpsertTypes(inserts []dbchange.Change, insertResults []map[string]interface{}) {
+	for index := range inserts {
+		if inserted, ok := insertResults[index][insertedColumn].(bool); ok && !inserted {
+			inserts[index].Type = dbchange.Update
+		}
+	}
+}
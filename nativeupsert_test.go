@@ -0,0 +1,181 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type dockModel struct {
+	Metadata metadata.Metadata `picard:"tablename=dock,conflict_target"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Code           string `picard:"lookup,column=code"`
+	Capacity       int    `picard:"column=capacity" validate:"required"`
+}
+
+func TestNativeUpsert(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	insertedID := "00000000-0000-0000-0000-000000000002"
+	updatedID := "00000000-0000-0000-0000-000000000003"
+
+	testCases := []struct {
+		description         string
+		runFunction         func(ORM) ([]DeployResult, error)
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []DeployResult
+	}{
+		{
+			"upserts with one statement instead of looking up existing rows",
+			func(p ORM) ([]DeployResult, error) {
+				return p.DeployWithResults([]warehouseModel{
+					{Code: "BNA", City: "Nashville"},
+					{Code: "ATL", City: "Atlanta"},
+				})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO warehouse (organization_id,code,city) VALUES ($1,$2,$3),($4,$5,$6)
+					ON CONFLICT (organization_id, code) DO UPDATE SET "city" = EXCLUDED."city"
+					WHERE warehouse."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id", (xmax = 0) AS picard_inserted
+				`)).
+					WithArgs(orgID, "BNA", "Nashville", orgID, "ATL", "Atlanta").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "picard_inserted"}).
+							AddRow(insertedID, true).
+							AddRow(updatedID, false),
+					)
+			},
+			[]DeployResult{
+				{Key: "BNA", PrimaryKey: insertedID, Type: dbchange.Insert},
+				{Key: "ATL", PrimaryKey: updatedID, Type: dbchange.Update},
+			},
+		},
+		{
+			"keeps the existing values of columns a model leaves unset",
+			func(p ORM) ([]DeployResult, error) {
+				return p.DeployWithResults([]warehouseModel{
+					{Metadata: metadata.Metadata{DefinedFields: []string{"Code"}}, Code: "BNA"},
+				})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO warehouse (organization_id,code,city) VALUES ($1,$2,DEFAULT)
+					ON CONFLICT (organization_id, code) DO UPDATE SET "organization_id" = EXCLUDED."organization_id"
+					WHERE warehouse."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id", (xmax = 0) AS picard_inserted
+				`)).
+					WithArgs(orgID, "BNA").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "picard_inserted"}).AddRow(updatedID, false))
+			},
+			[]DeployResult{
+				{Key: "BNA", PrimaryKey: updatedID, Type: dbchange.Update},
+			},
+		},
+		{
+			"looks up existing rows for a batch with a model that sets its primary key",
+			func(p ORM) ([]DeployResult, error) {
+				return nil, p.Deploy([]warehouseModel{
+					{Metadata: metadata.Metadata{DefinedFields: []string{"ID", "City"}}, ID: updatedID, City: "Memphis"},
+					{Code: "BNA", City: "Nashville"},
+				})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT warehouse.id, warehouse.id as warehouse_id
+					FROM warehouse
+					WHERE COALESCE(warehouse.id::"varchar",'') = ANY($1) AND warehouse.organization_id = $2
+				`)).
+					WithArgs(pq.Array([]string{updatedID}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_id"}).AddRow(updatedID, updatedID))
+				mock.ExpectExec(`^UPDATE warehouse SET city = \$1 WHERE organization_id = \$2 AND id = \$3$`).
+					WithArgs("Memphis", orgID, updatedID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO warehouse (organization_id,code,city) VALUES ($1,$2,$3)
+					ON CONFLICT (organization_id, code) DO UPDATE SET "city" = EXCLUDED."city"
+					WHERE warehouse."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id", (xmax = 0) AS picard_inserted
+				`)).
+					WithArgs(orgID, "BNA", "Nashville").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "picard_inserted"}).AddRow(insertedID, true))
+			},
+			nil,
+		},
+		{
+			"doesn't validate models that may update an existing row like inserts",
+			func(p ORM) ([]DeployResult, error) {
+				return p.DeployWithResults([]dockModel{
+					{Metadata: metadata.Metadata{DefinedFields: []string{"Code"}}, Code: "D1"},
+				})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO dock (organization_id,code,capacity) VALUES ($1,$2,DEFAULT)
+					ON CONFLICT (organization_id, code) DO UPDATE SET "organization_id" = EXCLUDED."organization_id"
+					WHERE dock."organization_id" = EXCLUDED."organization_id"
+					RETURNING "id", (xmax = 0) AS picard_inserted
+				`)).
+					WithArgs(orgID, "D1").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "picard_inserted"}).AddRow(updatedID, false))
+			},
+			[]DeployResult{
+				{Key: "D1", PrimaryKey: updatedID, Type: dbchange.Update},
+			},
+		},
+		{
+			"looks up existing rows for a table without a conflict target",
+			func(p ORM) ([]DeployResult, error) {
+				return p.DeployWithResults([]attributesModel{
+					{Name: "widget", Attributes: map[string]interface{}{"color": "red"}},
+				})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT attributes_model.id, attributes_model.name as attributes_model_name
+					FROM attributes_model
+					WHERE COALESCE(attributes_model.name::"varchar",'') = ANY($1) AND attributes_model.organization_id = $2
+				`)).
+					WithArgs(pq.Array([]string{"widget"}), orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "attributes_model_name"}))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO attributes_model (organization_id,name,attributes) VALUES ($1,$2,$3) RETURNING "id"
+				`)).
+					WithArgs(orgID, "widget", []byte(`{"color":"red"}`)).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(insertedID))
+			},
+			[]DeployResult{
+				{Key: "widget", PrimaryKey: insertedID, Type: dbchange.Insert},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectBegin()
+			tc.expectationFunction(mock)
+			mock.ExpectCommit()
+
+			results, err := tc.runFunction(New(orgID, sampleUserID).WithNativeUpsert())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantResults, results)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package picard
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Page holds one page of FilterModel results along with the total number of matching rows
+type Page struct {
+	Data    []interface{} `json:"data"`
+	Total   uint64        `json:"total"`
+	Limit   uint64        `json:"limit"`
+	Offset  uint64        `json:"offset"`
+	HasMore bool          `json:"hasMore"`
+}
+
+/*
+FilterModelPaginated returns the page of models described by the request's Limit and Offset, along with the
+total number of models that match the filter. The page and the count are read in the same transaction.
+If the request has no Runner, a transaction is started and committed for the call.
+
+Example:
+
+	page, err := p.FilterModelPaginated(picard.FilterRequest{
+		FilterModel: TableA{},
+		OrderBy:     []qp.OrderByRequest{{Field: "FieldA"}},
+		Limit:       20,
+		Offset:      40,
+	})
+
+	// page.Data holds up to 20 models, page.Total counts every match and
+	// page.HasMore is true when there are matches after this page
+
+By default the total is read with a second query. Set WithTotalCount to select it with the page instead, as a
+COUNT(*) OVER () window, so the matches are only scanned once. The window is computed for every row of the page,
+so it's opt-in. A page past the last match has no rows to hold the total, so it's counted with a second query,
+as are requests with ForUpdate, which Postgres doesn't allow with a window, and with Distinct or DistinctOn,
+whose duplicate rows the window would count.
+*/
+func (p PersistenceORM) FilterModelPaginated(request FilterRequest) (*Page, error) {
+	if request.Limit == 0 {
+		return nil, errors.New("FilterModelPaginated requires a positive Limit")
+	}
+
+	var tx *sql.Tx
+	if request.Runner == nil {
+		var err error
+		tx, err = GetConnection().Begin()
+		if err != nil {
+			return nil, err
+		}
+		request.Runner = tx
+	}
+
+	page, err := p.filterPage(request)
+	if tx == nil {
+		return page, err
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (p PersistenceORM) filterPage(request FilterRequest) (*Page, error) {
+	results, total, err := p.filterModelWithTotal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if !countsInWindow(request) || (len(results) == 0 && request.Offset > 0) {
+		filterMetadata, err := getFilterMetadata(request)
+		if err != nil {
+			return nil, err
+		}
+		total, err = p.countFilterResults(request, filterMetadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Page{
+		Data:    results,
+		Total:   total,
+		Limit:   request.Limit,
+		Offset:  request.Offset,
+		HasMore: request.Offset+uint64(len(results)) < total,
+	}, nil
+}
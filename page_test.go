@@ -0,0 +1,304 @@
+package picard
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterModelPaginated(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	pageSQL := testdata.FmtSQLRegex(`
+		SELECT
+			t0.primary_key_column AS "t0.primary_key_column",
+			t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+			t0.test_column_one AS "t0.test_column_one",
+			t0.deleted_at AS "t0.deleted_at"
+		FROM test_tablename AS t0
+		WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2 AND t0.deleted_at IS NULL
+		ORDER BY t0.primary_key_column
+		LIMIT $3 OFFSET $4
+	`)
+	countQuery := testdata.FmtSQL(`
+		SELECT COUNT(*) FROM (SELECT
+				t0.primary_key_column AS "t0.primary_key_column",
+				t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+				t0.test_column_one AS "t0.test_column_one",
+				t0.deleted_at AS "t0.deleted_at"
+			FROM test_tablename AS t0
+			WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2 AND t0.deleted_at IS NULL) AS filtered
+	`)
+	countSQL := "^" + regexp.QuoteMeta(countQuery) + "$"
+	pageRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one"}).
+			AddRow("00000000-0000-0000-0000-000000000003", orgID, "one").
+			AddRow("00000000-0000-0000-0000-000000000004", orgID, "one")
+	}
+	pageData := []interface{}{
+		softDeleteModel{
+			PrimaryKeyField:        "00000000-0000-0000-0000-000000000003",
+			TestMultitenancyColumn: orgID,
+			TestFieldOne:           "one",
+		},
+		softDeleteModel{
+			PrimaryKeyField:        "00000000-0000-0000-0000-000000000004",
+			TestMultitenancyColumn: orgID,
+			TestFieldOne:           "one",
+		},
+	}
+
+	testCases := []struct {
+		description         string
+		giveLimit           uint64
+		expectationFunction func(sqlmock.Sqlmock)
+		wantPage            *Page
+		wantErr             string
+	}{
+		{
+			"returns a middle page with the total and more to come",
+			2,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(pageSQL).
+					WithArgs(orgID, "one", 2, 2).
+					WillReturnRows(pageRows())
+				mock.ExpectQuery(countSQL).
+					WithArgs(orgID, "one").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+				mock.ExpectCommit()
+			},
+			&Page{
+				Data:    pageData,
+				Total:   5,
+				Limit:   2,
+				Offset:  2,
+				HasMore: true,
+			},
+			"",
+		},
+		{
+			"returns the last page without more to come",
+			2,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(pageSQL).
+					WithArgs(orgID, "one", 2, 2).
+					WillReturnRows(pageRows())
+				mock.ExpectQuery(countSQL).
+					WithArgs(orgID, "one").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+				mock.ExpectCommit()
+			},
+			&Page{
+				Data:    pageData,
+				Total:   4,
+				Limit:   2,
+				Offset:  2,
+				HasMore: false,
+			},
+			"",
+		},
+		{
+			"rolls back when the count fails",
+			2,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(pageSQL).
+					WithArgs(orgID, "one", 2, 2).
+					WillReturnRows(pageRows())
+				mock.ExpectQuery(countSQL).
+					WithArgs(orgID, "one").
+					WillReturnError(errors.New("some error"))
+				mock.ExpectRollback()
+			},
+			nil,
+			"some error: Query: " + countQuery,
+		},
+		{
+			"errors without a limit",
+			0,
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"FilterModelPaginated requires a positive Limit",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			page, err := p.FilterModelPaginated(FilterRequest{
+				FilterModel: softDeleteModel{
+					TestFieldOne: "one",
+				},
+				OrderBy: []qp.OrderByRequest{{Field: "PrimaryKeyField"}},
+				Limit:   tc.giveLimit,
+				Offset:  2,
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantPage, page)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterModelPaginatedWithTotalCount(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	pageSQL := func(paging string) string {
+		return testdata.FmtSQLRegex(`
+			SELECT
+				t0.primary_key_column AS "t0.primary_key_column",
+				t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+				t0.test_column_one AS "t0.test_column_one",
+				t0.deleted_at AS "t0.deleted_at",
+				COUNT(*) OVER () AS picard_total_count
+			FROM test_tablename AS t0
+			WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2 AND t0.deleted_at IS NULL
+			ORDER BY t0.primary_key_column
+			` + paging)
+	}
+	countSQL := "^" + regexp.QuoteMeta(testdata.FmtSQL(`
+		SELECT COUNT(*) FROM (SELECT
+				t0.primary_key_column AS "t0.primary_key_column",
+				t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+				t0.test_column_one AS "t0.test_column_one",
+				t0.deleted_at AS "t0.deleted_at"
+			FROM test_tablename AS t0
+			WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2 AND t0.deleted_at IS NULL) AS filtered
+	`)) + "$"
+	columns := []string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one", "picard_total_count"}
+
+	testCases := []struct {
+		description         string
+		giveOffset          uint64
+		expectationFunction func(sqlmock.Sqlmock)
+		wantPage            *Page
+	}{
+		{
+			"reads the total from the window in the page's query",
+			2,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(pageSQL("LIMIT $3 OFFSET $4")).
+					WithArgs(orgID, "one", 2, 2).
+					WillReturnRows(
+						sqlmock.NewRows(columns).
+							AddRow("00000000-0000-0000-0000-000000000003", orgID, "one", int64(5)).
+							AddRow("00000000-0000-0000-0000-000000000004", orgID, "one", int64(5)),
+					)
+				mock.ExpectCommit()
+			},
+			&Page{
+				Data: []interface{}{
+					softDeleteModel{
+						PrimaryKeyField:        "00000000-0000-0000-0000-000000000003",
+						TestMultitenancyColumn: orgID,
+						TestFieldOne:           "one",
+					},
+					softDeleteModel{
+						PrimaryKeyField:        "00000000-0000-0000-0000-000000000004",
+						TestMultitenancyColumn: orgID,
+						TestFieldOne:           "one",
+					},
+				},
+				Total:   5,
+				Limit:   2,
+				Offset:  2,
+				HasMore: true,
+			},
+		},
+		{
+			"returns a total of 0 for an empty first page",
+			0,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(pageSQL("LIMIT $3")).
+					WithArgs(orgID, "one", 2).
+					WillReturnRows(sqlmock.NewRows(columns))
+				mock.ExpectCommit()
+			},
+			&Page{
+				Data:   []interface{}{},
+				Total:  0,
+				Limit:  2,
+				Offset: 0,
+			},
+		},
+		{
+			"counts with a second query for a page past the last match",
+			6,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(pageSQL("LIMIT $3 OFFSET $4")).
+					WithArgs(orgID, "one", 2, 6).
+					WillReturnRows(sqlmock.NewRows(columns))
+				mock.ExpectQuery(countSQL).
+					WithArgs(orgID, "one").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+				mock.ExpectCommit()
+			},
+			&Page{
+				Data:   []interface{}{},
+				Total:  5,
+				Limit:  2,
+				Offset: 6,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			page, err := p.FilterModelPaginated(FilterRequest{
+				FilterModel: softDeleteModel{
+					TestFieldOne: "one",
+				},
+				OrderBy:        []qp.OrderByRequest{{Field: "PrimaryKeyField"}},
+				Limit:          2,
+				Offset:         tc.giveOffset,
+				WithTotalCount: true,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantPage, page)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type postModel struct {
+	Metadata metadata.Metadata `picard:"tablename=post"`
+
+	ID             string   `picard:"primary_key,column=id"`
+	OrganizationID string   `picard:"multitenancy_key,column=organization_id"`
+	Title          string   `picard:"column=title"`
+	Tags           []string `picard:"pgarray,column=tags"`
+	Ratings        []int    `picard:"pgarray,column=ratings"`
+}
+
+func TestPGArrayRoundTrip(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	postID := "00000000-0000-0000-0000-000000000002"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO post (organization_id,title,tags,ratings) VALUES ($1,$2,$3,$4) RETURNING "id"
+	`)).
+		WithArgs(orgID, "Arrays", `{"postgres","go"}`, "{4,5}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(postID))
+	mock.ExpectCommit()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.title AS "t0.title",
+			t0.tags AS "t0.tags",
+			t0.ratings AS "t0.ratings"
+		FROM post AS t0
+		WHERE t0.organization_id = $1 AND t0.tags = $2
+	`)).
+		WithArgs(orgID, `{"postgres","go"}`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.title", "t0.tags", "t0.ratings"}).
+				AddRow(postID, orgID, "Arrays", []byte(`{postgres,go}`), []byte(`{4,5}`)).
+				AddRow("00000000-0000-0000-0000-000000000003", orgID, "Untagged", nil, nil),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		performedBy:       sampleUserID,
+	}
+	assert.NoError(t, p.CreateModel(&postModel{
+		Title:   "Arrays",
+		Tags:    []string{"postgres", "go"},
+		Ratings: []int{4, 5},
+	}))
+
+	results, err := p.FilterModel(FilterRequest{FilterModel: postModel{Tags: []string{"postgres", "go"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		postModel{
+			ID:             postID,
+			OrganizationID: orgID,
+			Title:          "Arrays",
+			Tags:           []string{"postgres", "go"},
+			Ratings:        []int{4, 5},
+		},
+		postModel{
+			ID:             "00000000-0000-0000-0000-000000000003",
+			OrganizationID: orgID,
+			Title:          "Untagged",
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestPGArrayUpdate(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	postID := "00000000-0000-0000-0000-000000000002"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT post.id FROM post WHERE post.id = \$1 AND post.organization_id = \$2$`).
+		WithArgs(postID, orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(postID))
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		UPDATE post SET title = $1, tags = $2 WHERE organization_id = $3 AND id = $4
+	`)).
+		WithArgs("Arrays", pq.Array([]string{"sql"}), orgID, postID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	p := New(orgID, sampleUserID)
+	assert.NoError(t, p.SaveModel(&postModel{
+		Metadata: metadata.Metadata{DefinedFields: []string{"Title", "Tags"}},
+		ID:       postID,
+		Title:    "Arrays",
+		Tags:     []string{"sql"},
+	}))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
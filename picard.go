@@ -1,9 +1,9 @@
 package picard
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,8 +18,10 @@ import (
 	"github.com/skuid/picard/crypto"
 	"github.com/skuid/picard/dbchange"
 	"github.com/skuid/picard/decoding"
+	"github.com/skuid/picard/jsonb"
 	"github.com/skuid/picard/metadata"
 	"github.com/skuid/picard/reflectutil"
+	"github.com/skuid/picard/stringutil"
 	"github.com/skuid/picard/tags"
 	validator "gopkg.in/go-playground/validator.v9"
 )
@@ -29,23 +31,85 @@ const separator = "|"
 // ORM interface describes the behavior API of any picard ORM
 type ORM interface {
 	FilterModel(FilterRequest) ([]interface{}, error)
+	FilterModelOne(FilterRequest) (interface{}, error)
+	FilterModelPaginated(FilterRequest) (*Page, error)
+	FilterModelSQL(FilterRequest) (string, []interface{}, error)
+	FilterModelStream(FilterRequest) (*ResultIterator, error)
+	FilterModelFlat(FilterRequest) (*FlatResults, error)
+	AggregateModel(AggregateRequest) ([]map[string]interface{}, error)
 	SaveModel(model interface{}) error
+	SaveModelWithRowsAffected(model interface{}) (int64, error)
 	CreateModel(model interface{}) error
 	DeleteModel(model interface{}) (int64, error)
+	DeleteModels(FilterRequest) (int64, error)
+	RestoreModel(model interface{}) error
+	RestoreModels(FilterRequest) (int64, error)
 	Deploy(data interface{}) error
+	DeployWithResults(data interface{}) ([]DeployResult, error)
+	DryRun(data interface{}) ([]*dbchange.ChangeSet, error)
+	ValidateDeploy(data interface{}) ([]error, error)
 	DeployMultiple(data []interface{}) error
+	RefreshMaterializedView(model interface{}, concurrently bool) error
+	TruncateModel(model interface{}) error
+	ReencryptModel(model interface{}, oldKey []byte) (int64, error)
 	StartTransaction() (*sql.Tx, error)
+	StartSnapshotTransaction() (*sql.Tx, error)
+	StartTransactionWithOptions(opts sql.TxOptions) (*sql.Tx, error)
 	Commit() error
 	Rollback() error
+	AfterCommit(callback func())
+	RunInTransaction(fn func(ORM) error, opts TxOptions) error
+	WithChangeTracking(listener ChangeListener) ORM
+	WithSkipUnresolvable(listener SkipListener) ORM
+	WithDuplicatePrimaryKeyPolicy(policy DuplicatePrimaryKeyPolicy) ORM
+	WithBatchSize(n int) (ORM, error)
+	WithChunkedDeletes(chunkSize int, separateTransactions bool) (ORM, error)
+	WithCopyInserts(threshold int) (ORM, error)
+	WithStatementCache(cache *StatementCache) ORM
+	WithPerRowKeyMatching() ORM
+	WithMultitenancyMatch(match MultitenancyMatch) ORM
+	WithReplicaMaxLag(maxLag time.Duration) ORM
+	WithReadConsistency(consistency ReadConsistency) ORM
+	WithTruncateOptions(opts TruncateOptions) ORM
+	WithCheckValidation() ORM
+	WithNativeUpsert() ORM
+}
+
+// DeployResult describes the outcome of deploying a single top-level model
+type DeployResult struct {
+	// Key is the lookup key that was used to match the model to an existing row
+	Key string
+	// PrimaryKey is the primary key of the inserted, updated or deleted row
+	PrimaryKey interface{}
+	// Type is dbchange.Insert, dbchange.Update, or dbchange.Delete for models marked with a delete_flag field
+	Type dbchange.Type
+	// Conflict is set for an update that wasn't made because the row's version changed since the model was read
+	Conflict bool
 }
 
 // PersistenceORM provides the necessary configuration to perform an upsert of objects without IDs
 // into a relational database using lookup fields to match and field name transformations.
 type PersistenceORM struct {
-	multitenancyValue string
-	performedBy       string
-	transaction       *sql.Tx
-	batchSize         int
+	multitenancyValue          string
+	performedBy                string
+	transaction                *sql.Tx
+	batchSize                  int
+	changeListener             ChangeListener
+	skipListener               SkipListener
+	duplicatePolicy            DuplicatePrimaryKeyPolicy
+	afterCommit                *[]func()
+	deleteChunkSize            int
+	copyThreshold              int
+	statementCache             *StatementCache
+	perRowKeyMatching          bool
+	multitenancyMatch          MultitenancyMatch
+	separateDeleteTransactions bool
+	plannedChanges             *[]*dbchange.ChangeSet
+	replicaMaxLag              time.Duration
+	readConsistency            ReadConsistency
+	truncateOptions            TruncateOptions
+	checkValidation            bool
+	nativeUpsert               bool
 }
 
 // New Creates a new Picard Object and handle defaults
@@ -57,6 +121,30 @@ func New(multitenancyValue string, performerID string) ORM {
 	}
 }
 
+// WithBatchSize returns a copy of the ORM that deploys n records per batch instead of the default
+// of 100. The batch size applies to each level of a deployment separately, so the children
+// of a batch are upserted in batches of n as well.
+func (p PersistenceORM) WithBatchSize(n int) (ORM, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("batch size must be positive, got %d", n)
+	}
+	p.batchSize = n
+	return &p, nil
+}
+
+// WithChunkedDeletes returns a copy of the ORM that deletes at most chunkSize models per statement in
+// DeleteModel. The matching primary keys are looked up first, and the total number of rows affected
+// is returned. With separateTransactions, each chunk is committed in its own transaction unless one
+// was started with StartTransaction.
+func (p PersistenceORM) WithChunkedDeletes(chunkSize int, separateTransactions bool) (ORM, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("delete chunk size must be positive, got %d", chunkSize)
+	}
+	p.deleteChunkSize = chunkSize
+	p.separateDeleteTransactions = separateTransactions
+	return &p, nil
+}
+
 // StartTranscation begins a transaction and returns a sql.Tx param (see https://golang.org/pkg/database/sql/#Tx).
 // Picard methods use this transaction when executing queries and will initiate a rollback if there is an error
 // Using this method makes the caller responsible for ending a transaction to prevent a transaction leak.
@@ -67,26 +155,83 @@ func (p *PersistenceORM) StartTransaction() (*sql.Tx, error) {
 			return tx, err
 		}
 		p.transaction = tx
+		p.afterCommit = &[]func(){}
 	}
 	return p.transaction, nil
 }
 
-// Commit ends a transaction
+// StartSnapshotTransaction begins a read-only REPEATABLE READ transaction and returns it like StartTransaction does.
+// Every query in the transaction sees the same snapshot of the database, so pass it as the Runner of each
+// FilterRequest that needs a consistent view. The caller is responsible for ending the transaction.
+func (p *PersistenceORM) StartSnapshotTransaction() (*sql.Tx, error) {
+	return p.StartTransactionWithOptions(sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+// StartTransactionWithOptions begins a transaction with the isolation level and read only flag in opts, like
+// sql.LevelSerializable for flows that can't tolerate anomalies, and returns it like StartTransaction does. It
+// returns an error if a transaction has already been started, since that transaction's options can't change.
+func (p *PersistenceORM) StartTransactionWithOptions(opts sql.TxOptions) (*sql.Tx, error) {
+	if p.transaction != nil {
+		return nil, errors.New("a transaction has already been started")
+	}
+	tx, err := GetConnection().BeginTx(context.Background(), &opts)
+	if err != nil {
+		return nil, err
+	}
+	p.transaction = tx
+	p.afterCommit = &[]func(){}
+	return tx, nil
+}
+
+// AfterCommit registers a callback to run after the transaction started with StartTransaction commits.
+// Callbacks run in the order they were registered, and are discarded if the transaction is rolled back or
+// fails to commit. Without a transaction in progress, the callback runs immediately. The callbacks are shared
+// with the copies of the ORM made after the transaction started, like those returned by the With methods, so
+// a callback registered on any of them runs when one of them commits.
+func (p *PersistenceORM) AfterCommit(callback func()) {
+	if p.transaction == nil {
+		callback()
+		return
+	}
+	if p.afterCommit == nil {
+		p.afterCommit = &[]func(){}
+	}
+	*p.afterCommit = append(*p.afterCommit, callback)
+}
+
+// takeAfterCommit returns the callbacks registered with AfterCommit and removes them from the transaction, so
+// the copies of the ORM that share them don't run them again
+func (p *PersistenceORM) takeAfterCommit() []func() {
+	if p.afterCommit == nil {
+		return nil
+	}
+	callbacks := *p.afterCommit
+	*p.afterCommit = nil
+	p.afterCommit = nil
+	return callbacks
+}
+
+// Commit ends a transaction, then runs the callbacks registered with AfterCommit if it succeeded
 func (p *PersistenceORM) Commit() error {
 	if p.transaction != nil {
-		defer func(p *PersistenceORM) {
-			p.transaction = nil
-		}(p)
-		return p.transaction.Commit()
+		tx, callbacks := p.transaction, p.takeAfterCommit()
+		p.transaction = nil
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		for _, callback := range callbacks {
+			callback()
+		}
 	}
 	return nil
 }
 
-// Rollback aborts a transaction
+// Rollback aborts a transaction and discards the callbacks registered with AfterCommit
 func (p *PersistenceORM) Rollback() error {
 	if p.transaction != nil {
 		defer func(p *PersistenceORM) {
 			p.transaction = nil
+			p.takeAfterCommit()
 		}(p)
 		return p.transaction.Rollback()
 	}
@@ -140,7 +285,12 @@ func (p PersistenceORM) DeployMultiple(data []interface{}) error {
 	}
 
 	for _, dataItem := range data {
-		if err := p.upsert(dataItem, nil); err != nil {
+		changeSets, err := p.upsert(dataItem, nil)
+		if err == nil {
+			// Without results to report them in, conflicting updates fail the deploy
+			err = versionConflicts(changeSets, dataItem)
+		}
+		if err != nil {
 			p.Rollback()
 			return err
 		}
@@ -149,67 +299,218 @@ func (p PersistenceORM) DeployMultiple(data []interface{}) error {
 	return nil
 }
 
-func (p PersistenceORM) upsert(data interface{}, deleteFilters interface{}) error {
+// DeployWithResults performs the same deployment as Deploy, but also returns a DeployResult
+// for each top-level model in the data, in the order they were provided. Models that produced
+// no changes are omitted, and models sharing a primary key get a single result when the
+// DuplicatePrimaryKeyPolicy allows them.
+func (p PersistenceORM) DeployWithResults(data interface{}) ([]DeployResult, error) {
+	if p.transaction == nil {
+		tx, err := GetConnection().Begin()
+		if err != nil {
+			return nil, err
+		}
+		p.transaction = tx
+		defer p.Commit()
+	}
 
+	tableMetadata, err := tags.GetTableMetadata(data)
+	if err != nil {
+		p.Rollback()
+		return nil, err
+	}
+
+	// Resolve duplicates before upserting so the results line up with the models that were deployed
+	resolved, sources, err := p.resolveDuplicatePrimaryKeys(data, tableMetadata)
+	if err != nil {
+		p.Rollback()
+		return nil, err
+	}
+
+	changeSets, err := p.upsert(resolved, nil)
+	if err != nil {
+		p.Rollback()
+		return nil, err
+	}
+	writeBackResolved(data, resolved, sources, tableMetadata)
+	data = resolved
+
+	return getDeployResults(data, changeSets, tableMetadata, p.deployBatchSize(reflect.ValueOf(data).Len())), nil
+}
+
+// deployBatchSize returns the number of records upserted per batch. An ORM built without New has no batch size,
+// so it upserts every record in a single batch.
+func (p PersistenceORM) deployBatchSize(dataCount int) int {
+	if p.batchSize <= 0 {
+		return dataCount
+	}
+	return p.batchSize
+}
+
+// versionConflicts returns a VersionConflictError for the updates of the change sets that conflicted, or nil if
+// none did
+func versionConflicts(changeSets []*dbchange.ChangeSet, data interface{}) error {
+	conflicts := []dbchange.Change{}
+	for _, changeSet := range changeSets {
+		for _, update := range changeSet.Updates {
+			if update.Conflict {
+				conflicts = append(conflicts, update)
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
 	tableMetadata, err := tags.GetTableMetadata(data)
 	if err != nil {
 		return err
 	}
+	return newVersionConflictError(conflicts, tableMetadata)
+}
+
+// newVersionConflictError returns a VersionConflictError for the rows of conflicting updates
+func newVersionConflictError(conflicts []dbchange.Change, tableMetadata *tags.TableMetadata) *VersionConflictError {
+	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
+	primaryKeys := make([]interface{}, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		primaryKeys = append(primaryKeys, conflict.Changes[primaryKeyColumnName])
+	}
+	return &VersionConflictError{
+		Table:       tableMetadata.GetTableName(),
+		PrimaryKeys: primaryKeys,
+	}
+}
+
+// getDeployResults matches each item in the deployed data back to the change that was performed
+// for it, using the lookup key of the item within its batch.
+func getDeployResults(data interface{}, changeSets []*dbchange.ChangeSet, tableMetadata *tags.TableMetadata, batchSize int) []DeployResult {
+	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
+	dataValue := reflect.ValueOf(data)
+	results := []DeployResult{}
+
+	for batchIndex, changeSet := range changeSets {
+		changesByKey := map[string][]dbchange.Change{}
+		for _, changes := range [][]dbchange.Change{changeSet.Updates, changeSet.Inserts, changeSet.Deletes} {
+			for _, change := range changes {
+				changesByKey[change.Key] = append(changesByKey[change.Key], change)
+			}
+		}
+
+		start := batchIndex * batchSize
+		end := start + batchSize
+		if end > dataValue.Len() {
+			end = dataValue.Len()
+		}
+
+		for i := start; i < end; i++ {
+			var key string
+			var changes []dbchange.Change
+			for _, key = range changeSetKeys(dataValue.Index(i), changeSet) {
+				if changes = changesByKey[key]; len(changes) > 0 {
+					break
+				}
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			change := changes[0]
+			changesByKey[key] = changes[1:]
+
+			results = append(results, DeployResult{
+				Key:        key,
+				PrimaryKey: change.Changes[primaryKeyColumnName],
+				Type:       change.Type,
+				Conflict:   change.Conflict,
+			})
+		}
+	}
+
+	return results
+}
+
+func (p PersistenceORM) upsert(data interface{}, deleteFilters interface{}) ([]*dbchange.ChangeSet, error) {
+
+	tableMetadata, err := tags.GetTableMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(tableMetadata); err != nil {
+		return nil, err
+	}
+	original := data
+	data, sources, err := p.resolveDuplicatePrimaryKeys(data, tableMetadata)
+	if err != nil {
+		return nil, err
+	}
 	dataValue := reflect.ValueOf(data)
 	dataCount := dataValue.Len()
 	var changeSets []*dbchange.ChangeSet
 	if dataCount > 0 {
-		for i := 0; i < dataCount; i += p.batchSize {
-			end := i + p.batchSize
+		batchSize := p.deployBatchSize(dataCount)
+		for i := 0; i < dataCount; i += batchSize {
+			end := i + batchSize
 			if end > dataCount {
 				end = dataCount
 			}
 			changeSet, err := p.generateChanges(dataValue.Slice(i, end).Interface(), tableMetadata)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			changeSets = append(changeSets, changeSet)
+			if p.isDryRun() {
+				p.planChanges(changeSet)
+				continue
+			}
 			err = p.upsertBatch(changeSet, tableMetadata)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			setPrimaryKeysFromChangeSet(changeSet, tableMetadata)
+			if err := callAfterSaveForChangeSet(changeSet); err != nil {
+				return nil, err
 			}
 		}
 	} else {
 		changeSet, err := p.generateChanges(data, tableMetadata)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		changeSets = append(changeSets, changeSet)
 	}
 
+	// The children of a model whose update conflicted aren't deployed either
 	combinedOperations := []dbchange.Change{}
 	for _, changeSet := range changeSets {
-		combinedOperations = append(combinedOperations, append(changeSet.Updates, changeSet.Inserts...)...)
+		for _, change := range append(changeSet.Updates, changeSet.Inserts...) {
+			if !change.Conflict {
+				combinedOperations = append(combinedOperations, change)
+			}
+		}
 	}
 
 	// Perform Child Upserts
 	err = p.performChildUpserts(combinedOperations, tableMetadata)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	writeBackResolved(original, data, sources, tableMetadata)
 
 	if deleteFilters != nil {
 		deletes := []dbchange.Change{}
-		deleteResults, err := p.FilterModel(FilterRequest{
+		deleteResults, err := p.exactTenant().FilterModel(FilterRequest{
 			FilterModel:  deleteFilters,
 			Runner:       p.transaction,
 			Associations: getAssociations(tableMetadata),
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		var lookupsToUse []tags.Lookup
+		var keyChangeSet *dbchange.ChangeSet
 
 		updateKeyMap := map[string]bool{}
 		for _, changeSet := range changeSets {
 
-			if lookupsToUse == nil {
-				lookupsToUse = changeSet.LookupsUsed
+			if keyChangeSet == nil {
+				keyChangeSet = changeSet
 			}
 
 			for _, update := range changeSet.Updates {
@@ -219,27 +520,61 @@ func (p PersistenceORM) upsert(data interface{}, deleteFilters interface{}) erro
 			for _, insert := range changeSet.Inserts {
 				updateKeyMap[insert.Key] = true
 			}
+
+			for _, key := range changeSet.SkippedKeys {
+				updateKeyMap[key] = true
+			}
 		}
 
 		for _, result := range deleteResults {
 			resultValue := reflect.ValueOf(result)
-			compoundObjectKey := getObjectKeyReflect(resultValue, lookupsToUse)
-			if _, ok := updateKeyMap[compoundObjectKey]; !ok {
+			isDeployed := false
+			if keyChangeSet != nil {
+				for _, compoundObjectKey := range changeSetKeys(resultValue, keyChangeSet) {
+					isDeployed = isDeployed || updateKeyMap[compoundObjectKey]
+				}
+			}
+			if !isDeployed {
+				changes := map[string]interface{}{}
+				for _, fieldName := range tableMetadata.GetPrimaryKeyFieldNames() {
+					changes[tableMetadata.GetField(fieldName).GetColumnName()] = resultValue.FieldByName(fieldName).Interface()
+				}
 				deletes = append(deletes, dbchange.Change{
-					Changes: map[string]interface{}{
-						tableMetadata.GetPrimaryKeyColumnName(): getObjectProperty(resultValue, tableMetadata.GetPrimaryKeyFieldName()),
-					},
-					Type: dbchange.Delete,
+					Changes: changes,
+					Type:    dbchange.Delete,
+				})
+			}
+		}
+
+		if p.isDryRun() {
+			if len(deletes) > 0 {
+				p.planChanges(&dbchange.ChangeSet{
+					TableName: tableMetadata.GetTableName(),
+					Deletes:   deletes,
 				})
 			}
+			return changeSets, nil
 		}
 
 		// Execute Delete Queries
 		if err := p.performDeletes(deletes, tableMetadata); err != nil {
-			return err
+			return nil, err
+		}
+	}
+	return changeSets, nil
+}
+
+// setPrimaryKeysFromChangeSet writes the primary keys of inserted and updated rows
+// back onto the structs that were deployed
+func setPrimaryKeysFromChangeSet(changeSet *dbchange.ChangeSet, tableMetadata *tags.TableMetadata) {
+	for _, changes := range [][]dbchange.Change{changeSet.Updates, changeSet.Inserts} {
+		for _, change := range changes {
+			if change.OriginalValue.CanSet() {
+				setPrimaryKeyFromInsertResult(change.OriginalValue, change, tableMetadata)
+				setReturnedValues(change.OriginalValue, change, tableMetadata)
+			}
 		}
 	}
-	return nil
 }
 
 // Upsert takes data in the form of a slice of structs and performs a series of database
@@ -252,7 +587,7 @@ func (p PersistenceORM) upsertBatch(changeSet *dbchange.ChangeSet, tableMetadata
 	}
 
 	// Execute Update Queries
-	if err := p.performUpdates(changeSet.Updates, tableMetadata); err != nil {
+	if _, err := p.performUpdates(changeSet.Updates, tableMetadata); err != nil {
 		return err
 	}
 
@@ -267,33 +602,84 @@ func (p PersistenceORM) upsertBatch(changeSet *dbchange.ChangeSet, tableMetadata
 func (p PersistenceORM) performDeletes(deletes []dbchange.Change, tableMetadata *tags.TableMetadata) error {
 	if len(deletes) > 0 {
 		tableName := tableMetadata.GetTableName()
-		primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
 		multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName()
 
 		psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
-		keys := []string{}
-		for _, delete := range deletes {
-			changes := delete.Changes
-			keys = append(keys, changes[primaryKeyColumnName].(string))
+		keysWhere := deleteKeysWhere(deletes, tableMetadata)
+
+		if softDeleteColumnName := tableMetadata.GetSoftDeleteColumnName(); softDeleteColumnName != "" {
+			updateQuery := psql.Update(tableName).
+				Set(softDeleteColumnName, squirrel.Expr("now()")).
+				Where(keysWhere)
+
+			if multitenancyKeyColumnName != "" {
+				updateQuery = updateQuery.Where(squirrel.Eq{multitenancyKeyColumnName: p.multitenancyValue})
+			}
+
+			_, err := updateQuery.RunWith(p.runner()).Exec()
+			if err != nil {
+				q, _, _ := updateQuery.ToSql()
+				return newQueryError(err, q)
+			}
+			return nil
 		}
 
 		deleteQuery := psql.Delete(tableName)
-		deleteQuery = deleteQuery.Where(squirrel.Eq{primaryKeyColumnName: keys})
+		deleteQuery = deleteQuery.Where(keysWhere)
 
 		if multitenancyKeyColumnName != "" {
 			deleteQuery = deleteQuery.Where(squirrel.Eq{multitenancyKeyColumnName: p.multitenancyValue})
 		}
 
-		_, err := deleteQuery.RunWith(p.transaction).Exec()
+		_, err := deleteQuery.RunWith(p.runner()).Exec()
 		if err != nil {
 			q, _, _ := deleteQuery.ToSql()
-			return NewQueryError(err, q)
+			return newQueryError(err, q)
 		}
 	}
 	return nil
 }
 
-func (p PersistenceORM) performUpdates(updates []dbchange.Change, tableMetadata *tags.TableMetadata) error {
+// deleteKeysWhere matches the rows of the deletes by their primary keys. Tables with a composite
+// primary key match each row on all of its key columns.
+func deleteKeysWhere(deletes []dbchange.Change, tableMetadata *tags.TableMetadata) squirrel.Sqlizer {
+	if !tableMetadata.HasCompositePrimaryKey() {
+		primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
+		keys := []interface{}{}
+		for _, delete := range deletes {
+			keys = append(keys, delete.Changes[primaryKeyColumnName])
+		}
+		return squirrel.Eq{primaryKeyColumnName: keys}
+	}
+
+	keysWhere := squirrel.Or{}
+	for _, delete := range deletes {
+		keysWhere = append(keysWhere, primaryKeyWhere(tableMetadata, delete.Changes))
+	}
+	return keysWhere
+}
+
+// primaryKeyWhere matches the row with the primary key values held in changes. Composite key columns
+// are matched in struct order.
+func primaryKeyWhere(tableMetadata *tags.TableMetadata, changes map[string]interface{}) squirrel.Sqlizer {
+	if !tableMetadata.HasCompositePrimaryKey() {
+		primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
+		return squirrel.Eq{primaryKeyColumnName: changes[primaryKeyColumnName]}
+	}
+
+	where := squirrel.And{}
+	for _, columnName := range tableMetadata.GetPrimaryKeyColumnNames() {
+		where = append(where, squirrel.Eq{columnName: changes[columnName]})
+	}
+	return where
+}
+
+// performUpdates updates the rows of the changes and returns the number of rows the updates matched. Postgres
+// counts every matched row, even when the update leaves its values unchanged. On a table with a version column,
+// every update increments the version, and a change that sets the version only updates the row if it still has
+// that version. Changes whose row doesn't are marked as conflicts.
+func (p PersistenceORM) performUpdates(updates []dbchange.Change, tableMetadata *tags.TableMetadata) (int64, error) {
+	var rowsMatched int64
 	if len(updates) > 0 {
 
 		tableName := tableMetadata.GetTableName()
@@ -302,36 +688,190 @@ func (p PersistenceORM) performUpdates(updates []dbchange.Change, tableMetadata
 
 		primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
 		multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName()
+		returningColumnNames := tableMetadata.GetReturningColumns()
+		timestampColumns := tableMetadata.GetTimestampColumns()
+		versionColumnName := tableMetadata.GetVersionColumnName()
+		if versionColumnName != "" {
+			// The incremented version is read back, so it's returned even when the table has no other returning columns
+			returningColumnNames = append(returningColumnNames, versionColumnName)
+		}
+
+		var changedColumns [][]string
+		if p.changeListener != nil {
+			var err error
+			changedColumns, err = p.getChangedColumns(tableMetadata, updates, columnNames)
+			if err != nil {
+				return 0, err
+			}
+		}
 
 		psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
 
-		for _, update := range updates {
+		for index, update := range updates {
 			changes := update.Changes
 			updateQuery := psql.Update(tableName)
 
 			for _, columnName := range columnNames {
+				if columnName == versionColumnName {
+					updateQuery = updateQuery.Set(columnName, squirrel.Expr(columnName+" + 1"))
+					continue
+				}
 				value, ok := changes[columnName]
 				if ok {
-					updateQuery = updateQuery.Set(columnName, value)
+					updateQuery = updateQuery.Set(columnName, castTimestamp(value, timestampColumns[columnName]))
 				}
 			}
 
 			if multitenancyKeyColumnName != "" {
 				updateQuery = updateQuery.Where(squirrel.Eq{multitenancyKeyColumnName: p.multitenancyValue})
 			}
-			updateQuery = updateQuery.Where(squirrel.Eq{primaryKeyColumnName: changes[primaryKeyColumnName]})
+			updateQuery = updateQuery.Where(primaryKeyWhere(tableMetadata, changes))
+			expectedVersion, hasVersion := changes[versionColumnName]
+			if hasVersion {
+				updateQuery = updateQuery.Where(squirrel.Eq{versionColumnName: expectedVersion})
+			}
+
+			if len(returningColumnNames) > 0 {
+				updateQuery = updateQuery.Suffix(returningClause(returningColumnNames))
+				rows, err := updateQuery.RunWith(p.runner()).Query()
+				if err != nil {
+					q, _, _ := updateQuery.ToSql()
+					return 0, newQueryError(err, q)
+				}
 
-			_, err := updateQuery.RunWith(p.transaction).Exec()
+				updateResults, err := getQueryResults(rows)
+				if err != nil {
+					return 0, err
+				}
+				rowsMatched += int64(len(updateResults))
 
-			if err != nil {
-				q, _, _ := updateQuery.ToSql()
-				return NewQueryError(err, q)
+				if len(updateResults) > 0 {
+					for _, columnName := range returningColumnNames {
+						changes[columnName] = updateResults[0][columnName]
+					}
+				} else if hasVersion {
+					updates[index].Conflict = true
+					continue
+				}
+			} else {
+				result, err := updateQuery.RunWith(p.runner()).Exec()
+
+				if err != nil {
+					q, _, _ := updateQuery.ToSql()
+					return 0, newQueryError(err, q)
+				}
+				rowsAffected, err := result.RowsAffected()
+				if err != nil {
+					return 0, err
+				}
+				rowsMatched += rowsAffected
+			}
+
+			if len(changedColumns) > 0 && len(changedColumns[index]) > 0 {
+				p.changeListener(ChangeRecord{
+					TableName:      tableName,
+					PrimaryKey:     changes[primaryKeyColumnName],
+					ChangedColumns: changedColumns[index],
+				})
+			}
+		}
+	}
+	return rowsMatched, nil
+}
+
+// returningClause builds a RETURNING suffix for the provided columns
+func returningClause(columnNames []string) string {
+	quoted := make([]string, 0, len(columnNames))
+	for _, columnName := range columnNames {
+		quoted = append(quoted, fmt.Sprintf("\"%s\"", columnName))
+	}
+	return "RETURNING " + strings.Join(quoted, ", ")
+}
+
+// onConflictClause updates the existing row when an insert conflicts with it on the conflict target, so the
+// insert still returns the row's keys. The conflict target, primary key, multitenancy key and created audit
+// fields keep their existing values, as do columns that any of the inserts leaves unset. On a table with a
+// multitenancy key, a row of another tenant is never updated, so its conflicting insert returns no row.
+func onConflictClause(conflictTarget []string, columnNames []string, inserts []dbchange.Change, tableMetadata *tags.TableMetadata) string {
+	updatable := map[string]bool{}
+	for _, columnName := range tableMetadata.GetColumnNamesForUpdate() {
+		updatable[columnName] = true
+	}
+	updateColumnNames := []string{}
+	for _, columnName := range removeColumns(columnNames, conflictTarget) {
+		if updatable[columnName] && setByAll(inserts, columnName) {
+			updateColumnNames = append(updateColumnNames, columnName)
+		}
+	}
+	// DO NOTHING doesn't return conflicting rows, so a table with nothing to update sets a target column to itself
+	if len(updateColumnNames) == 0 {
+		updateColumnNames = conflictTarget[:1]
+	}
+
+	assignments := make([]string, 0, len(updateColumnNames))
+	for _, columnName := range updateColumnNames {
+		assignments = append(assignments, fmt.Sprintf("\"%s\" = EXCLUDED.\"%s\"", columnName, columnName))
+	}
+	clause := fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(conflictTarget, ", "), strings.Join(assignments, ", "))
+	if multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName(); multitenancyKeyColumnName != "" {
+		clause += fmt.Sprintf(" WHERE %s.\"%s\" = EXCLUDED.\"%s\"", tableMetadata.GetTableName(), multitenancyKeyColumnName, multitenancyKeyColumnName)
+	}
+	return clause
+}
+
+// checkConflictKeys errors when two inserts have the same values in every column of the conflict target, since
+// one INSERT ... ON CONFLICT DO UPDATE can't update a row twice. NULLs never conflict, so inserts that leave a
+// target column unset or NULL are not compared.
+func checkConflictKeys(inserts []dbchange.Change, conflictTarget []string, tableName string) error {
+	seen := map[string]bool{}
+	for _, insert := range inserts {
+		values := make([]string, 0, len(conflictTarget))
+		for _, columnName := range conflictTarget {
+			value := reflect.ValueOf(insert.Changes[columnName])
+			if value.Kind() == reflect.Ptr && !value.IsNil() {
+				value = value.Elem()
+			}
+			if !value.IsValid() || value.Kind() == reflect.Ptr {
+				break
 			}
+			values = append(values, fmt.Sprint(value.Interface()))
 		}
+		if len(values) < len(conflictTarget) {
+			continue
+		}
+		key := fmt.Sprintf("%q", values)
+		if seen[key] {
+			return fmt.Errorf("more than one model inserted into table '%s' has the conflict target values (%s)", tableName, strings.Join(values, ", "))
+		}
+		seen[key] = true
 	}
 	return nil
 }
 
+// setByAll reports whether every change sets the column
+func setByAll(changes []dbchange.Change, columnName string) bool {
+	for _, change := range changes {
+		if _, ok := change.Changes[columnName]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// removeColumns returns the column names that are not in the columns to remove
+func removeColumns(columnNames []string, columnsToRemove []string) []string {
+	if len(columnsToRemove) == 0 {
+		return columnNames
+	}
+	kept := []string{}
+	for _, columnName := range columnNames {
+		if !stringutil.StringSliceContainsKey(columnsToRemove, columnName) {
+			kept = append(kept, columnName)
+		}
+	}
+	return kept
+}
+
 func deDup(values []string) []string {
 	r := make([]string, 0, len(values))
 	seen := make(map[string]bool)
@@ -352,7 +892,7 @@ func (p PersistenceORM) performInserts(inserts []dbchange.Change, insertsHavePri
 
 		tableName := tableMetadata.GetTableName()
 
-		primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
+		primaryKeyColumnNames := tableMetadata.GetPrimaryKeyColumnNames()
 
 		var columnNames []string
 
@@ -362,47 +902,88 @@ func (p PersistenceORM) performInserts(inserts []dbchange.Change, insertsHavePri
 			columnNames = tableMetadata.GetColumnNamesWithoutPrimaryKey()
 		}
 
-		columnNames = deDup(columnNames)
+		returningColumnNames := tableMetadata.GetReturningColumns()
+		columnNames = removeColumns(deDup(columnNames), returningColumnNames)
+
+		conflictTarget := tableMetadata.GetConflictTarget()
+		timestampColumns := tableMetadata.GetTimestampColumns()
+
+		// COPY has no ON CONFLICT, so tables with a conflict target are always inserted
+		if len(conflictTarget) == 0 && p.canCopyInserts(inserts, insertsHavePrimaryKey, returningColumnNames) {
+			return p.copyInserts(inserts, tableName, columnNames, timestampColumns)
+		}
+
+		if len(conflictTarget) > 0 {
+			if err := checkConflictKeys(inserts, conflictTarget, tableName); err != nil {
+				return err
+			}
+		}
 
 		insertQuery := psql.Insert(tableName)
 		insertQuery = insertQuery.Columns(columnNames...)
 
 		for _, insert := range inserts {
 			changes := insert.Changes
-			insertQuery = insertQuery.Values(getColumnValues(columnNames, changes)...)
+			columnValues := getColumnValues(columnNames, changes)
+			for index, columnName := range columnNames {
+				columnValues[index] = castTimestamp(columnValues[index], timestampColumns[columnName])
+			}
+			insertQuery = insertQuery.Values(columnValues...)
 		}
 
-		insertQuery = insertQuery.Suffix(fmt.Sprintf("RETURNING \"%s\"", primaryKeyColumnName))
+		suffix := returningClause(append(primaryKeyColumnNames, returningColumnNames...))
+		nativeUpsert := p.usesNativeUpsert(tableMetadata)
+		if nativeUpsert {
+			// xmax is 0 for a row the statement inserted, and set for an existing row it updated on a conflict
+			suffix += ", (xmax = 0) AS " + insertedColumn
+		}
+		if len(conflictTarget) > 0 {
+			suffix = onConflictClause(conflictTarget, columnNames, inserts, tableMetadata) + " " + suffix
+		}
+		insertQuery = insertQuery.Suffix(suffix)
 
-		rows, err := insertQuery.RunWith(p.transaction).Query()
+		rows, err := insertQuery.RunWith(p.runner()).Query()
 		if err != nil {
 			q, _, _ := insertQuery.ToSql()
-			return NewQueryError(err, q)
+			return newQueryError(err, q)
 		}
 
 		insertResults, err := getQueryResults(rows)
 		if err != nil {
 			return err
 		}
+		if len(insertResults) < len(inserts) {
+			return fmt.Errorf("%d of the models inserted into table '%s' conflicted with rows of another tenant", len(inserts)-len(insertResults), tableName)
+		}
 
-		// Insert our new keys into the change objects
+		// Insert our new keys and the values set by the database into the change objects
 		for index, insert := range inserts {
-			insert.Changes[primaryKeyColumnName] = insertResults[index][primaryKeyColumnName]
+			for _, columnName := range primaryKeyColumnNames {
+				insert.Changes[columnName] = insertResults[index][columnName]
+			}
+			for _, columnName := range returningColumnNames {
+				insert.Changes[columnName] = insertResults[index][columnName]
+			}
+		}
+		if nativeUpsert {
+			setUpsertTypes(inserts, insertResults)
 		}
 	}
 	return nil
 }
 
-func (p PersistenceORM) getExistingObjectByID(tableMetadata *tags.TableMetadata, IDValue interface{}) (map[string]interface{}, error) {
+func (p PersistenceORM) getExistingObjectByID(tableMetadata *tags.TableMetadata, IDValues map[string]interface{}) (map[string]interface{}, error) {
 	tableName := tableMetadata.GetTableName()
-	IDColumn := tableMetadata.GetPrimaryKeyColumnName()
 	multitenancyColumn := tableMetadata.GetMultitenancyKeyColumnName()
-	rows, err := squirrel.Select(fmt.Sprintf("%v.%v", tableName, IDColumn)).PlaceholderFormat(squirrel.Dollar).
-		From(tableName).
-		Where(squirrel.Eq{fmt.Sprintf("%v.%v", tableName, IDColumn): IDValue}).
-		Where(squirrel.Eq{fmt.Sprintf("%v.%v", tableName, multitenancyColumn): p.multitenancyValue}).
-		RunWith(p.transaction).
-		Query()
+	query := squirrel.Select().PlaceholderFormat(squirrel.Dollar).From(tableName)
+	for _, IDColumn := range tableMetadata.GetPrimaryKeyColumnNames() {
+		query = query.Column(fmt.Sprintf("%v.%v", tableName, IDColumn)).
+			Where(squirrel.Eq{fmt.Sprintf("%v.%v", tableName, IDColumn): IDValues[IDColumn]})
+	}
+	if multitenancyColumn != "" {
+		query = query.Where(squirrel.Eq{fmt.Sprintf("%v.%v", tableName, multitenancyColumn): p.multitenancyValue})
+	}
+	rows, err := query.RunWith(p.runner()).Query()
 
 	if err != nil {
 		return nil, err
@@ -421,6 +1002,10 @@ func getAssociations(tableMetadata *tags.TableMetadata) []tags.Association {
 	assocs := []tags.Association{}
 	foreignKeys := tableMetadata.GetForeignKeys()
 	for _, foreignKey := range foreignKeys {
+		// A self-referential model would load its references forever
+		if foreignKey.TableMetadata == tableMetadata {
+			continue
+		}
 		a := tags.Association{
 			Name:         foreignKey.RelatedFieldName,
 			Associations: getAssociations(foreignKey.TableMetadata),
@@ -440,17 +1025,24 @@ func (p PersistenceORM) checkForExisting(
 	error,
 ) {
 	tableName := tableMetadata.GetTableName()
-	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
 	multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName()
 	tableAliasCache := map[string]string{}
 	lookupsToUse := getLookupsForDeploy(data, tableMetadata, foreignKey, tableAliasCache)
+	for _, lookup := range lookupsToUse {
+		if err := lookup.ValidateCastType(); err != nil {
+			return nil, nil, err
+		}
+	}
 	lookupObjectKeys := getLookupObjectKeys(data, lookupsToUse, foreignKey)
 
 	if len(lookupObjectKeys) == 0 || len(lookupsToUse) == 0 {
 		return map[string]interface{}{}, lookupsToUse, nil
 	}
 
-	query := squirrel.Select(fmt.Sprintf("%v.%v", tableName, primaryKeyColumnName))
+	query := squirrel.Select()
+	for _, columnName := range tableMetadata.GetPrimaryKeyColumnNames() {
+		query = query.Column(fmt.Sprintf("%v.%v", tableName, columnName))
+	}
 	query = query.From(tableName)
 
 	columns, joins, whereFields := getQueryParts(tableMetadata, lookupsToUse, tableAliasCache)
@@ -461,24 +1053,50 @@ func (p PersistenceORM) checkForExisting(
 
 	query = query.Columns(columns...)
 
-	if len(whereFields) > 0 {
+	// getQueryParts returns one where field for each lookup, in the same order
+	whereColumns := map[int]string{}
+	matchedAsText := true
+	for index, whereField := range whereFields {
+		eq, ok := whereField.(squirrel.Eq)
+		if ok {
+			for whereFieldKey := range eq {
+				whereColumns[index] = whereFieldKey
+			}
+			matchedAsText = matchedAsText && lookupsToUse[index].IsMatchedAsText()
+		}
+	}
+
+	if column, ok := uuidLookupColumn(lookupsToUse, whereFields); ok {
+		// Comparing a lone uuid lookup without casting it lets Postgres use the column's index
+		query = query.Where(column+" = ANY(?)", pq.Array(joinLookupObjectKeys(lookupObjectKeys)))
+	} else if len(whereColumns) > 0 && matchedAsText {
 		wheres := []string{}
-		for _, whereField := range whereFields {
-			eq, ok := whereField.(squirrel.Eq)
-			if ok {
-				for whereFieldKey := range eq {
-					wheres = append(wheres, fmt.Sprintf("COALESCE(%v::\"varchar\",'')", whereFieldKey))
+		for index := range whereFields {
+			if column, ok := whereColumns[index]; ok {
+				wheres = append(wheres, lookupsToUse[index].GetKeyExpression(column))
+			}
+		}
+		query = query.Where(strings.Join(wheres, " || '"+separator+"' || ")+" = ANY(?)", pq.Array(joinLookupObjectKeys(lookupObjectKeys)))
+	} else if len(whereColumns) > 0 {
+		// Uncast and citext columns are compared with each key part, so they keep their own type's comparison
+		keyMatches := squirrel.Or{}
+		for _, keyParts := range lookupObjectKeys {
+			keyMatch := squirrel.And{}
+			for index := range whereFields {
+				if column, ok := whereColumns[index]; ok {
+					keyMatch = append(keyMatch, lookupsToUse[index].GetMatchExpression(column, keyParts[index]))
 				}
 			}
+			keyMatches = append(keyMatches, keyMatch)
 		}
-		query = query.Where(strings.Join(wheres, " || '"+separator+"' || ")+" = ANY(?)", pq.Array(lookupObjectKeys))
+		query = query.Where(keyMatches)
 	}
 
 	if multitenancyKeyColumnName != "" {
 		query = query.Where(fmt.Sprintf("%v.%v = ?", tableName, multitenancyKeyColumnName), p.multitenancyValue)
 	}
 
-	rows, err := query.PlaceholderFormat(squirrel.Dollar).RunWith(p.transaction).Query()
+	rows, err := query.PlaceholderFormat(squirrel.Dollar).RunWith(p.runner()).Query()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -491,7 +1109,39 @@ func (p PersistenceORM) checkForExisting(
 	return results, lookupsToUse, nil
 }
 
-func getLookupsFromForeignKeys(foreignKeys []tags.ForeignKey, baseJoinKey string, baseObjectProperty string, tableAliasCache map[string]string) []tags.Lookup {
+// joinLookupObjectKeys joins the parts of each lookup key into the text the lookups are matched against
+func joinLookupObjectKeys(lookupObjectKeys [][]string) []string {
+	keys := []string{}
+	for _, keyParts := range lookupObjectKeys {
+		keys = append(keys, strings.Join(keyParts, separator))
+	}
+	return keys
+}
+
+// uuidLookupColumn returns the column of the only lookup, if it's a uuid column whose cast wasn't changed with
+// the cast tag
+func uuidLookupColumn(lookupsToUse []tags.Lookup, whereFields []squirrel.Sqlizer) (string, bool) {
+	if len(lookupsToUse) != 1 || len(whereFields) != 1 {
+		return "", false
+	}
+	lookup := lookupsToUse[0]
+	if !lookup.IsUUID || lookup.CastType != "" || lookup.SubQuery != nil {
+		return "", false
+	}
+	eq, ok := whereFields[0].(squirrel.Eq)
+	if !ok {
+		return "", false
+	}
+	for column := range eq {
+		return column, true
+	}
+	return "", false
+}
+
+// getLookupsFromForeignKeys returns the lookups of the related tables of foreign keys that need a lookup, and of
+// their related tables in turn. visitedTables are the tables on the path to the foreign keys, whose lookups
+// aren't followed again, so self-referential and cyclic relations end.
+func getLookupsFromForeignKeys(foreignKeys []tags.ForeignKey, baseJoinKey string, baseObjectProperty string, tableAliasCache map[string]string, visitedTables map[string]bool) []tags.Lookup {
 	lookupsToUse := []tags.Lookup{}
 
 	for _, foreignKey := range foreignKeys {
@@ -504,15 +1154,30 @@ func getLookupsFromForeignKeys(foreignKeys []tags.ForeignKey, baseJoinKey string
 					MatchDBColumn:       lookup.MatchDBColumn,
 					MatchObjectProperty: getMatchObjectProperty(baseObjectProperty, foreignKey.RelatedFieldName, lookup.MatchObjectProperty),
 					JoinKey:             joinKey,
+					CastType:            lookup.CastType,
+					DisableCast:         lookup.DisableCast,
+					IsUUID:              lookup.IsUUID,
 				})
 			}
 			newBaseJoinKey := getTableAlias(tableMetadata.GetTableName(), joinKey, tableAliasCache)
-			lookupsToUse = append(lookupsToUse, getLookupsFromForeignKeys(tableMetadata.GetForeignKeys(), newBaseJoinKey, getNewBaseObjectProperty(baseObjectProperty, foreignKey.RelatedFieldName), tableAliasCache)...)
+			relatedTableName := tableMetadata.GetTableName()
+			if visitedTables[relatedTableName] {
+				continue
+			}
+			visitedTables[relatedTableName] = true
+			lookupsToUse = append(lookupsToUse, getLookupsFromForeignKeys(tableMetadata.GetForeignKeys(), newBaseJoinKey, getNewBaseObjectProperty(baseObjectProperty, foreignKey.RelatedFieldName), tableAliasCache, visitedTables)...)
+			delete(visitedTables, relatedTableName)
 		}
 	}
 	return lookupsToUse
 }
 
+// lookupColumnAlias returns the name a lookup column is selected as. The dot of a schema qualified table
+// name isn't allowed in a column alias, so it is replaced with an underscore.
+func lookupColumnAlias(tableAlias string, columnName string) string {
+	return strings.Replace(tableAlias, ".", "_", -1) + "_" + columnName
+}
+
 func getTableAlias(table string, joinKey string, tableAliasCache map[string]string) string {
 	if table == "" || joinKey == "" {
 		return table
@@ -549,7 +1214,6 @@ func getMatchObjectProperty(baseObjectProperty string, relatedFieldName string,
 func getLookupsForDeploy(data interface{}, tableMetadata *tags.TableMetadata, foreignKey *tags.ForeignKey, tableAliasCache map[string]string) []tags.Lookup {
 	lookupsToUse := []tags.Lookup{}
 	tableName := tableMetadata.GetTableName()
-	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
 	primaryKeyFieldName := tableMetadata.GetPrimaryKeyFieldName()
 	lookups := tableMetadata.GetLookups()
 
@@ -566,45 +1230,36 @@ func getLookupsForDeploy(data interface{}, tableMetadata *tags.TableMetadata, fo
 		if foreignKey != nil {
 			keyMapField := foreignKey.KeyMapField
 			keyValue := item.FieldByName(foreignKey.FieldName)
-			item = item.FieldByName(foreignKey.RelatedFieldName)
+			item = relatedValue(item, *foreignKey)
 			if keyMapField != "" {
-				relatedKeyField := item.FieldByName(keyMapField)
-				relatedKeyField.Set(keyValue)
+				// A nil related model has nothing to set the key on
+				if relatedKeyField := item.FieldByName(keyMapField); relatedKeyField.CanSet() {
+					relatedKeyField.Set(keyValue)
+				}
 			}
 		}
 
 		// If any piece of data has a primary key we will assume that the data set
 		// contains records with the primary key included. We can then just use the
 		// primary key to do the lookup.
-		pkValue := item.FieldByName(primaryKeyFieldName)
-		if pkValue.IsValid() && pkValue.String() != "" && !hasValidPK {
+		if getObjectProperty(item, primaryKeyFieldName) != "" && !hasValidPK {
 			hasValidPK = true
-			lookupsToUse = append([]tags.Lookup{
-				{
+			primaryKeyLookups := []tags.Lookup{}
+			for _, fieldName := range tableMetadata.GetPrimaryKeyFieldNames() {
+				primaryKeyLookups = append(primaryKeyLookups, tags.Lookup{
 					TableName:           tableName,
-					MatchDBColumn:       primaryKeyColumnName,
-					MatchObjectProperty: primaryKeyFieldName,
-				},
-			}, lookupsToUse...)
+					MatchDBColumn:       tableMetadata.GetField(fieldName).GetColumnName(),
+					MatchObjectProperty: fieldName,
+				})
+			}
+			lookupsToUse = append(primaryKeyLookups, lookupsToUse...)
 		}
 
 		// Iterate over foreign keys to check in reverse so we can remove foreign keys that already have values
 		for i := len(foreignKeysToCheck) - 1; i >= 0; i-- {
 			foreignKeyToCheck := foreignKeysToCheck[i]
 			fkValue := item.FieldByName(foreignKeyToCheck.FieldName)
-			fkValueType := fkValue.Kind()
-			fkValueActual := ""
-			if fkValueType == reflect.String {
-				fkValueActual = fkValue.String()
-			} else if fkValueType == reflect.Pointer {
-				v := fkValue.Elem()
-				if v.IsValid() {
-					fkValueActual = v.String()
-				}
-			} else {
-				fkValueActual = fkValue.String()
-			}
-			if fkValue.IsValid() && fkValueActual != "" && foreignKeyToCheck.NeedsLookup {
+			if formatKeyValue(fkValue) != "" && foreignKeyToCheck.NeedsLookup {
 				// Take this foreign key out because we already have its id and do not need to look it up
 				foreignKeysToCheck = append(foreignKeysToCheck[:i], foreignKeysToCheck[i+1:]...)
 				lookupsToUse = append(lookupsToUse, tags.Lookup{
@@ -625,13 +1280,13 @@ func getLookupsForDeploy(data interface{}, tableMetadata *tags.TableMetadata, fo
 		lookupsToUse = append(lookups, lookupsToUse...)
 	}
 
-	lookupsToUse = append(lookupsToUse, getLookupsFromForeignKeys(foreignKeysToCheck, "", "", tableAliasCache)...)
+	lookupsToUse = append(lookupsToUse, getLookupsFromForeignKeys(foreignKeysToCheck, "", "", tableAliasCache, map[string]bool{tableName: true})...)
 
 	return lookupsToUse
 }
 
 func hasForeignKeyData(item reflect.Value, foreignKey tags.ForeignKey) bool {
-	fk := item.FieldByName(foreignKey.RelatedFieldName)
+	fk := relatedValue(item, foreignKey)
 	tableMetadata := foreignKey.TableMetadata
 	hasData := true
 
@@ -645,8 +1300,26 @@ func hasForeignKeyData(item reflect.Value, foreignKey tags.ForeignKey) bool {
 	return hasData
 }
 
-func getLookupObjectKeys(data interface{}, lookupsToUse []tags.Lookup, foreignKey *tags.ForeignKey) []string {
-	keys := []string{}
+// relatedValue returns the related model of a foreign key, dereferenced when the related field is a pointer
+func relatedValue(item reflect.Value, foreignKey tags.ForeignKey) reflect.Value {
+	return indirectRelated(item.FieldByName(foreignKey.RelatedFieldName))
+}
+
+// indirectRelated dereferences a pointer to a related model. A nil pointer has no data, so it returns the zero
+// value of the model.
+func indirectRelated(related reflect.Value) reflect.Value {
+	if related.Kind() != reflect.Ptr {
+		return related
+	}
+	if related.IsNil() {
+		return reflect.Zero(related.Type().Elem())
+	}
+	return related.Elem()
+}
+
+// getLookupObjectKeys returns the unique lookup keys of the models, as the key part of each lookup
+func getLookupObjectKeys(data interface{}, lookupsToUse []tags.Lookup, foreignKey *tags.ForeignKey) [][]string {
+	keys := [][]string{}
 	keyMap := map[string]bool{}
 	s := reflect.ValueOf(data)
 	emptyKeyLength := len(separator) * len(lookupsToUse)
@@ -654,13 +1327,14 @@ func getLookupObjectKeys(data interface{}, lookupsToUse []tags.Lookup, foreignKe
 		item := s.Index(i)
 
 		if foreignKey != nil {
-			item = item.FieldByName(foreignKey.RelatedFieldName)
+			item = relatedValue(item, *foreignKey)
 		}
 		isZeroField := reflect.DeepEqual(item.Interface(), reflect.Zero(item.Type()).Interface())
 		if isZeroField {
 			continue
 		}
-		objectKey := getObjectKeyReflect(item, lookupsToUse)
+		keyParts := getObjectKeyParts(item, lookupsToUse)
+		objectKey := strings.Join(keyParts, separator)
 		// If none of the lookups have values, don't do the lookup
 		if len(objectKey) == emptyKeyLength-1 {
 			continue
@@ -670,7 +1344,7 @@ func getLookupObjectKeys(data interface{}, lookupsToUse []tags.Lookup, foreignKe
 		if _, ok := keyMap[objectKey]; !ok {
 			keyMap[objectKey] = true
 			// Determine the where values that we need for this lookup
-			keys = append(keys, objectKey)
+			keys = append(keys, keyParts)
 		}
 	}
 	return keys
@@ -690,15 +1364,20 @@ func getQueryParts(tableMetadata *tags.TableMetadata, lookupsToUse []tags.Lookup
 			tableToUse = lookup.TableName
 		}
 		tableAlias := tableToUse
-		if lookup.JoinKey != "" && lookup.TableName != "" && tableToUse != tableName {
+		if lookup.JoinKey != "" && lookup.TableName != "" {
 			tableAlias = getTableAlias(tableToUse, lookup.JoinKey, tableAliasCache)
 			_, alreadyAddedJoin := joinMap[tableAlias]
 			if !alreadyAddedJoin {
 				joinMap[tableAlias] = true
-				joins = append(joins, fmt.Sprintf("%[1]v as %[4]v on %[4]v.%[2]v::\"varchar\" = %[3]v::\"varchar\"", tableToUse, primaryKeyColumnName, lookup.JoinKey, tableAlias))
+				joinKey := lookup.JoinKey
+				// A self-referential join has the key column on both sides, so the base table's is qualified
+				if tableToUse == tableName && !strings.Contains(joinKey, ".") {
+					joinKey = tableName + "." + joinKey
+				}
+				joins = append(joins, fmt.Sprintf("%[1]v as %[4]v on %[4]v.%[2]v::\"varchar\" = %[3]v::\"varchar\"", tableToUse, primaryKeyColumnName, joinKey, tableAlias))
 			}
 		}
-		columns = append(columns, fmt.Sprintf("%[3]v.%[2]v as %[3]v_%[2]v", tableToUse, lookup.MatchDBColumn, tableAlias))
+		columns = append(columns, fmt.Sprintf("%[3]v.%[2]v as %[4]v", tableToUse, lookup.MatchDBColumn, tableAlias, lookupColumnAlias(tableAlias, lookup.MatchDBColumn)))
 		if lookup.SubQuery != nil {
 			_, joinParts, whereParts := getQueryParts(lookup.SubQueryMetadata, lookup.SubQuery, tableAliasCache)
 			subQueryFKField := lookup.SubQueryMetadata.GetField(lookup.SubQueryForeignKey)
@@ -740,12 +1419,19 @@ func (p PersistenceORM) performChildUpserts(changeObjects []dbchange.Change, tab
 	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
 
 	for _, child := range tableMetadata.GetChildren() {
+		// Many-to-many children are only loaded, their junction rows are not deployed
+		if child.Junction != nil {
+			continue
+		}
 
 		var data reflect.Value
 		var deleteFiltersValue reflect.Value
 		var deleteFilters interface{}
 		index := 0
 
+		// Keep track of where each child came from so results can be written back to the caller's structs
+		var writeBacks []func(reflect.Value)
+
 		if child.FieldKind == reflect.Slice {
 			// Creates a new Slice of the same type of elements that were stored in the slice of data.
 			data = reflect.New(child.FieldType).Elem()
@@ -767,11 +1453,15 @@ func (p PersistenceORM) performChildUpserts(changeObjects []dbchange.Change, tab
 				}
 			}
 
+			// A dry run can't plan the children of inserts whose primary key would be generated
+			if p.isDryRun() && isEmptyKey(foreignKeyValue) {
+				continue
+			}
+
 			if child.DeleteOrphans && !childValue.IsNil() && changeObject.Type == dbchange.Update {
 				// If we're doing deletes
 				filter := reflect.New(child.FieldType.Elem()).Elem()
-				foreignKeyField := filter.FieldByName(child.ForeignKey)
-				foreignKeyField.SetString(foreignKeyValue.(string))
+				setKeyValue(filter.FieldByName(child.ForeignKey), foreignKeyValue)
 				deleteFiltersValue = reflect.Append(deleteFiltersValue, filter)
 			}
 
@@ -779,19 +1469,11 @@ func (p PersistenceORM) performChildUpserts(changeObjects []dbchange.Change, tab
 				for i := 0; i < childValue.Len(); i++ {
 					value := childValue.Index(i)
 					if child.ForeignKey != "" {
-						keyField := getValueFromLookupString(value, child.ForeignKey)
-						keyFieldType := keyField.Kind()
-						if keyFieldType == reflect.String {
-							keyField.SetString(foreignKeyValue.(string))
-						} else if keyFieldType == reflect.Pointer {
-							stringValue := foreignKeyValue.(string)
-							keyField.Set(reflect.ValueOf(&stringValue))
-						} else {
-							keyField.SetString(foreignKeyValue.(string))
-						}
+						setKeyValue(getValueFromLookupString(value, child.ForeignKey), foreignKeyValue)
 					}
 					data = reflect.Append(data, value)
 					index = index + 1
+					writeBacks = append(writeBacks, value.Set)
 				}
 			} else if childValue.Kind() == reflect.Map {
 				mapKeys := childValue.MapKeys()
@@ -800,9 +1482,12 @@ func (p PersistenceORM) performChildUpserts(changeObjects []dbchange.Change, tab
 					data = reflect.Append(data, value)
 					addressableData := data.Index(index)
 					index = index + 1
+					mapValue, mapKey := childValue, key
+					writeBacks = append(writeBacks, func(v reflect.Value) {
+						mapValue.SetMapIndex(mapKey, v)
+					})
 					if child.ForeignKey != "" {
-						valueToChange := getValueFromLookupString(addressableData, child.ForeignKey)
-						valueToChange.SetString(foreignKeyValue.(string))
+						setKeyValue(getValueFromLookupString(addressableData, child.ForeignKey), foreignKeyValue)
 					}
 					if child.KeyMapping != "" {
 						valueToChange := getValueFromLookupString(addressableData, child.KeyMapping)
@@ -825,10 +1510,21 @@ func (p PersistenceORM) performChildUpserts(changeObjects []dbchange.Change, tab
 			deleteFilters = deleteFiltersValue.Interface()
 		}
 
-		err := p.upsert(data.Interface(), deleteFilters)
+		childChangeSets, err := p.upsert(data.Interface(), deleteFilters)
 		if err != nil {
 			return err
 		}
+		// Children have no deploy results to report conflicts in, so they fail the deploy
+		if err := versionConflicts(childChangeSets, data.Interface()); err != nil {
+			return err
+		}
+
+		if p.isDryRun() {
+			continue
+		}
+		for i, writeBack := range writeBacks {
+			writeBack(data.Index(i))
+		}
 	}
 	return nil
 }
@@ -846,10 +1542,11 @@ func (p PersistenceORM) generateChanges(
 	foreignKeys := tableMetadata.GetForeignKeys()
 	insertsHavePrimaryKey := false
 	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
-	lookupResults, lookups, err := p.checkForExisting(data, tableMetadata, nil)
+	lookupResults, lookups, primaryKeyLookupResults, primaryKeyLookups, err := p.checkForExistingByKey(data, tableMetadata)
 	if err != nil {
 		return nil, err
 	}
+	nativeUpsert := p.usesNativeUpsertForBatch(data, tableMetadata)
 
 	for index := range foreignKeys {
 		foreignKey := &foreignKeys[index]
@@ -864,6 +1561,7 @@ func (p PersistenceORM) generateChanges(
 	inserts := []dbchange.Change{}
 	updates := []dbchange.Change{}
 	deletes := []dbchange.Change{}
+	skippedKeys := []string{}
 
 	s := reflect.ValueOf(data)
 
@@ -873,22 +1571,26 @@ func (p PersistenceORM) generateChanges(
 		value := s.Index(i)
 
 		objectKey := getObjectKeyReflect(value, lookups)
-
 		object := lookupResults[objectKey]
+		if primaryKeyLookups != nil && getObjectProperty(value, tableMetadata.GetPrimaryKeyFieldName()) != "" {
+			objectKey = getObjectKeyReflect(value, primaryKeyLookups)
+			object = primaryKeyLookupResults[objectKey]
+		}
 
 		var existingObj map[string]interface{}
 
 		if object != nil {
 			existingObj = object.(map[string]interface{})
 		}
-		// TODO: Implement Delete Conditions
-		shouldDelete := false
-
-		if shouldDelete {
+		// Models marked with their delete_flag field delete the row they match, and are skipped along with
+		// their children if they don't match one
+		if isMarkedForDelete(value, tableMetadata) {
 			if existingObj != nil {
 				deletes = append(deletes, dbchange.Change{
-					Changes: existingObj,
-					Type:    dbchange.Delete,
+					Changes:       existingObj,
+					OriginalValue: value,
+					Key:           objectKey,
+					Type:          dbchange.Delete,
 				})
 			}
 			continue
@@ -901,7 +1603,18 @@ func (p PersistenceORM) generateChanges(
 			continue
 		}
 
-		dbChange, err := p.processObject(value, existingObj, foreignKeys, tableMetadata)
+		// Models with unresolvable optional references are skipped before they're saved, and the rows they
+		// match are kept out of orphan deletes
+		if reason := p.unresolvableReference(value, foreignKeys, tableMetadata); reason != nil {
+			p.skipListener(SkippedRecord{
+				Model:  value.Interface(),
+				Reason: reason,
+			})
+			skippedKeys = append(skippedKeys, objectKey)
+			continue
+		}
+
+		dbChange, err := p.processObject(value, existingObj, foreignKeys, tableMetadata, !nativeUpsert)
 
 		if err != nil {
 			processingErrors = append(processingErrors, err)
@@ -932,16 +1645,29 @@ func (p PersistenceORM) generateChanges(
 	}
 
 	return &dbchange.ChangeSet{
+		TableName:             tableMetadata.GetTableName(),
 		Inserts:               inserts,
 		Updates:               updates,
 		Deletes:               deletes,
 		InsertsHavePrimaryKey: insertsHavePrimaryKey,
 		LookupsUsed:           lookups,
+		PrimaryKeyLookupsUsed: primaryKeyLookups,
+		SkippedKeys:           skippedKeys,
 	}, nil
 }
 
-func serializeJSONBColumns(columns []string, returnObject map[string]interface{}) error {
-	for _, column := range columns {
+// isMarkedForDelete reports whether a model's delete_flag field is set
+func isMarkedForDelete(value reflect.Value, tableMetadata *tags.TableMetadata) bool {
+	deleteFlagFieldName := tableMetadata.GetDeleteFlagFieldName()
+	return deleteFlagFieldName != "" && value.FieldByName(deleteFlagFieldName).Bool()
+}
+
+func serializeJSONBColumns(tableMetadata *tags.TableMetadata, returnObject map[string]interface{}) error {
+	for _, field := range tableMetadata.GetFields() {
+		if !field.IsJSONB() {
+			continue
+		}
+		column := field.GetColumnName()
 		value := returnObject[column]
 
 		// No value to process
@@ -949,7 +1675,7 @@ func serializeJSONBColumns(columns []string, returnObject map[string]interface{}
 			continue
 		}
 
-		serializedValue, err := serializeJSONBColumn(value)
+		serializedValue, err := serializeJSONBColumn(value, field.GetJSONBCodec())
 		if err != nil {
 			return err
 		}
@@ -959,7 +1685,23 @@ func serializeJSONBColumns(columns []string, returnObject map[string]interface{}
 	return nil
 }
 
-func serializeJSONBColumn(value interface{}) (interface{}, error) {
+// serializePGArrayColumns wraps the slices of fields tagged with pgarray in pq.Array, so they are written as
+// Postgres arrays. Nil slices are written as NULL.
+func serializePGArrayColumns(tableMetadata *tags.TableMetadata, returnObject map[string]interface{}) {
+	for _, field := range tableMetadata.GetFields() {
+		if !field.IsPGArray() {
+			continue
+		}
+		column := field.GetColumnName()
+		if value, ok := returnObject[column]; ok && value != nil {
+			returnObject[column] = pq.Array(value)
+		}
+	}
+}
+
+// serializeJSONBColumn marshals a jsonb value with the named codec, the codec registered for its type, or else
+// encoding/json
+func serializeJSONBColumn(value interface{}, codecName string) (interface{}, error) {
 	// No value to process
 	if value == nil || value == "" {
 		return value, nil
@@ -970,7 +1712,7 @@ func serializeJSONBColumn(value interface{}) (interface{}, error) {
 		return nil, nil
 	}
 
-	return json.Marshal(value)
+	return jsonb.Marshal(codecName, value)
 }
 
 func isFieldDefinedOnStruct(modelMetadata metadata.Metadata, fieldName string, data reflect.Value) bool {
@@ -996,7 +1738,12 @@ func (p PersistenceORM) processObject(
 	databaseObject map[string]interface{},
 	foreignKeys []tags.ForeignKey,
 	tableMetadata *tags.TableMetadata,
+	validateInsert bool,
 ) (dbchange.Change, error) {
+	if err := callBeforeSave(metadataObject); err != nil {
+		return dbchange.Change{}, err
+	}
+
 	returnObject := map[string]interface{}{}
 
 	isUpdate := databaseObject != nil
@@ -1012,6 +1759,11 @@ func (p PersistenceORM) processObject(
 			continue
 		}
 
+		// Columns set by the database are never written
+		if field.IsReturning() {
+			continue
+		}
+
 		auditType := field.GetAudit()
 
 		if auditType != "" {
@@ -1031,33 +1783,54 @@ func (p PersistenceORM) processObject(
 			returnValue = metadataObject.FieldByName(field.GetName()).Interface()
 		}
 
-		if !isUpdate && field.IsPrimaryKey() && (returnValue == nil || returnValue == "") {
+		if !isUpdate && field.IsPrimaryKey() && isEmptyKey(returnValue) {
 			continue
 		}
 
 		returnObject[field.GetColumnName()] = returnValue
 	}
 
-	primaryKeyColumnName := tableMetadata.GetPrimaryKeyColumnName()
 	multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName()
 
 	if isUpdate {
-		returnObject[primaryKeyColumnName] = databaseObject[primaryKeyColumnName]
-	} else {
+		for _, columnName := range tableMetadata.GetPrimaryKeyColumnNames() {
+			returnObject[columnName] = databaseObject[columnName]
+		}
+	} else if multitenancyKeyColumnName != "" {
 		returnObject[multitenancyKeyColumnName] = p.multitenancyValue
 	}
 
 	// Process encrypted columns
 
 	encryptedColumns := tableMetadata.GetEncryptedColumns()
+	deterministicColumns := tableMetadata.GetDeterministicColumns()
 	for _, column := range encryptedColumns {
 		value := returnObject[column]
 
+		// Pointer fields are encrypted by value, and nil pointers are stored as NULL
+		if pointer, ok := value.(*string); ok {
+			if pointer == nil {
+				returnObject[column] = nil
+				continue
+			}
+			value = *pointer
+			returnObject[column] = value
+		}
+
 		// If value is nil or an empty string, no point in encrypting it.
 		if value == nil || value == "" {
 			continue
 		}
 
+		if stringutil.StringSliceContainsKey(deterministicColumns, column) {
+			encoded, err := tags.EncryptDeterministic(value)
+			if err != nil {
+				return dbchange.Change{}, err
+			}
+			returnObject[column] = encoded
+			continue
+		}
+
 		var valueAsBytes []byte
 
 		// Handle both non-interface and interface types as we convert to byte array
@@ -1086,21 +1859,23 @@ func (p PersistenceORM) processObject(
 	}
 
 	// Process JSONB columns that need to be serialized prior to storage
-	serializeJSONBColumns(tableMetadata.GetJSONBColumns(), returnObject)
+	if err := serializeJSONBColumns(tableMetadata, returnObject); err != nil {
+		return dbchange.Change{}, err
+	}
+
+	serializePGArrayColumns(tableMetadata, returnObject)
 
 	for _, foreignKey := range foreignKeys {
 		fkValue, keyIsDefined := returnObject[foreignKey.KeyColumn]
-		if keyIsDefined && fkValue != "" && foreignKey.KeyMapField == "" {
+		lookup, needsLookup := lookupForeignKey(metadataObject, foreignKey, fkValue, keyIsDefined)
+		if !needsLookup {
 			continue
 		}
-		foreignValue := metadataObject.FieldByName(foreignKey.RelatedFieldName)
-		key := getObjectKeyReflect(foreignValue, foreignKey.LookupsUsed)
-		lookupData, foundLookupData := foreignKey.LookupResults[key]
+		key := lookup.key
 
-		if foundLookupData {
-			lookupDataInterface := lookupData.(map[string]interface{})
+		if lookup.result != nil {
 			lookupKeyColumnName := foreignKey.TableMetadata.GetPrimaryKeyColumnName()
-			returnObject[foreignKey.KeyColumn] = lookupDataInterface[lookupKeyColumnName]
+			returnObject[foreignKey.KeyColumn] = lookup.result[lookupKeyColumnName]
 		} else {
 			// If it's optional we can just keep going, if it's required, throw an error
 			if foreignKey.Required {
@@ -1115,18 +1890,47 @@ func (p PersistenceORM) processObject(
 		}
 	}
 
-	if !isUpdate {
+	if !isUpdate && validateInsert {
 		if err := validator.New().Struct(metadataObject.Interface()); err != nil {
 			return dbchange.Change{}, err
 		}
 	}
 
+	if p.checkValidation {
+		if err := validateChecks(metadataObject, modelMetadata, tableMetadata); err != nil {
+			return dbchange.Change{}, err
+		}
+	}
+
 	return dbchange.Change{
 		Changes:       returnObject,
 		OriginalValue: metadataObject,
 	}, nil
 }
 
+// foreignKeyLookup is a foreign key's reference of a model, resolved through the foreign key's lookups
+type foreignKeyLookup struct {
+	// related is the model's value of the reference
+	related reflect.Value
+	// key is the lookup key of the reference
+	key string
+	// result is the row the lookup found, or nil if it found none
+	result map[string]interface{}
+}
+
+// lookupForeignKey resolves a foreign key of a model through its lookups. keyValue is the model's value for the key
+// column, if it's defined. A model that sets the key itself doesn't need a lookup, unless the key is a key map, so
+// false is returned.
+func lookupForeignKey(value reflect.Value, foreignKey tags.ForeignKey, keyValue interface{}, keyIsDefined bool) (foreignKeyLookup, bool) {
+	if keyIsDefined && !isEmptyKey(keyValue) && foreignKey.KeyMapField == "" {
+		return foreignKeyLookup{}, false
+	}
+	related := relatedValue(value, foreignKey)
+	key := getObjectKeyReflect(related, foreignKey.LookupsUsed)
+	result, _ := foreignKey.LookupResults[key].(map[string]interface{})
+	return foreignKeyLookup{related: related, key: key, result: result}, true
+}
+
 func getObjectKey(objects map[string]interface{}, tableName string, lookups []tags.Lookup, tableAliasCache map[string]string) string {
 	keyValue := []string{}
 	for _, lookup := range lookups {
@@ -1136,52 +1940,50 @@ func getObjectKey(objects map[string]interface{}, tableName string, lookups []ta
 		}
 
 		tableAlias := tableToUse
-		if lookup.JoinKey != "" && lookup.TableName != "" && tableToUse != tableName {
+		if lookup.JoinKey != "" && lookup.TableName != "" {
 			tableAlias = getTableAlias(tableToUse, lookup.JoinKey, tableAliasCache)
 		}
 
-		keyPart := objects[fmt.Sprintf("%v_%v", tableAlias, lookup.MatchDBColumn)]
-		var keyString string
-		if keyPart == nil {
-			keyString = ""
-		} else {
-			keyString = keyPart.(string)
+		keyPart := formatDBKeyValue(objects[lookupColumnAlias(tableAlias, lookup.MatchDBColumn)])
+		if lookup.IsCaseInsensitive() {
+			keyPart = strings.ToLower(keyPart)
 		}
-		keyValue = append(keyValue, keyString)
+		keyValue = append(keyValue, keyPart)
 
 	}
 	return strings.Join(keyValue, separator)
 }
 
 func getObjectKeyReflect(value reflect.Value, lookups []tags.Lookup) string {
-	keyValue := []string{}
+	return strings.Join(getObjectKeyParts(value, lookups), separator)
+}
+
+// getObjectKeyParts returns the model's value of each lookup, formatted the way lookup keys are matched
+func getObjectKeyParts(value reflect.Value, lookups []tags.Lookup) []string {
+	keyParts := []string{}
 	for _, lookup := range lookups {
-		keyValue = append(keyValue, getObjectProperty(value, lookup.MatchObjectProperty))
+		keyPart := getObjectProperty(value, lookup.MatchObjectProperty)
+		// Postgres writes uuids in lower case, so keys must match them that way, as do case insensitive lookups
+		if lookup.IsUUID || lookup.IsCaseInsensitive() {
+			keyPart = strings.ToLower(keyPart)
+		}
+		keyParts = append(keyParts, keyPart)
 	}
-	return strings.Join(keyValue, separator)
+	return keyParts
 }
 
 func getValueFromLookupString(value reflect.Value, lookupString string) reflect.Value {
 	// If the lookupString has a dot in it, recursively look up the property's value
 	propertyKeys := strings.Split(lookupString, ".")
 	if len(propertyKeys) > 1 {
-		subValue := value.FieldByName(propertyKeys[0])
+		subValue := indirectRelated(value.FieldByName(propertyKeys[0]))
 		return getValueFromLookupString(subValue, strings.Join(propertyKeys[1:], "."))
 	}
 	return value.FieldByName(lookupString)
 }
 
 func getObjectProperty(value reflect.Value, lookupString string) string {
-	val := getValueFromLookupString(value, lookupString)
-	valueKind := val.Kind()
-	if valueKind == reflect.String {
-		return val.String()
-	} else if valueKind == reflect.Pointer {
-		v := val.Elem().String()
-		return v
-	} else {
-		return val.String()
-	}
+	return formatKeyValue(getValueFromLookupString(value, lookupString))
 }
 
 func getQueryResults(rows *sql.Rows) ([]map[string]interface{}, error) {
@@ -1,14 +1,22 @@
 package picard
 
 import (
+	"bytes"
+	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/jsonb"
 	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
 	"github.com/skuid/picard/testdata"
 	"github.com/stretchr/testify/assert"
 )
@@ -98,18 +106,33 @@ var personModelWithIDHelper = ExpectationHelper{
 }
 
 func TestSerializeJSONBColumns(t *testing.T) {
+	type serializedModel struct {
+		Metadata  metadata.Metadata             `picard:"tablename=serialized"`
+		ColumnOne testdata.TestSerializedObject `picard:"jsonb,column=column_one"`
+		ColumnTwo string                        `picard:"column=column_two"`
+	}
+	type codecModel struct {
+		Metadata  metadata.Metadata             `picard:"tablename=serialized"`
+		ColumnOne testdata.TestSerializedObject `picard:"jsonb=upper,column=column_one"`
+		ColumnTwo map[string]string             `picard:"jsonb=missing,column=column_two"`
+	}
+	jsonb.RegisterCodec("upper", jsonb.Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			serialized, err := json.Marshal(v)
+			return bytes.ToUpper(serialized), err
+		},
+	})
+
 	testCases := []struct {
 		testDescription string
-		giveColumns     []string
+		giveMetadata    *tags.TableMetadata
 		giveObject      map[string]interface{}
 		wantObject      map[string]interface{}
 		wantErrMsg      string
 	}{
 		{
 			testDescription: "serializes only columns provided into JSON format",
-			giveColumns: []string{
-				"column_one",
-			},
+			giveMetadata:    tags.TableMetadataFromType(reflect.TypeOf(serializedModel{})),
 			giveObject: map[string]interface{}{
 				"column_one": testdata.TestSerializedObject{
 					Name:               "Matt",
@@ -124,11 +147,33 @@ func TestSerializeJSONBColumns(t *testing.T) {
 			},
 			wantErrMsg: "",
 		},
+		{
+			testDescription: "serializes with the codec named in the jsonb tag",
+			giveMetadata:    tags.TableMetadataFromType(reflect.TypeOf(codecModel{})),
+			giveObject: map[string]interface{}{
+				"column_one": testdata.TestSerializedObject{
+					Name:   "Matt",
+					Active: true,
+				},
+			},
+			wantObject: map[string]interface{}{
+				"column_one": []byte(`{"NAME":"MATT","ACTIVE":TRUE}`),
+			},
+			wantErrMsg: "",
+		},
+		{
+			testDescription: "errors for a codec that isn't registered",
+			giveMetadata:    tags.TableMetadataFromType(reflect.TypeOf(codecModel{})),
+			giveObject: map[string]interface{}{
+				"column_two": map[string]string{"key": "value"},
+			},
+			wantErrMsg: "no jsonb codec registered with the name 'missing'",
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.testDescription, func(t *testing.T) {
-			err := serializeJSONBColumns(tc.giveColumns, tc.giveObject)
+			err := serializeJSONBColumns(tc.giveMetadata, tc.giveObject)
 			if tc.wantErrMsg != "" {
 				assert.Error(t, err)
 				assert.EqualError(t, err, tc.wantErrMsg)
@@ -243,6 +288,34 @@ func TestDeployments(t *testing.T) {
 			},
 			"",
 		},
+		{
+			"Single Import without a Batch Size",
+			[]string{"Simple"},
+			testdata.TestObject{},
+			0,
+			func(mock *sqlmock.Sqlmock, fixtures interface{}) {
+				helper := testObjectHelper
+				returnData := GetReturnDataForLookup(helper, nil)
+				lookupKeys := GetLookupKeys(helper, fixtures)
+				ExpectLookup(mock, helper, lookupKeys, returnData)
+				ExpectInsert(mock, helper, helper.GetInsertDBColumns(false), [][]driver.Value{
+					[]driver.Value{
+						sampleOrgID,
+						helper.GetFixtureValue(fixtures, 0, "Name"),
+						nil,
+						helper.GetFixtureValue(fixtures, 0, "Type"),
+						helper.GetFixtureValue(fixtures, 0, "IsActive"),
+						nil,
+						helper.GetFixtureValue(fixtures, 0, "Config"),
+						sampleUserID,
+						sampleUserID,
+						sqlmock.AnyArg(),
+						sqlmock.AnyArg(),
+					},
+				})
+			},
+			"",
+		},
 		{
 			"Single Import with That Already Exists",
 			[]string{"Simple"},
@@ -2093,6 +2166,881 @@ func TestDeployments(t *testing.T) {
 	}
 }
 
+func TestDeployWritesBackPrimaryKeys(t *testing.T) {
+	fixturesAbstract, err := loadTestObjects([]string{"SimpleWithChildren"}, testdata.TestObject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := fixturesAbstract.([]testdata.TestObject)
+
+	var insertRows, childInsertRows [][]driver.Value
+	err = RunImportTest(fixtures, func(mock *sqlmock.Sqlmock, fixturesAbstract interface{}) {
+		ExpectLookup(mock, testObjectHelper, GetLookupKeys(testObjectHelper, fixtures), GetReturnDataForLookup(testObjectHelper, nil))
+		insertRows = ExpectInsert(mock, testObjectHelper, testObjectHelper.GetInsertDBColumns(false), [][]driver.Value{
+			[]driver.Value{
+				sampleOrgID,
+				testObjectHelper.GetFixtureValue(fixtures, 0, "Name"),
+				nil,
+				testObjectHelper.GetFixtureValue(fixtures, 0, "Type"),
+				nil,
+				nil,
+				nil,
+				sampleUserID,
+				sampleUserID,
+				sqlmock.AnyArg(),
+				sqlmock.AnyArg(),
+			},
+		})
+
+		childObjects := []testdata.ChildTestObject{}
+		for _, childObject := range fixtures[0].Children {
+			childObject.ParentID = insertRows[0][0].(string)
+			childObjects = append(childObjects, childObject)
+		}
+
+		ExpectLookup(mock, testChildObjectHelper, GetLookupKeys(testChildObjectHelper, childObjects), GetReturnDataForLookup(testChildObjectHelper, nil))
+		childInsertRows = ExpectInsert(mock, testChildObjectHelper, testChildObjectHelper.GetInsertDBColumns(false), [][]driver.Value{
+			[]driver.Value{
+				sampleOrgID,
+				testChildObjectHelper.GetFixtureValue(childObjects, 0, "Name"),
+				nil,
+				testChildObjectHelper.GetFixtureValue(childObjects, 0, "ParentID"),
+				nil,
+			},
+			[]driver.Value{
+				sampleOrgID,
+				testChildObjectHelper.GetFixtureValue(childObjects, 1, "Name"),
+				nil,
+				testChildObjectHelper.GetFixtureValue(childObjects, 1, "ParentID"),
+				nil,
+			},
+		})
+	}, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, insertRows[0][0], fixtures[0].ID)
+	for index, child := range fixtures[0].Children {
+		assert.Equal(t, childInsertRows[index][0], child.ID)
+		assert.Equal(t, fixtures[0].ID, child.ParentID)
+	}
+}
+
+func TestDeployWithResults(t *testing.T) {
+	helper := testObjectHelper
+	fixturesAbstract, err := loadTestObjects([]string{"Simple", "Simple2"}, testdata.TestObject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := fixturesAbstract.([]testdata.TestObject)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetConnection(db)
+
+	mock.ExpectBegin()
+	returnData := GetReturnDataForLookup(helper, []testdata.TestObject{
+		fixtures[0],
+	})
+	ExpectLookup(&mock, helper, GetLookupKeys(helper, fixtures), returnData)
+	ExpectUpdate(&mock, helper, [][]string{
+		helper.GetUpdateDBColumnsForFixture(fixtures, 0),
+	}, [][]driver.Value{
+		[]driver.Value{
+			helper.GetFixtureValue(fixtures, 0, "Name"),
+			helper.GetFixtureValue(fixtures, 0, "Type"),
+			helper.GetFixtureValue(fixtures, 0, "IsActive"),
+			helper.GetFixtureValue(fixtures, 0, "Config"),
+			sampleUserID,
+			sqlmock.AnyArg(),
+		},
+	}, returnData)
+	insertRows := ExpectInsert(&mock, helper, helper.GetInsertDBColumns(false), [][]driver.Value{
+		[]driver.Value{
+			sampleOrgID,
+			helper.GetFixtureValue(fixtures, 1, "Name"),
+			nil,
+			helper.GetFixtureValue(fixtures, 1, "Type"),
+			nil,
+			nil,
+			nil,
+			sampleUserID,
+			sampleUserID,
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+		},
+	})
+	mock.ExpectCommit()
+
+	p := New(sampleOrgID, sampleUserID)
+	results, err := p.DeployWithResults(fixtures)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []DeployResult{
+		{
+			Key:        "Simple|",
+			PrimaryKey: returnData[0][0],
+			Type:       dbchange.Update,
+		},
+		{
+			Key:        "Simple2|",
+			PrimaryKey: insertRows[0][0],
+			Type:       dbchange.Insert,
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+type castLookupModel struct {
+	metadata.Metadata `picard:"tablename=castlookup"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Email          string `picard:"lookup,column=email,cast=citext"`
+	Status         string `picard:"lookup,column=status,cast=none"`
+}
+
+func TestDeployLookupCastTypes(t *testing.T) {
+	existingID := "00000000-0000-0000-0000-000000000001"
+	returnCols := []string{"id", "castlookup_email", "castlookup_status"}
+
+	t.Run("matches citext lookups regardless of case", func(t *testing.T) {
+		err := RunImportTest([]castLookupModel{
+			{
+				Email:  "Kirk@Enterprise.COM",
+				Status: "active",
+			},
+		}, func(mock *sqlmock.Sqlmock, objects interface{}) {
+			(*mock).ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT castlookup.id, castlookup.email as castlookup_email, castlookup.status as castlookup_status
+				FROM castlookup
+				WHERE ((castlookup.email::citext = $1 AND castlookup.status = $2)) AND castlookup.organization_id = $3
+			`)).
+				WithArgs("kirk@enterprise.com", "active", sampleOrgID).
+				WillReturnRows(sqlmock.NewRows(returnCols).AddRow(existingID, "kirk@ENTERPRISE.com", "active"))
+			(*mock).ExpectExec(`^UPDATE castlookup SET email = \$1, status = \$2 WHERE organization_id = \$3 AND id = \$4$`).
+				WithArgs("Kirk@Enterprise.COM", "active", sampleOrgID, existingID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 100)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("matches an empty uncast lookup to a NULL column", func(t *testing.T) {
+		err := RunImportTest([]castLookupModel{
+			{
+				Metadata: metadata.Metadata{DefinedFields: []string{"Email", "Status"}},
+				Email:    "kirk@enterprise.com",
+			},
+		}, func(mock *sqlmock.Sqlmock, objects interface{}) {
+			(*mock).ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT castlookup.id, castlookup.email as castlookup_email, castlookup.status as castlookup_status
+				FROM castlookup
+				WHERE ((castlookup.email::citext = $1 AND castlookup.status IS NULL)) AND castlookup.organization_id = $2
+			`)).
+				WithArgs("kirk@enterprise.com", sampleOrgID).
+				WillReturnRows(sqlmock.NewRows(returnCols).AddRow(existingID, "kirk@enterprise.com", nil))
+			(*mock).ExpectExec(`^UPDATE castlookup SET email = \$1, status = \$2 WHERE organization_id = \$3 AND id = \$4$`).
+				WithArgs("kirk@enterprise.com", "", sampleOrgID, existingID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 100)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("casts to a type name of several words without quoting it", func(t *testing.T) {
+		type varyingLookupModel struct {
+			metadata.Metadata `picard:"tablename=varyinglookup"`
+
+			ID             string `picard:"primary_key,column=id"`
+			OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+			Code           string `picard:"lookup,column=code,cast=character varying(10)"`
+		}
+
+		err := RunImportTest([]varyingLookupModel{
+			{Code: "a1"},
+		}, func(mock *sqlmock.Sqlmock, objects interface{}) {
+			ExpectLookup(mock, ExpectationHelper{
+				FixtureType:      varyingLookupModel{},
+				LookupSelect:     "varyinglookup.id, varyinglookup.code as varyinglookup_code",
+				LookupWhere:      `COALESCE(varyinglookup.code::character varying(10)::text,'')`,
+				LookupReturnCols: []string{"id", "varyinglookup_code"},
+			}, []string{"a1"}, [][]driver.Value{
+				{existingID, "a1"},
+			})
+			(*mock).ExpectExec(`^UPDATE varyinglookup SET code = \$1 WHERE organization_id = \$2 AND id = \$3$`).
+				WithArgs("a1", sampleOrgID, existingID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 100)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejects a cast that isn't a type name", func(t *testing.T) {
+		type badCastLookupModel struct {
+			metadata.Metadata `picard:"tablename=badcastlookup"`
+
+			ID             string `picard:"primary_key,column=id"`
+			OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+			Code           string `picard:"lookup,column=code,cast=text); DROP TABLE x; --"`
+		}
+
+		err := RunImportTest([]badCastLookupModel{
+			{Code: "a1"},
+		}, func(mock *sqlmock.Sqlmock, objects interface{}) {}, 100)
+
+		assert.EqualError(t, err, "invalid cast type 'text); DROP TABLE x; --' for lookup column 'code'")
+	})
+}
+
+type deviceModel struct {
+	metadata.Metadata `picard:"tablename=device"`
+
+	ID             string    `picard:"primary_key,column=id"`
+	OrganizationID string    `picard:"multitenancy_key,column=organization_id"`
+	Serial         uuid.UUID `picard:"lookup,column=serial"`
+	Name           string    `picard:"column=name"`
+}
+
+type badgeModel struct {
+	metadata.Metadata `picard:"tablename=badge"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	HolderID       string `picard:"lookup,uuid,column=holder_id"`
+	Kind           string `picard:"lookup,column=kind"`
+}
+
+func TestDeployUUIDLookups(t *testing.T) {
+	serial := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	existingID := "00000000-0000-0000-0000-000000000001"
+
+	t.Run("compares a lone uuid lookup without casting it", func(t *testing.T) {
+		deviceHelper := ExpectationHelper{
+			FixtureType:      deviceModel{},
+			LookupSelect:     "device.id, device.serial as device_serial",
+			LookupWhere:      "device.serial",
+			LookupReturnCols: []string{"id", "device_serial"},
+		}
+
+		err := RunImportTest([]deviceModel{
+			{
+				Serial: serial,
+				Name:   "scanner",
+			},
+		}, func(mock *sqlmock.Sqlmock, objects interface{}) {
+			ExpectLookup(mock, deviceHelper, []string{serial.String()}, [][]driver.Value{
+				{existingID, []byte(serial.String())},
+			})
+			(*mock).ExpectExec(`^UPDATE device SET serial = \$1, name = \$2 WHERE organization_id = \$3 AND id = \$4$`).
+				WithArgs(serial, "scanner", sampleOrgID, existingID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 100)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("matches uuid keys in lower case with other lookups", func(t *testing.T) {
+		badgeHelper := ExpectationHelper{
+			FixtureType:      badgeModel{},
+			LookupSelect:     "badge.id, badge.holder_id as badge_holder_id, badge.kind as badge_kind",
+			LookupWhere:      `COALESCE(badge.holder_id::"varchar",'') || '|' || COALESCE(badge.kind::"varchar",'')`,
+			LookupReturnCols: []string{"id", "badge_holder_id", "badge_kind"},
+		}
+
+		err := RunImportTest([]badgeModel{
+			{
+				HolderID: "6BA7B810-9DAD-11D1-80B4-00C04FD430C8",
+				Kind:     "visitor",
+			},
+		}, func(mock *sqlmock.Sqlmock, objects interface{}) {
+			ExpectLookup(mock, badgeHelper, []string{serial.String() + "|visitor"}, [][]driver.Value{
+				{existingID, serial.String(), "visitor"},
+			})
+			(*mock).ExpectExec(`^UPDATE badge SET holder_id = \$1, kind = \$2 WHERE organization_id = \$3 AND id = \$4$`).
+				WithArgs("6BA7B810-9DAD-11D1-80B4-00C04FD430C8", "visitor", sampleOrgID, existingID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 100)
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestDeploySkipUnresolvable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetConnection(db)
+
+	parentUUID := uuid.NewV4().String()
+	optionalParentUUID := uuid.NewV4().String()
+	children := []testdata.ChildTestObject{
+		{
+			Name:           "ChildItem",
+			Parent:         testdata.TestObject{Name: "Simple"},
+			OptionalParent: testdata.TestObject{Name: "Simple2"},
+		},
+		{
+			Name:           "ChildItem2",
+			Parent:         testdata.TestObject{Name: "Simple"},
+			OptionalParent: testdata.TestObject{Name: "Missing"},
+		},
+	}
+
+	mock.ExpectBegin()
+	ExpectLookup(&mock, testChildObjectWithLookupHelper, []string{"ChildItem", "ChildItem2"}, [][]driver.Value{})
+	ExpectLookup(&mock, testObjectHelper, []string{"Simple|"}, [][]driver.Value{
+		{parentUUID, "Simple", ""},
+	})
+	ExpectLookup(&mock, testObjectHelper, []string{"Simple2|", "Missing|"}, [][]driver.Value{
+		{optionalParentUUID, "Simple2", ""},
+	})
+	ExpectInsert(&mock, testChildObjectWithLookupHelper, testChildObjectWithLookupHelper.GetInsertDBColumns(false), [][]driver.Value{
+		{sampleOrgID, "ChildItem", "", parentUUID, optionalParentUUID},
+	})
+	mock.ExpectCommit()
+
+	skipped := []SkippedRecord{}
+	p := New(sampleOrgID, sampleUserID).WithSkipUnresolvable(func(record SkippedRecord) {
+		skipped = append(skipped, record)
+	})
+
+	err = p.Deploy(children)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []SkippedRecord{
+		{
+			Model: children[1],
+			Reason: NewForeignKeyError(
+				"Unresolvable Optional Foreign Key Lookup",
+				"childtest",
+				"Missing|",
+				"optional_parent_id",
+				"OptionalParent",
+			),
+		},
+	}, skipped)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+type crewModel struct {
+	metadata.Metadata `picard:"tablename=crew"`
+
+	ID             string            `picard:"primary_key,column=id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Name           string            `picard:"lookup,column=name"`
+	Members        []crewMemberModel `picard:"child,foreign_key=CrewID,delete_orphans"`
+}
+
+type crewRefModel struct {
+	metadata.Metadata `picard:"tablename=crew"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"lookup,column=name"`
+}
+
+type shipModel struct {
+	metadata.Metadata `picard:"tablename=ship"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"lookup,column=name"`
+}
+
+type crewMemberModel struct {
+	metadata.Metadata `picard:"tablename=crew_member"`
+
+	ID             string       `picard:"primary_key,column=id"`
+	OrganizationID string       `picard:"multitenancy_key,column=organization_id"`
+	Name           string       `picard:"lookup,column=name"`
+	CrewID         string       `picard:"foreign_key,lookup,required,related=Crew,column=crew_id"`
+	Crew           crewRefModel `validate:"-"`
+	ShipID         string       `picard:"foreign_key,related=Ship,column=ship_id"`
+	Ship           shipModel    `validate:"-"`
+
+	saved *[]string
+}
+
+func (m *crewMemberModel) BeforeSave() error {
+	*m.saved = append(*m.saved, m.Name)
+	return nil
+}
+
+func TestDeploySkipUnresolvableKeepsOrphans(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetConnection(db)
+
+	crewID := "00000000-0000-0000-0000-000000000001"
+	keptID := "00000000-0000-0000-0000-000000000002"
+	orphanID := "00000000-0000-0000-0000-000000000003"
+	saved := []string{}
+	crews := []crewModel{
+		{
+			Name: "Bridge",
+			Members: []crewMemberModel{
+				{Name: "Kirk", saved: &saved},
+				{Name: "Sulu", Ship: shipModel{Name: "Missing"}, saved: &saved},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT crew.id, crew.name as crew_name
+		FROM crew
+		WHERE COALESCE(crew.name::"varchar",'') = ANY($1) AND crew.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"Bridge"}), sampleOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "crew_name"}).AddRow(crewID, "Bridge"))
+	mock.ExpectExec(`^UPDATE crew SET name = \$1 WHERE organization_id = \$2 AND id = \$3$`).
+		WithArgs("Bridge", sampleOrgID, crewID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT crew_member.id, crew_member.name as crew_member_name, crew_member.crew_id as crew_member_crew_id
+		FROM crew_member
+		WHERE COALESCE(crew_member.name::"varchar",'') || '|' || COALESCE(crew_member.crew_id::"varchar",'') = ANY($1) AND crew_member.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"Kirk|" + crewID, "Sulu|" + crewID}), sampleOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "crew_member_name", "crew_member_crew_id"}).AddRow(keptID, "Sulu", crewID))
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT ship.id, ship.name as ship_name
+		FROM ship
+		WHERE COALESCE(ship.name::"varchar",'') = ANY($1) AND ship.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"Missing"}), sampleOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ship_name"}))
+	mock.ExpectQuery(`^INSERT INTO crew_member \(organization_id,name,crew_id,ship_id\) VALUES \(\$1,\$2,\$3,\$4\) RETURNING "id"$`).
+		WithArgs(sampleOrgID, "Kirk", crewID, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("00000000-0000-0000-0000-000000000004"))
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name",
+			t0.crew_id AS "t0.crew_id",
+			t0.ship_id AS "t0.ship_id",
+			t1.id AS "t1.id",
+			t1.organization_id AS "t1.organization_id",
+			t1.name AS "t1.name",
+			t2.id AS "t2.id",
+			t2.organization_id AS "t2.organization_id",
+			t2.name AS "t2.name"
+		FROM crew_member AS t0
+		LEFT JOIN crew AS t1 ON (t1.id = t0.crew_id AND t1.organization_id = $1)
+		LEFT JOIN ship AS t2 ON (t2.id = t0.ship_id AND t2.organization_id = $2)
+		WHERE t0.organization_id = $3 AND ((t0.crew_id = $4))
+	`)).
+		WithArgs(sampleOrgID, sampleOrgID, sampleOrgID, crewID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.name", "t0.crew_id"}).
+				AddRow(keptID, "Sulu", crewID).
+				AddRow(orphanID, "Chekov", crewID),
+		)
+	// The skipped member's row isn't an orphan
+	mock.ExpectExec(`^DELETE FROM crew_member WHERE id IN \(\$1\) AND organization_id = \$2$`).
+		WithArgs(orphanID, sampleOrgID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	skipped := []SkippedRecord{}
+	p := New(sampleOrgID, sampleUserID).WithSkipUnresolvable(func(record SkippedRecord) {
+		skipped = append(skipped, record)
+	})
+
+	err = p.Deploy(crews)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Kirk"}, saved, "the skipped member shouldn't have been saved")
+	assert.Equal(t, []SkippedRecord{
+		{
+			Model: crews[0].Members[1],
+			Reason: NewForeignKeyError(
+				"Unresolvable Optional Foreign Key Lookup",
+				"crew_member",
+				"Missing",
+				"ship_id",
+				"Ship",
+			),
+		},
+	}, skipped)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+type duplicateItem struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField        string `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string `picard:"column=test_column_one"`
+	TestFieldTwo           string `picard:"column=test_column_two"`
+}
+
+func TestDeployDuplicatePrimaryKeys(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	firstID := "00000000-0000-0000-0000-000000000001"
+	secondID := "00000000-0000-0000-0000-000000000002"
+	lookupSQL := testdata.FmtSQLRegex(`
+		SELECT test_tablename.primary_key_column, test_tablename.primary_key_column as test_tablename_primary_key_column
+		FROM test_tablename
+		WHERE COALESCE(test_tablename.primary_key_column::"varchar",'') = ANY($1) AND test_tablename.multitenancy_key_column = $2
+	`)
+
+	testCases := []struct {
+		description         string
+		givePolicy          DuplicatePrimaryKeyPolicy
+		expectationFunction func(sqlmock.Sqlmock)
+		wantErr             string
+	}{
+		{
+			"deploys every duplicate by default",
+			DuplicatePrimaryKeyAllow,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookupSQL).
+					WithArgs(pq.Array([]string{firstID, secondID}), orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column", "test_tablename_primary_key_column"}).
+							AddRow(firstID, firstID).
+							AddRow(secondID, secondID),
+					)
+				for _, args := range [][]driver.Value{
+					{"ice", "sleet", orgID, firstID},
+					{"rain", "", orgID, secondID},
+					{"snow", "", orgID, firstID},
+				} {
+					mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2 WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+						WithArgs(args...).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"fails the deployment",
+			DuplicatePrimaryKeyError,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			"duplicate primary key '00000000-0000-0000-0000-000000000001' in deployment to table 'test_tablename'",
+		},
+		{
+			"merges the fields set on each duplicate",
+			DuplicatePrimaryKeyMerge,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookupSQL).
+					WithArgs(pq.Array([]string{firstID, secondID}), orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column", "test_tablename_primary_key_column"}).
+							AddRow(firstID, firstID).
+							AddRow(secondID, secondID),
+					)
+				mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2 WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+					WithArgs("snow", "sleet", orgID, firstID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2 WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+					WithArgs("rain", "", orgID, secondID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"deploys only the last duplicate",
+			DuplicatePrimaryKeyLastWins,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lookupSQL).
+					WithArgs(pq.Array([]string{secondID, firstID}), orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column", "test_tablename_primary_key_column"}).
+							AddRow(firstID, firstID).
+							AddRow(secondID, secondID),
+					)
+				mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2 WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+					WithArgs("rain", "", orgID, secondID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2 WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+					WithArgs("snow", "", orgID, firstID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			err = New(orgID, sampleUserID).WithDuplicatePrimaryKeyPolicy(tc.givePolicy).Deploy([]duplicateItem{
+				{PrimaryKeyField: firstID, TestFieldOne: "ice", TestFieldTwo: "sleet"},
+				{PrimaryKeyField: secondID, TestFieldOne: "rain"},
+				{PrimaryKeyField: firstID, TestFieldOne: "snow"},
+			})
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestResolveDuplicateCompositePrimaryKeys(t *testing.T) {
+	tableMetadata := tags.TableMetadataFromType(reflect.TypeOf(membershipModel{}))
+	p := PersistenceORM{duplicatePolicy: DuplicatePrimaryKeyError}
+
+	// Models that share only part of a composite key aren't duplicates
+	models := []membershipModel{
+		{GroupID: "group1", UserID: "user1"},
+		{GroupID: "group1", UserID: "user2"},
+		{GroupID: "group1"},
+		{GroupID: "group1"},
+	}
+	resolved, _, err := p.resolveDuplicatePrimaryKeys(models, tableMetadata)
+	assert.NoError(t, err)
+	assert.Equal(t, models, resolved)
+
+	_, _, err = p.resolveDuplicatePrimaryKeys(append(models, membershipModel{GroupID: "group1", UserID: "user2"}), tableMetadata)
+	assert.EqualError(t, err, "duplicate primary key 'group1|user2' in deployment to table 'membership'")
+}
+
+func TestDeployDuplicatePrimaryKeysWriteBack(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	firstID := "00000000-0000-0000-0000-000000000001"
+	secondID := "00000000-0000-0000-0000-000000000002"
+	modifiedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expectUpdate := func(mock sqlmock.Sqlmock, value string, id string, version int) {
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			UPDATE test_tablename SET test_column_one = $1
+			WHERE multitenancy_key_column = $2 AND primary_key_column = $3
+			RETURNING "version", "modified_at"
+		`)).
+			WithArgs(value, orgID, id).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "modified_at"}).AddRow(version, modifiedAt))
+	}
+
+	testCases := []struct {
+		description         string
+		givePolicy          DuplicatePrimaryKeyPolicy
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"writes the merged model's returned values back to each duplicate",
+			DuplicatePrimaryKeyMerge,
+			func(mock sqlmock.Sqlmock) {
+				expectUpdate(mock, "snow", firstID, 7)
+				expectUpdate(mock, "rain", secondID, 4)
+			},
+		},
+		{
+			"writes the last duplicate's returned values back to each duplicate",
+			DuplicatePrimaryKeyLastWins,
+			func(mock sqlmock.Sqlmock) {
+				expectUpdate(mock, "rain", secondID, 4)
+				expectUpdate(mock, "snow", firstID, 7)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`^SELECT test_tablename.primary_key_column`).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"primary_key_column", "test_tablename_primary_key_column"}).
+						AddRow(firstID, firstID).
+						AddRow(secondID, secondID),
+				)
+			tc.expectationFunction(mock)
+			mock.ExpectCommit()
+
+			models := []returningModel{
+				{PrimaryKeyField: firstID, TestFieldOne: "ice"},
+				{PrimaryKeyField: secondID, TestFieldOne: "rain"},
+				{PrimaryKeyField: firstID, TestFieldOne: "snow"},
+			}
+			err = New(orgID, sampleUserID).WithDuplicatePrimaryKeyPolicy(tc.givePolicy).Deploy(models)
+
+			assert.NoError(t, err)
+			assert.Equal(t, []returningModel{
+				{PrimaryKeyField: firstID, TestFieldOne: "ice", Version: 7, ModifiedAt: modifiedAt},
+				{PrimaryKeyField: secondID, TestFieldOne: "rain", Version: 4, ModifiedAt: modifiedAt},
+				{PrimaryKeyField: firstID, TestFieldOne: "snow", Version: 7, ModifiedAt: modifiedAt},
+			}, models)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type flaggedItem struct {
+	Metadata metadata.Metadata `picard:"tablename=flagged_item"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"column=name"`
+	Removed        bool   `picard:"delete_flag"`
+}
+
+func TestDeployDeleteFlag(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	keptID := "00000000-0000-0000-0000-000000000001"
+	removedID := "00000000-0000-0000-0000-000000000002"
+	missingID := "00000000-0000-0000-0000-000000000003"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT flagged_item.id, flagged_item.id as flagged_item_id
+		FROM flagged_item
+		WHERE COALESCE(flagged_item.id::"varchar",'') = ANY($1) AND flagged_item.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{keptID, removedID, missingID}), orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "flagged_item_id"}).
+				AddRow(keptID, keptID).
+				AddRow(removedID, removedID),
+		)
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		DELETE FROM flagged_item WHERE id IN ($1) AND organization_id = $2
+	`)).
+		WithArgs(removedID, orgID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		UPDATE flagged_item SET name = $1 WHERE organization_id = $2 AND id = $3
+	`)).
+		WithArgs("kept", orgID, keptID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	results, err := New(orgID, sampleUserID).DeployWithResults([]flaggedItem{
+		{ID: keptID, Name: "kept"},
+		{ID: removedID, Removed: true},
+		{ID: missingID, Removed: true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []DeployResult{
+		{Key: keptID, PrimaryKey: keptID, Type: dbchange.Update},
+		{Key: removedID, PrimaryKey: removedID, Type: dbchange.Delete},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestWithBatchSize(t *testing.T) {
+	testCases := []struct {
+		description         string
+		giveBatchSize       int
+		expectationFunction func(sqlmock.Sqlmock)
+		wantErr             string
+	}{
+		{
+			"deploys one batch per record with a batch size of 1",
+			1,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column"$`).
+					WithArgs("00000000-0000-0000-0000-000000000005", "ice").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow("00000000-0000-0000-0000-000000000001"),
+					)
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column"$`).
+					WithArgs("00000000-0000-0000-0000-000000000005", "snow").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow("00000000-0000-0000-0000-000000000002"),
+					)
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"rejects a batch size of zero",
+			0,
+			func(mock sqlmock.Sqlmock) {},
+			"batch size must be positive, got 0",
+		},
+		{
+			"rejects a negative batch size",
+			-5,
+			func(mock sqlmock.Sqlmock) {},
+			"batch size must be positive, got -5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			orm, err := New("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000002").WithBatchSize(tc.giveBatchSize)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				assert.Nil(t, orm)
+			} else {
+				assert.NoError(t, err)
+				err = orm.Deploy([]Item{
+					{TestFieldOne: "ice"},
+					{TestFieldOne: "snow"},
+				})
+				assert.NoError(t, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
 type Item struct {
 	metadata.Metadata `picard:"tablename=test_tablename"`
 
@@ -2377,3 +3325,282 @@ func TestStartTransaction(t *testing.T) {
 		})
 	}
 }
+
+func TestStartSnapshotTransaction(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	itemSQL := testdata.FmtSQLRegex(`
+		SELECT
+			t0.primary_key_column AS "t0.primary_key_column",
+			t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+			t0.test_column_one AS "t0.test_column_one"
+		FROM test_tablename AS t0
+		WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2
+	`)
+
+	t.Run("reads share one repeatable read transaction", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin()
+		for _, name := range []string{"kayak", "canoe"} {
+			mock.ExpectQuery(itemSQL).
+				WithArgs(orgID, name).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one"}).
+						AddRow("00000000-0000-0000-0000-000000000001", orgID, name),
+				)
+		}
+		mock.ExpectCommit()
+
+		p := New(orgID, "00000000-0000-0000-0000-000000000002")
+		tx, err := p.StartSnapshotTransaction()
+		assert.NoError(t, err)
+
+		for _, name := range []string{"kayak", "canoe"} {
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel: Item{TestFieldOne: name},
+				Runner:      tx,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, results, 1)
+		}
+		assert.NoError(t, p.Commit())
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+
+	t.Run("returns begin errors", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin().WillReturnError(errors.New("some error"))
+
+		p := New(orgID, "00000000-0000-0000-0000-000000000002")
+		tx, err := p.StartSnapshotTransaction()
+		assert.EqualError(t, err, "some error")
+		assert.Nil(t, tx)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+
+	t.Run("errors when a transaction has already been started", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin()
+
+		p := New(orgID, "00000000-0000-0000-0000-000000000002")
+		_, err = p.StartTransaction()
+		assert.NoError(t, err)
+		tx, err := p.StartSnapshotTransaction()
+		assert.EqualError(t, err, "a transaction has already been started")
+		assert.Nil(t, tx)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+}
+
+func TestStartTransactionWithOptions(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+
+	t.Run("begins a transaction that later calls use", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`^REFRESH MATERIALIZED VIEW test_view$`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		p := New(orgID, "00000000-0000-0000-0000-000000000002")
+		tx, err := p.StartTransactionWithOptions(sql.TxOptions{Isolation: sql.LevelSerializable})
+		assert.NoError(t, err)
+		assert.NotNil(t, tx)
+		assert.NoError(t, p.RefreshMaterializedView(viewModel{}, false))
+		assert.NoError(t, p.Commit())
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+
+	t.Run("returns begin errors", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin().WillReturnError(errors.New("some error"))
+
+		p := New(orgID, "00000000-0000-0000-0000-000000000002")
+		tx, err := p.StartTransactionWithOptions(sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		assert.EqualError(t, err, "some error")
+		assert.Nil(t, tx)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+
+	t.Run("errors when a transaction has already been started", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+
+		mock.ExpectBegin()
+
+		p := New(orgID, "00000000-0000-0000-0000-000000000002")
+		_, err = p.StartTransaction()
+		assert.NoError(t, err)
+		tx, err := p.StartTransactionWithOptions(sql.TxOptions{Isolation: sql.LevelSerializable})
+		assert.EqualError(t, err, "a transaction has already been started")
+		assert.Nil(t, tx)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+}
+
+func TestAfterCommit(t *testing.T) {
+	testCases := []struct {
+		description         string
+		endTransaction      func(ORM) error
+		expectationFunction func(sqlmock.Sqlmock)
+		wantCalls           []string
+		wantErr             string
+	}{
+		{
+			"runs callbacks in order after a commit",
+			func(orm ORM) error {
+				return orm.Commit()
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectCommit()
+			},
+			[]string{"first", "second"},
+			"",
+		},
+		{
+			"discards callbacks on a rollback",
+			func(orm ORM) error {
+				return orm.Rollback()
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			[]string{},
+			"",
+		},
+		{
+			"discards callbacks when the commit fails",
+			func(orm ORM) error {
+				return orm.Commit()
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectCommit().WillReturnError(errors.New("commit failed"))
+			},
+			[]string{},
+			"commit failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			calls := []string{}
+			orm := New("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000002")
+			_, err = orm.StartTransaction()
+			assert.NoError(t, err)
+			orm.AfterCommit(func() { calls = append(calls, "first") })
+			orm.AfterCommit(func() { calls = append(calls, "second") })
+			assert.Equal(t, []string{}, calls)
+
+			err = tc.endTransaction(orm)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantCalls, calls)
+
+			// Callbacks don't carry over to the next transaction
+			calls = []string{}
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+			_, err = orm.StartTransaction()
+			assert.NoError(t, err)
+			assert.NoError(t, orm.Commit())
+			assert.Equal(t, []string{}, calls)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+
+	t.Run("shares callbacks with copies of the ORM made in the transaction", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = db
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		calls := []string{}
+		orm := New("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000002")
+		_, err = orm.StartTransaction()
+		assert.NoError(t, err)
+		first := orm.WithNativeUpsert()
+		second := orm.WithCheckValidation()
+		first.AfterCommit(func() { calls = append(calls, "first") })
+		second.AfterCommit(func() { calls = append(calls, "second") })
+		orm.AfterCommit(func() { calls = append(calls, "third") })
+
+		assert.NoError(t, first.Commit())
+		assert.Equal(t, []string{"first", "second", "third"}, calls)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unmet sqlmock expectations: %s", err)
+		}
+	})
+
+	t.Run("runs the callback immediately without a transaction", func(t *testing.T) {
+		called := false
+		orm := New("00000000-0000-0000-0000-000000000005", "00000000-0000-0000-0000-000000000002")
+		orm.AfterCommit(func() { called = true })
+		assert.True(t, called)
+	})
+}
@@ -5,30 +5,102 @@ import (
 	"database/sql"
 	"errors"
 	"reflect"
+	"time"
 
 	"github.com/skuid/picard"
+	"github.com/skuid/picard/dbchange"
 )
 
 // MockORM can be used to test client functionality that calls picard.ORM behavior.
 type MockORM struct {
-	FilterModelReturns       []interface{}
-	FilterModelError         error
-	FilterModelCalledWith    picard.FilterRequest
-	SaveModelError           error
-	SaveModelCalledWith      interface{}
-	CreateModelError         error
-	CreateModelCalledWith    interface{}
-	DeployError              error
-	DeployCalledWith         interface{}
-	DeployMultipleError      error
-	DeployMultipleCalledWith []interface{}
-	DeleteModelRowsAffected  int64
-	DeleteModelError         error
-	DeleteModelCalledWith    interface{}
-	StartTransactionReturns  *sql.Tx
-	StartTransactionError    error
-	CommitError              error
-	RollbackError            error
+	FilterModelReturns                    []interface{}
+	FilterModelError                      error
+	FilterModelCalledWith                 picard.FilterRequest
+	FilterModelOneReturns                 interface{}
+	FilterModelOneError                   error
+	FilterModelOneCalledWith              picard.FilterRequest
+	SaveModelError                        error
+	SaveModelCalledWith                   interface{}
+	SaveModelRowsAffected                 int64
+	CreateModelError                      error
+	CreateModelCalledWith                 interface{}
+	DeployError                           error
+	DeployCalledWith                      interface{}
+	DeployWithResultsReturns              []picard.DeployResult
+	DeployWithResultsError                error
+	DeployWithResultsCalledWith           interface{}
+	DryRunReturns                         []*dbchange.ChangeSet
+	DryRunError                           error
+	DryRunCalledWith                      interface{}
+	ValidateDeployReturns                 []error
+	ValidateDeployError                   error
+	ValidateDeployCalledWith              interface{}
+	DeployMultipleError                   error
+	DeployMultipleCalledWith              []interface{}
+	RefreshMaterializedViewError          error
+	RefreshMaterializedViewCalledWith     interface{}
+	TruncateModelError                    error
+	TruncateModelCalledWith               interface{}
+	ReencryptModelRowCount                int64
+	ReencryptModelError                   error
+	ReencryptModelCalledWith              interface{}
+	DeleteModelRowsAffected               int64
+	DeleteModelError                      error
+	DeleteModelCalledWith                 interface{}
+	DeleteModelsRowsAffected              int64
+	DeleteModelsError                     error
+	DeleteModelsCalledWith                picard.FilterRequest
+	RestoreModelError                     error
+	RestoreModelCalledWith                interface{}
+	RestoreModelsRowsAffected             int64
+	RestoreModelsError                    error
+	RestoreModelsCalledWith               picard.FilterRequest
+	StartTransactionReturns               *sql.Tx
+	StartTransactionError                 error
+	StartSnapshotTransactionReturns       *sql.Tx
+	StartSnapshotTransactionError         error
+	StartTransactionWithOptionsReturns    *sql.Tx
+	StartTransactionWithOptionsError      error
+	StartTransactionWithOptionsCalledWith sql.TxOptions
+	CommitError                           error
+	RollbackError                         error
+	ChangeListener                        picard.ChangeListener
+	BatchSize                             int
+	FilterModelPaginatedReturns           *picard.Page
+	FilterModelPaginatedError             error
+	FilterModelPaginatedCalledWith        picard.FilterRequest
+	FilterModelSQLReturns                 string
+	FilterModelSQLArgs                    []interface{}
+	FilterModelSQLError                   error
+	FilterModelSQLCalledWith              picard.FilterRequest
+	FilterModelStreamReturns              *picard.ResultIterator
+	FilterModelStreamError                error
+	FilterModelStreamCalledWith           picard.FilterRequest
+	FilterModelFlatReturns                *picard.FlatResults
+	FilterModelFlatError                  error
+	FilterModelFlatCalledWith             picard.FilterRequest
+	AggregateModelReturns                 []map[string]interface{}
+	AggregateModelError                   error
+	AggregateModelCalledWith              picard.AggregateRequest
+	WithBatchSizeError                    error
+	SkipListener                          picard.SkipListener
+	DuplicatePrimaryKeyPolicy             picard.DuplicatePrimaryKeyPolicy
+	AfterCommitCallbacks                  []func()
+	RunInTransactionError                 error
+	RunInTransactionCalledWith            picard.TxOptions
+	DeleteChunkSize                       int
+	SeparateDeleteTransactions            bool
+	WithChunkedDeletesError               error
+	CopyThreshold                         int
+	WithCopyInsertsError                  error
+	StatementCache                        *picard.StatementCache
+	PerRowKeyMatching                     bool
+	MultitenancyMatch                     picard.MultitenancyMatch
+	ReplicaMaxLag                         time.Duration
+	ReadConsistency                       picard.ReadConsistency
+	TruncateOptions                       picard.TruncateOptions
+	CheckValidation                       bool
+	NativeUpsert                          bool
 }
 
 // FilterModel simply returns an error or return objects when set on the MockORM
@@ -40,12 +112,72 @@ func (morm *MockORM) FilterModel(request picard.FilterRequest) ([]interface{}, e
 	return morm.FilterModelReturns, nil
 }
 
+// FilterModelOne returns the model & error stored in MockORM, and records the call value
+func (morm *MockORM) FilterModelOne(request picard.FilterRequest) (interface{}, error) {
+	morm.FilterModelOneCalledWith = request
+	if morm.FilterModelOneError != nil {
+		return nil, morm.FilterModelOneError
+	}
+	return morm.FilterModelOneReturns, nil
+}
+
+// FilterModelPaginated returns the page & error stored in MockORM, and records the call value
+func (morm *MockORM) FilterModelPaginated(request picard.FilterRequest) (*picard.Page, error) {
+	morm.FilterModelPaginatedCalledWith = request
+	if morm.FilterModelPaginatedError != nil {
+		return nil, morm.FilterModelPaginatedError
+	}
+	return morm.FilterModelPaginatedReturns, nil
+}
+
+// FilterModelSQL returns the SQL, arguments & error stored in MockORM, and records the call value
+func (morm *MockORM) FilterModelSQL(request picard.FilterRequest) (string, []interface{}, error) {
+	morm.FilterModelSQLCalledWith = request
+	if morm.FilterModelSQLError != nil {
+		return "", nil, morm.FilterModelSQLError
+	}
+	return morm.FilterModelSQLReturns, morm.FilterModelSQLArgs, nil
+}
+
+// FilterModelStream returns the iterator & error stored in MockORM, and records the call value
+func (morm *MockORM) FilterModelStream(request picard.FilterRequest) (*picard.ResultIterator, error) {
+	morm.FilterModelStreamCalledWith = request
+	if morm.FilterModelStreamError != nil {
+		return nil, morm.FilterModelStreamError
+	}
+	return morm.FilterModelStreamReturns, nil
+}
+
+// FilterModelFlat returns the flat results & error stored in MockORM, and records the call value
+func (morm *MockORM) FilterModelFlat(request picard.FilterRequest) (*picard.FlatResults, error) {
+	morm.FilterModelFlatCalledWith = request
+	if morm.FilterModelFlatError != nil {
+		return nil, morm.FilterModelFlatError
+	}
+	return morm.FilterModelFlatReturns, nil
+}
+
+// AggregateModel returns the results & error stored in MockORM, and records the call value
+func (morm *MockORM) AggregateModel(request picard.AggregateRequest) ([]map[string]interface{}, error) {
+	morm.AggregateModelCalledWith = request
+	if morm.AggregateModelError != nil {
+		return nil, morm.AggregateModelError
+	}
+	return morm.AggregateModelReturns, nil
+}
+
 // SaveModel returns the error stored in MockORM, and records the call value
 func (morm *MockORM) SaveModel(model interface{}) error {
 	morm.SaveModelCalledWith = model
 	return morm.SaveModelError
 }
 
+// SaveModelWithRowsAffected returns the rows affected number & error stored in MockORM, and records the call value
+func (morm *MockORM) SaveModelWithRowsAffected(model interface{}) (int64, error) {
+	morm.SaveModelCalledWith = model
+	return morm.SaveModelRowsAffected, morm.SaveModelError
+}
+
 // CreateModel returns the error stored in MockORM, and records the call value
 func (morm *MockORM) CreateModel(model interface{}) error {
 	morm.CreateModelCalledWith = model
@@ -58,18 +190,81 @@ func (morm *MockORM) DeleteModel(data interface{}) (int64, error) {
 	return morm.DeleteModelRowsAffected, morm.DeleteModelError
 }
 
+// DeleteModels returns the rows affected number & error stored in MockORM, and records the call value
+func (morm *MockORM) DeleteModels(request picard.FilterRequest) (int64, error) {
+	morm.DeleteModelsCalledWith = request
+	return morm.DeleteModelsRowsAffected, morm.DeleteModelsError
+}
+
+// RestoreModel returns the error stored in MockORM, and records the call value
+func (morm *MockORM) RestoreModel(model interface{}) error {
+	morm.RestoreModelCalledWith = model
+	return morm.RestoreModelError
+}
+
+// RestoreModels returns the rows affected number & error stored in MockORM, and records the call value
+func (morm *MockORM) RestoreModels(request picard.FilterRequest) (int64, error) {
+	morm.RestoreModelsCalledWith = request
+	return morm.RestoreModelsRowsAffected, morm.RestoreModelsError
+}
+
 // Deploy returns the error stored in MockORM, and records the call value
 func (morm *MockORM) Deploy(data interface{}) error {
 	morm.DeployCalledWith = data
 	return morm.DeployError
 }
 
+// DeployWithResults returns the results & error stored in MockORM, and records the call value
+func (morm *MockORM) DeployWithResults(data interface{}) ([]picard.DeployResult, error) {
+	morm.DeployWithResultsCalledWith = data
+	if morm.DeployWithResultsError != nil {
+		return nil, morm.DeployWithResultsError
+	}
+	return morm.DeployWithResultsReturns, nil
+}
+
+// DryRun returns the change sets & error stored in MockORM, and records the call value
+func (morm *MockORM) DryRun(data interface{}) ([]*dbchange.ChangeSet, error) {
+	morm.DryRunCalledWith = data
+	if morm.DryRunError != nil {
+		return nil, morm.DryRunError
+	}
+	return morm.DryRunReturns, nil
+}
+
+// ValidateDeploy returns the problems & error stored in MockORM, and records the call value
+func (morm *MockORM) ValidateDeploy(data interface{}) ([]error, error) {
+	morm.ValidateDeployCalledWith = data
+	if morm.ValidateDeployError != nil {
+		return nil, morm.ValidateDeployError
+	}
+	return morm.ValidateDeployReturns, nil
+}
+
 // DeployMultiple returns the error stored in MockORM, and records the call value
 func (morm *MockORM) DeployMultiple(data []interface{}) error {
 	morm.DeployMultipleCalledWith = data
 	return morm.DeployMultipleError
 }
 
+// RefreshMaterializedView returns the error stored in MockORM, and records the call value
+func (morm *MockORM) RefreshMaterializedView(model interface{}, concurrently bool) error {
+	morm.RefreshMaterializedViewCalledWith = model
+	return morm.RefreshMaterializedViewError
+}
+
+// TruncateModel returns the error stored in MockORM, and records the call value
+func (morm *MockORM) TruncateModel(model interface{}) error {
+	morm.TruncateModelCalledWith = model
+	return morm.TruncateModelError
+}
+
+// ReencryptModel returns the row count and error stored in MockORM, and records the call value
+func (morm *MockORM) ReencryptModel(model interface{}, oldKey []byte) (int64, error) {
+	morm.ReencryptModelCalledWith = model
+	return morm.ReencryptModelRowCount, morm.ReencryptModelError
+}
+
 // StartTransaction returns the error stored in MockORM and returns the value stored in the orm
 func (morm *MockORM) StartTransaction() (*sql.Tx, error) {
 	if morm.StartTransactionError != nil {
@@ -78,6 +273,24 @@ func (morm *MockORM) StartTransaction() (*sql.Tx, error) {
 	return morm.StartTransactionReturns, nil
 }
 
+// StartSnapshotTransaction returns the error stored in MockORM and returns the value stored in the orm
+func (morm *MockORM) StartSnapshotTransaction() (*sql.Tx, error) {
+	if morm.StartSnapshotTransactionError != nil {
+		return nil, morm.StartSnapshotTransactionError
+	}
+	return morm.StartSnapshotTransactionReturns, nil
+}
+
+// StartTransactionWithOptions returns the error stored in MockORM and returns the value stored in the orm, and
+// records the call value
+func (morm *MockORM) StartTransactionWithOptions(opts sql.TxOptions) (*sql.Tx, error) {
+	morm.StartTransactionWithOptionsCalledWith = opts
+	if morm.StartTransactionWithOptionsError != nil {
+		return nil, morm.StartTransactionWithOptionsError
+	}
+	return morm.StartTransactionWithOptionsReturns, nil
+}
+
 // Commit returns the error stored in MockORM
 func (morm *MockORM) Commit() error {
 	if morm.CommitError != nil {
@@ -94,6 +307,114 @@ func (morm *MockORM) Rollback() error {
 	return nil
 }
 
+// AfterCommit records the callback on the MockORM without running it
+func (morm *MockORM) AfterCommit(callback func()) {
+	morm.AfterCommitCallbacks = append(morm.AfterCommitCallbacks, callback)
+}
+
+// RunInTransaction records the options and runs fn with the MockORM, unless an error is stored in MockORM
+func (morm *MockORM) RunInTransaction(fn func(picard.ORM) error, opts picard.TxOptions) error {
+	morm.RunInTransactionCalledWith = opts
+	if morm.RunInTransactionError != nil {
+		return morm.RunInTransactionError
+	}
+	return fn(morm)
+}
+
+// WithChangeTracking records the listener on the MockORM and returns the same MockORM
+func (morm *MockORM) WithChangeTracking(listener picard.ChangeListener) picard.ORM {
+	morm.ChangeListener = listener
+	return morm
+}
+
+// WithSkipUnresolvable records the listener on the MockORM and returns the same MockORM
+func (morm *MockORM) WithSkipUnresolvable(listener picard.SkipListener) picard.ORM {
+	morm.SkipListener = listener
+	return morm
+}
+
+// WithDuplicatePrimaryKeyPolicy records the policy on the MockORM and returns the same MockORM
+func (morm *MockORM) WithDuplicatePrimaryKeyPolicy(policy picard.DuplicatePrimaryKeyPolicy) picard.ORM {
+	morm.DuplicatePrimaryKeyPolicy = policy
+	return morm
+}
+
+// WithBatchSize records the batch size on the MockORM and returns the same MockORM, or the error stored in MockORM
+func (morm *MockORM) WithBatchSize(n int) (picard.ORM, error) {
+	if morm.WithBatchSizeError != nil {
+		return nil, morm.WithBatchSizeError
+	}
+	morm.BatchSize = n
+	return morm, nil
+}
+
+// WithChunkedDeletes records the chunking on the MockORM and returns the same MockORM, or the error stored in MockORM
+func (morm *MockORM) WithChunkedDeletes(chunkSize int, separateTransactions bool) (picard.ORM, error) {
+	if morm.WithChunkedDeletesError != nil {
+		return nil, morm.WithChunkedDeletesError
+	}
+	morm.DeleteChunkSize = chunkSize
+	morm.SeparateDeleteTransactions = separateTransactions
+	return morm, nil
+}
+
+// WithCopyInserts records the copy threshold on the MockORM and returns the same MockORM, or the error stored in MockORM
+func (morm *MockORM) WithCopyInserts(threshold int) (picard.ORM, error) {
+	if morm.WithCopyInsertsError != nil {
+		return nil, morm.WithCopyInsertsError
+	}
+	morm.CopyThreshold = threshold
+	return morm, nil
+}
+
+// WithStatementCache records the statement cache on the MockORM and returns the same MockORM
+func (morm *MockORM) WithStatementCache(cache *picard.StatementCache) picard.ORM {
+	morm.StatementCache = cache
+	return morm
+}
+
+// WithPerRowKeyMatching records that per-row key matching is on and returns the same MockORM
+func (morm *MockORM) WithPerRowKeyMatching() picard.ORM {
+	morm.PerRowKeyMatching = true
+	return morm
+}
+
+// WithMultitenancyMatch records the match on the MockORM and returns the same MockORM
+func (morm *MockORM) WithMultitenancyMatch(match picard.MultitenancyMatch) picard.ORM {
+	morm.MultitenancyMatch = match
+	return morm
+}
+
+// WithReplicaMaxLag records the maximum lag on the MockORM and returns the same MockORM
+func (morm *MockORM) WithReplicaMaxLag(maxLag time.Duration) picard.ORM {
+	morm.ReplicaMaxLag = maxLag
+	return morm
+}
+
+// WithReadConsistency records the read consistency on the MockORM and returns the same MockORM
+func (morm *MockORM) WithReadConsistency(consistency picard.ReadConsistency) picard.ORM {
+	morm.ReadConsistency = consistency
+	return morm
+}
+
+// WithTruncateOptions records the truncate options on the MockORM and returns the same MockORM
+func (morm *MockORM) WithTruncateOptions(opts picard.TruncateOptions) picard.ORM {
+	morm.TruncateOptions = opts
+	return morm
+}
+
+// WithCheckValidation records that check validation is on and returns the same MockORM
+func (morm *MockORM) WithCheckValidation() picard.ORM {
+	morm.CheckValidation = true
+	return morm
+}
+
+// WithNativeUpsert records that native upserts are on and returns the same MockORM
+func (morm *MockORM) WithNativeUpsert() picard.ORM {
+	morm.NativeUpsert = true
+	return morm
+}
+
 // MultiMockORM can be used to string together a series of calls to picard.ORM
 type MultiMockORM struct {
 	MockORMs []MockORM
@@ -129,6 +450,60 @@ func (multi *MultiMockORM) FilterModel(request picard.FilterRequest) ([]interfac
 	return next.FilterModel(request)
 }
 
+// FilterModelPaginated returns the page & error stored in the next MockORM
+func (multi *MultiMockORM) FilterModelPaginated(request picard.FilterRequest) (*picard.Page, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.FilterModelPaginated(request)
+}
+
+// FilterModelSQL returns the SQL, arguments & error stored in the next MockORM
+func (multi *MultiMockORM) FilterModelSQL(request picard.FilterRequest) (string, []interface{}, error) {
+	next, err := multi.next()
+	if err != nil {
+		return "", nil, err
+	}
+	return next.FilterModelSQL(request)
+}
+
+// FilterModelStream returns the iterator & error stored in the next MockORM
+func (multi *MultiMockORM) FilterModelStream(request picard.FilterRequest) (*picard.ResultIterator, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.FilterModelStream(request)
+}
+
+// FilterModelOne returns the model & error stored in the next MockORM
+func (multi *MultiMockORM) FilterModelOne(request picard.FilterRequest) (interface{}, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.FilterModelOne(request)
+}
+
+// FilterModelFlat returns the flat results & error stored in the next MockORM
+func (multi *MultiMockORM) FilterModelFlat(request picard.FilterRequest) (*picard.FlatResults, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.FilterModelFlat(request)
+}
+
+// AggregateModel returns the results & error stored in the next MockORM
+func (multi *MultiMockORM) AggregateModel(request picard.AggregateRequest) ([]map[string]interface{}, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.AggregateModel(request)
+}
+
 // SaveModel returns the error stored in MockORM, and records the call value
 func (multi *MultiMockORM) SaveModel(model interface{}) error {
 	next, err := multi.next()
@@ -138,6 +513,15 @@ func (multi *MultiMockORM) SaveModel(model interface{}) error {
 	return next.SaveModel(model)
 }
 
+// SaveModelWithRowsAffected returns the rows affected number & error stored in MockORM, and records the call value
+func (multi *MultiMockORM) SaveModelWithRowsAffected(model interface{}) (int64, error) {
+	next, err := multi.next()
+	if err != nil {
+		return 0, err
+	}
+	return next.SaveModelWithRowsAffected(model)
+}
+
 // CreateModel returns the error stored in MockORM, and records the call value
 func (multi *MultiMockORM) CreateModel(model interface{}) error {
 	next, err := multi.next()
@@ -156,6 +540,33 @@ func (multi *MultiMockORM) DeleteModel(data interface{}) (int64, error) {
 	return next.DeleteModel(data)
 }
 
+// DeleteModels returns the rows affected number & error stored in MockORM, and records the call value
+func (multi *MultiMockORM) DeleteModels(request picard.FilterRequest) (int64, error) {
+	next, err := multi.next()
+	if err != nil {
+		return 0, err
+	}
+	return next.DeleteModels(request)
+}
+
+// RestoreModel returns the error stored in MockORM, and records the call value
+func (multi *MultiMockORM) RestoreModel(model interface{}) error {
+	next, err := multi.next()
+	if err != nil {
+		return err
+	}
+	return next.RestoreModel(model)
+}
+
+// RestoreModels returns the rows affected number & error stored in MockORM, and records the call value
+func (multi *MultiMockORM) RestoreModels(request picard.FilterRequest) (int64, error) {
+	next, err := multi.next()
+	if err != nil {
+		return 0, err
+	}
+	return next.RestoreModels(request)
+}
+
 // Deploy returns the error stored in MockORM, and records the call value
 func (multi *MultiMockORM) Deploy(data interface{}) error {
 	next, err := multi.next()
@@ -165,6 +576,33 @@ func (multi *MultiMockORM) Deploy(data interface{}) error {
 	return next.Deploy(data)
 }
 
+// DeployWithResults returns the results & error stored in MockORM, and records the call value
+func (multi *MultiMockORM) DeployWithResults(data interface{}) ([]picard.DeployResult, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.DeployWithResults(data)
+}
+
+// DryRun returns the change sets & error stored in MockORM, and records the call value
+func (multi *MultiMockORM) DryRun(data interface{}) ([]*dbchange.ChangeSet, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.DryRun(data)
+}
+
+// ValidateDeploy returns the problems & error stored in MockORM, and records the call value
+func (multi *MultiMockORM) ValidateDeploy(data interface{}) ([]error, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.ValidateDeploy(data)
+}
+
 // DeployMultiple returns the error stored in MockORM, and records the call value
 func (multi *MultiMockORM) DeployMultiple(data []interface{}) error {
 	next, err := multi.next()
@@ -174,6 +612,33 @@ func (multi *MultiMockORM) DeployMultiple(data []interface{}) error {
 	return next.DeployMultiple(data)
 }
 
+// RefreshMaterializedView returns the error stored in MockORM, and records the call value
+func (multi *MultiMockORM) RefreshMaterializedView(model interface{}, concurrently bool) error {
+	next, err := multi.next()
+	if err != nil {
+		return err
+	}
+	return next.RefreshMaterializedView(model, concurrently)
+}
+
+// TruncateModel returns the error stored in the next MockORM, and records the call value
+func (multi *MultiMockORM) TruncateModel(model interface{}) error {
+	next, err := multi.next()
+	if err != nil {
+		return err
+	}
+	return next.TruncateModel(model)
+}
+
+// ReencryptModel returns the row count and error stored in the next MockORM, and records the call value
+func (multi *MultiMockORM) ReencryptModel(model interface{}, oldKey []byte) (int64, error) {
+	next, err := multi.next()
+	if err != nil {
+		return 0, err
+	}
+	return next.ReencryptModel(model, oldKey)
+}
+
 // StartTransaction returns the error stored in MockORM and returns the value stored in the orm
 func (multi *MultiMockORM) StartTransaction() (*sql.Tx, error) {
 	next, err := multi.next()
@@ -183,6 +648,24 @@ func (multi *MultiMockORM) StartTransaction() (*sql.Tx, error) {
 	return next.StartTransaction()
 }
 
+// StartSnapshotTransaction returns the error stored in MockORM and returns the value stored in the orm
+func (multi *MultiMockORM) StartSnapshotTransaction() (*sql.Tx, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.StartSnapshotTransaction()
+}
+
+// StartTransactionWithOptions returns the error stored in the next MockORM and returns the value stored in it
+func (multi *MultiMockORM) StartTransactionWithOptions(opts sql.TxOptions) (*sql.Tx, error) {
+	next, err := multi.next()
+	if err != nil {
+		return nil, err
+	}
+	return next.StartTransactionWithOptions(opts)
+}
+
 // Commit returns the error stored in MockORM
 func (multi *MultiMockORM) Commit() error {
 	next, err := multi.next()
@@ -200,3 +683,85 @@ func (multi *MultiMockORM) Rollback() error {
 	}
 	return next.Rollback()
 }
+
+// AfterCommit records the callback on the next MockORM in the series without using it up
+func (multi *MultiMockORM) AfterCommit(callback func()) {
+	if multi.index < len(multi.MockORMs) {
+		multi.MockORMs[multi.index].AfterCommit(callback)
+	}
+}
+
+// RunInTransaction runs fn with the MultiMockORM, so the calls fn makes use up the mocks in the series
+func (multi *MultiMockORM) RunInTransaction(fn func(picard.ORM) error, opts picard.TxOptions) error {
+	return fn(multi)
+}
+
+// WithChangeTracking returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithChangeTracking(listener picard.ChangeListener) picard.ORM {
+	return multi
+}
+
+// WithSkipUnresolvable returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithSkipUnresolvable(listener picard.SkipListener) picard.ORM {
+	return multi
+}
+
+// WithDuplicatePrimaryKeyPolicy returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithDuplicatePrimaryKeyPolicy(policy picard.DuplicatePrimaryKeyPolicy) picard.ORM {
+	return multi
+}
+
+// WithBatchSize returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithBatchSize(n int) (picard.ORM, error) {
+	return multi, nil
+}
+
+// WithChunkedDeletes returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithChunkedDeletes(chunkSize int, separateTransactions bool) (picard.ORM, error) {
+	return multi, nil
+}
+
+// WithCopyInserts returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithCopyInserts(threshold int) (picard.ORM, error) {
+	return multi, nil
+}
+
+// WithStatementCache returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithStatementCache(cache *picard.StatementCache) picard.ORM {
+	return multi
+}
+
+// WithPerRowKeyMatching returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithPerRowKeyMatching() picard.ORM {
+	return multi
+}
+
+// WithMultitenancyMatch returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithMultitenancyMatch(match picard.MultitenancyMatch) picard.ORM {
+	return multi
+}
+
+// WithReplicaMaxLag returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithReplicaMaxLag(maxLag time.Duration) picard.ORM {
+	return multi
+}
+
+// WithReadConsistency returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithReadConsistency(consistency picard.ReadConsistency) picard.ORM {
+	return multi
+}
+
+// WithTruncateOptions returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithTruncateOptions(opts picard.TruncateOptions) picard.ORM {
+	return multi
+}
+
+// WithCheckValidation returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithCheckValidation() picard.ORM {
+	return multi
+}
+
+// WithNativeUpsert returns the same MultiMockORM, so configuring it does not use up a mock in the series
+func (multi *MultiMockORM) WithNativeUpsert() picard.ORM {
+	return multi
+}
@@ -2,8 +2,10 @@ package query
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 
+	"github.com/lib/pq"
 	qp "github.com/skuid/picard/queryparts"
 	"github.com/skuid/picard/reflectutil"
 	"github.com/skuid/picard/stringutil"
@@ -47,6 +49,20 @@ func getAssociation(associations []tags.Association, name string) (tags.Associat
 	return found, false
 }
 
+// nestedAssociations returns the associations to load from the related model of an association. An association
+// with a MaxDepth loads itself again, one level less deep, until the depth runs out. Its filters only apply to
+// the first level.
+func nestedAssociations(association tags.Association) []tags.Association {
+	if association.MaxDepth <= 1 {
+		return association.Associations
+	}
+	nested := association
+	nested.MaxDepth--
+	nested.FieldFilters = nil
+	nested.FilterPlacement = tags.FilterInWhere
+	return append([]tags.Association{nested}, association.Associations...)
+}
+
 /*
 buildQuery is called recursively to create a Table object, which can be used
 to generate the SQL. It takes
@@ -121,6 +137,14 @@ func buildQuery(
 		case isFk:
 			relatedName := field.GetRelatedName()
 			relatedVal := modelVal.FieldByName(relatedName)
+			// Self-referential models hold the related model in a pointer, which is nil until it's loaded
+			if relatedVal.Kind() == reflect.Ptr {
+				if relatedVal.IsNil() {
+					relatedVal = reflect.New(relatedVal.Type().Elem()).Elem()
+				} else {
+					relatedVal = relatedVal.Elem()
+				}
+			}
 
 			association, ok := getAssociation(associations, relatedName)
 
@@ -147,11 +171,22 @@ func buildQuery(
 					fkRefPath = refPath + "." + fieldName
 				}
 
-				refTbl, err := buildQuery(multitenancyVal, refTyp, &relatedVal, association.FieldFilters, association.Associations, association.SelectFields, childOnlyJoin, fkRefPath, refMetadata, counter)
+				refFilters := association.FieldFilters
+				if association.FilterPlacement == tags.FilterInJoin {
+					refFilters = nil
+				}
+
+				refTbl, err := buildQuery(multitenancyVal, refTyp, &relatedVal, refFilters, nestedAssociations(association), association.SelectFields, childOnlyJoin, fkRefPath, refMetadata, counter)
 				if err != nil {
 					return nil, err
 				}
 
+				// Filters in the join's ON clause keep the rows with no matching related row, instead of
+				// dropping them like a WHERE would
+				if association.FilterPlacement == tags.FilterInJoin && association.FieldFilters != nil {
+					refTbl.AddJoinWhereGroup(association.FieldFilters.Apply(refTbl, refMetadata))
+				}
+
 				joinField := column
 
 				direction := "left"
@@ -161,15 +196,33 @@ func buildQuery(
 				tbl.AppendJoinTable(refTbl, pkName, joinField, direction)
 			}
 
+		case field.IsXmin():
+			// xmin is selected for change tracking, but filtering on it goes through FilterRequest.ModifiedSinceXmin
+			if addCol && !seen[column] {
+				cols = append(cols, column)
+				seen[column] = true
+			}
 		case notZero:
-			if field.IsEncrypted() {
+			if field.IsEncrypted() && !field.IsDeterministic() {
 				return nil, errors.New("cannot perform queries with where clauses on encrypted fields")
 			}
 			if !seen[column] {
 				cols = append(cols, column)
 				seen[column] = true
 			}
-			tbl.AddWhere(column, val.Interface())
+			if field.IsDeterministic() {
+				encrypted, err := tags.EncryptDeterministic(val.Interface())
+				if err != nil {
+					return nil, err
+				}
+				tbl.AddWhere(column, encrypted)
+			} else if field.IsPGArray() {
+				tbl.AddWhere(column, pq.Array(val.Interface()))
+			} else if isAnyFilter(field, val) {
+				tbl.AddWhereAny(column, val.Interface())
+			} else {
+				tbl.AddWhere(column, val.Interface())
+			}
 		default:
 			if addCol && !seen[column] {
 				cols = append(cols, column)
@@ -180,6 +233,12 @@ func buildQuery(
 
 	tbl.AddColumns(cols)
 
+	if !onlyJoin {
+		if err := addJoinedFields(multitenancyVal, tbl, selectFields, refPath, filterMetadata, counter); err != nil {
+			return nil, err
+		}
+	}
+
 	if filters != nil && modelVal != nil {
 		tbl.AddWhereGroup(filters.Apply(tbl, filterMetadata))
 	}
@@ -187,3 +246,53 @@ func buildQuery(
 	return tbl, nil
 
 }
+
+/*
+addJoinedFields selects the columns of fields tagged with join from their parent's table. The join that eager
+loads the parent is used if the parent was requested as an association, and a left join is added otherwise, so
+rows without a parent are still returned.
+*/
+func addJoinedFields(multitenancyVal interface{}, tbl *qp.Table, selectFields []string, refPath string, filterMetadata *tags.TableMetadata, counter *int) error {
+	for _, field := range filterMetadata.GetJoinedFields() {
+		if selectFields != nil && !stringutil.StringSliceContainsKey(selectFields, field.GetName()) {
+			continue
+		}
+
+		foreignKey := filterMetadata.GetForeignKeyFieldFromRelation(field.GetJoinRelation())
+		if foreignKey == nil {
+			return fmt.Errorf(
+				"field '%s' on table '%s' joins '%s', which is not the related field of a foreign key",
+				field.GetName(), filterMetadata.GetTableName(), field.GetJoinRelation(),
+			)
+		}
+
+		fkRefPath := foreignKey.FieldName
+		if refPath != "" {
+			fkRefPath = refPath + "." + foreignKey.FieldName
+		}
+
+		refMetadata := foreignKey.TableMetadata
+		refTbl := tbl.JoinedTable(fkRefPath)
+		if refTbl == nil {
+			refTbl = NewAliased(refMetadata.GetTableName(), stringutil.GenerateTableAlias(counter), fkRefPath)
+			if multitenancyColumn := refMetadata.GetMultitenancyKeyColumnName(); multitenancyColumn != "" {
+				refTbl.AddMultitenancyWhere(multitenancyColumn, multitenancyVal)
+			}
+			tbl.AppendJoinTable(refTbl, refMetadata.GetPrimaryKeyColumnName(), foreignKey.KeyColumn, "left")
+		}
+
+		tbl.AddJoinedColumn(refTbl.Alias, field.GetColumnName(), joinedFieldKey(field))
+	}
+	return nil
+}
+
+// joinedFieldKey is the name a joined field's column is selected and hydrated under, like ParentA.name
+func joinedFieldKey(field tags.FieldMetadata) string {
+	return field.GetJoinRelation() + "." + field.GetColumnName()
+}
+
+// isAnyFilter reports whether a filter value holds a list of values for the column to match any of.
+// Byte slices, JSONB fields and pgarray fields hold a single value.
+func isAnyFilter(field tags.FieldMetadata, val reflect.Value) bool {
+	return val.Kind() == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8 && !field.IsJSONB() && !field.IsPGArray()
+}
@@ -3,6 +3,7 @@ package query
 import (
 	"testing"
 
+	"github.com/lib/pq"
 	"github.com/skuid/picard/tags"
 	"github.com/skuid/picard/testdata"
 	"github.com/stretchr/testify/assert"
@@ -38,6 +39,25 @@ func TestQueryBuilder(t *testing.T) {
 				"pops",
 			},
 		},
+		{
+			"should match any of the values of a slice field",
+			fieldsByName{
+				Names: []string{"a_field", "b_field"},
+			},
+			nil,
+			testdata.FmtSQL(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.name AS "t0.name"
+				FROM field AS t0
+				WHERE t0.organization_id = $1 AND t0.name = ANY($2)
+			`),
+			[]interface{}{
+				orgID,
+				pq.Array([]string{"a_field", "b_field"}),
+			},
+		},
 		{
 			"should return a table with columns and a reference",
 			field{
@@ -3,12 +3,14 @@ package query
 import (
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/skuid/picard/crypto"
+	"github.com/skuid/picard/jsonb"
 	qp "github.com/skuid/picard/queryparts"
 	"github.com/skuid/picard/stringutil"
 	"github.com/skuid/picard/tags"
@@ -19,23 +21,186 @@ Hydrate takes the rows and pops them into the correct struct, in the correct
 order. This is usually called after you've built and executed the query model.
 */
 func Hydrate(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata) ([]*reflect.Value, error) {
+	return hydrateRows(filterModel, tblAlias, aliasMap, rows, meta, DecryptAll)
+}
+
+/*
+HydrateCiphertext works like Hydrate, but leaves encrypted fields as the
+base64 ciphertext stored in the database instead of decrypting them.
+*/
+func HydrateCiphertext(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata) ([]*reflect.Value, error) {
+	return hydrateRows(filterModel, tblAlias, aliasMap, rows, meta, DecryptNone)
+}
+
+/*
+HydrateWithTotal works like Hydrate, and also returns the total held by every row in the column named total,
+like a COUNT(*) OVER () window selected alongside a page of results. The total is 0 when there are no rows.
+*/
+func HydrateWithTotal(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, total string) ([]*reflect.Value, uint64, error) {
+	return hydrateRowsWithTotal(filterModel, tblAlias, aliasMap, rows, meta, total, DecryptAll)
+}
+
+/*
+HydrateCiphertextWithTotal works like HydrateWithTotal, but leaves encrypted fields as the
+base64 ciphertext stored in the database instead of decrypting them.
+*/
+func HydrateCiphertextWithTotal(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, total string) ([]*reflect.Value, uint64, error) {
+	return hydrateRowsWithTotal(filterModel, tblAlias, aliasMap, rows, meta, total, DecryptNone)
+}
+
+/*
+HydrateRow works like Hydrate for the current row of rows only, so results can be read one at a time. The caller
+advances the rows with Next before each call.
+*/
+func HydrateRow(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata) (*reflect.Value, error) {
+	return hydrateRow(filterModel, tblAlias, aliasMap, rows, meta, DecryptAll)
+}
+
+/*
+HydrateRowCiphertext works like HydrateRow, but leaves encrypted fields as the
+base64 ciphertext stored in the database instead of decrypting them.
+*/
+func HydrateRowCiphertext(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata) (*reflect.Value, error) {
+	return hydrateRow(filterModel, tblAlias, aliasMap, rows, meta, DecryptNone)
+}
+
+/*
+HydrateDecrypting works like Hydrate, but only decrypts the encrypted fields that decryption picks, leaving the
+others as the base64 ciphertext stored in the database.
+*/
+func HydrateDecrypting(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, decryption Decryption) ([]*reflect.Value, error) {
+	return hydrateRows(filterModel, tblAlias, aliasMap, rows, meta, decryption)
+}
+
+/*
+HydrateDecryptingWithTotal works like HydrateWithTotal, but only decrypts the encrypted fields that decryption
+picks, leaving the others as the base64 ciphertext stored in the database.
+*/
+func HydrateDecryptingWithTotal(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, total string, decryption Decryption) ([]*reflect.Value, uint64, error) {
+	return hydrateRowsWithTotal(filterModel, tblAlias, aliasMap, rows, meta, total, decryption)
+}
+
+/*
+HydrateRowDecrypting works like HydrateRow, but only decrypts the encrypted fields that decryption picks, leaving
+the others as the base64 ciphertext stored in the database.
+*/
+func HydrateRowDecrypting(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, decryption Decryption) (*reflect.Value, error) {
+	return hydrateRow(filterModel, tblAlias, aliasMap, rows, meta, decryption)
+}
+
+// Decryption picks the encrypted fields that are decrypted as rows are hydrated
+type Decryption struct {
+	all   bool
+	paths []string
+}
+
+var (
+	// DecryptAll decrypts every encrypted field
+	DecryptAll = Decryption{all: true}
+	// DecryptNone leaves every encrypted field as ciphertext
+	DecryptNone = Decryption{}
+)
+
+// DecryptFields decrypts only the encrypted fields at the dot separated paths, like Secret for a field of the
+// model or Parent.Secret for a field of an eager loaded parent
+func DecryptFields(paths []string) Decryption {
+	return Decryption{paths: paths}
+}
+
+// Association returns the decryption of the fields of the model's association with the name
+func (d Decryption) Association(name string) Decryption {
+	if d.all {
+		return d
+	}
+	prefix := name + "."
+	paths := []string{}
+	for _, path := range d.paths {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, strings.TrimPrefix(path, prefix))
+		}
+	}
+	return Decryption{paths: paths}
+}
+
+func (d Decryption) decrypts(fieldName string) bool {
+	return d.all || stringutil.StringSliceContainsKey(d.paths, fieldName)
+}
+
+func hydrateRow(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, decryption Decryption) (*reflect.Value, error) {
 	modelVal, err := stringutil.GetStructValue(filterModel)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get the models type and picard tags
-	typ := modelVal.Type()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, err := mapRow2Cols(aliasMap, cols, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	alias := fmt.Sprintf(qp.AliasedField, tblAlias, meta.GetTableName())
+	return hydrate(modelVal.Type(), mapped, alias, aliasMap, "", meta, decryption)
+}
+
+func hydrateRows(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, decryption Decryption) ([]*reflect.Value, error) {
+	modelVal, err := stringutil.GetStructValue(filterModel)
+	if err != nil {
+		return nil, err
+	}
 
 	mappedCols, err := mapRows2Cols(aliasMap, rows)
 	if err != nil {
 		return nil, err
 	}
 
+	return hydrateMapped(modelVal.Type(), mappedCols, tblAlias, aliasMap, meta, decryption)
+}
+
+func hydrateRowsWithTotal(filterModel interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows, meta *tags.TableMetadata, total string, decryption Decryption) ([]*reflect.Value, uint64, error) {
+	modelVal, err := stringutil.GetStructValue(filterModel)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The total isn't a column of any table, so it's mapped on its own
+	totalAliasMap := make(map[string]qp.FieldDescriptor, len(aliasMap)+1)
+	for key, descriptor := range aliasMap {
+		totalAliasMap[key] = descriptor
+	}
+	totalAliasMap[total] = qp.FieldDescriptor{Column: total}
+
+	mappedCols, err := mapRows2Cols(totalAliasMap, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var totalValue uint64
+	if len(mappedCols) > 0 {
+		switch value := mappedCols[0][fmt.Sprintf(qp.AliasedField, "", "")][total].(type) {
+		case int64:
+			totalValue = uint64(value)
+		case nil:
+		default:
+			return nil, 0, fmt.Errorf("total column '%s' must be an integer, got %T", total, value)
+		}
+	}
+
+	hydrateds, err := hydrateMapped(modelVal.Type(), mappedCols, tblAlias, aliasMap, meta, decryption)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hydrateds, totalValue, nil
+}
+
+func hydrateMapped(typ reflect.Type, mappedCols []map[string]map[string]interface{}, tblAlias string, aliasMap map[string]qp.FieldDescriptor, meta *tags.TableMetadata, decryption Decryption) ([]*reflect.Value, error) {
 	hydrateds := make([]*reflect.Value, 0, len(mappedCols))
 	alias := fmt.Sprintf(qp.AliasedField, tblAlias, meta.GetTableName())
 	for _, mapped := range mappedCols {
-		hydrated, err := hydrate(typ, mapped, alias, aliasMap, "", meta)
+		hydrated, err := hydrate(typ, mapped, alias, aliasMap, "", meta, decryption)
 
 		if err != nil {
 			return nil, err
@@ -53,6 +218,7 @@ func hydrate(
 	aliasMap map[string]qp.FieldDescriptor,
 	refPath string,
 	meta *tags.TableMetadata,
+	decryption Decryption,
 ) (*reflect.Value, error) {
 
 	model := reflect.Indirect(reflect.New(typ))
@@ -61,7 +227,11 @@ func hydrate(
 
 	for _, field := range meta.GetFields() {
 		fieldVal := mappedFields[field.GetColumnName()]
-		err := setFieldValue(&model, field, fieldVal)
+		// Discriminated fields are set once every field is, since their discriminator may be declared after them
+		if field.GetDiscriminator() != "" {
+			continue
+		}
+		err := setFieldValue(&model, field, fieldVal, decryption.decrypts(field.GetName()))
 		if err != nil {
 			return nil, err
 		}
@@ -92,13 +262,59 @@ func hydrate(
 				continue
 			}
 
+			// Self-referential models hold the related model in a pointer, which is left nil when the join
+			// found no related row
+			isPointer := refTyp.Kind() == reflect.Ptr
+			if isPointer {
+				refTyp = refTyp.Elem()
+				if mapped[fkAlias][foreignMetadata.GetPrimaryKeyColumnName()] == nil {
+					continue
+				}
+			}
+
 			// Recursively hydrate this reference field
-			refValHydrated, err := hydrate(refTyp, mapped, fkAlias, aliasMap, fkRefPath, foreignMetadata)
+			refValHydrated, err := hydrate(refTyp, mapped, fkAlias, aliasMap, fkRefPath, foreignMetadata, decryption.Association(field.GetRelatedName()))
 			if err != nil {
 				return nil, err
 			}
 
-			model.FieldByName(field.GetRelatedName()).Set(*refValHydrated)
+			if isPointer {
+				model.FieldByName(field.GetRelatedName()).Set(refValHydrated.Addr())
+			} else {
+				model.FieldByName(field.GetRelatedName()).Set(*refValHydrated)
+			}
+		}
+	}
+
+	for _, field := range meta.GetFields() {
+		if field.GetDiscriminator() != "" {
+			if err := setVariantValue(&model, field, mappedFields[field.GetColumnName()]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, field := range meta.GetJoinedFields() {
+		if err := setFieldValue(&model, field, mappedFields[joinedFieldKey(field)], decryption.decrypts(field.GetName())); err != nil {
+			return nil, err
+		}
+	}
+
+	// Aggregate fields are selected under their own name, and only when the request asks for them
+	for _, field := range meta.GetAggregateFields() {
+		if value, ok := mappedFields[field.GetName()]; ok {
+			if err := setFieldValue(&model, field, value, decryption.decrypts(field.GetName())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Computed fields are selected under their own name too, when the request calls a function into them
+	for _, field := range meta.GetComputedFields() {
+		if value, ok := mappedFields[field.GetName()]; ok {
+			if err := setFieldValue(&model, field, value, false); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -106,9 +322,67 @@ func hydrate(
 	return &hydratedModel, nil
 }
 
-func setFieldValue(model *reflect.Value, field tags.FieldMetadata, value interface{}) error {
+// setVariantValue unmarshals a jsonb field with an interface type into the concrete type registered for the
+// value of its discriminator field. The field is left nil when the column or the discriminator is NULL.
+func setVariantValue(model *reflect.Value, field tags.FieldMetadata, value interface{}) error {
+	var data []byte
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return fmt.Errorf("jsonb field '%s' must be read as text, not %T", field.GetName(), value)
+	}
+
+	discriminatorField := model.FieldByName(field.GetDiscriminator())
+	if !discriminatorField.IsValid() {
+		return fmt.Errorf("discriminator '%s' of field '%s' is not a field", field.GetDiscriminator(), field.GetName())
+	}
+	discriminatorField = reflect.Indirect(discriminatorField)
+	if !discriminatorField.IsValid() {
+		return nil
+	}
+	discriminator := fmt.Sprint(discriminatorField.Interface())
+
+	variantType, ok := jsonb.VariantType(field.GetFieldType(), discriminator)
+	if !ok {
+		return fmt.Errorf("no jsonb variant of %s registered for discriminator '%s' of field '%s'", field.GetFieldType(), discriminator, field.GetName())
+	}
+	destination := reflect.New(variantType)
+	if err := jsonb.Unmarshal(field.GetJSONBCodec(), data, destination.Interface()); err != nil {
+		return err
+	}
+	model.FieldByName(field.GetName()).Set(destination.Elem())
+	return nil
+}
+
+func setFieldValue(model *reflect.Value, field tags.FieldMetadata, value interface{}, decrypt bool) error {
 	reflectedValue := reflect.ValueOf(value)
 
+	if field.IsRange() {
+		scanner, ok := reflect.New(field.GetFieldType()).Interface().(sql.Scanner)
+		if !ok {
+			return fmt.Errorf("range field '%s' must be a type from the ranges package", field.GetName())
+		}
+		if err := scanner.Scan(value); err != nil {
+			return err
+		}
+		model.FieldByName(field.GetName()).Set(reflect.ValueOf(scanner).Elem())
+		return nil
+	}
+
+	if field.IsPGArray() {
+		destination, err := scanPGArray(field.GetFieldType(), value)
+		if err != nil {
+			return err
+		}
+		model.FieldByName(field.GetName()).Set(destination)
+		return nil
+	}
+
 	if reflectedValue.IsValid() {
 		if field.IsJSONB() {
 			valueString, isString := value.(string)
@@ -116,20 +390,30 @@ func setFieldValue(model *reflect.Value, field tags.FieldMetadata, value interfa
 				valueString = string(value.([]byte))
 			}
 			destinationValue := reflect.New(field.GetFieldType()).Interface()
-			json.Unmarshal([]byte(valueString), destinationValue)
+			// encoding/json errors are ignored, so values that don't fit the field still load, but a codec's
+			// errors are returned
+			err := jsonb.Unmarshal(field.GetJSONBCodec(), []byte(valueString), destinationValue)
+			if err != nil && jsonb.HasCodec(field.GetJSONBCodec(), field.GetFieldType()) {
+				return err
+			}
 			rval := reflect.Indirect(reflect.ValueOf(destinationValue))
 			model.FieldByName(field.GetName()).Set(rval)
 		}
 
-		if field.IsEncrypted() {
-			if value == nil || value == "" {
-				return nil
-			}
-
-			valueAsString, ok := value.(string)
-			if !ok {
+		if field.IsEncrypted() && decrypt {
+			var valueAsString string
+			switch value := value.(type) {
+			case string:
+				valueAsString = value
+			case []byte:
+				valueAsString = string(value)
+			default:
 				return errors.New("can only decrypt values which are stored as base64 strings")
 			}
+			// Empty strings are never encrypted, so the field is left empty
+			if valueAsString == "" {
+				return nil
+			}
 
 			valueAsBytes, err := base64.StdEncoding.DecodeString(valueAsString)
 			if err != nil {
@@ -140,7 +424,12 @@ func setFieldValue(model *reflect.Value, field tags.FieldMetadata, value interfa
 			if err != nil {
 				return err
 			}
-			model.FieldByName(field.GetName()).Set(reflect.ValueOf(string(decryptedValue)))
+			plaintext := string(decryptedValue)
+			if field.GetFieldType().Kind() == reflect.Ptr {
+				model.FieldByName(field.GetName()).Set(reflect.ValueOf(&plaintext))
+			} else {
+				model.FieldByName(field.GetName()).Set(reflect.ValueOf(plaintext))
+			}
 		} else if reflectedValue.Type().ConvertibleTo(field.GetFieldType()) {
 			reflectedValue = reflectedValue.Convert(field.GetFieldType())
 			value = reflectedValue.Interface()
@@ -228,42 +517,88 @@ func mapRows2Cols(aliasMap map[string]qp.FieldDescriptor, rows *sql.Rows) ([]map
 	}
 
 	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columns {
-			columnPointers[i] = &columns[i]
-		}
-
-		// Scan the result into the column pointers...
-		if err := rows.Scan(columnPointers...); err != nil {
+		result, err := mapRow2Cols(aliasMap, cols, rows)
+		if err != nil {
 			return nil, err
 		}
+		results = append(results, result)
+	}
 
-		result := make(map[string]map[string]interface{})
+	return results, nil
+}
 
-		// Create our map, and retrieve the value for each column from the pointers slice,
-		// storing it in the map with the name of the column as the key.
-		for i, colName := range cols {
-			tmap := aliasMap[colName]
-			aliasedTbl := fmt.Sprintf(qp.AliasedField, tmap.Alias, tmap.Table)
+// mapRow2Cols scans the current row into a map of column values, keyed by the aliased table they belong to
+func mapRow2Cols(aliasMap map[string]qp.FieldDescriptor, cols []string, rows *sql.Rows) (map[string]map[string]interface{}, error) {
+	columns := make([]interface{}, len(cols))
+	columnPointers := make([]interface{}, len(cols))
+	for i := range columns {
+		columnPointers[i] = &columns[i]
+	}
 
-			if result[aliasedTbl] == nil {
-				result[aliasedTbl] = make(map[string]interface{})
-			}
+	// Scan the result into the column pointers...
+	if err := rows.Scan(columnPointers...); err != nil {
+		return nil, err
+	}
 
-			val := columns[i]
-			if reflectValue := reflect.ValueOf(val); reflectValue.IsValid() {
-				reflectTyp := reflectValue.Type()
-				if reflectTyp == reflect.TypeOf([]byte(nil)) && reflectValue.Len() == 36 {
-					result[aliasedTbl][tmap.Column] = string(val.([]uint8))
-				} else {
-					result[aliasedTbl][tmap.Column] = val
-				}
+	result := make(map[string]map[string]interface{})
+
+	// Create our map, and retrieve the value for each column from the pointers slice,
+	// storing it in the map with the name of the column as the key.
+	for i, colName := range cols {
+		tmap := aliasMap[colName]
+		aliasedTbl := fmt.Sprintf(qp.AliasedField, tmap.Alias, tmap.Table)
+
+		if result[aliasedTbl] == nil {
+			result[aliasedTbl] = make(map[string]interface{})
+		}
+
+		val := columns[i]
+		if reflectValue := reflect.ValueOf(val); reflectValue.IsValid() {
+			reflectTyp := reflectValue.Type()
+			if reflectTyp == reflect.TypeOf([]byte(nil)) && reflectValue.Len() == 36 {
+				result[aliasedTbl][tmap.Column] = string(val.([]uint8))
+			} else {
+				result[aliasedTbl][tmap.Column] = val
 			}
 		}
+	}
 
-		results = append(results, result)
+	return result, nil
+}
+
+// scanPGArray scans a Postgres array into a slice of sliceType. Slices of strings, bools, ints and floats are
+// scanned with pq's array of the widest type of their kind and then converted, so named and narrower element
+// types work too. Other elements must implement sql.Scanner.
+func scanPGArray(sliceType reflect.Type, value interface{}) (reflect.Value, error) {
+	var wide sql.Scanner
+	switch sliceType.Elem().Kind() {
+	case reflect.String:
+		wide = &pq.StringArray{}
+	case reflect.Bool:
+		wide = &pq.BoolArray{}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		wide = &pq.Int64Array{}
+	case reflect.Float32, reflect.Float64:
+		wide = &pq.Float64Array{}
+	default:
+		destination := reflect.New(sliceType)
+		if err := pq.Array(destination.Interface()).Scan(value); err != nil {
+			return reflect.Value{}, err
+		}
+		return destination.Elem(), nil
 	}
 
-	return results, nil
+	if err := wide.Scan(value); err != nil {
+		return reflect.Value{}, err
+	}
+	elems := reflect.ValueOf(wide).Elem()
+	if elems.IsNil() {
+		return reflect.Zero(sliceType), nil
+	}
+	destination := reflect.MakeSlice(sliceType, elems.Len(), elems.Len())
+	for i := 0; i < elems.Len(); i++ {
+		destination.Index(i).Set(elems.Index(i).Convert(sliceType.Elem()))
+	}
+	return destination, nil
 }
@@ -361,3 +361,17 @@ func TestHydrate(t *testing.T) {
 		})
 	}
 }
+
+func TestDecryptionAssociation(t *testing.T) {
+	decryption := DecryptFields([]string{"Secret", "Parent.Secret", "Parent.GrandParent.Secret"})
+	assert.True(t, decryption.decrypts("Secret"))
+	assert.False(t, decryption.decrypts("Other"))
+
+	parent := decryption.Association("Parent")
+	assert.True(t, parent.decrypts("Secret"))
+	assert.True(t, parent.Association("GrandParent").decrypts("Secret"))
+	assert.False(t, decryption.Association("Sibling").decrypts("Secret"))
+
+	assert.True(t, DecryptAll.Association("Parent").decrypts("Secret"))
+	assert.False(t, DecryptNone.Association("Parent").decrypts("Secret"))
+}
@@ -26,6 +26,14 @@ type field struct {
 	ReferenceTo referenceTo `json:"referenceTo" validate:"-"`
 }
 
+// fieldsByName filters fields on any of several names
+type fieldsByName struct {
+	Metadata       metadata.Metadata `picard:"tablename=field"`
+	ID             string            `json:"id" picard:"primary_key,column=id"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Names          []string          `picard:"column=name"`
+}
+
 type referenceTo struct {
 	Metadata       metadata.Metadata `picard:"tablename=reference_to"`
 	ID             string            `json:"id" picard:"primary_key,column=id"`
@@ -19,8 +19,85 @@ results, err := p.FilterModel(picard.FilterRequest{
 
 // SELECT ... ORDER BY t0.field_a DESC
 
+Set Association to order by a field of an eager loaded parent instead. It is the name of the related field, or a
+path of related field names separated by dots for a parent of a parent. The association must also be requested in
+the Associations of the filter request, so its table is joined.
+
+results, err := p.FilterModel(picard.FilterRequest{
+	FilterModel: tableB{},
+	Associations: []tags.Association{
+		{
+			Name: "TableA",
+		},
+	},
+	OrderBy: []qp.OrderByRequest{
+		{
+			Association: "TableA",
+			Field:       "FieldA",
+		},
+	},
+})
+
+// SELECT ... LEFT JOIN table_a AS t1 ON ... ORDER BY t1.field_a
+
+Postgres sorts nulls last in ascending order and first in descending order. Set NullsFirst to place them
+explicitly, or leave it nil to keep the default.
+
+nullsFirst := false
+results, err := p.FilterModel(picard.FilterRequest{
+	FilterModel: tableA{},
+	OrderBy: []qp.OrderByRequest{
+		{
+			Field:      "FieldA",
+			Descending: true,
+			NullsFirst: &nullsFirst,
+		},
+	},
+})
+
+// SELECT ... ORDER BY t0.field_a DESC NULLS LAST
+
+Set Expression to order by a SQL expression instead of a field. The expression is added to the ORDER BY clause
+verbatim and Field and Association are ignored, while Descending and NullsFirst still apply. The expression is
+not validated or escaped, so it must never contain user input: building it from request parameters opens the
+query to SQL injection. Columns of the filter model are referenced through the t0 alias.
+
+results, err := p.FilterModel(picard.FilterRequest{
+	FilterModel: tableA{},
+	OrderBy: []qp.OrderByRequest{
+		{
+			Expression: "lower(t0.field_a)",
+		},
+	},
+})
+
+// SELECT ... ORDER BY lower(t0.field_a)
+
+Set Values to order by an explicit list of values of the field or expression, like records pinned to the top in
+a custom order. Rows are sorted in the order of the values, with rows holding any other value after them. The
+values are passed as query parameters.
+
+results, err := p.FilterModel(picard.FilterRequest{
+	FilterModel: tableA{},
+	OrderBy: []qp.OrderByRequest{
+		{
+			Field:  "ID",
+			Values: []interface{}{"x", "y"},
+		},
+		{
+			Field: "FieldA",
+		},
+	},
+})
+
+// SELECT ... ORDER BY CASE t0.id WHEN $2 THEN 0 WHEN $3 THEN 1 ELSE 2 END, t0.field_a
+
 */
 type OrderByRequest struct {
-	Field      string
-	Descending bool
+	Association string
+	Field       string
+	Expression  string
+	Descending  bool
+	NullsFirst  *bool
+	Values      []interface{}
 }
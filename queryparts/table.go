@@ -5,13 +5,25 @@ import (
 	"strings"
 
 	sql "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
 	"github.com/skuid/picard/stringutil"
 )
 
 const (
 	aliasedCol string = "%[1]v.%[2]v AS \"%[1]v.%[2]v\""
+	// XminColumn is the Postgres system column holding the id of the transaction that last wrote a row
+	XminColumn string = "xmin"
+	// XminExpression reads a table's xmin as a bigint. xid values can't be compared or scanned directly.
+	XminExpression string = "%[1]v.xmin::text::bigint"
+	aliasedXmin    string = XminExpression + " AS \"%[1]v.xmin\""
 )
 
+/*
+MultitenancyPrefix is a multitenancy value that matches every tenant whose id starts with it, instead of only
+the tenant with exactly that id
+*/
+type MultitenancyPrefix string
+
 /*
 Table represents a select, and is the root of the structure. Start here to build
 a query by calling
@@ -23,9 +35,12 @@ type Table struct {
 	RefPath      string
 	Name         string
 	columns      []string
+	joinedCols   []joinedColumn
+	computedCols []string
 	lookups      map[string]interface{}
 	Joins        []Join
 	Wheres       sql.And
+	JoinWheres   sql.And
 	MultiTenancy sql.Eq
 }
 
@@ -55,6 +70,50 @@ func (t *Table) AddColumns(cols []string) {
 	t.columns = append(t.columns, cols...)
 }
 
+// joinedColumn is a column of a joined table that is selected as if it belonged to this table
+type joinedColumn struct {
+	joinAlias string
+	column    string
+	key       string
+}
+
+/*
+AddJoinedColumn selects a column of a joined table under an alias of this table, so it's hydrated like one
+of this table's columns, with key in place of the column name:
+
+	t1.name AS "t0.ParentA.name"
+*/
+func (t *Table) AddJoinedColumn(joinAlias string, column string, key string) {
+	t.joinedCols = append(t.joinedCols, joinedColumn{
+		joinAlias: joinAlias,
+		column:    column,
+		key:       key,
+	})
+}
+
+/*
+AddComputedColumn registers a value that the caller selects under an alias of this table, like a subquery, so
+it's hydrated like one of this table's columns, with key in place of the column name:
+
+	(SELECT COUNT(*) ...) AS "t0.ChildCount"
+*/
+func (t *Table) AddComputedColumn(key string) {
+	t.computedCols = append(t.computedCols, key)
+}
+
+/*
+ComputedColumn returns the quoted alias a computed column is selected under, or an empty string if no column
+was registered with the key
+*/
+func (t *Table) ComputedColumn(key string) string {
+	for _, computed := range t.computedCols {
+		if computed == key {
+			return fmt.Sprintf(`"%s.%s"`, t.Alias, key)
+		}
+	}
+	return ""
+}
+
 /*
 AddWhere adds one where clause, WHERE {field} = {val}
 */
@@ -62,6 +121,13 @@ func (t *Table) AddWhere(column string, val interface{}) {
 	t.Wheres = append(t.Wheres, sql.Eq{fmt.Sprintf(AliasedField, t.Alias, column): val})
 }
 
+/*
+AddWhereAny adds one where clause matching any of the values in a slice, WHERE {field} = ANY({vals})
+*/
+func (t *Table) AddWhereAny(column string, vals interface{}) {
+	t.Wheres = append(t.Wheres, sql.Expr(fmt.Sprintf(AliasedField, t.Alias, column)+" = ANY(?)", pq.Array(vals)))
+}
+
 /*
 AddWhereGroup adds a grouping of ORS or ANDs to the where clause
 */
@@ -69,6 +135,14 @@ func (t *Table) AddWhereGroup(group sql.Sqlizer) {
 	t.Wheres = append(t.Wheres, group)
 }
 
+/*
+AddJoinWhereGroup adds a grouping of ORS or ANDs to the ON clause used when this table is joined, instead of the
+where clause
+*/
+func (t *Table) AddJoinWhereGroup(group sql.Sqlizer) {
+	t.JoinWheres = append(t.JoinWheres, group)
+}
+
 /*
 AddMultitenancyWhere creates a multitenancy WHERE condition
 */
@@ -78,6 +152,36 @@ func (t *Table) AddMultitenancyWhere(column string, val interface{}) {
 	}
 }
 
+/*
+MultiTenancyWhere returns the multitenancy condition, comparing the column with LIKE when its value is a
+MultitenancyPrefix. It returns nil when the table has no multitenancy condition.
+*/
+func (t *Table) MultiTenancyWhere() sql.Sqlizer {
+	if t.MultiTenancy == nil {
+		return nil
+	}
+	for column, val := range t.MultiTenancy {
+		return MultitenancyWhere(column, val)
+	}
+	return nil
+}
+
+/*
+MultitenancyWhere compares a multitenancy column with a value, with LIKE when the value is a MultitenancyPrefix,
+for queries that aren't built from a Table
+*/
+func MultitenancyWhere(column string, val interface{}) sql.Sqlizer {
+	if prefix, ok := val.(MultitenancyPrefix); ok {
+		return sql.Expr(column+" LIKE ? || '%'", escapeLike(string(prefix)))
+	}
+	return sql.Eq{column: val}
+}
+
+// escapeLike escapes the LIKE wildcards in a value, so it only matches itself
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
 /*
 AppendJoin adds a join with the proper aliasing, including any columns requested
 from that table
@@ -134,8 +238,15 @@ func (t *Table) Columns() []string {
 	cols := make([]string, 0, len(t.columns))
 
 	for _, col := range t.columns {
+		if col == XminColumn {
+			cols = append(cols, fmt.Sprintf(aliasedXmin, t.Alias))
+			continue
+		}
 		cols = append(cols, fmt.Sprintf(aliasedCol, t.Alias, col))
 	}
+	for _, joined := range t.joinedCols {
+		cols = append(cols, fmt.Sprintf("%s.%s AS \"%s.%s\"", joined.joinAlias, joined.column, t.Alias, joined.key))
+	}
 
 	return cols
 }
@@ -153,6 +264,22 @@ func (t *Table) FieldAliases() map[string]FieldDescriptor {
 			Column:  col,
 		}
 	}
+	for _, joined := range t.joinedCols {
+		aliasMap[fmt.Sprintf(AliasedField, t.Alias, joined.key)] = FieldDescriptor{
+			Alias:   t.Alias,
+			RefPath: t.RefPath,
+			Table:   t.Name,
+			Column:  joined.key,
+		}
+	}
+	for _, key := range t.computedCols {
+		aliasMap[fmt.Sprintf(AliasedField, t.Alias, key)] = FieldDescriptor{
+			Alias:   t.Alias,
+			RefPath: t.RefPath,
+			Table:   t.Name,
+			Column:  key,
+		}
+	}
 
 	for _, join := range t.Joins {
 		jmap := join.Table.FieldAliases()
@@ -164,6 +291,22 @@ func (t *Table) FieldAliases() map[string]FieldDescriptor {
 	return aliasMap
 }
 
+/*
+JoinedTable returns the table joined through the foreign key path refPath, searching the joins of joined tables
+as well. It returns nil if no table was joined through that path.
+*/
+func (t *Table) JoinedTable(refPath string) *Table {
+	for _, join := range t.Joins {
+		if join.Table.RefPath == refPath {
+			return join.Table
+		}
+		if joined := join.Table.JoinedTable(refPath); joined != nil {
+			return joined
+		}
+	}
+	return nil
+}
+
 /*
 ToSQL returns the SQL statement, as it currently stands.
 */
@@ -181,7 +324,7 @@ func (t *Table) BuildSQL() sql.SelectBuilder {
 		From(fmt.Sprintf("%s AS %s", t.Name, t.Alias))
 
 	if t.MultiTenancy != nil {
-		bld = bld.Where(t.MultiTenancy)
+		bld = bld.Where(t.MultiTenancyWhere())
 	}
 
 	for _, where := range t.Wheres {
@@ -204,7 +347,26 @@ func (t *Table) DeleteSQL() sql.DeleteBuilder {
 		PlaceholderFormat(sql.Dollar)
 
 	if t.MultiTenancy != nil {
-		bld = bld.Where(t.MultiTenancy)
+		bld = bld.Where(t.MultiTenancyWhere())
+	}
+
+	for _, where := range t.Wheres {
+		bld = bld.Where(where)
+	}
+
+	return bld
+}
+
+/*
+UpdateSQL returns a squirrel UpdateBuilder, which can be used to execute the query
+or to just add more to the query
+*/
+func (t *Table) UpdateSQL() sql.UpdateBuilder {
+	bld := sql.Update(fmt.Sprintf("%s AS %s", t.Name, t.Alias)).
+		PlaceholderFormat(sql.Dollar)
+
+	if t.MultiTenancy != nil {
+		bld = bld.Where(t.MultiTenancyWhere())
 	}
 
 	for _, where := range t.Wheres {
@@ -219,12 +381,13 @@ func sqlizeJoin(bld sql.SelectBuilder, join Join) sql.SelectBuilder {
 	bld = bld.Columns(join.Columns()...)
 
 	jc := sql.Sqlizer(sql.Expr(fmt.Sprintf(AliasedField, join.Table.Alias, join.JoinField) + " = " + fmt.Sprintf(AliasedField, join.Parent.Alias, join.ParentField)))
+	conditions := sql.And{jc}
 	if join.Table.MultiTenancy != nil {
-		where := join.Table.MultiTenancy
-		jc = sql.And{
-			jc,
-			where,
-		}
+		conditions = append(conditions, join.Table.MultiTenancyWhere())
+	}
+	conditions = append(conditions, join.Table.JoinWheres...)
+	if len(conditions) > 1 {
+		jc = conditions
 	}
 
 	switch strings.ToLower(join.Type) {
@@ -0,0 +1,116 @@
+package picard
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/ranges"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type availabilityWindow struct {
+	Metadata metadata.Metadata `picard:"tablename=availability_window"`
+
+	ID             string           `picard:"primary_key,column=id"`
+	OrganizationID string           `picard:"multitenancy_key,column=organization_id"`
+	Available      ranges.TimeRange `picard:"range=tstzrange,column=available"`
+}
+
+func TestCreateModelRange(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	start := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 3, 1, 17, 0, 0, 0, time.UTC)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO availability_window (organization_id,available) VALUES ($1,$2) RETURNING "id"
+	`)).
+		WithArgs(orgID, `["2021-03-01T09:00:00Z","2021-03-01T17:00:00Z")`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("00000000-0000-0000-0000-000000000002"))
+	mock.ExpectCommit()
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+		performedBy:       sampleUserID,
+	}
+	assert.NoError(t, p.CreateModel(&availabilityWindow{
+		Available: ranges.NewTimeRange(start, end),
+	}))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestFilterModelRange(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	point := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	start := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 3, 1, 17, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		description string
+		giveFilter  tags.FieldFilter
+		wantWhere   string
+	}{
+		{
+			"should filter ranges that contain a point",
+			tags.FieldFilter{FieldName: "Available", FilterOperator: "@>", FilterValue: point},
+			"t0.available @> $2::timestamptz",
+		},
+		{
+			"should filter ranges that overlap a range",
+			tags.FieldFilter{FieldName: "Available", FilterOperator: "&&", FilterValue: ranges.NewTimeRange(start, end)},
+			"t0.available && $2::tstzrange",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			mock.ExpectQuery(testdata.FmtSQLRegex(`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.available AS "t0.available"
+				FROM availability_window AS t0
+				WHERE t0.organization_id = $1 AND `+tc.wantWhere+`
+			`)).
+				WithArgs(orgID, sqlmock.AnyArg()).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.available"}).
+						AddRow("00000000-0000-0000-0000-000000000002", orgID, []byte(`["2021-03-01 09:00:00+00","2021-03-01 17:00:00+00")`)),
+				)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel:  availabilityWindow{},
+				FieldFilters: tc.giveFilter,
+			})
+			assert.NoError(t, err)
+			if assert.Len(t, results, 1) {
+				window := results[0].(availabilityWindow)
+				assert.True(t, start.Equal(*window.Available.Lower))
+				assert.True(t, end.Equal(*window.Available.Upper))
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Package ranges maps Postgres range columns, like tstzrange and int4range, to Go structs
+
+Fields of these types are tagged with `range`, and can be filtered with the `@>` and `&&` operators of a
+tags.FieldFilter.
+*/
+package ranges
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TimeRange holds a tstzrange, tsrange or daterange value. A nil bound is unbounded. Bounds are inclusive
+// below and exclusive above unless LowerExclusive or UpperInclusive are set, matching Postgres' default.
+// The zero value is stored as NULL, and NULL is read as the zero value.
+type TimeRange struct {
+	Lower          *time.Time
+	Upper          *time.Time
+	LowerExclusive bool
+	UpperInclusive bool
+	// Empty is set for the empty range, which contains no points
+	Empty bool
+}
+
+// NewTimeRange returns the range from lower, inclusive, to upper, exclusive
+func NewTimeRange(lower, upper time.Time) TimeRange {
+	return TimeRange{Lower: &lower, Upper: &upper}
+}
+
+// Value implements driver.Valuer
+func (r TimeRange) Value() (driver.Value, error) {
+	if r == (TimeRange{}) {
+		return nil, nil
+	}
+	return formatRange(r.Empty, r.LowerExclusive, r.UpperInclusive, formatTimeBound(r.Lower), formatTimeBound(r.Upper)), nil
+}
+
+// Scan implements sql.Scanner
+func (r *TimeRange) Scan(src interface{}) error {
+	text, isNull, err := rangeText(src)
+	if err != nil || isNull {
+		*r = TimeRange{}
+		return err
+	}
+	parsed, err := parseRange(text)
+	if err != nil {
+		return err
+	}
+	lower, err := parseTimeBound(parsed.lower)
+	if err != nil {
+		return err
+	}
+	upper, err := parseTimeBound(parsed.upper)
+	if err != nil {
+		return err
+	}
+	*r = TimeRange{
+		Lower:          lower,
+		Upper:          upper,
+		LowerExclusive: parsed.lowerExclusive,
+		UpperInclusive: parsed.upperInclusive,
+		Empty:          parsed.empty,
+	}
+	return nil
+}
+
+// IntRange holds an int4range or int8range value. A nil bound is unbounded. Bounds are inclusive below and
+// exclusive above unless LowerExclusive or UpperInclusive are set, and Postgres always returns integer ranges
+// in that form. The zero value is stored as NULL, and NULL is read as the zero value.
+type IntRange struct {
+	Lower          *int64
+	Upper          *int64
+	LowerExclusive bool
+	UpperInclusive bool
+	// Empty is set for the empty range, which contains no points
+	Empty bool
+}
+
+// NewIntRange returns the range from lower, inclusive, to upper, exclusive
+func NewIntRange(lower, upper int64) IntRange {
+	return IntRange{Lower: &lower, Upper: &upper}
+}
+
+// Value implements driver.Valuer
+func (r IntRange) Value() (driver.Value, error) {
+	if r == (IntRange{}) {
+		return nil, nil
+	}
+	return formatRange(r.Empty, r.LowerExclusive, r.UpperInclusive, formatIntBound(r.Lower), formatIntBound(r.Upper)), nil
+}
+
+// Scan implements sql.Scanner
+func (r *IntRange) Scan(src interface{}) error {
+	text, isNull, err := rangeText(src)
+	if err != nil || isNull {
+		*r = IntRange{}
+		return err
+	}
+	parsed, err := parseRange(text)
+	if err != nil {
+		return err
+	}
+	lower, err := parseIntBound(parsed.lower)
+	if err != nil {
+		return err
+	}
+	upper, err := parseIntBound(parsed.upper)
+	if err != nil {
+		return err
+	}
+	*r = IntRange{
+		Lower:          lower,
+		Upper:          upper,
+		LowerExclusive: parsed.lowerExclusive,
+		UpperInclusive: parsed.upperInclusive,
+		Empty:          parsed.empty,
+	}
+	return nil
+}
+
+// ElementType returns the type of the points in a range type, like timestamptz for tstzrange, or an empty
+// string for a type that isn't a built in range type
+func ElementType(rangeType string) string {
+	switch strings.ToLower(rangeType) {
+	case "tstzrange":
+		return "timestamptz"
+	case "tsrange":
+		return "timestamp"
+	case "daterange":
+		return "date"
+	case "int4range":
+		return "integer"
+	case "int8range":
+		return "bigint"
+	case "numrange":
+		return "numeric"
+	}
+	return ""
+}
+
+func formatRange(empty, lowerExclusive, upperInclusive bool, lower, upper string) string {
+	if empty {
+		return "empty"
+	}
+	lowerBracket, upperBracket := "[", ")"
+	if lowerExclusive {
+		lowerBracket = "("
+	}
+	if upperInclusive {
+		upperBracket = "]"
+	}
+	return lowerBracket + lower + "," + upper + upperBracket
+}
+
+func formatTimeBound(bound *time.Time) string {
+	if bound == nil {
+		return ""
+	}
+	return strconv.Quote(bound.Format(time.RFC3339Nano))
+}
+
+func formatIntBound(bound *int64) string {
+	if bound == nil {
+		return ""
+	}
+	return strconv.FormatInt(*bound, 10)
+}
+
+func parseTimeBound(bound *string) (*time.Time, error) {
+	if bound == nil {
+		return nil, nil
+	}
+	parsed, err := pq.ParseTimestamp(nil, *bound)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func parseIntBound(bound *string) (*int64, error) {
+	if bound == nil {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseInt(*bound, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// rangeText returns the text of a range read from the database
+func rangeText(src interface{}) (string, bool, error) {
+	switch src := src.(type) {
+	case nil:
+		return "", true, nil
+	case string:
+		return src, false, nil
+	case []byte:
+		return string(src), false, nil
+	}
+	return "", false, fmt.Errorf("cannot scan %T into a range", src)
+}
+
+type parsedRange struct {
+	lower          *string
+	upper          *string
+	lowerExclusive bool
+	upperInclusive bool
+	empty          bool
+}
+
+// parseRange splits the text form of a range, like `["2021-01-01 00:00:00+00",)`, into its bounds. Missing
+// bounds are returned as nil.
+func parseRange(text string) (parsedRange, error) {
+	text = strings.TrimSpace(text)
+	if strings.EqualFold(text, "empty") {
+		return parsedRange{empty: true}, nil
+	}
+	if len(text) < 3 || !strings.ContainsRune("[(", rune(text[0])) || !strings.ContainsRune("])", rune(text[len(text)-1])) {
+		return parsedRange{}, fmt.Errorf("malformed range '%s'", text)
+	}
+
+	bounds, err := splitBounds(text[1 : len(text)-1])
+	if err != nil {
+		return parsedRange{}, fmt.Errorf("malformed range '%s': %s", text, err)
+	}
+	return parsedRange{
+		lower:          bounds[0],
+		upper:          bounds[1],
+		lowerExclusive: text[0] == '(',
+		upperInclusive: text[len(text)-1] == ']',
+	}, nil
+}
+
+// splitBounds splits the inside of a range on its comma, unquoting the bounds
+func splitBounds(text string) ([2]*string, error) {
+	bounds := [2]*string{}
+	index := 0
+	var bound strings.Builder
+	hasBound, quoted := false, false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			i++
+			bound.WriteByte(text[i])
+			hasBound = true
+		case c == '"' && quoted && i+1 < len(text) && text[i+1] == '"':
+			i++
+			bound.WriteByte('"')
+		case c == '"':
+			quoted = !quoted
+			hasBound = true
+		case c == ',' && !quoted:
+			if index > 0 {
+				return bounds, errors.New("too many bounds")
+			}
+			if hasBound {
+				value := bound.String()
+				bounds[index] = &value
+			}
+			bound.Reset()
+			hasBound = false
+			index++
+		default:
+			bound.WriteByte(c)
+			hasBound = true
+		}
+	}
+	if quoted || index != 1 {
+		return bounds, errors.New("expected two bounds")
+	}
+	if hasBound {
+		value := bound.String()
+		bounds[index] = &value
+	}
+	return bounds, nil
+}
@@ -0,0 +1,103 @@
+package ranges
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeRangeValue(t *testing.T) {
+	start := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 3, 1, 17, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		description string
+		giveRange   TimeRange
+		wantValue   driver.Value
+	}{
+		{"should format bounded ranges", NewTimeRange(start, end), `["2021-03-01T09:00:00Z","2021-03-01T17:00:00Z")`},
+		{"should format unbounded and inclusive bounds", TimeRange{Lower: &start, LowerExclusive: true, UpperInclusive: true}, `("2021-03-01T09:00:00Z",]`},
+		{"should format empty ranges", TimeRange{Empty: true}, "empty"},
+		{"should store the zero value as null", TimeRange{}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			value, err := tc.giveRange.Value()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantValue, value)
+		})
+	}
+}
+
+func TestTimeRangeScan(t *testing.T) {
+	start := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 3, 1, 17, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		description string
+		giveSrc     interface{}
+		wantRange   TimeRange
+		wantErr     string
+	}{
+		{
+			"should scan quoted bounds",
+			[]byte(`["2021-03-01 09:00:00+00","2021-03-01 17:00:00+00")`),
+			NewTimeRange(start, end),
+			"",
+		},
+		{
+			"should scan unbounded and inclusive bounds",
+			`(,"2021-03-01 17:00:00+00"]`,
+			TimeRange{Upper: &end, LowerExclusive: true, UpperInclusive: true},
+			"",
+		},
+		{"should scan empty ranges", "empty", TimeRange{Empty: true}, ""},
+		{"should scan null as the zero value", nil, TimeRange{}, ""},
+		{"should fail on malformed ranges", "2021-03-01", TimeRange{}, "malformed range '2021-03-01'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var r TimeRange
+			err := r.Scan(tc.giveSrc)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantRange.Empty, r.Empty)
+			assert.Equal(t, tc.wantRange.LowerExclusive, r.LowerExclusive)
+			assert.Equal(t, tc.wantRange.UpperInclusive, r.UpperInclusive)
+			assertTimeBound(t, tc.wantRange.Lower, r.Lower)
+			assertTimeBound(t, tc.wantRange.Upper, r.Upper)
+		})
+	}
+}
+
+func TestIntRange(t *testing.T) {
+	r := NewIntRange(1, 10)
+	value, err := r.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "[1,10)", value)
+
+	var scanned IntRange
+	assert.NoError(t, scanned.Scan([]byte("[1,10)")))
+	assert.Equal(t, r, scanned)
+
+	assert.NoError(t, scanned.Scan("[5,)"))
+	assert.Equal(t, int64(5), *scanned.Lower)
+	assert.Nil(t, scanned.Upper)
+}
+
+func assertTimeBound(t *testing.T, want, got *time.Time) {
+	if want == nil {
+		assert.Nil(t, got)
+		return
+	}
+	if assert.NotNil(t, got) {
+		assert.True(t, want.Equal(*got), "expected %s, got %s", want, got)
+	}
+}
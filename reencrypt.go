@@ -0,0 +1,209 @@
+package picard
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/skuid/picard/crypto"
+	"github.com/skuid/picard/stringutil"
+	"github.com/skuid/picard/tags"
+)
+
+/*
+ReencryptModel re-encrypts the encrypted columns of every row in the model's table. Values are read as
+the stored ciphertext, decrypted with oldKey and encrypted again with the current key, which is the one
+set with crypto.SetCurrentEncryptionKey or else crypto.SetEncryptionKey. Fields tagged with
+encrypted=deterministic are encrypted deterministically. Soft-deleted rows are included.
+
+Rows are processed in batches ordered by primary key, and each batch is updated in its own transaction,
+so a failure leaves earlier batches re-encrypted. If a transaction was started with StartTransaction, every
+batch runs in that transaction instead. Returns the number of rows that were re-encrypted.
+*/
+func (p PersistenceORM) ReencryptModel(model interface{}, oldKey []byte) (int64, error) {
+	val, err := stringutil.GetStructValue(model)
+	if err != nil {
+		return 0, err
+	}
+
+	tableMetadata := tags.TableMetadataFromType(val.Type())
+	if err := checkWritable(tableMetadata); err != nil {
+		return 0, err
+	}
+	if len(tableMetadata.GetPrimaryKeyColumnNames()) == 0 {
+		return 0, fmt.Errorf("missing 'primary_key' tag on type '%v'", val.Type())
+	}
+	if len(tableMetadata.GetEncryptedColumns()) == 0 {
+		return 0, fmt.Errorf("no 'encrypted' fields defined on type '%v'", val.Type())
+	}
+	if p.batchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", p.batchSize)
+	}
+
+	var count int64
+	var lastPrimaryKey map[string]interface{}
+	for {
+		rowsRead, rowsUpdated, batchLastPrimaryKey, err := p.reencryptBatch(tableMetadata, oldKey, lastPrimaryKey)
+		if err != nil {
+			return count, err
+		}
+		count += rowsUpdated
+		if rowsRead < p.batchSize {
+			return count, nil
+		}
+		lastPrimaryKey = batchLastPrimaryKey
+	}
+}
+
+// reencryptBatch re-encrypts the next batch of rows after the row of lastPrimaryKey, returning how many rows
+// it read, how many it updated and the last one, whose primary key columns the next batch starts after
+func (p PersistenceORM) reencryptBatch(tableMetadata *tags.TableMetadata, oldKey []byte, lastPrimaryKey map[string]interface{}) (int, int64, map[string]interface{}, error) {
+	tableName := tableMetadata.GetTableName()
+	primaryKeyColumnNames := tableMetadata.GetPrimaryKeyColumnNames()
+	multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName()
+	encryptedColumns := tableMetadata.GetEncryptedColumns()
+	deterministicColumns := tableMetadata.GetDeterministicColumns()
+
+	if p.transaction == nil {
+		tx, err := GetConnection().Begin()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		p.transaction = tx
+		defer p.Commit()
+	}
+
+	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+
+	selectQuery := psql.Select(append(append([]string{}, primaryKeyColumnNames...), encryptedColumns...)...).
+		From(tableName)
+	if multitenancyKeyColumnName != "" {
+		selectQuery = selectQuery.Where(squirrel.Eq{multitenancyKeyColumnName: p.multitenancyValue})
+	}
+	if lastPrimaryKey != nil {
+		selectQuery = selectQuery.Where(primaryKeyAfter(primaryKeyColumnNames, lastPrimaryKey))
+	}
+	selectQuery = selectQuery.
+		OrderBy(strings.Join(primaryKeyColumnNames, ", ")).
+		Suffix(fmt.Sprintf("LIMIT %d FOR UPDATE", p.batchSize))
+
+	rows, err := selectQuery.RunWith(p.transaction).Query()
+	if err != nil {
+		p.Rollback()
+		q, _, _ := selectQuery.ToSql()
+		return 0, 0, nil, newQueryError(err, q)
+	}
+
+	results, err := getQueryResults(rows)
+	if err != nil {
+		p.Rollback()
+		return 0, 0, nil, err
+	}
+
+	var rowsUpdated int64
+	for _, result := range results {
+		updateQuery := psql.Update(tableName)
+		hasValues := false
+		for _, column := range encryptedColumns {
+			value, err := reencryptValue(result[column], oldKey, stringutil.StringSliceContainsKey(deterministicColumns, column))
+			if err != nil {
+				p.Rollback()
+				return 0, 0, nil, fmt.Errorf("re-encrypting column '%s' of '%v': %w", column, primaryKeyDescription(primaryKeyColumnNames, result), err)
+			}
+			if value == nil {
+				continue
+			}
+			updateQuery = updateQuery.Set(column, value)
+			hasValues = true
+		}
+		if !hasValues {
+			continue
+		}
+
+		if multitenancyKeyColumnName != "" {
+			updateQuery = updateQuery.Where(squirrel.Eq{multitenancyKeyColumnName: p.multitenancyValue})
+		}
+		updateQuery = updateQuery.Where(primaryKeyWhere(tableMetadata, result))
+
+		if _, err := updateQuery.RunWith(p.transaction).Exec(); err != nil {
+			p.Rollback()
+			q, _, _ := updateQuery.ToSql()
+			return 0, 0, nil, newQueryError(err, q)
+		}
+		rowsUpdated++
+	}
+
+	if len(results) == 0 {
+		return 0, 0, nil, nil
+	}
+	return len(results), rowsUpdated, results[len(results)-1], nil
+}
+
+// primaryKeyAfter returns the condition for rows whose primary key sorts after the row's. A composite key is
+// compared as a row value, so rows are paged on the whole key.
+func primaryKeyAfter(primaryKeyColumnNames []string, row map[string]interface{}) squirrel.Sqlizer {
+	if len(primaryKeyColumnNames) == 1 {
+		return squirrel.Gt{primaryKeyColumnNames[0]: row[primaryKeyColumnNames[0]]}
+	}
+	placeholders := []string{}
+	args := []interface{}{}
+	for _, columnName := range primaryKeyColumnNames {
+		placeholders = append(placeholders, "?")
+		args = append(args, row[columnName])
+	}
+	return squirrel.Expr(fmt.Sprintf("(%s) > (%s)", strings.Join(primaryKeyColumnNames, ", "), strings.Join(placeholders, ", ")), args...)
+}
+
+// primaryKeyDescription returns the row's primary key for error messages, with its values joined for a composite key
+func primaryKeyDescription(primaryKeyColumnNames []string, row map[string]interface{}) interface{} {
+	if len(primaryKeyColumnNames) == 1 {
+		return row[primaryKeyColumnNames[0]]
+	}
+	values := []string{}
+	for _, columnName := range primaryKeyColumnNames {
+		values = append(values, fmt.Sprint(row[columnName]))
+	}
+	return strings.Join(values, ", ")
+}
+
+// reencryptValue decrypts a stored base64 value with oldKey and encrypts it with the current key, deterministically
+// for columns tagged with encrypted=deterministic. Empty values are returned as nil so they are left untouched.
+func reencryptValue(value interface{}, oldKey []byte, deterministic bool) (interface{}, error) {
+	var encoded string
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		encoded = value
+	case []byte:
+		encoded = string(value)
+	default:
+		return nil, errors.New("can only decrypt values which are stored as base64 strings")
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("base64 decoding of value failed")
+	}
+
+	plaintext, err := crypto.DecryptBytesWithKey(ciphertext, oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if deterministic {
+		return tags.EncryptDeterministic(plaintext)
+	}
+
+	encrypted, err := crypto.EncryptBytes(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
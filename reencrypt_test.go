@@ -0,0 +1,220 @@
+package picard
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/crypto"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+// decryptsTo matches base64 ciphertext that decrypts to plaintext under the current encryption key
+type decryptsTo string
+
+func (d decryptsTo) Match(v driver.Value) bool {
+	encoded, ok := v.(string)
+	if !ok {
+		return false
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	plaintext, err := crypto.DecryptBytes(ciphertext)
+	return err == nil && string(plaintext) == string(d)
+}
+
+type compositeSecretModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=compositesecret"`
+	AccountID      string            `picard:"primary_key,column=account_id"`
+	Name           string            `picard:"primary_key,column=name"`
+	OrganizationID string            `picard:"multitenancy_key,column=organization_id"`
+	Secret         string            `picard:"encrypted,column=secret"`
+}
+
+func TestReencryptModel(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	oldKey := []byte("the-key-has-to-be-32-bytes-long!")
+	newKey := []byte("the-key-really-is-32-bytes-long!")
+
+	encryptWithOldKey := func(plaintext string) string {
+		crypto.SetEncryptionKey(oldKey)
+		encrypted, err := crypto.EncryptBytes([]byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(encrypted)
+	}
+	firstSecret := encryptWithOldKey("first secret")
+	thirdSecret := encryptWithOldKey("third secret")
+
+	testCases := []struct {
+		description         string
+		giveModel           interface{}
+		expectationFunction func(sqlmock.Sqlmock)
+		wantCount           int64
+		wantErr             string
+	}{
+		{
+			"re-encrypts every row in batches",
+			secretModel{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT id, secret FROM secretmodel
+					WHERE organization_id = $1
+					ORDER BY id LIMIT 2 FOR UPDATE
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "secret"}).
+							AddRow("00000000-0000-0000-0000-000000000002", firstSecret).
+							AddRow("00000000-0000-0000-0000-000000000003", nil),
+					)
+				mock.ExpectExec(`^UPDATE secretmodel SET secret = \$1 WHERE organization_id = \$2 AND id = \$3$`).
+					WithArgs(decryptsTo("first secret"), orgID, "00000000-0000-0000-0000-000000000002").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT id, secret FROM secretmodel
+					WHERE organization_id = $1 AND id > $2
+					ORDER BY id LIMIT 2 FOR UPDATE
+				`)).
+					WithArgs(orgID, "00000000-0000-0000-0000-000000000003").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "secret"}).
+							AddRow("00000000-0000-0000-0000-000000000004", thirdSecret),
+					)
+				mock.ExpectExec(`^UPDATE secretmodel SET secret = \$1 WHERE organization_id = \$2 AND id = \$3$`).
+					WithArgs(decryptsTo("third secret"), orgID, "00000000-0000-0000-0000-000000000004").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			2,
+			"",
+		},
+		{
+			"pages on every column of a composite primary key",
+			compositeSecretModel{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT account_id, name, secret FROM compositesecret
+					WHERE organization_id = $1
+					ORDER BY account_id, name LIMIT 2 FOR UPDATE
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"account_id", "name", "secret"}).
+							AddRow("account1", "first", firstSecret).
+							AddRow("account1", "second", nil),
+					)
+				mock.ExpectExec(`^UPDATE compositesecret SET secret = \$1 WHERE organization_id = \$2 AND \(account_id = \$3 AND name = \$4\)$`).
+					WithArgs(decryptsTo("first secret"), orgID, "account1", "first").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT account_id, name, secret FROM compositesecret
+					WHERE organization_id = $1 AND (account_id, name) > ($2, $3)
+					ORDER BY account_id, name LIMIT 2 FOR UPDATE
+				`)).
+					WithArgs(orgID, "account1", "second").
+					WillReturnRows(sqlmock.NewRows([]string{"account_id", "name", "secret"}))
+				mock.ExpectCommit()
+			},
+			1,
+			"",
+		},
+		{
+			"rolls back the batch when a value can't be decrypted with the old key",
+			secretModel{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT id, secret FROM secretmodel
+					WHERE organization_id = $1
+					ORDER BY id LIMIT 2 FOR UPDATE
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "secret"}).
+							AddRow("00000000-0000-0000-0000-000000000002", base64.StdEncoding.EncodeToString([]byte("not encrypted with the old key"))),
+					)
+				mock.ExpectRollback()
+			},
+			0,
+			"re-encrypting column 'secret' of '00000000-0000-0000-0000-000000000002': cipher: message authentication failed",
+		},
+		{
+			"rolls back the batch when an update fails",
+			secretModel{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT id, secret FROM secretmodel
+					WHERE organization_id = $1
+					ORDER BY id LIMIT 2 FOR UPDATE
+				`)).
+					WithArgs(orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"id", "secret"}).
+							AddRow("00000000-0000-0000-0000-000000000002", firstSecret),
+					)
+				mock.ExpectExec(`^UPDATE secretmodel SET secret = \$1 WHERE organization_id = \$2 AND id = \$3$`).
+					WillReturnError(errors.New("some error"))
+				mock.ExpectRollback()
+			},
+			0,
+			"some error",
+		},
+		{
+			"errors for a model without encrypted fields",
+			testdata.ToyModel{},
+			func(mock sqlmock.Sqlmock) {},
+			0,
+			"no 'encrypted' fields defined on type 'testdata.ToyModel'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			crypto.SetEncryptionKey(newKey)
+
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+				batchSize:         2,
+			}
+
+			count, err := p.ReencryptModel(tc.giveModel, oldKey)
+
+			if tc.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantCount, count)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package picard
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ReadConsistency decides where a read without a Runner is run
+type ReadConsistency int
+
+const (
+	// ReadDefault uses the ORM's read consistency, which is ReadStrong unless it was changed with
+	// WithReadConsistency. This is the default.
+	ReadDefault ReadConsistency = iota
+	// ReadStrong runs the read on the primary connection
+	ReadStrong
+	// ReadEventual runs the read on the replica connection set with SetReplicaConnection, unless the replica
+	// lags the primary by more than the ORM's maximum replica lag, or no replica is set
+	ReadEventual
+)
+
+// DefaultReplicaMaxLag is how far a replica may lag the primary before eventual reads fall back to the primary,
+// unless the ORM sets its own with WithReplicaMaxLag
+const DefaultReplicaMaxLag = 5 * time.Second
+
+// replicaLagQuery returns the seconds since the last transaction replayed on a replica, or 0 on a primary. A replica
+// that has replayed all of the WAL it received is caught up, however long ago its last transaction was, so it
+// returns 0 too.
+const replicaLagQuery = "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 " +
+	"ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END"
+
+// replicaLagCacheDuration is how long a replica's measured lag is reused before eventual reads measure it again
+const replicaLagCacheDuration = time.Second
+
+// replicaLagCache holds the last lag measured on the replica connection
+var replicaLagCache struct {
+	sync.Mutex
+	replica    *sql.DB
+	lag        time.Duration
+	measuredAt time.Time
+}
+
+/*
+WithReplicaMaxLag returns a copy of the ORM that runs eventual reads on the replica only while it lags the
+primary by at most maxLag. The lag is the time since the replica last replayed a transaction, unless it has
+replayed everything it received from the primary, so an idle primary doesn't make a caught up replica look stale.
+It is measured before an eventual read, and reused by the reads in the following second.
+
+Example:
+
+	picard.SetReplicaConnection(replicaDB)
+
+	dashboardORM := picard.New(orgID, userID).WithReplicaMaxLag(30 * time.Second)
+	results, err := dashboardORM.FilterModel(picard.FilterRequest{
+		FilterModel: TableA{},
+		Consistency: picard.ReadEventual,
+	})
+*/
+func (p PersistenceORM) WithReplicaMaxLag(maxLag time.Duration) ORM {
+	p.replicaMaxLag = maxLag
+	return &p
+}
+
+/*
+WithReadConsistency returns a copy of the ORM that uses consistency for reads whose request leaves Consistency
+unset. With ReadEventual, FilterModel, FilterModelStream and AggregateModel read from the replica connection set
+with SetReplicaConnection, while writes like Deploy, SaveModel and DeleteModel always use the primary. While the
+ORM has a transaction in progress, reads stay on the primary, so they don't miss data the transaction depends on.
+
+Example:
+
+	picard.SetReplicaConnection(replicaDB)
+
+	reportORM := picard.New(orgID, userID).WithReadConsistency(picard.ReadEventual)
+	results, err := reportORM.FilterModel(picard.FilterRequest{
+		FilterModel: TableA{},
+	})
+*/
+func (p PersistenceORM) WithReadConsistency(consistency ReadConsistency) ORM {
+	p.readConsistency = consistency
+	return &p
+}
+
+// readRunner returns the connection to run a read without a Runner on. Eventual reads use the replica if it
+// is fresh enough and the ORM has no transaction in progress, and anything else, including a failed lag check,
+// uses the primary.
+func (p PersistenceORM) readRunner(consistency ReadConsistency) sq.BaseRunner {
+	if consistency == ReadDefault {
+		consistency = p.readConsistency
+	}
+	replica := GetReplicaConnection()
+	if consistency != ReadEventual || replica == nil || p.transaction != nil {
+		return GetConnection()
+	}
+
+	maxLag := p.replicaMaxLag
+	if maxLag == 0 {
+		maxLag = DefaultReplicaMaxLag
+	}
+	lag, err := replicaLag(replica)
+	if err != nil || lag > maxLag {
+		return GetConnection()
+	}
+	return replica
+}
+
+// replicaLag returns how far behind the primary a replica is, measuring it again once the cached lag is older than
+// replicaLagCacheDuration. Failed measurements aren't cached.
+func replicaLag(replica *sql.DB) (time.Duration, error) {
+	replicaLagCache.Lock()
+	defer replicaLagCache.Unlock()
+	if replicaLagCache.replica == replica && time.Since(replicaLagCache.measuredAt) < replicaLagCacheDuration {
+		return replicaLagCache.lag, nil
+	}
+
+	var seconds float64
+	if err := replica.QueryRow(replicaLagQuery).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	lag := time.Duration(seconds * float64(time.Second))
+	replicaLagCache.replica = replica
+	replicaLagCache.lag = lag
+	replicaLagCache.measuredAt = time.Now()
+	return lag, nil
+}
+
+// resetReplicaLag discards the cached lag, so the next eventual read measures it
+func resetReplicaLag() {
+	replicaLagCache.Lock()
+	defer replicaLagCache.Unlock()
+	replicaLagCache.replica = nil
+}
@@ -0,0 +1,296 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterModelReplicaConsistency(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	itemSQL := testdata.FmtSQLRegex(`
+		SELECT
+			t0.primary_key_column AS "t0.primary_key_column",
+			t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+			t0.test_column_one AS "t0.test_column_one"
+		FROM test_tablename AS t0
+		WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2
+	`)
+	lagSQL := testdata.FmtSQLRegex(replicaLagQuery)
+	expectItem := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(itemSQL).
+			WithArgs(orgID, "kayak").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one"}).
+					AddRow("00000000-0000-0000-0000-000000000001", orgID, "kayak"),
+			)
+	}
+
+	testCases := []struct {
+		description        string
+		giveConsistency    ReadConsistency
+		giveMaxLag         time.Duration
+		primaryExpectation func(sqlmock.Sqlmock)
+		replicaExpectation func(sqlmock.Sqlmock)
+	}{
+		{
+			"should read strong requests from the primary",
+			ReadStrong,
+			0,
+			expectItem,
+			func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			"should read eventual requests from a fresh replica",
+			ReadEventual,
+			0,
+			func(mock sqlmock.Sqlmock) {},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(lagSQL).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+				expectItem(mock)
+			},
+		},
+		{
+			"should fall back to the primary when the replica lags too far",
+			ReadEventual,
+			10 * time.Second,
+			expectItem,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(lagSQL).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(42.0))
+			},
+		},
+		{
+			"should fall back to the primary when the lag can't be checked",
+			ReadEventual,
+			0,
+			expectItem,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(lagSQL).WillReturnError(errors.New("replica unavailable"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			primary, primaryMock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			replica, replicaMock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = primary
+			SetReplicaConnection(replica)
+			defer SetReplicaConnection(nil)
+
+			tc.primaryExpectation(primaryMock)
+			tc.replicaExpectation(replicaMock)
+
+			results, err := New(orgID, sampleUserID).WithReplicaMaxLag(tc.giveMaxLag).FilterModel(FilterRequest{
+				FilterModel: Item{TestFieldOne: "kayak"},
+				Consistency: tc.giveConsistency,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, results, 1)
+
+			if err := primaryMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations on the primary: %s", err)
+			}
+			if err := replicaMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations on the replica: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterModelReadConsistency(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000005"
+	itemSQL := testdata.FmtSQLRegex(`
+		SELECT
+			t0.primary_key_column AS "t0.primary_key_column",
+			t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+			t0.test_column_one AS "t0.test_column_one"
+		FROM test_tablename AS t0
+		WHERE t0.multitenancy_key_column = $1 AND t0.test_column_one = $2
+	`)
+	lagSQL := testdata.FmtSQLRegex(replicaLagQuery)
+	expectItem := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(itemSQL).
+			WithArgs(orgID, "kayak").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one"}).
+					AddRow("00000000-0000-0000-0000-000000000001", orgID, "kayak"),
+			)
+	}
+
+	testCases := []struct {
+		description        string
+		giveConsistency    ReadConsistency
+		giveTransaction    bool
+		primaryExpectation func(sqlmock.Sqlmock)
+		replicaExpectation func(sqlmock.Sqlmock)
+	}{
+		{
+			"should read requests without a consistency from the replica",
+			ReadDefault,
+			false,
+			func(mock sqlmock.Sqlmock) {},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(lagSQL).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+				expectItem(mock)
+			},
+		},
+		{
+			"should read strong requests from the primary",
+			ReadStrong,
+			false,
+			expectItem,
+			func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			"should read from the primary while a transaction is in progress",
+			ReadDefault,
+			true,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectItem(mock)
+				mock.ExpectCommit()
+			},
+			func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			primary, primaryMock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			replica, replicaMock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = primary
+			SetReplicaConnection(replica)
+			defer SetReplicaConnection(nil)
+
+			tc.primaryExpectation(primaryMock)
+			tc.replicaExpectation(replicaMock)
+
+			p := New(orgID, sampleUserID).WithReadConsistency(ReadEventual)
+			if tc.giveTransaction {
+				_, err := p.StartTransaction()
+				assert.NoError(t, err)
+			}
+
+			results, err := p.FilterModel(FilterRequest{
+				FilterModel: Item{TestFieldOne: "kayak"},
+				Consistency: tc.giveConsistency,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, results, 1)
+
+			if tc.giveTransaction {
+				assert.NoError(t, p.Commit())
+			}
+
+			if err := primaryMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations on the primary: %s", err)
+			}
+			if err := replicaMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations on the replica: %s", err)
+			}
+		})
+	}
+}
+
+func TestDeleteModelsReadsKeysFromPrimary(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	weasleyID := "00000000-0000-0000-0000-000000000002"
+
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = primary
+	SetReplicaConnection(replica)
+	defer SetReplicaConnection(nil)
+
+	// The replica is fresh, so only the delete's own consistency keeps the lookup of its keys on the primary
+	replicaMock.ExpectQuery(testdata.FmtSQLRegex(replicaLagQuery)).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+	primaryMock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT t0.id AS "t0.id", t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id = $1 AND t0.name = $2
+	`)).
+		WithArgs(orgID, "Weasley").
+		WillReturnRows(sqlmock.NewRows([]string{"t0.id", "t0.name"}).AddRow(weasleyID, "Weasley"))
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectExec(testdata.FmtSQLRegex(`
+		DELETE FROM personmodel AS t0
+		WHERE t0.organization_id = $1 AND t0.name = $2 AND t0.id IN ($3)
+	`)).
+		WithArgs(orgID, "Weasley", weasleyID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectCommit()
+
+	p, err := PersistenceORM{
+		multitenancyValue: orgID,
+		readConsistency:   ReadEventual,
+	}.WithChunkedDeletes(2, false)
+	assert.NoError(t, err)
+
+	rowsAffected, err := p.DeleteModels(FilterRequest{
+		FilterModel: testdata.PersonModel{Name: "Weasley"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rowsAffected)
+	if err := primaryMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations on the primary: %s", err)
+	}
+	assert.Error(t, replicaMock.ExpectationsWereMet(), "the replica's lag shouldn't have been checked")
+}
+
+func TestReplicaLagIsCached(t *testing.T) {
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetReplicaConnection(replica)
+	defer SetReplicaConnection(nil)
+
+	lagSQL := testdata.FmtSQLRegex(replicaLagQuery)
+	replicaMock.ExpectQuery(lagSQL).WillReturnError(errors.New("replica unavailable"))
+	replicaMock.ExpectQuery(lagSQL).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+
+	// A failed measurement isn't cached
+	_, err = replicaLag(replica)
+	assert.EqualError(t, err, "replica unavailable")
+
+	for i := 0; i < 3; i++ {
+		lag, err := replicaLag(replica)
+		assert.NoError(t, err)
+		assert.Equal(t, 500*time.Millisecond, lag)
+	}
+
+	// Setting the replica again discards the cached lag
+	SetReplicaConnection(replica)
+	replicaMock.ExpectQuery(lagSQL).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.0))
+	lag, err := replicaLag(replica)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), lag)
+
+	if err := replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations on the replica: %s", err)
+	}
+}
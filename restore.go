@@ -0,0 +1,92 @@
+package picard
+
+import (
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/skuid/picard/query"
+	"github.com/skuid/picard/reflectutil"
+	"github.com/skuid/picard/stringutil"
+	"github.com/skuid/picard/tags"
+)
+
+// RestoreModel un-deletes a single soft-deleted model, identified by its primary key.
+// Returns ModelNotFoundError if no soft-deleted row matches.
+func (porm PersistenceORM) RestoreModel(model interface{}) error {
+	val, err := stringutil.GetStructValue(model)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := tags.GetTableMetadata(val.Interface())
+	if err != nil {
+		return err
+	}
+
+	pkField := metadata.GetPrimaryKeyFieldName()
+	if pkField == "" || reflectutil.IsZeroValue(val.FieldByName(pkField)) {
+		return errors.New("a primary key value is required to restore a model")
+	}
+
+	rowsAffected, err := porm.RestoreModels(FilterRequest{
+		FilterModel: val.Interface(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ModelNotFoundError
+	}
+
+	return nil
+}
+
+// RestoreModels un-deletes every soft-deleted model that matches the provided filter request,
+// ignoring zero values on the filter model. FieldFilters may be used for more complex conditions.
+// Returns the number of rows restored or an error.
+func (porm PersistenceORM) RestoreModels(request FilterRequest) (int64, error) {
+	val, err := stringutil.GetStructValue(request.FilterModel)
+	if err != nil {
+		return 0, err
+	}
+
+	metadata, err := tags.GetTableMetadata(val.Interface())
+	if err != nil {
+		return 0, err
+	}
+
+	softDeleteColumn := metadata.GetSoftDeleteColumnName()
+	if softDeleteColumn == "" {
+		return 0, fmt.Errorf("no 'soft_delete' field defined on type '%v'", val.Type())
+	}
+
+	tbl, err := query.Build(porm.multitenancyValue, val.Interface(), request.FieldFilters, nil, nil, metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	uSQL := tbl.UpdateSQL().
+		Set(softDeleteColumn, sq.Expr("NULL")).
+		Where(sq.NotEq{fmt.Sprintf("%s.%s", tbl.Alias, softDeleteColumn): nil})
+
+	if porm.transaction == nil {
+		tx, err := GetConnection().Begin()
+		if err != nil {
+			return 0, err
+		}
+
+		porm.transaction = tx
+		defer porm.Commit()
+	}
+
+	results, err := uSQL.RunWith(porm.transaction).Exec()
+	if err != nil {
+		porm.Rollback()
+		q, _, _ := uSQL.ToSql()
+		return 0, newQueryError(err, q)
+	}
+
+	return results.RowsAffected()
+}
@@ -0,0 +1,239 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type softDeleteModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField        string    `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string    `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string    `picard:"column=test_column_one"`
+	DeletedAt              time.Time `picard:"soft_delete,column=deleted_at"`
+}
+
+type noSoftDeleteModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField string `picard:"primary_key,column=primary_key_column"`
+}
+
+func TestRestoreModel(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description         string
+		giveModel           interface{}
+		expectationFunction func(sqlmock.Sqlmock)
+		wantErr             error
+	}{
+		{
+			"restores a single soft-deleted row by pk",
+			softDeleteModel{
+				PrimaryKeyField: "00000000-0000-0000-0000-000000000555",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE test_tablename AS t0 SET deleted_at = NULL
+					WHERE
+						t0.multitenancy_key_column = $1 AND
+						t0.primary_key_column = $2 AND
+						t0.deleted_at IS NOT NULL
+				`)).
+					WithArgs(
+						testMultitenancyValue,
+						"00000000-0000-0000-0000-000000000555",
+					).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			nil,
+		},
+		{
+			"returns ModelNotFoundError when no soft-deleted row matches",
+			&softDeleteModel{
+				PrimaryKeyField: "00000000-0000-0000-0000-000000000555",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE test_tablename AS t0 SET deleted_at = NULL
+					WHERE
+						t0.multitenancy_key_column = $1 AND
+						t0.primary_key_column = $2 AND
+						t0.deleted_at IS NOT NULL
+				`)).
+					WithArgs(
+						testMultitenancyValue,
+						"00000000-0000-0000-0000-000000000555",
+					).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			ModelNotFoundError,
+		},
+		{
+			"returns error without a primary key value",
+			softDeleteModel{
+				TestFieldOne: "test value 1",
+			},
+			func(mock sqlmock.Sqlmock) {},
+			errors.New("a primary key value is required to restore a model"),
+		},
+		{
+			"returns error when the model has no soft_delete field",
+			noSoftDeleteModel{
+				PrimaryKeyField: "00000000-0000-0000-0000-000000000555",
+			},
+			func(mock sqlmock.Sqlmock) {},
+			errors.New("no 'soft_delete' field defined on type 'picard.noSoftDeleteModel'"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetConnection(db)
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: testMultitenancyValue,
+			}
+
+			err = p.RestoreModel(tc.giveModel)
+
+			if tc.wantErr != nil {
+				assert.Equal(t, tc.wantErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestRestoreModels(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000001"
+	cutoff := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		description            string
+		giveRequest            FilterRequest
+		expectationFunction    func(sqlmock.Sqlmock)
+		wantReturnRowsAffected int64
+		wantErr                string
+	}{
+		{
+			"restores every soft-deleted row matching the filter model",
+			FilterRequest{
+				FilterModel: softDeleteModel{
+					TestFieldOne: "test value 1",
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE test_tablename AS t0 SET deleted_at = NULL
+					WHERE
+						t0.multitenancy_key_column = $1 AND
+						t0.test_column_one = $2 AND
+						t0.deleted_at IS NOT NULL
+				`)).
+					WithArgs(testMultitenancyValue, "test value 1").
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectCommit()
+			},
+			3,
+			"",
+		},
+		{
+			"restores rows matching field filters",
+			FilterRequest{
+				FilterModel: softDeleteModel{},
+				FieldFilters: tags.FieldFilter{
+					FieldName:      "DeletedAt",
+					FilterOperator: ">=",
+					FilterValue:    cutoff,
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE test_tablename AS t0 SET deleted_at = NULL
+					WHERE
+						t0.multitenancy_key_column = $1 AND
+						t0.deleted_at >= $2 AND
+						t0.deleted_at IS NOT NULL
+				`)).
+					WithArgs(testMultitenancyValue, cutoff).
+					WillReturnResult(sqlmock.NewResult(0, 12))
+				mock.ExpectCommit()
+			},
+			12,
+			"",
+		},
+		{
+			"returns error on Exec update statement",
+			FilterRequest{
+				FilterModel: softDeleteModel{},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE test_tablename AS t0 SET deleted_at = NULL
+					WHERE
+						t0.multitenancy_key_column = $1 AND
+						t0.deleted_at IS NOT NULL
+				`)).
+					WithArgs(testMultitenancyValue).
+					WillReturnError(errors.New("some test error"))
+				mock.ExpectRollback()
+			},
+			0,
+			"some test error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetConnection(db)
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: testMultitenancyValue,
+			}
+
+			rowsAffected, err := p.RestoreModels(tc.giveRequest)
+
+			if tc.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantReturnRowsAffected, rowsAffected)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -10,82 +10,147 @@ import (
 
 // SaveModel performs an upsert operation for the provided model.
 func (p PersistenceORM) SaveModel(model interface{}) error {
+	_, err := p.persistModel(model, false)
+	return err
+}
+
+/*
+SaveModelWithRowsAffected performs the same upsert as SaveModel, and also returns the number of rows it wrote. An
+insert writes 1 row. An update writes the row it matches, which Postgres counts even when none of its values
+change, so 1 means the row existed whether or not it was changed. It's 0 when the row was deleted after SaveModel
+found it, and ModelNotFoundError is still returned when it didn't exist to begin with.
+*/
+func (p PersistenceORM) SaveModelWithRowsAffected(model interface{}) (int64, error) {
 	return p.persistModel(model, false)
 }
 
 // CreateModel performs an insert operation for the provided model.
 func (p PersistenceORM) CreateModel(model interface{}) error {
-	return p.persistModel(model, true)
+	_, err := p.persistModel(model, true)
+	return err
 }
 
-// persistModel performs an upsert operation for the provided model.
-func (p PersistenceORM) persistModel(model interface{}, alwaysInsert bool) error {
+// persistModel performs an upsert operation for the provided model, and returns the number of rows it wrote.
+func (p PersistenceORM) persistModel(model interface{}, alwaysInsert bool) (int64, error) {
 	// This makes modelValue a reflect.Value of model whether model is a pointer or not.
 	modelValue := reflect.Indirect(reflect.ValueOf(model))
 	if modelValue.Kind() != reflect.Struct {
-		return errors.New("models must be structs")
+		return 0, errors.New("models must be structs")
+	}
+
+	tableMetadata := tags.TableMetadataFromType(modelValue.Type())
+	if err := checkWritable(tableMetadata); err != nil {
+		return 0, err
 	}
 
 	if p.transaction == nil {
 		tx, err := GetConnection().Begin()
 		if err != nil {
-			return err
+			return 0, err
 		}
 		p.transaction = tx
 		defer p.Commit()
 	}
 
-	tableMetadata := tags.TableMetadataFromType(modelValue.Type())
-	primaryKeyValue := modelValue.FieldByName(tableMetadata.GetPrimaryKeyFieldName()).Interface()
+	primaryKeyValues, hasPrimaryKey, hasPartialPrimaryKey := getPrimaryKeyValues(modelValue, tableMetadata)
 
-	if primaryKeyValue == nil || primaryKeyValue == "" || alwaysInsert {
-		if err := p.insertModel(modelValue, tableMetadata, primaryKeyValue); err != nil {
+	if !hasPrimaryKey || alwaysInsert {
+		if err := p.insertModel(modelValue, tableMetadata, hasPartialPrimaryKey); err != nil {
 			p.Rollback()
-			return err
-		}
-	} else {
-		// Non-Empty UUID: the model needs to update.
-		if err := p.updateModel(modelValue, tableMetadata, primaryKeyValue); err != nil {
-			p.Rollback()
-			return err
+			return 0, err
 		}
+		return 1, nil
+	}
+
+	// Non-Empty UUID: the model needs to update.
+	rowsAffected, err := p.updateModel(modelValue, tableMetadata, primaryKeyValues)
+	if err != nil {
+		p.Rollback()
+		return 0, err
 	}
+	return rowsAffected, nil
+}
 
-	return nil
+// getPrimaryKeyValues returns the model's primary key values by column name, whether every primary key
+// field is set, and whether any of them is set. They only differ for composite primary keys.
+func getPrimaryKeyValues(modelValue reflect.Value, tableMetadata *tags.TableMetadata) (map[string]interface{}, bool, bool) {
+	values := map[string]interface{}{}
+	setCount := 0
+	fieldNames := tableMetadata.GetPrimaryKeyFieldNames()
+	for _, fieldName := range fieldNames {
+		value := modelValue.FieldByName(fieldName).Interface()
+		values[tableMetadata.GetField(fieldName).GetColumnName()] = value
+		if !isEmptyKey(value) {
+			setCount++
+		}
+	}
+	return values, len(fieldNames) > 0 && setCount == len(fieldNames), setCount > 0
 }
 
-func (p PersistenceORM) updateModel(modelValue reflect.Value, tableMetadata *tags.TableMetadata, primaryKeyValue interface{}) error {
-	existingObject, err := p.getExistingObjectByID(tableMetadata, primaryKeyValue)
+func (p PersistenceORM) updateModel(modelValue reflect.Value, tableMetadata *tags.TableMetadata, primaryKeyValues map[string]interface{}) (int64, error) {
+	existingObject, err := p.getExistingObjectByID(tableMetadata, primaryKeyValues)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if existingObject == nil {
-		return ModelNotFoundError
+		return 0, ModelNotFoundError
 	}
-	change, err := p.processObject(modelValue, existingObject, nil, tableMetadata)
+	change, err := p.processObject(modelValue, existingObject, nil, tableMetadata, true)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return p.performUpdates([]dbchange.Change{change}, tableMetadata)
+	updates := []dbchange.Change{change}
+	rowsAffected, err := p.performUpdates(updates, tableMetadata)
+	if err != nil {
+		return 0, err
+	}
+	if updates[0].Conflict {
+		return 0, newVersionConflictError(updates, tableMetadata)
+	}
+	setReturnedValues(modelValue, change, tableMetadata)
+	return rowsAffected, callAfterSave(modelValue)
 }
 
-func (p PersistenceORM) insertModel(modelValue reflect.Value, tableMetadata *tags.TableMetadata, primaryKeyValue interface{}) error {
-	change, err := p.processObject(modelValue, nil, nil, tableMetadata)
+func (p PersistenceORM) insertModel(modelValue reflect.Value, tableMetadata *tags.TableMetadata, insertsHavePrimaryKey bool) error {
+	change, err := p.processObject(modelValue, nil, nil, tableMetadata, true)
 	if err != nil {
 		return err
 	}
-	insertsHavePrimaryKey := !(primaryKeyValue == nil || primaryKeyValue == "")
 	if err := p.performInserts([]dbchange.Change{change}, insertsHavePrimaryKey, tableMetadata); err != nil {
 		return err
 	}
 	setPrimaryKeyFromInsertResult(modelValue, change, tableMetadata)
-	return nil
+	setReturnedValues(modelValue, change, tableMetadata)
+	return callAfterSave(modelValue)
 }
 
 func setPrimaryKeyFromInsertResult(v reflect.Value, change dbchange.Change, tableMetadata *tags.TableMetadata) {
-	fieldName := tableMetadata.GetPrimaryKeyFieldName()
-	columnName := tableMetadata.GetPrimaryKeyColumnName()
-	if fieldName != "" {
-		v.FieldByName(fieldName).Set(reflect.ValueOf(change.Changes[columnName]))
+	for _, fieldName := range tableMetadata.GetPrimaryKeyFieldNames() {
+		columnName := tableMetadata.GetField(fieldName).GetColumnName()
+		setKeyValue(v.FieldByName(fieldName), change.Changes[columnName])
+	}
+}
+
+// setReturnedValues sets the values of columns tagged with returning and of the version column, as returned by the
+// database, on the model
+func setReturnedValues(v reflect.Value, change dbchange.Change, tableMetadata *tags.TableMetadata) {
+	for _, field := range tableMetadata.GetFields() {
+		if !field.IsReturning() && !field.IsVersion() {
+			continue
+		}
+		returnedValue, ok := change.Changes[field.GetColumnName()]
+		if !ok {
+			continue
+		}
+		modelField := v.FieldByName(field.GetName())
+		if !modelField.CanSet() {
+			continue
+		}
+		value := reflect.ValueOf(returnedValue)
+		if !value.IsValid() {
+			modelField.Set(reflect.Zero(modelField.Type()))
+		} else if value.Type().ConvertibleTo(modelField.Type()) {
+			modelField.Set(value.Convert(modelField.Type()))
+		}
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/skuid/picard/crypto"
@@ -594,6 +595,28 @@ func TestEncryptedSaveModel(t *testing.T) {
 			},
 			"",
 		},
+		{
+			"should encrypt the value of a pointer field",
+			&struct {
+				metadata.Metadata `picard:"tablename=test_tablename"`
+
+				PrimaryKeyField        string  `picard:"primary_key,column=primary_key_column"`
+				TestMultitenancyColumn string  `picard:"multitenancy_key,column=multitenancy_key_column"`
+				TestFieldOne           *string `picard:"encrypted,column=test_column_one"`
+			}{
+				TestFieldOne: func() *string { value := "test value one"; return &value }(),
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column"$`).
+					WithArgs("00000000-0000-0000-0000-000000000005", "MTIzNDEyMzQxMjM0jr1+eYgvzzj1Kl8w9Yrz7qDKxGXmqer4gTwJTDUi").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow("00000000-0000-0000-0000-000000000001"),
+					)
+				mock.ExpectCommit()
+			},
+			"",
+		},
 		{
 			"should run update with nil value when not doing partial update",
 			&struct {
@@ -876,3 +899,418 @@ func TestSetPrimaryKeyFromInsertResult(t *testing.T) {
 		})
 	}
 }
+
+type returningModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField        string    `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string    `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string    `picard:"column=test_column_one"`
+	Version                int       `picard:"column=version,returning"`
+	ModifiedAt             time.Time `picard:"column=modified_at,returning"`
+}
+
+func TestSaveModelReturning(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	testPrimaryKeyValue := "00000000-0000-0000-0000-000000000001"
+	modifiedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		description         string
+		giveValue           *returningModel
+		expectationFunction func(sqlmock.Sqlmock)
+		wantValue           *returningModel
+	}{
+		{
+			"sets returned columns on the model after an insert",
+			&returningModel{
+				TestFieldOne: "kayak",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column", "version", "modified_at"$`).
+					WithArgs(testMultitenancyValue, "kayak").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column", "version", "modified_at"}).
+							AddRow(testPrimaryKeyValue, int64(1), modifiedAt),
+					)
+				mock.ExpectCommit()
+			},
+			&returningModel{
+				PrimaryKeyField: testPrimaryKeyValue,
+				TestFieldOne:    "kayak",
+				Version:         1,
+				ModifiedAt:      modifiedAt,
+			},
+		},
+		{
+			"sets returned columns on the model after an update",
+			&returningModel{
+				PrimaryKeyField: testPrimaryKeyValue,
+				TestFieldOne:    "canoe",
+				Version:         1,
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^SELECT test_tablename.primary_key_column FROM test_tablename WHERE test_tablename.primary_key_column = \$1 AND test_tablename.multitenancy_key_column = \$2$`).
+					WithArgs(testPrimaryKeyValue, testMultitenancyValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+					)
+				mock.ExpectQuery(`^UPDATE test_tablename SET test_column_one = \$1 WHERE multitenancy_key_column = \$2 AND primary_key_column = \$3 RETURNING "version", "modified_at"$`).
+					WithArgs("canoe", testMultitenancyValue, testPrimaryKeyValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"version", "modified_at"}).
+							AddRow(int64(2), modifiedAt),
+					)
+				mock.ExpectCommit()
+			},
+			&returningModel{
+				PrimaryKeyField: testPrimaryKeyValue,
+				TestFieldOne:    "canoe",
+				Version:         2,
+				ModifiedAt:      modifiedAt,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: testMultitenancyValue,
+			}
+
+			err = p.SaveModel(tc.giveValue)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantValue, tc.giveValue)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+type generatedModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField        string `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string `picard:"column=test_column_one"`
+	Sequence               int    `picard:"column=sequence,generated"`
+}
+
+func TestGeneratedColumn(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	testPrimaryKeyValue := "00000000-0000-0000-0000-000000000001"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one\) VALUES \(\$1,\$2\) RETURNING "primary_key_column", "sequence"$`).
+		WithArgs(testMultitenancyValue, "kayak").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"primary_key_column", "sequence"}).
+				AddRow(testPrimaryKeyValue, int64(7)),
+		)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT test_tablename.primary_key_column FROM test_tablename WHERE test_tablename.primary_key_column = \$1 AND test_tablename.multitenancy_key_column = \$2$`).
+		WithArgs(testPrimaryKeyValue, testMultitenancyValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+		)
+	mock.ExpectQuery(`^UPDATE test_tablename SET test_column_one = \$1 WHERE multitenancy_key_column = \$2 AND primary_key_column = \$3 RETURNING "sequence"$`).
+		WithArgs("canoe", testMultitenancyValue, testPrimaryKeyValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"sequence"}).AddRow(int64(7)),
+		)
+	mock.ExpectCommit()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.primary_key_column AS "t0.primary_key_column",
+			t0.multitenancy_key_column AS "t0.multitenancy_key_column",
+			t0.test_column_one AS "t0.test_column_one",
+			t0.sequence AS "t0.sequence"
+		FROM test_tablename AS t0
+		WHERE t0.multitenancy_key_column = $1 AND t0.primary_key_column = $2
+	`)).
+		WithArgs(testMultitenancyValue, testPrimaryKeyValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.primary_key_column", "t0.multitenancy_key_column", "t0.test_column_one", "t0.sequence"}).
+				AddRow(testPrimaryKeyValue, testMultitenancyValue, "canoe", int64(7)),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: testMultitenancyValue,
+	}
+
+	model := &generatedModel{
+		TestFieldOne: "kayak",
+		Sequence:     3,
+	}
+	assert.NoError(t, p.SaveModel(model))
+	assert.Equal(t, 7, model.Sequence)
+
+	model.TestFieldOne = "canoe"
+	model.Sequence = 4
+	assert.NoError(t, p.SaveModel(model))
+	assert.Equal(t, 7, model.Sequence)
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: generatedModel{
+			PrimaryKeyField: testPrimaryKeyValue,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		generatedModel{
+			PrimaryKeyField:        testPrimaryKeyValue,
+			TestMultitenancyColumn: testMultitenancyValue,
+			TestFieldOne:           "canoe",
+			Sequence:               7,
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+type dbAuditModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename,db_audit"`
+
+	PrimaryKeyField        string    `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string    `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string    `picard:"column=test_column_one"`
+	CreatedByID            string    `picard:"column=created_by_id,audit=created_by"`
+	CreatedDate            time.Time `picard:"column=created_at,audit=created_at"`
+	UpdatedDate            time.Time `picard:"column=updated_at,audit=updated_at"`
+}
+
+func TestDBAudit(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	testPerformedByValue := "00000000-0000-0000-0000-000000000002"
+	testPrimaryKeyValue := "00000000-0000-0000-0000-000000000001"
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	updatedAt := time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one,created_by_id\) VALUES \(\$1,\$2,\$3\) RETURNING "primary_key_column", "created_at", "updated_at"$`).
+		WithArgs(testMultitenancyValue, "kayak", testPerformedByValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"primary_key_column", "created_at", "updated_at"}).
+				AddRow(testPrimaryKeyValue, createdAt, createdAt),
+		)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT test_tablename.primary_key_column FROM test_tablename WHERE test_tablename.primary_key_column = \$1 AND test_tablename.multitenancy_key_column = \$2$`).
+		WithArgs(testPrimaryKeyValue, testMultitenancyValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+		)
+	mock.ExpectQuery(`^UPDATE test_tablename SET test_column_one = \$1 WHERE multitenancy_key_column = \$2 AND primary_key_column = \$3 RETURNING "created_at", "updated_at"$`).
+		WithArgs("canoe", testMultitenancyValue, testPrimaryKeyValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(createdAt, updatedAt),
+		)
+	mock.ExpectCommit()
+
+	p := PersistenceORM{
+		multitenancyValue: testMultitenancyValue,
+		performedBy:       testPerformedByValue,
+	}
+
+	model := &dbAuditModel{
+		TestFieldOne: "kayak",
+	}
+	assert.NoError(t, p.SaveModel(model))
+	assert.Equal(t, createdAt, model.CreatedDate)
+	assert.Equal(t, createdAt, model.UpdatedDate)
+
+	model.TestFieldOne = "canoe"
+	assert.NoError(t, p.SaveModel(model))
+	assert.Equal(t, createdAt, model.CreatedDate)
+	assert.Equal(t, updatedAt, model.UpdatedDate)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestSaveModelWithRowsAffected(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	personID := "00000000-0000-0000-0000-000000000002"
+
+	expectLookup := func(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			SELECT personmodel.id FROM personmodel WHERE personmodel.id = $1 AND personmodel.organization_id = $2
+		`)).
+			WithArgs(personID, orgID).
+			WillReturnRows(rows)
+	}
+	expectUpdate := func(mock sqlmock.Sqlmock, rowsAffected int64) {
+		mock.ExpectExec(testdata.FmtSQLRegex(`
+			UPDATE personmodel SET name = $1 WHERE organization_id = $2 AND id = $3
+		`)).
+			WithArgs("Fred", orgID, personID).
+			WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+	}
+
+	testCases := []struct {
+		description         string
+		giveValue           *testdata.PersonModel
+		expectationFunction func(sqlmock.Sqlmock)
+		wantRowsAffected    int64
+		wantErr             error
+	}{
+		{
+			"counts the row matched by an update",
+			&testdata.PersonModel{ID: personID, Name: "Fred"},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLookup(mock, sqlmock.NewRows([]string{"id"}).AddRow(personID))
+				expectUpdate(mock, 1)
+				mock.ExpectCommit()
+			},
+			1,
+			nil,
+		},
+		{
+			"counts no rows when the row was deleted after it was found",
+			&testdata.PersonModel{ID: personID, Name: "Fred"},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLookup(mock, sqlmock.NewRows([]string{"id"}).AddRow(personID))
+				expectUpdate(mock, 0)
+				mock.ExpectCommit()
+			},
+			0,
+			nil,
+		},
+		{
+			"returns ModelNotFoundError when the row doesn't exist",
+			&testdata.PersonModel{ID: personID, Name: "Fred"},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLookup(mock, sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			0,
+			ModelNotFoundError,
+		},
+		{
+			"counts the row written by an insert",
+			&testdata.PersonModel{Name: "Fred"},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO personmodel (organization_id,name) VALUES ($1,$2) RETURNING "id"
+				`)).
+					WithArgs(orgID, "Fred").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(personID))
+				mock.ExpectCommit()
+			},
+			1,
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+			tc.expectationFunction(mock)
+
+			rowsAffected, err := New(orgID, sampleUserID).SaveModelWithRowsAffected(tc.giveValue)
+
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantRowsAffected, rowsAffected)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestSaveModelColumnOrder(t *testing.T) {
+	type orderedModel struct {
+		Metadata metadata.Metadata `picard:"tablename=ordered"`
+
+		ID             string                 `picard:"primary_key,column=id"`
+		Zeta           string                 `picard:"column=zeta"`
+		OrganizationID string                 `picard:"multitenancy_key,column=organization_id"`
+		Alpha          string                 `picard:"column=alpha"`
+		Attributes     map[string]interface{} `picard:"jsonb,column=attributes"`
+		Mu             int                    `picard:"column=mu"`
+		UpdatedByID    string                 `picard:"column=updated_by_id,audit=updated_by"`
+	}
+	orgID := "00000000-0000-0000-0000-000000000001"
+	modelID := "00000000-0000-0000-0000-000000000002"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	// Map iteration order changes from run to run, so repeated saves would catch columns ordered by a map
+	for i := 0; i < 20; i++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			INSERT INTO ordered (zeta,organization_id,alpha,attributes,mu,updated_by_id) VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"
+		`)).
+			WithArgs("z", orgID, "a", []byte(`{"key":"value"}`), 1, sampleUserID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(modelID))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			SELECT ordered.id FROM ordered WHERE ordered.id = $1 AND ordered.organization_id = $2
+		`)).
+			WithArgs(modelID, orgID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(modelID))
+		mock.ExpectExec(testdata.FmtSQLRegex(`
+			UPDATE ordered SET zeta = $1, alpha = $2, attributes = $3, mu = $4, updated_by_id = $5
+			WHERE organization_id = $6 AND id = $7
+		`)).
+			WithArgs("z", "a", []byte(`{"key":"value"}`), 1, sampleUserID, orgID, modelID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		p := New(orgID, sampleUserID)
+		model := orderedModel{
+			Zeta:       "z",
+			Alpha:      "a",
+			Attributes: map[string]interface{}{"key": "value"},
+			Mu:         1,
+		}
+		assert.NoError(t, p.SaveModel(&model))
+		assert.NoError(t, p.SaveModel(&model))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
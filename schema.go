@@ -0,0 +1,230 @@
+package picard
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/stringutil"
+	"github.com/skuid/picard/tags"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+	picardTagKey    = "picard"
+)
+
+// jsonSchema is the subset of JSON Schema that picard generates for models
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ReadOnly             bool                   `json:"readOnly,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+type schemaGenerator struct {
+	root        reflect.Type
+	definitions map[string]*jsonSchema
+}
+
+// jsonPointerEscaper escapes a definition name for the JSON pointer of a $ref
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	metadataType      = reflect.TypeOf(metadata.Metadata{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+/*
+GenerateJSONSchema produces a JSON Schema document describing the JSON representation of a picard model.
+
+Property names are read from `json` tags, falling back to the field name. A field is listed as required when
+its `validate` tag includes `required` or its picard tag includes `required`. Multitenancy keys and audit fields
+are marked as `readOnly` since picard populates them. Related structs and children are emitted as definitions
+named by their package path and type name, and referenced with `$ref`, so self-referential models are supported.
+Like encoding/json, the fields of embedded structs without a json name are promoted into their parent.
+*/
+func GenerateJSONSchema(model interface{}) ([]byte, error) {
+	val, err := stringutil.GetStructValue(model)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := val.Type()
+	gen := schemaGenerator{
+		root:        typ,
+		definitions: map[string]*jsonSchema{},
+	}
+
+	schema := gen.objectSchema(typ)
+	schema.Schema = jsonSchemaDraft
+	schema.Title = typ.Name()
+	if len(gen.definitions) > 0 {
+		schema.Definitions = gen.definitions
+	}
+
+	return json.Marshal(schema)
+}
+
+func (gen *schemaGenerator) objectSchema(typ reflect.Type) *jsonSchema {
+	schema := &jsonSchema{
+		Type:       "object",
+		Properties: map[string]*jsonSchema{},
+	}
+
+	// Embedded structs are read a level at a time, so a shallower field hides a promoted one with the same name
+	level := []reflect.Type{typ}
+	for len(level) > 0 {
+		embedded := []reflect.Type{}
+		for _, levelType := range level {
+			for i := 0; i < levelType.NumField(); i++ {
+				field := levelType.Field(i)
+				if field.Type == metadataType {
+					continue
+				}
+				if embeddedType, ok := promotedStruct(field); ok {
+					embedded = append(embedded, embeddedType)
+					continue
+				}
+				if field.PkgPath != "" {
+					continue
+				}
+
+				name, skip := jsonFieldName(field)
+				if _, exists := schema.Properties[name]; skip || exists {
+					continue
+				}
+
+				propertySchema := gen.typeSchema(field.Type)
+
+				picardTags := tags.GetStructTagsMap(field, picardTagKey)
+				_, isMultitenancyKey := picardTags["multitenancy_key"]
+				if isMultitenancyKey || picardTags["audit"] != "" {
+					propertySchema.ReadOnly = true
+				}
+
+				schema.Properties[name] = propertySchema
+
+				if isRequiredField(field) {
+					schema.Required = append(schema.Required, name)
+				}
+			}
+		}
+		level = embedded
+	}
+
+	return schema
+}
+
+// promotedStruct returns the struct type of an embedded field whose fields encoding/json promotes into the
+// parent: a struct, or a pointer to an exported one, without a name in its json tag
+func promotedStruct(field reflect.StructField) (reflect.Type, bool) {
+	if !field.Anonymous {
+		return nil, false
+	}
+	jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+	if jsonName != "" {
+		return nil, false
+	}
+	typ := field.Type
+	if typ.Kind() == reflect.Ptr {
+		if field.PkgPath != "" {
+			return nil, false
+		}
+		typ = typ.Elem()
+	}
+	return typ, typ.Kind() == reflect.Struct
+}
+
+func (gen *schemaGenerator) typeSchema(typ reflect.Type) *jsonSchema {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ == timeType {
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	}
+
+	if typ.Implements(textMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType) {
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json marshals byte slices as base64 strings
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string"}
+		}
+		return &jsonSchema{Type: "array", Items: gen.typeSchema(typ.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: gen.typeSchema(typ.Elem())}
+	case reflect.Struct:
+		return gen.structSchema(typ)
+	}
+
+	// interfaces and anything else can hold any value
+	return &jsonSchema{}
+}
+
+func (gen *schemaGenerator) structSchema(typ reflect.Type) *jsonSchema {
+	if typ == gen.root {
+		return &jsonSchema{Ref: "#"}
+	}
+
+	if typ.Name() == "" {
+		return gen.objectSchema(typ)
+	}
+
+	// Types with the same name from different packages get their own definitions
+	name := typ.PkgPath() + "." + typ.Name()
+	ref := &jsonSchema{Ref: "#/definitions/" + jsonPointerEscaper.Replace(name)}
+	if _, ok := gen.definitions[name]; ok {
+		return ref
+	}
+
+	// Reserve the definition before recursing so cycles resolve to the reference
+	gen.definitions[name] = &jsonSchema{}
+	gen.definitions[name] = gen.objectSchema(typ)
+	return ref
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	jsonTag := field.Tag.Get("json")
+	if jsonTag == "-" {
+		return "", true
+	}
+	name := strings.Split(jsonTag, ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, false
+}
+
+func isRequiredField(field reflect.StructField) bool {
+	validations := strings.Split(field.Tag.Get("validate"), ",")
+	if stringutil.StringSliceContainsKey(validations, "required") {
+		return true
+	}
+	_, isRequired := tags.GetStructTagsMap(field, picardTagKey)["required"]
+	return isRequired
+}
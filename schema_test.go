@@ -0,0 +1,182 @@
+package picard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaTestModel struct {
+	Metadata       metadata.Metadata `picard:"tablename=schema_model"`
+	ID             string            `json:"id" picard:"primary_key,column=id"`
+	OrganizationID string            `json:"organization_id" picard:"multitenancy_key,column=organization_id"`
+	Name           string            `json:"name" picard:"lookup,column=name" validate:"required"`
+	Count          int               `json:"count" picard:"column=count"`
+	Ratio          *float64          `json:"ratio,omitempty" picard:"column=ratio"`
+	IsActive       bool              `json:"is_active" picard:"column=is_active"`
+	Config         testdata.Config   `json:"config" picard:"jsonb,column=config"`
+	Secret         string            `json:"-" picard:"encrypted,column=secret"`
+	Labels         map[string]string `json:"labels" picard:"jsonb,column=labels"`
+	ParentID       string            `json:"parent_id" picard:"foreign_key,required,related=Parent,column=parent_id"`
+	Parent         schemaTestParent  `json:"parent" validate:"-"`
+	Children       []schemaTestChild `json:"children" picard:"child,foreign_key=ParentID"`
+	CreatedDate    time.Time         `json:"created_at" picard:"column=created_at,audit=created_at"`
+}
+
+type schemaTestParent struct {
+	Metadata metadata.Metadata `picard:"tablename=schema_parent"`
+	ID       string            `json:"id" picard:"primary_key,column=id"`
+}
+
+type schemaTestChild struct {
+	Metadata metadata.Metadata `picard:"tablename=schema_child"`
+	ID       string            `json:"id" picard:"primary_key,column=id"`
+	Name     string            `picard:"lookup,column=name" validate:"required"`
+	ParentID string            `json:"parent_id" picard:"foreign_key,related=Parent,column=parent_id"`
+	Parent   schemaTestModel   `json:"parent" validate:"-"`
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	type Config struct {
+		Region string `json:"region"`
+	}
+	type schemaTestAudit struct {
+		CreatedBy string `json:"created_by" picard:"column=created_by,audit=created_by"`
+		Name      string `json:"name"`
+	}
+	type schemaTestEmbedding struct {
+		Metadata metadata.Metadata `picard:"tablename=schema_embedding"`
+		schemaTestAudit
+		ID       string          `json:"id" picard:"primary_key,column=id"`
+		Name     string          `json:"name" picard:"lookup,column=name" validate:"required"`
+		Settings Config          `json:"settings" picard:"jsonb,column=settings"`
+		Legacy   testdata.Config `json:"legacy" picard:"jsonb,column=legacy"`
+	}
+
+	testCases := []struct {
+		description string
+		giveModel   interface{}
+		wantSchema  string
+		wantErr     string
+	}{
+		{
+			"generates a schema for a model with references, children, and jsonb fields",
+			schemaTestModel{},
+			`{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"title": "schemaTestModel",
+				"type": "object",
+				"properties": {
+					"id": {"type": "string"},
+					"organization_id": {"type": "string", "readOnly": true},
+					"name": {"type": "string"},
+					"count": {"type": "integer"},
+					"ratio": {"type": "number"},
+					"is_active": {"type": "boolean"},
+					"config": {"$ref": "#/definitions/github.com~1skuid~1picard~1testdata.Config"},
+					"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+					"parent_id": {"type": "string"},
+					"parent": {"$ref": "#/definitions/github.com~1skuid~1picard.schemaTestParent"},
+					"children": {"type": "array", "items": {"$ref": "#/definitions/github.com~1skuid~1picard.schemaTestChild"}},
+					"created_at": {"type": "string", "format": "date-time", "readOnly": true}
+				},
+				"required": ["name", "parent_id"],
+				"definitions": {
+					"github.com/skuid/picard/testdata.Config": {
+						"type": "object",
+						"properties": {
+							"ConfigA": {"type": "string"},
+							"ConfigB": {"type": "string"}
+						}
+					},
+					"github.com/skuid/picard.schemaTestChild": {
+						"type": "object",
+						"properties": {
+							"id": {"type": "string"},
+							"Name": {"type": "string"},
+							"parent_id": {"type": "string"},
+							"parent": {"$ref": "#"}
+						},
+						"required": ["Name"]
+					},
+					"github.com/skuid/picard.schemaTestParent": {
+						"type": "object",
+						"properties": {
+							"id": {"type": "string"}
+						}
+					}
+				}
+			}`,
+			"",
+		},
+		{
+			"promotes the fields of embedded structs and keeps same-named types of different packages apart",
+			schemaTestEmbedding{},
+			`{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"title": "schemaTestEmbedding",
+				"type": "object",
+				"properties": {
+					"id": {"type": "string"},
+					"name": {"type": "string"},
+					"settings": {"$ref": "#/definitions/github.com~1skuid~1picard.Config"},
+					"legacy": {"$ref": "#/definitions/github.com~1skuid~1picard~1testdata.Config"},
+					"created_by": {"type": "string", "readOnly": true}
+				},
+				"required": ["name"],
+				"definitions": {
+					"github.com/skuid/picard.Config": {
+						"type": "object",
+						"properties": {
+							"region": {"type": "string"}
+						}
+					},
+					"github.com/skuid/picard/testdata.Config": {
+						"type": "object",
+						"properties": {
+							"ConfigA": {"type": "string"},
+							"ConfigB": {"type": "string"}
+						}
+					}
+				}
+			}`,
+			"",
+		},
+		{
+			"generates a schema from a pointer to a model",
+			&testdata.PersonModel{},
+			`{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"title": "PersonModel",
+				"type": "object",
+				"properties": {
+					"id": {"type": "string"},
+					"OrganizationID": {"type": "string", "readOnly": true},
+					"name": {"type": "string"}
+				}
+			}`,
+			"",
+		},
+		{
+			"returns an error for non-struct models",
+			"not a model",
+			"",
+			"models must be structs",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			schema, err := GenerateJSONSchema(tc.giveModel)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tc.wantSchema, string(schema))
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package picard
+
+import (
+	"testing"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type articleModel struct {
+	Metadata metadata.Metadata `picard:"tablename=article"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Title          string `picard:"column=title"`
+	Body           string `picard:"column=body"`
+}
+
+func TestFilterModelTextSearch(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveFilter  tags.Filterable
+		wantWhere   string
+		wantArgs    []interface{}
+		wantErr     string
+	}{
+		{
+			"should search a column with the english configuration by default",
+			tags.TextSearchFilter{FieldName: "Title", Query: "red widget"},
+			"to_tsvector('english', t0.title) @@ plainto_tsquery('english', $2)",
+			[]interface{}{orgID, "red widget"},
+			"",
+		},
+		{
+			"should search with the configuration named in the filter",
+			tags.TextSearchFilter{FieldName: "Body", Query: "rote Widgets", Config: "german"},
+			"to_tsvector('german', t0.body) @@ plainto_tsquery('german', $2)",
+			[]interface{}{orgID, "rote Widgets"},
+			"",
+		},
+		{
+			"should search inside filter groups",
+			tags.OrFilterGroup{
+				tags.TextSearchFilter{FieldName: "Title", Query: "widget"},
+				tags.TextSearchFilter{FieldName: "Body", Query: "widget"},
+			},
+			"(to_tsvector('english', t0.title) @@ plainto_tsquery('english', $2) OR to_tsvector('english', t0.body) @@ plainto_tsquery('english', $3))",
+			[]interface{}{orgID, "widget", "widget"},
+			"",
+		},
+		{
+			"should error for fields that aren't columns",
+			tags.TextSearchFilter{FieldName: "Summary", Query: "widget"},
+			"",
+			nil,
+			"field 'Summary' on table 'article' can't be searched, it isn't a column",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel:  articleModel{},
+				FieldFilters: tc.giveFilter,
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.title AS "t0.title",
+					t0.body AS "t0.body"
+				FROM article AS t0
+				WHERE t0.organization_id = $1 AND `+tc.wantWhere+`
+			`), sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
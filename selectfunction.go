@@ -0,0 +1,99 @@
+package picard
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/tags"
+)
+
+// functionNamePattern matches a function name, optionally qualified by its schema
+var functionNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+/*
+SelectFunction selects the result of a SQL function call into Into, a field of the filter model tagged with
+computed. Function is the name of the function, optionally qualified by its schema. Each of Args is passed to
+the function in order: a FieldArg passes the column of a field of the filter model, and any other value is
+passed as a query parameter.
+
+	type Person struct {
+		Metadata    metadata.Metadata `picard:"tablename=person"`
+		ID          string            `picard:"primary_key,column=id"`
+		FirstName   string            `picard:"column=first_name"`
+		LastName    string            `picard:"column=last_name"`
+		DisplayName string            `picard:"computed"`
+	}
+
+	results, err := p.FilterModel(picard.FilterRequest{
+		FilterModel: Person{},
+		SelectFunctions: []picard.SelectFunction{
+			{
+				Into:     "DisplayName",
+				Function: "format_name",
+				Args:     []interface{}{"Dr.", picard.FieldArg("FirstName"), picard.FieldArg("LastName")},
+			},
+		},
+	})
+
+	// SELECT ..., format_name($1, t0.first_name, t0.last_name) AS "t0.DisplayName" FROM person AS t0 ...
+*/
+type SelectFunction struct {
+	Into     string
+	Function string
+	Args     []interface{}
+}
+
+// FieldArg is an argument of a SelectFunction that passes the column of the named field of the filter model
+type FieldArg string
+
+// addSelectFunctions selects the result of each function call into its field
+func addSelectFunctions(builder sq.SelectBuilder, functions []SelectFunction, filterMetadata *tags.TableMetadata, tbl *qp.Table) (sq.SelectBuilder, error) {
+	for _, function := range functions {
+		if !hasComputedField(filterMetadata, function.Into) {
+			return builder, fmt.Errorf("field '%s' on table '%s' must be tagged with computed to hold the result of a function", function.Into, filterMetadata.GetTableName())
+		}
+		call, values, err := functionCall(function, filterMetadata, tbl.Alias)
+		if err != nil {
+			return builder, err
+		}
+		tbl.AddComputedColumn(function.Into)
+		builder = builder.Column(sq.Expr(call+" AS "+tbl.ComputedColumn(function.Into), values...))
+	}
+	return builder, nil
+}
+
+// functionCall returns the call of a select function, with placeholders for the values of the arguments that
+// aren't fields
+func functionCall(function SelectFunction, filterMetadata *tags.TableMetadata, tableAlias string) (string, []interface{}, error) {
+	if !functionNamePattern.MatchString(function.Function) {
+		return "", nil, fmt.Errorf("'%s' is not a valid function name", function.Function)
+	}
+	arguments := make([]string, 0, len(function.Args))
+	values := []interface{}{}
+	for _, arg := range function.Args {
+		fieldName, isField := arg.(FieldArg)
+		if !isField {
+			arguments = append(arguments, "?")
+			values = append(values, arg)
+			continue
+		}
+		columnName := filterMetadata.GetField(string(fieldName)).GetColumnName()
+		if columnName == "" {
+			return "", nil, fmt.Errorf("field '%s' passed to function '%s' is not a column of table '%s'", fieldName, function.Function, filterMetadata.GetTableName())
+		}
+		arguments = append(arguments, fmt.Sprintf(qp.AliasedField, tableAlias, columnName))
+	}
+	return function.Function + "(" + strings.Join(arguments, ", ") + ")", values, nil
+}
+
+func hasComputedField(filterMetadata *tags.TableMetadata, fieldName string) bool {
+	for _, field := range filterMetadata.GetComputedFields() {
+		if field.GetName() == fieldName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type memberModel struct {
+	metadata.Metadata `picard:"tablename=member"`
+
+	ID             string  `picard:"primary_key,column=id"`
+	OrganizationID string  `picard:"multitenancy_key,column=organization_id"`
+	FirstName      string  `picard:"column=first_name"`
+	LastName       string  `picard:"column=last_name"`
+	DisplayName    string  `picard:"computed"`
+	Initials       *string `picard:"computed"`
+}
+
+func TestFilterModelSelectFunctions(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	memberID := "00000000-0000-0000-0000-000000000002"
+
+	testCases := []struct {
+		description         string
+		giveRequest         FilterRequest
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []interface{}
+		wantErr             string
+	}{
+		{
+			"selects a function of bound values and columns into a computed field",
+			FilterRequest{
+				FilterModel: memberModel{LastName: "Hopper"},
+				SelectFunctions: []SelectFunction{
+					{
+						Into:     "DisplayName",
+						Function: "format_name",
+						Args:     []interface{}{"Rear Admiral", FieldArg("FirstName"), FieldArg("LastName")},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.first_name AS "t0.first_name",
+						t0.last_name AS "t0.last_name",
+						format_name($1, t0.first_name, t0.last_name) AS "t0.DisplayName"
+					FROM member AS t0
+					WHERE t0.organization_id = $2 AND t0.last_name = $3
+				`)).
+					WithArgs("Rear Admiral", orgID, "Hopper").
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.first_name", "t0.last_name", "t0.DisplayName"}).
+							AddRow(memberID, orgID, "Grace", "Hopper", "Rear Admiral Grace Hopper"),
+					)
+			},
+			[]interface{}{
+				memberModel{
+					ID:             memberID,
+					OrganizationID: orgID,
+					FirstName:      "Grace",
+					LastName:       "Hopper",
+					DisplayName:    "Rear Admiral Grace Hopper",
+				},
+			},
+			"",
+		},
+		{
+			"numbers the parameters of several functions in the order they're selected",
+			FilterRequest{
+				FilterModel:  memberModel{},
+				SelectFields: []string{"ID"},
+				SelectFunctions: []SelectFunction{
+					{
+						Into:     "DisplayName",
+						Function: "concat_ws",
+						Args:     []interface{}{" ", FieldArg("FirstName"), FieldArg("LastName")},
+					},
+					{
+						Into:     "Initials",
+						Function: "people.initials",
+						Args:     []interface{}{FieldArg("FirstName"), FieldArg("LastName"), 2},
+					},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT
+						t0.id AS "t0.id",
+						concat_ws($1, t0.first_name, t0.last_name) AS "t0.DisplayName",
+						people.initials(t0.first_name, t0.last_name, $2) AS "t0.Initials"
+					FROM member AS t0
+					WHERE t0.organization_id = $3
+				`)).
+					WithArgs(" ", 2, orgID).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"t0.id", "t0.DisplayName", "t0.Initials"}).
+							AddRow(memberID, "Grace Hopper", nil),
+					)
+			},
+			[]interface{}{
+				memberModel{
+					ID:          memberID,
+					DisplayName: "Grace Hopper",
+				},
+			},
+			"",
+		},
+		{
+			"errors for fields without the computed tag",
+			FilterRequest{
+				FilterModel: memberModel{},
+				SelectFunctions: []SelectFunction{
+					{Into: "FirstName", Function: "upper", Args: []interface{}{FieldArg("FirstName")}},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"field 'FirstName' on table 'member' must be tagged with computed to hold the result of a function",
+		},
+		{
+			"errors for function names that aren't identifiers",
+			FilterRequest{
+				FilterModel: memberModel{},
+				SelectFunctions: []SelectFunction{
+					{Into: "DisplayName", Function: "upper(t0.first_name); DROP TABLE member; --"},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"'upper(t0.first_name); DROP TABLE member; --' is not a valid function name",
+		},
+		{
+			"errors for field arguments that aren't columns",
+			FilterRequest{
+				FilterModel: memberModel{},
+				SelectFunctions: []SelectFunction{
+					{Into: "DisplayName", Function: "upper", Args: []interface{}{FieldArg("Nickname")}},
+				},
+			},
+			func(mock sqlmock.Sqlmock) {},
+			nil,
+			"field 'Nickname' passed to function 'upper' is not a column of table 'member'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(tc.giveRequest)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantResults, results)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package picard
+
+import (
+	"reflect"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+)
+
+// SkippedRecord describes a model that was left out of a deployment because one of its optional
+// references could not be resolved
+type SkippedRecord struct {
+	Model  interface{}
+	Reason *ForeignKeyError
+}
+
+// SkipListener receives a SkippedRecord for every model that was skipped during a deployment
+type SkipListener func(SkippedRecord)
+
+/*
+WithSkipUnresolvable returns a copy of the ORM that skips models with unresolvable optional references
+when deploying.
+
+By default, Deploy fails when a required foreign key lookup finds no match, and leaves an optional
+foreign key empty when its lookup finds no match. With this option, a model whose optional reference has
+lookup values but no match is not inserted or updated, and neither are its children. The listener is
+called with the skipped model and the failed lookup, before the model's BeforeSave hook would run.
+Required references still fail the deployment, and rows that match a skipped model are never deleted as
+orphans.
+*/
+func (p PersistenceORM) WithSkipUnresolvable(listener SkipListener) ORM {
+	p.skipListener = listener
+	return &p
+}
+
+// unresolvableReference returns the failed lookup of a model that should be skipped, or nil if the ORM doesn't
+// skip models or the model's references all resolve. A missing required reference is left to fail the deployment.
+func (p PersistenceORM) unresolvableReference(value reflect.Value, foreignKeys []tags.ForeignKey, tableMetadata *tags.TableMetadata) *ForeignKeyError {
+	if p.skipListener == nil {
+		return nil
+	}
+	modelMetadata := metadata.GetMetadataFromPicardStruct(value)
+	for _, foreignKey := range foreignKeys {
+		var keyValue interface{}
+		keyIsDefined := isFieldDefinedOnStruct(modelMetadata, foreignKey.FieldName, value)
+		if keyIsDefined {
+			keyValue = value.FieldByName(foreignKey.FieldName).Interface()
+		}
+		lookup, needsLookup := lookupForeignKey(value, foreignKey, keyValue, keyIsDefined)
+		if !needsLookup || lookup.result != nil {
+			continue
+		}
+		if foreignKey.Required {
+			return nil
+		}
+		if hasReferenceData(lookup.related, foreignKey.LookupsUsed) {
+			return NewForeignKeyError(
+				"Unresolvable Optional Foreign Key Lookup",
+				tableMetadata.GetTableName(),
+				lookup.key,
+				foreignKey.KeyColumn,
+				foreignKey.RelatedFieldName,
+			)
+		}
+	}
+	return nil
+}
+
+// hasReferenceData reports whether any of the lookup values of a reference were provided
+func hasReferenceData(value reflect.Value, lookups []tags.Lookup) bool {
+	for _, lookup := range lookups {
+		if getObjectProperty(value, lookup.MatchObjectProperty) != "" {
+			return true
+		}
+	}
+	return false
+}
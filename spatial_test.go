@@ -0,0 +1,80 @@
+package picard
+
+import (
+	"testing"
+
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type storeLocation struct {
+	Metadata metadata.Metadata `picard:"tablename=store_location"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Location       string `picard:"spatial=geography,column=location"`
+	Footprint      string `picard:"spatial=geometry,column=footprint"`
+	Address        string `picard:"column=address"`
+}
+
+func TestFilterModelDistance(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveFilter  tags.DistanceFilter
+		wantWhere   string
+		wantErr     string
+	}{
+		{
+			"should filter geography columns by meters from a point",
+			tags.DistanceFilter{FieldName: "Location", Longitude: -86.7816, Latitude: 36.1627, Distance: 5000},
+			"ST_DWithin(t0.location, ST_MakePoint($2, $3)::geography, $4)",
+			"",
+		},
+		{
+			"should filter geometry columns with the point in the column's SRID",
+			tags.DistanceFilter{FieldName: "Footprint", Longitude: -86.7816, Latitude: 36.1627, Distance: 5000},
+			"ST_DWithin(t0.footprint, ST_SetSRID(ST_MakePoint($2, $3), ST_SRID(t0.footprint)), $4)",
+			"",
+		},
+		{
+			"should error for columns without the spatial tag",
+			tags.DistanceFilter{FieldName: "Address", Longitude: -86.7816, Latitude: 36.1627, Distance: 5000},
+			"",
+			"field 'Address' on table 'store_location' must be tagged with spatial=geography or spatial=geometry to filter by distance",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel:  storeLocation{},
+				FieldFilters: tc.giveFilter,
+			})
+
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(`
+				SELECT
+					t0.id AS "t0.id",
+					t0.organization_id AS "t0.organization_id",
+					t0.location AS "t0.location",
+					t0.footprint AS "t0.footprint",
+					t0.address AS "t0.address"
+				FROM store_location AS t0
+				WHERE t0.organization_id = $1 AND `+tc.wantWhere+`
+			`), sql)
+			assert.Equal(t, []interface{}{orgID, -86.7816, 36.1627, float64(5000)}, args)
+		})
+	}
+}
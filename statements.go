@@ -0,0 +1,126 @@
+package picard
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/Masterminds/squirrel"
+)
+
+/*
+StatementCache holds statements prepared on the database connection, keyed by their SQL, so that repeated
+deployments of structurally identical models don't prepare the same queries again. A cache is safe for
+concurrent use and is meant to be created once and shared by the ORMs of a process through WithStatementCache.
+
+Statements are prepared on the connection pool and bound to each transaction as they are used, so they stay
+valid after the transaction that first used them commits or rolls back. Preparing a statement takes a second
+connection from the pool while the transaction holds its own, so the pool must allow more than one open
+connection. Once maxStatements statements are cached, other queries run without being prepared.
+*/
+type StatementCache struct {
+	mu            sync.Mutex
+	db            *sql.DB
+	statements    map[string]*sql.Stmt
+	maxStatements int
+}
+
+// NewStatementCache creates a statement cache that holds at most maxStatements prepared statements
+func NewStatementCache(maxStatements int) *StatementCache {
+	return &StatementCache{
+		statements:    map[string]*sql.Stmt{},
+		maxStatements: maxStatements,
+	}
+}
+
+// WithStatementCache returns a copy of the ORM that reuses the cache's prepared statements for the lookups,
+// inserts, updates and deletes of a deployment
+func (p PersistenceORM) WithStatementCache(cache *StatementCache) ORM {
+	p.statementCache = cache
+	return &p
+}
+
+// Close closes every cached statement and empties the cache
+func (c *StatementCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for query, stmt := range c.statements {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.statements, query)
+	}
+	return firstErr
+}
+
+// prepare returns the cached statement for the query, preparing it on the connection if needed. It returns nil
+// when the cache is full.
+func (c *StatementCache) prepare(query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Statements prepared on a connection that has since been replaced can't be used anymore
+	if db := GetConnection(); c.db != db {
+		for cachedQuery, stmt := range c.statements {
+			stmt.Close()
+			delete(c.statements, cachedQuery)
+		}
+		c.db = db
+	}
+
+	if stmt, ok := c.statements[query]; ok {
+		return stmt, nil
+	}
+	if len(c.statements) >= c.maxStatements {
+		return nil, nil
+	}
+
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	c.statements[query] = stmt
+	return stmt, nil
+}
+
+// cachedStatementRunner runs queries in a transaction using the statements of a StatementCache
+type cachedStatementRunner struct {
+	tx    *sql.Tx
+	cache *StatementCache
+}
+
+// Exec runs the cached statement for the query in the transaction
+func (r cachedStatementRunner) Exec(query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := r.cache.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return r.tx.Exec(query, args...)
+	}
+	return r.tx.Stmt(stmt).Exec(args...)
+}
+
+// Query runs the cached statement for the query in the transaction
+func (r cachedStatementRunner) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := r.cache.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return r.tx.Query(query, args...)
+	}
+	return r.tx.Stmt(stmt).Query(args...)
+}
+
+// runner returns what deployment queries run with: the ORM's transaction, using the statement cache when it has one
+func (p PersistenceORM) runner() squirrel.BaseRunner {
+	if p.statementCache == nil || p.transaction == nil {
+		return p.transaction
+	}
+	return cachedStatementRunner{
+		tx:    p.transaction,
+		cache: p.statementCache,
+	}
+}
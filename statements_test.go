@@ -0,0 +1,59 @@
+package picard
+
+import (
+	"regexp"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementCache(t *testing.T) {
+	updateSQL := "UPDATE test_tablename SET test_column_one = $1 WHERE primary_key_column = $2"
+	deleteSQL := "DELETE FROM test_tablename WHERE primary_key_column = $1"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	cache := NewStatementCache(1)
+
+	mock.ExpectBegin()
+	// Prepared once on the pool, and once more on the transaction's connection the first time it's used there
+	mock.ExpectPrepare(regexp.QuoteMeta(updateSQL))
+	mock.ExpectPrepare(regexp.QuoteMeta(updateSQL))
+	mock.ExpectExec(regexp.QuoteMeta(updateSQL)).
+		WithArgs("one", "00000000-0000-0000-0000-000000000001").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(updateSQL)).
+		WithArgs("two", "00000000-0000-0000-0000-000000000002").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The cache is full, so the delete isn't prepared
+	mock.ExpectExec(regexp.QuoteMeta(deleteSQL)).
+		WithArgs("00000000-0000-0000-0000-000000000003").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	p := PersistenceORM{}
+	tx, err := p.StartTransaction()
+	assert.NoError(t, err)
+	p = *p.WithStatementCache(cache).(*PersistenceORM)
+
+	_, err = p.runner().Exec(updateSQL, "one", "00000000-0000-0000-0000-000000000001")
+	assert.NoError(t, err)
+	_, err = p.runner().Exec(updateSQL, "two", "00000000-0000-0000-0000-000000000002")
+	assert.NoError(t, err)
+	_, err = p.runner().Exec(deleteSQL, "00000000-0000-0000-0000-000000000003")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	assert.Len(t, cache.statements, 1)
+	assert.NoError(t, cache.Close())
+	assert.Len(t, cache.statements, 0)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
@@ -0,0 +1,285 @@
+package picard
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/skuid/picard/query"
+	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/tags"
+)
+
+/*
+ResultIterator reads the results of FilterModelStream one model at a time. It holds the query's rows, and the
+connection they were read on, open until Close is called or Next returns false.
+*/
+type ResultIterator struct {
+	rows           *sql.Rows
+	cursor         *streamCursor
+	request        FilterRequest
+	filterModel    interface{}
+	tblAlias       string
+	aliasMap       map[string]qp.FieldDescriptor
+	filterMetadata *tags.TableMetadata
+	current        *reflect.Value
+	err            error
+}
+
+// cursorCount numbers the cursors declared by FilterModelStream, so streams sharing a transaction don't collide
+var cursorCount uint64
+
+// streamCursor is a server-side cursor that a ResultIterator fetches its rows from in batches
+type streamCursor struct {
+	tx        *sql.Tx
+	ownsTx    bool
+	name      string
+	batchSize uint64
+	// fetched counts the rows read from the current batch. A batch with fewer rows than the batch size is the last.
+	fetched uint64
+	closed  bool
+}
+
+// declareCursor declares a cursor for the filter query in the request's transaction, or in a new one on the
+// request's connection, which is committed when the cursor is closed
+func declareCursor(runner sq.BaseRunner, selectBuilder sq.SelectBuilder, batchSize uint64) (*streamCursor, error) {
+	selectSQL, args, err := selectBuilder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := &streamCursor{
+		name:      fmt.Sprintf("picard_cursor_%d", atomic.AddUint64(&cursorCount, 1)),
+		batchSize: batchSize,
+	}
+	switch runner := runner.(type) {
+	case *sql.Tx:
+		cursor.tx = runner
+	case *sql.DB:
+		tx, err := runner.Begin()
+		if err != nil {
+			return nil, err
+		}
+		cursor.tx = tx
+		cursor.ownsTx = true
+	default:
+		return nil, fmt.Errorf("CursorBatchSize requires a transaction or connection Runner, got '%T'", runner)
+	}
+
+	if _, err := cursor.tx.Exec(fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", cursor.name, selectSQL), args...); err != nil {
+		if cursor.ownsTx {
+			cursor.tx.Rollback()
+		}
+		return nil, newReadQueryError(err, selectBuilder)
+	}
+	return cursor, nil
+}
+
+// fetch reads the next batch of rows from the cursor
+func (c *streamCursor) fetch() (*sql.Rows, error) {
+	c.fetched = 0
+	return c.tx.Query(fmt.Sprintf("FETCH FORWARD %d FROM %s", c.batchSize, c.name))
+}
+
+// more reports whether the cursor may have rows after the current batch
+func (c *streamCursor) more() bool {
+	return c.fetched == c.batchSize
+}
+
+// close closes the cursor, and ends the transaction if the cursor began it. It is safe to call more than once.
+func (c *streamCursor) close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	_, err := c.tx.Exec(fmt.Sprintf("CLOSE %s", c.name))
+	if !c.ownsTx {
+		return err
+	}
+	if err != nil {
+		c.tx.Rollback()
+		return err
+	}
+	return c.tx.Commit()
+}
+
+/*
+FilterModelStream runs the same query as FilterModel, but returns an iterator that hydrates one model at a
+time instead of reading every result into memory. Eager loaded parents are supported, but child associations
+are not.
+
+Set CursorBatchSize on the request to read the results through a server-side cursor instead, fetching that many
+rows at a time, so neither the database nor the driver holds more than a batch in memory. The cursor is declared
+in the request's transaction Runner, or in a transaction the iterator begins and commits when it is closed.
+
+Example:
+
+	results, err := p.FilterModelStream(picard.FilterRequest{
+		FilterModel: TableA{},
+	})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var model TableA
+		if err := results.Scan(&model); err != nil {
+			return err
+		}
+		// Use model
+	}
+	if err := results.Err(); err != nil {
+		return err
+	}
+*/
+func (p PersistenceORM) FilterModelStream(request FilterRequest) (*ResultIterator, error) {
+	if request.ForUpdate {
+		if _, ok := request.Runner.(*sql.Tx); !ok {
+			return nil, errors.New("ForUpdate requires a transaction Runner")
+		}
+	}
+	if request.Runner == nil {
+		request.Runner = p.readRunner(request.Consistency)
+	}
+
+	filterMetadata, err := getFilterMetadata(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, association := range request.Associations {
+		if filterMetadata.GetChildField(association.Name) != nil {
+			return nil, fmt.Errorf("FilterModelStream can't load child association '%s'", association.Name)
+		}
+	}
+
+	selectBuilder, tbl, filterModel, err := p.buildFilterSelect(request, filterMetadata)
+	if err != nil {
+		return nil, err
+	}
+	if tbl == nil {
+		return &ResultIterator{}, nil
+	}
+
+	var cursor *streamCursor
+	var rows *sql.Rows
+	if request.CursorBatchSize > 0 {
+		cursor, err = declareCursor(request.Runner, selectBuilder, request.CursorBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		rows, err = cursor.fetch()
+		if err != nil {
+			cursor.close()
+			return nil, err
+		}
+	} else {
+		rows, err = selectBuilder.RunWith(request.Runner).Query()
+		if err != nil {
+			return nil, newReadQueryError(err, selectBuilder)
+		}
+	}
+
+	return &ResultIterator{
+		rows:           rows,
+		cursor:         cursor,
+		request:        request,
+		filterModel:    filterModel,
+		tblAlias:       tbl.Alias,
+		aliasMap:       tbl.FieldAliases(),
+		filterMetadata: filterMetadata,
+	}, nil
+}
+
+// Next hydrates the next model, returning false when there are no more results or hydrating failed
+func (it *ResultIterator) Next() bool {
+	it.current = nil
+	if it.rows == nil || it.err != nil {
+		return false
+	}
+	for !it.rows.Next() {
+		if it.cursor == nil {
+			return false
+		}
+		if it.err = it.rows.Err(); it.err != nil || !it.cursor.more() {
+			it.finish()
+			return false
+		}
+		// The batch is used up, but the cursor may have more
+		it.rows.Close()
+		rows, err := it.cursor.fetch()
+		if err != nil {
+			it.err = err
+			it.finish()
+			return false
+		}
+		it.rows = rows
+	}
+	if it.cursor != nil {
+		it.cursor.fetched++
+	}
+
+	var result *reflect.Value
+	result, it.err = query.HydrateRowDecrypting(it.filterModel, it.tblAlias, it.aliasMap, it.rows, it.filterMetadata, requestDecryption(it.request))
+	if it.err != nil {
+		it.finish()
+		return false
+	}
+
+	it.current = result
+	return true
+}
+
+// Scan copies the current model into dest, which must be a pointer to the filter model's type
+func (it *ResultIterator) Scan(dest interface{}) error {
+	if it.current == nil {
+		return errors.New("Scan called without a successful call to Next")
+	}
+
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() {
+		return fmt.Errorf("Scan destination must be a non-nil pointer, got '%T'", dest)
+	}
+	if !it.current.Type().AssignableTo(destValue.Elem().Type()) {
+		return fmt.Errorf("Scan destination must be a pointer to '%v', got '%T'", it.current.Type(), dest)
+	}
+
+	destValue.Elem().Set(*it.current)
+	return nil
+}
+
+// Err returns the error that stopped Next, if any
+func (it *ResultIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	if it.rows == nil {
+		return nil
+	}
+	return it.rows.Err()
+}
+
+// Close releases the rows held by the iterator, and closes its cursor. It is safe to call more than once.
+func (it *ResultIterator) Close() error {
+	if it.rows == nil {
+		return nil
+	}
+	err := it.rows.Close()
+	if it.cursor != nil {
+		if cursorErr := it.cursor.close(); err == nil {
+			err = cursorErr
+		}
+	}
+	return err
+}
+
+// finish releases the rows and cursor once Next is done, keeping the error that stopped it
+func (it *ResultIterator) finish() {
+	if err := it.Close(); it.err == nil {
+		it.err = err
+	}
+}
@@ -0,0 +1,214 @@
+package picard
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterModelStream(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	fredID := "00000000-0000-0000-0000-000000000002"
+	georgeID := "00000000-0000-0000-0000-000000000003"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name"
+		FROM personmodel AS t0
+		WHERE t0.organization_id = $1
+	`)).
+		WithArgs(orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"t0.id", "t0.organization_id", "t0.name"}).
+				AddRow(fredID, orgID, "Fred").
+				AddRow(georgeID, orgID, "George"),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	results, err := p.FilterModelStream(FilterRequest{
+		FilterModel: testdata.PersonModel{},
+	})
+	assert.NoError(t, err)
+
+	var people []testdata.PersonModel
+	for results.Next() {
+		var person testdata.PersonModel
+		assert.NoError(t, results.Scan(&person))
+		people = append(people, person)
+	}
+	assert.NoError(t, results.Err())
+	assert.NoError(t, results.Close())
+
+	assert.Equal(t, []testdata.PersonModel{
+		{ID: fredID, OrganizationID: orgID, Name: "Fred"},
+		{ID: georgeID, OrganizationID: orgID, Name: "George"},
+	}, people)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestFilterModelStreamErrors(t *testing.T) {
+	p := PersistenceORM{
+		multitenancyValue: "00000000-0000-0000-0000-000000000001",
+	}
+
+	_, err := p.FilterModelStream(FilterRequest{
+		FilterModel: testdata.ParentModel{},
+		Associations: []tags.Association{
+			{Name: "Children"},
+		},
+	})
+	assert.EqualError(t, err, "FilterModelStream can't load child association 'Children'")
+
+	results, err := p.FilterModelStream(FilterRequest{
+		FilterModel: []testdata.PersonModel{},
+	})
+	assert.NoError(t, err)
+	assert.False(t, results.Next())
+	assert.EqualError(t, results.Scan(&testdata.PersonModel{}), "Scan called without a successful call to Next")
+	assert.NoError(t, results.Err())
+	assert.NoError(t, results.Close())
+}
+
+func TestFilterModelStreamCursor(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	fredID := "00000000-0000-0000-0000-000000000002"
+	georgeID := "00000000-0000-0000-0000-000000000003"
+	ronID := "00000000-0000-0000-0000-000000000004"
+	columns := []string{"t0.id", "t0.organization_id", "t0.name"}
+
+	testCases := []struct {
+		description         string
+		giveTransaction     bool
+		expectationFunction func(sqlmock.Sqlmock, string)
+		wantPeople          []testdata.PersonModel
+	}{
+		{
+			"fetches batches from a cursor in a transaction it begins and commits",
+			false,
+			func(mock sqlmock.Sqlmock, cursorName string) {
+				mock.ExpectBegin()
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					DECLARE ` + cursorName + ` NO SCROLL CURSOR FOR
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name"
+					FROM personmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`FETCH FORWARD 2 FROM ` + cursorName)).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(fredID, orgID, "Fred").AddRow(georgeID, orgID, "George"))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`FETCH FORWARD 2 FROM ` + cursorName)).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(ronID, orgID, "Ron"))
+				mock.ExpectExec(testdata.FmtSQLRegex(`CLOSE ` + cursorName)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			[]testdata.PersonModel{
+				{ID: fredID, OrganizationID: orgID, Name: "Fred"},
+				{ID: georgeID, OrganizationID: orgID, Name: "George"},
+				{ID: ronID, OrganizationID: orgID, Name: "Ron"},
+			},
+		},
+		{
+			"declares the cursor in the request's transaction",
+			true,
+			func(mock sqlmock.Sqlmock, cursorName string) {
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					DECLARE ` + cursorName + ` NO SCROLL CURSOR FOR
+					SELECT
+						t0.id AS "t0.id",
+						t0.organization_id AS "t0.organization_id",
+						t0.name AS "t0.name"
+					FROM personmodel AS t0
+					WHERE t0.organization_id = $1
+				`)).
+					WithArgs(orgID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`FETCH FORWARD 2 FROM ` + cursorName)).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(fredID, orgID, "Fred").AddRow(georgeID, orgID, "George"))
+				mock.ExpectQuery(testdata.FmtSQLRegex(`FETCH FORWARD 2 FROM ` + cursorName)).
+					WillReturnRows(sqlmock.NewRows(columns))
+				mock.ExpectExec(testdata.FmtSQLRegex(`CLOSE ` + cursorName)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			[]testdata.PersonModel{
+				{ID: fredID, OrganizationID: orgID, Name: "Fred"},
+				{ID: georgeID, OrganizationID: orgID, Name: "George"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			var tx *sql.Tx
+			if tc.giveTransaction {
+				mock.ExpectBegin()
+				tx, err = db.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cursorName := fmt.Sprintf("picard_cursor_%d", atomic.LoadUint64(&cursorCount)+1)
+			tc.expectationFunction(mock, cursorName)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			request := FilterRequest{
+				FilterModel:     testdata.PersonModel{},
+				CursorBatchSize: 2,
+			}
+			if tx != nil {
+				request.Runner = tx
+			}
+
+			results, err := p.FilterModelStream(request)
+			assert.NoError(t, err)
+
+			var people []testdata.PersonModel
+			for results.Next() {
+				var person testdata.PersonModel
+				assert.NoError(t, results.Scan(&person))
+				people = append(people, person)
+			}
+			assert.NoError(t, results.Err())
+			assert.NoError(t, results.Close())
+			assert.Equal(t, tc.wantPeople, people)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type billingCustomer struct {
+	Metadata metadata.Metadata `picard:"tablename=billing.customer"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"lookup,column=name"`
+}
+
+type billingInvoice struct {
+	Metadata metadata.Metadata `picard:"tablename=billing.invoice"`
+
+	ID             string          `picard:"primary_key,column=id"`
+	OrganizationID string          `picard:"multitenancy_key,column=organization_id"`
+	Number         string          `picard:"lookup,column=number"`
+	CustomerID     string          `picard:"foreign_key,lookup,required,related=Customer,column=customer_id"`
+	Customer       billingCustomer `validate:"-"`
+}
+
+func TestSchemaQualifiedTableName(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	customerID := "00000000-0000-0000-0000-000000000002"
+	invoiceID := "00000000-0000-0000-0000-000000000003"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT billing.customer.id, billing.customer.name as billing_customer_name
+		FROM billing.customer
+		WHERE COALESCE(billing.customer.name::"varchar",'') = ANY($1) AND billing.customer.organization_id = $2
+	`)).
+		WithArgs(pq.Array([]string{"Acme"}), orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "billing_customer_name"}))
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		INSERT INTO billing.customer (organization_id,name) VALUES ($1,$2) RETURNING "id"
+	`)).
+		WithArgs(orgID, "Acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(customerID))
+	mock.ExpectCommit()
+
+	p := New(orgID, sampleUserID)
+	err = p.Deploy([]billingCustomer{{Name: "Acme"}})
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.id AS "t0.id",
+			t0.organization_id AS "t0.organization_id",
+			t0.number AS "t0.number",
+			t0.customer_id AS "t0.customer_id",
+			t1.id AS "t1.id",
+			t1.organization_id AS "t1.organization_id",
+			t1.name AS "t1.name"
+		FROM billing.invoice AS t0
+		LEFT JOIN billing.customer AS t1 ON
+			(t1.id = t0.customer_id AND t1.organization_id = $1)
+		WHERE t0.organization_id = $2
+	`)).
+		WithArgs(orgID, orgID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"t0.id", "t0.organization_id", "t0.number", "t0.customer_id",
+				"t1.id", "t1.organization_id", "t1.name",
+			}).
+				AddRow(invoiceID, orgID, "INV-1", customerID, customerID, orgID, "Acme"),
+		)
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel:  billingInvoice{},
+		Associations: []tags.Association{{Name: "Customer"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		billingInvoice{
+			ID:             invoiceID,
+			OrganizationID: orgID,
+			Number:         "INV-1",
+			CustomerID:     customerID,
+			Customer: billingCustomer{
+				ID:             customerID,
+				OrganizationID: orgID,
+				Name:           "Acme",
+			},
+		},
+	}, results)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(testdata.FmtSQLRegex(`
+		DELETE FROM billing.invoice AS t0 WHERE t0.organization_id = $1 AND t0.id = $2
+	`)).
+		WithArgs(orgID, invoiceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rowsAffected, err := p.DeleteModel(billingInvoice{ID: invoiceID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rowsAffected)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
@@ -4,14 +4,22 @@ Package tags generates table metadata by reading picard struct tag annotations
 package tags
 
 import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+	"github.com/skuid/picard/crypto"
 	"github.com/skuid/picard/metadata"
 	qp "github.com/skuid/picard/queryparts"
+	"github.com/skuid/picard/ranges"
 )
 
 const picardTagKey = "picard"
@@ -207,84 +215,963 @@ import "github.com/skuid/picard/tags"
 	})
 
 	// SELECT ... WHERE (t1.field_a = 'foo' AND t1.field_b = 'bar')
+
+# DependsOn lists the names of other associations in the same request that must be loaded before this one
+
+Child associations are loaded in the order they are provided unless a dependency says otherwise.
+
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: ParentModel{},
+		Associations: []tags.Association{
+			{
+				Name:      "ChildrenByGroup",
+				DependsOn: []string{"Children"},
+			},
+			{
+				Name: "Children",
+			},
+		},
+	})
+
+# FilterPlacement decides whether the FieldFilters of a reference association go in the outer WHERE or the ON clause of its LEFT JOIN
+
+A filter on the joined table in the WHERE clause drops the rows it doesn't match, including rows with no related row
+at all, so the LEFT JOIN acts like an INNER JOIN. With FilterInJoin every row is kept, and only the related rows that
+match are loaded. Child associations are loaded with their own query, so their filters always select the children.
+
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: ChildModel{},
+		Associations: []tags.Association{
+			{
+				Name:            "Parent",
+				FilterPlacement: tags.FilterInJoin,
+				FieldFilters: tags.FieldFilter{
+					FieldName:   "Name",
+					FilterValue: "foo",
+				},
+			},
+		},
+	})
+
+	// SELECT ... FROM child AS t0 LEFT JOIN parent AS t1 ON (t1.id = t0.parent_id AND t1.organization_id = $1 AND t1.name = $2)
+
+# MaxDepth loads a self-referential reference again from each related model, up to that many levels
+
+A model that references its own table holds the related model in a pointer field. Each level is joined like the
+first, scoped to the same tenant and with the same SelectFields and Associations, but the FieldFilters only filter
+the first level. The related field of the last level is left nil, like the related field of a model without one.
+
+	type FolderModel struct {
+		Metadata	metadata.Metadata	`picard:"tablename=folder"`
+		ID			string				`picard:"primary_key,column=id"`
+		ParentID	string				`picard:"foreign_key,related=Parent,column=parent_id"`
+		Parent		*FolderModel
+	}
+
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: FolderModel{},
+		Associations: []tags.Association{
+			{
+				Name:     "Parent",
+				MaxDepth: 3,
+			},
+		},
+	})
+
+	// SELECT ... FROM folder AS t0 LEFT JOIN folder AS t1 ON ... LEFT JOIN folder AS t2 ON ... LEFT JOIN folder AS t3 ON ...
+
+# Limit loads at most that many children for each parent
+
+The children of each parent are numbered in the order of the association's OrderBy, and only the first Limit of
+them are loaded. Children loaded through a junction table can't be limited.
+
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: CustomerModel{},
+		Associations: []tags.Association{
+			{
+				Name:    "Orders",
+				OrderBy: []qp.OrderByRequest{{Field: "PlacedAt", Descending: true}},
+				Limit:   5,
+			},
+		},
+	})
+
+	// SELECT * FROM (
+	//	SELECT ..., ROW_NUMBER() OVER (PARTITION BY t0.customer_id ORDER BY t0.placed_at DESC) AS picard_row_number
+	//	FROM orders AS t0 WHERE ...
+	// ) AS picard_ranked WHERE picard_ranked.picard_row_number <= $3 ORDER BY picard_ranked.picard_row_number
 */
 type Association struct {
-	Name         string
-	Associations []Association
-	OrderBy      []qp.OrderByRequest
-	SelectFields []string
-	FieldFilters Filterable
+	Name            string
+	Associations    []Association
+	OrderBy         []qp.OrderByRequest
+	SelectFields    []string
+	FieldFilters    Filterable
+	FilterPlacement FilterPlacement
+	DependsOn       []string
+	MaxDepth        int
+	Limit           uint64
+}
+
+// FilterPlacement decides where the FieldFilters of a reference association are added to the query
+type FilterPlacement int
+
+const (
+	// FilterInWhere adds the filters to the query's WHERE clause, so rows whose related row doesn't match, or
+	// that have no related row, are left out. This is the default.
+	FilterInWhere FilterPlacement = iota
+	// FilterInJoin adds the filters to the ON clause of the association's join, so every row is kept and only
+	// the related rows that match are loaded
+	FilterInJoin
+)
+
+/*
+BuildAssociations turns a list of dot separated association paths into nested associations. Paths that
+share a prefix share the associations for it, and associations keep the order they first appear in.
+
+Example:
+
+	tags.BuildAssociations([]string{"Children.Toys", "Animals", "Children.Pets"})
+
+	// []tags.Association{
+	// 	{
+	// 		Name: "Children",
+	// 		Associations: []tags.Association{
+	// 			{Name: "Toys"},
+	// 			{Name: "Pets"},
+	// 		},
+	// 	},
+	// 	{Name: "Animals"},
+	// }
+*/
+func BuildAssociations(paths []string) []Association {
+	associations := []Association{}
+	for _, path := range paths {
+		associations = addAssociationPath(associations, strings.Split(path, "."))
+	}
+	return associations
+}
+
+func addAssociationPath(associations []Association, names []string) []Association {
+	name := strings.TrimSpace(names[0])
+	if name == "" {
+		return associations
+	}
+	for i := range associations {
+		if associations[i].Name == name {
+			if len(names) > 1 {
+				associations[i].Associations = addAssociationPath(associations[i].Associations, names[1:])
+			}
+			return associations
+		}
+	}
+	association := Association{Name: name}
+	if len(names) > 1 {
+		association.Associations = addAssociationPath(nil, names[1:])
+	}
+	return append(associations, association)
+}
+
+/*
+BuildFieldset turns a list of dot separated field paths, like the fields a client asks for in a partial
+response, into the SelectFields of a model and the associations to load with their own SelectFields. The last
+name in a path is a field, and the names before it are associations of the model. A path that ends in an
+association loads all of its fields, as does a model or association that only has associations named below it.
+
+Example:
+
+	selectFields, associations, err := tags.BuildFieldset(tableA{}, []string{
+		"Name",
+		"AllTheBs.Name",
+		"AllTheBs.AllTheCs",
+	})
+
+	// selectFields: []string{"Name"}
+	// associations: []tags.Association{
+	// 	{
+	// 		Name:         "AllTheBs",
+	// 		SelectFields: []string{"Name"},
+	// 		Associations: []tags.Association{
+	// 			{Name: "AllTheCs"},
+	// 		},
+	// 	},
+	// }
+*/
+func BuildFieldset(model interface{}, paths []string) ([]string, []Association, error) {
+	tableMetadata, err := GetTableMetadata(model)
+	if err != nil {
+		return nil, nil, err
+	}
+	root := &fieldsetNode{metadata: tableMetadata}
+	for _, path := range paths {
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+		if err := root.add(strings.Split(path, "."), path); err != nil {
+			return nil, nil, err
+		}
+	}
+	return root.selectFields(), root.associations(), nil
+}
+
+type fieldsetNode struct {
+	name      string
+	metadata  *TableMetadata
+	selectAll bool
+	fields    []string
+	children  []*fieldsetNode
+}
+
+func (node *fieldsetNode) add(names []string, path string) error {
+	name := strings.TrimSpace(names[0])
+	associationMetadata := node.associationMetadata(name)
+
+	if associationMetadata == nil {
+		if !node.hasField(name) {
+			return fmt.Errorf("fieldset path '%s': '%s' is not a field or association of '%s'", path, name, node.metadata.GetTableName())
+		}
+		if len(names) > 1 {
+			return fmt.Errorf("fieldset path '%s': '%s' is a field of '%s', not an association", path, name, node.metadata.GetTableName())
+		}
+		for _, field := range node.fields {
+			if field == name {
+				return nil
+			}
+		}
+		node.fields = append(node.fields, name)
+		return nil
+	}
+
+	var child *fieldsetNode
+	for _, existing := range node.children {
+		if existing.name == name {
+			child = existing
+			break
+		}
+	}
+	if child == nil {
+		child = &fieldsetNode{name: name, metadata: associationMetadata}
+		node.children = append(node.children, child)
+	}
+	if len(names) == 1 {
+		child.selectAll = true
+		return nil
+	}
+	return child.add(names[1:], path)
+}
+
+func (node *fieldsetNode) associationMetadata(name string) *TableMetadata {
+	if child := node.metadata.GetChildField(name); child != nil {
+		return TableMetadataFromType(child.FieldType.Elem())
+	}
+	if foreignKey := node.metadata.GetForeignKeyFieldFromRelation(name); foreignKey != nil {
+		return foreignKey.TableMetadata
+	}
+	return nil
+}
+
+func (node *fieldsetNode) hasField(name string) bool {
+	if node.metadata.GetField(name).GetName() != "" {
+		return true
+	}
+	for _, field := range node.metadata.GetJoinedFields() {
+		if field.GetName() == name {
+			return true
+		}
+	}
+	for _, field := range node.metadata.GetAggregateFields() {
+		if field.GetName() == name {
+			return true
+		}
+	}
+	for _, field := range node.metadata.GetComputedFields() {
+		if field.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFields is nil, which selects every field, unless only some fields of the node were asked for
+func (node *fieldsetNode) selectFields() []string {
+	if node.selectAll || len(node.fields) == 0 {
+		return nil
+	}
+	return node.fields
+}
+
+func (node *fieldsetNode) associations() []Association {
+	if len(node.children) == 0 {
+		return nil
+	}
+	associations := make([]Association, 0, len(node.children))
+	for _, child := range node.children {
+		associations = append(associations, Association{
+			Name:         child.name,
+			SelectFields: child.selectFields(),
+			Associations: child.associations(),
+		})
+	}
+	return associations
+}
+
+/*
+	FieldFilter defines an arbitrary filter on a FilterRequest
+
+Specify the fields that should be added in a AndFilterGroup or a OrFilterGroup WHERE clause grouping.
+
+Example:
+
+	import "github.com/skuid/picard/tags"
+
+	tags.FieldFilter{
+		FieldName:   "FieldB",
+		FilterValue: "bar",
+	},
+
+SQL translation in WHERE clause grouping:
+
+	t0.field_B = "bar"
+
+Set JSONPath to filter by a key inside a field tagged with jsonb. The key's value is compared as text, and
+a path with dots compares a key inside nested objects.
+
+	tags.FieldFilter{
+		FieldName:   "Config",
+		JSONPath:    "status",
+		FilterValue: "active",
+	},
+
+SQL translation in WHERE clause grouping:
+
+	t0.config->>'status' = "active"
+
+FilterOperator @> compares jsonb, array and range columns by containment, and && compares array and range
+columns by overlap.
+
+Set NullAsEmpty to compare NULL as an empty string, for text columns where an empty string and NULL mean the
+same thing, like the lookups of a deploy. Filtering by an empty string or nil then matches both. An empty string
+isn't a value of other column types, so the filter fails unless the field is a string, without a jsonb, uuid or
+spatial tag, or it filters by a JSONPath.
+
+	tags.FieldFilter{
+		FieldName:   "FieldB",
+		FilterValue: "",
+		NullAsEmpty: true,
+	},
+
+SQL translation in WHERE clause grouping:
+
+	COALESCE(t0.field_b,'') = $2
+*/
+type FieldFilter struct {
+	FieldName      string
+	FilterValue    interface{}
+	FilterOperator string
+	JSONPath       string
+	NullAsEmpty    bool
+}
+
+// Apply applies the filter
+func (ff FieldFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	// Return early if no fieldname was provided in our filter
+	if ff.FieldName == "" {
+		return squirrel.Eq{}
+	}
+	fieldMetadata := metadata.GetField(ff.FieldName)
+	columnName := fieldMetadata.GetColumnName()
+	expr := fmt.Sprintf(qp.AliasedField, table.Alias, columnName)
+	if ff.JSONPath != "" {
+		if !fieldMetadata.IsJSONB() {
+			return invalidFilter{err: fmt.Errorf("field '%s' on table '%s' must be tagged with jsonb to filter by a JSON path", ff.FieldName, metadata.GetTableName())}
+		}
+		expr = jsonPathExpr(expr, ff.JSONPath)
+	} else if fieldMetadata.IsDeterministic() && ff.FilterOperator == "" {
+		// Ciphertexts only match for equality, so other operators are left to compare them as they are
+		encrypted, err := EncryptDeterministic(ff.FilterValue)
+		if err != nil {
+			return invalidFilter{err: err}
+		}
+		return squirrel.Eq{expr: encrypted}
+	}
+	switch ff.FilterOperator {
+	case "<":
+		return squirrel.Lt{expr: ff.FilterValue}
+	case "<=":
+		return squirrel.LtOrEq{expr: ff.FilterValue}
+	case ">":
+		return squirrel.Gt{expr: ff.FilterValue}
+	case ">=":
+		return squirrel.GtOrEq{expr: ff.FilterValue}
+	case "@>", "&&":
+		if ff.JSONPath == "" && fieldMetadata.IsJSONB() {
+			document, err := jsonbDocument(ff.FilterValue)
+			if err != nil {
+				return invalidFilter{err: err}
+			}
+			return squirrel.Expr(fmt.Sprintf("%s %s ?::jsonb", expr, ff.FilterOperator), document)
+		}
+		if ff.JSONPath == "" && isArrayField(fieldMetadata) {
+			return squirrel.Expr(fmt.Sprintf("%s %s ?", expr, ff.FilterOperator), arrayValue(ff.FilterValue))
+		}
+		return squirrel.Expr(fmt.Sprintf("%s %s ?%s", expr, ff.FilterOperator, rangeValueCast(fieldMetadata, ff.FilterValue)), ff.FilterValue)
+	default:
+		if ff.NullAsEmpty {
+			if ff.JSONPath == "" && !isTextField(fieldMetadata) {
+				return invalidFilter{err: fmt.Errorf("field '%s' on table '%s' must be a text column to compare NULL as an empty string", ff.FieldName, metadata.GetTableName())}
+			}
+			filterValue := ff.FilterValue
+			if filterValue == nil {
+				filterValue = ""
+			}
+			return squirrel.Eq{fmt.Sprintf("COALESCE(%s,'')", expr): filterValue}
+		}
+		return squirrel.Eq{expr: ff.FilterValue}
+	}
+}
+
+// isTextField reports whether a field's column holds text, so it can be coalesced to an empty string
+func isTextField(field FieldMetadata) bool {
+	fieldType := field.GetFieldType()
+	if fieldType == nil {
+		return false
+	}
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	return fieldType.Kind() == reflect.String && !field.IsJSONB() && !field.IsUUID() && field.GetSpatialType() == ""
+}
+
+// jsonPathExpr selects the text of the key at a dotted path inside a jsonb column. The keys are written as
+// quoted literals rather than parameters, so the filter can use an expression index like ((config->>'status')).
+func jsonPathExpr(expr string, path string) string {
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		operator := "->"
+		if i == len(keys)-1 {
+			operator = "->>"
+		}
+		expr = fmt.Sprintf("%s%s'%s'", expr, operator, strings.ReplaceAll(key, "'", "''"))
+	}
+	return expr
+}
+
+// jsonbDocument serializes the value compared to a jsonb column with @>. Strings and byte slices are taken to be
+// JSON documents already, and other values are marshaled with encoding/json.
+func jsonbDocument(value interface{}) (string, error) {
+	switch document := value.(type) {
+	case string:
+		return document, nil
+	case []byte:
+		return string(document), nil
+	}
+	document, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(document), nil
+}
+
+// isArrayField reports whether a field is stored in a Postgres array column, which holds slices other than
+// bytes that aren't serialized to jsonb
+func isArrayField(fieldMetadata FieldMetadata) bool {
+	fieldType := fieldMetadata.GetFieldType()
+	return fieldType != nil && fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() != reflect.Uint8 && !fieldMetadata.IsJSONB()
+}
+
+// arrayValue binds a slice compared to an array column as a Postgres array, unless it already knows how to
+func arrayValue(value interface{}) interface{} {
+	if _, ok := value.(driver.Valuer); ok {
+		return value
+	}
+	if value != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+		return pq.Array(value)
+	}
+	return value
+}
+
+// rangeValueCast casts the value compared to a range column with @> or &&, since Postgres can't tell whether a
+// text parameter is a range or one of its points. Values of the field's own type are cast to the range type,
+// and other values to the type of its points. Nothing is cast if the range tag doesn't name the type.
+func rangeValueCast(fieldMetadata FieldMetadata, value interface{}) string {
+	rangeType := fieldMetadata.GetRangeType()
+	if rangeType == "" {
+		return ""
+	}
+	if reflect.TypeOf(value) == fieldMetadata.GetFieldType() {
+		return "::" + rangeType
+	}
+	if elementType := ranges.ElementType(rangeType); elementType != "" {
+		return "::" + elementType
+	}
+	return ""
+}
+
+// OrFilterGroup applies a group of filters using ors
+type OrFilterGroup []Filterable
+
+// Apply applies the filter
+func (ofg OrFilterGroup) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	ors := squirrel.Or{}
+	for _, filter := range ofg {
+		ors = append(ors, filter.Apply(table, metadata))
+	}
+	return ors
+}
+
+// AndFilterGroup applies a group of filters using ands
+type AndFilterGroup []Filterable
+
+// Apply applies the filter
+func (afg AndFilterGroup) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	ands := squirrel.And{}
+	for _, filter := range afg {
+		ands = append(ands, filter.Apply(table, metadata))
+	}
+	return ands
+}
+
+/*
+	ChildAggregateFilter filters models by an aggregate over the rows of one of their children
+
+The aggregate is computed in a correlated subquery, so the parent query does not need a GROUP BY. ChildName
+is the name of the field with the `child` tag. Aggregate is one of COUNT, SUM, AVG, MIN or MAX and defaults
+to COUNT, and FieldName may be left empty to count all rows. FilterOperator is one of =, <>, <, <=, > or >=,
+and defaults to =. FieldFilters are applied to the child rows before aggregating. Soft-deleted child rows are
+left out of the aggregate unless IncludeDeleted is set.
+
+Example:
+
+	import "github.com/skuid/picard/tags"
+
+	// Parents with at least 3 active children
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: ParentModel{},
+		FieldFilters: tags.ChildAggregateFilter{
+			ChildName: "Children",
+			FieldFilters: tags.FieldFilter{
+				FieldName:   "IsActive",
+				FilterValue: true,
+			},
+			FilterOperator: ">=",
+			FilterValue:    3,
+		},
+	})
+
+SQL translation in WHERE clause grouping:
+
+	(SELECT COUNT(*) FROM child AS t0_children WHERE t0_children.parent_id = t0.id AND ...) >= 3
+*/
+type ChildAggregateFilter struct {
+	ChildName      string
+	Aggregate      string
+	FieldName      string
+	FieldFilters   Filterable
+	FilterValue    interface{}
+	FilterOperator string
+	IncludeDeleted bool
+}
+
+// aggregateFunctions are the aggregate functions queries can compute
+var aggregateFunctions = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MIN":   true,
+	"MAX":   true,
+}
+
+// IsAggregateFunction reports whether fn is one of the aggregate functions COUNT, SUM, AVG, MIN or MAX, in any case
+func IsAggregateFunction(fn string) bool {
+	return aggregateFunctions[strings.ToUpper(fn)]
+}
+
+// comparisonOperators are the operators aggregates can be compared with
+var comparisonOperators = map[string]bool{
+	"=":  true,
+	"<>": true,
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+}
+
+// IsComparisonOperator reports whether operator is one of =, <>, <, <=, > or >=
+func IsComparisonOperator(operator string) bool {
+	return comparisonOperators[operator]
+}
+
+// Apply applies the filter
+func (caf ChildAggregateFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	operator := caf.FilterOperator
+	if operator == "" {
+		operator = "="
+	}
+	if !IsComparisonOperator(operator) {
+		return aggregateSubquery{err: fmt.Errorf("unsupported FilterOperator '%s' for child aggregate filter", caf.FilterOperator)}
+	}
+
+	query, err := caf.Subquery(table, metadata)
+	if err != nil {
+		return aggregateSubquery{err: err}
+	}
+
+	return aggregateSubquery{
+		query:    query,
+		operator: operator,
+		value:    caf.FilterValue,
+	}
+}
+
+// Subquery returns the correlated subquery that computes the aggregate for each row of the table, without
+// comparing it to FilterValue
+func (caf ChildAggregateFilter) Subquery(table *qp.Table, metadata *TableMetadata) (squirrel.SelectBuilder, error) {
+	aggregate := strings.ToUpper(caf.Aggregate)
+	if aggregate == "" {
+		aggregate = "COUNT"
+	}
+	if !IsAggregateFunction(aggregate) {
+		return squirrel.SelectBuilder{}, fmt.Errorf("unsupported aggregate function '%s'", caf.Aggregate)
+	}
+	if caf.FieldName == "" && aggregate != "COUNT" {
+		return squirrel.SelectBuilder{}, fmt.Errorf("aggregate function '%s' requires a field", aggregate)
+	}
+	return childSubquery(caf.ChildName, caf.FieldFilters, caf.IncludeDeleted, table, metadata, func(childTable *qp.Table, childMetadata *TableMetadata) (string, error) {
+		aggregateColumn := "*"
+		if caf.FieldName != "" {
+			columnName := childMetadata.GetField(caf.FieldName).GetColumnName()
+			if columnName == "" {
+				return "", fmt.Errorf("aggregate field '%s' is not a column on table '%s'", caf.FieldName, childMetadata.GetTableName())
+			}
+			aggregateColumn = fmt.Sprintf(qp.AliasedField, childTable.Alias, columnName)
+		}
+		return fmt.Sprintf("%s(%s)", aggregate, aggregateColumn), nil
+	})
+}
+
+// childSubquery selects the column returned by selectColumn from the rows of a child that belong to each row of
+// the table, in the same tenant, and match the child's filters. Soft-deleted children are left out unless
+// includeDeleted is set. A child's foreign key holds a single column, so tables with a composite primary key
+// can't be correlated with their children.
+func childSubquery(
+	childName string,
+	fieldFilters Filterable,
+	includeDeleted bool,
+	table *qp.Table,
+	metadata *TableMetadata,
+	selectColumn func(childTable *qp.Table, childMetadata *TableMetadata) (string, error),
+) (squirrel.SelectBuilder, error) {
+	child := metadata.GetChildField(childName)
+	if child == nil {
+		return squirrel.SelectBuilder{}, fmt.Errorf("no child field '%s' defined on table '%s'", childName, metadata.GetTableName())
+	}
+	if child.ForeignKey == "" {
+		return squirrel.SelectBuilder{}, fmt.Errorf("child field '%s' must define a foreign_key to be used in a child filter", childName)
+	}
+	if len(metadata.GetPrimaryKeyColumnNames()) > 1 {
+		return squirrel.SelectBuilder{}, fmt.Errorf("child filters aren't supported on table '%s', which has a composite primary key", metadata.GetTableName())
+	}
+
+	childMetadata := TableMetadataFromType(child.FieldType.Elem())
+	childTable := qp.NewAliased(childMetadata.GetTableName(), table.Alias+"_"+strings.ToLower(childName), "")
+
+	column, err := selectColumn(childTable, childMetadata)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
+	}
+
+	query := squirrel.Select(column).
+		From(fmt.Sprintf("%s AS %s", childTable.Name, childTable.Alias)).
+		Where(fmt.Sprintf(
+			"%s = %s",
+			fmt.Sprintf(qp.AliasedField, childTable.Alias, childMetadata.GetField(child.ForeignKey).GetColumnName()),
+			fmt.Sprintf(qp.AliasedField, table.Alias, metadata.GetPrimaryKeyColumnName()),
+		))
+
+	// Scope the subquery to the same tenant as the parent
+	multitenancyColumn := childMetadata.GetMultitenancyKeyColumnName()
+	if multitenancyColumn != "" && table.MultiTenancy != nil {
+		for _, multitenancyVal := range table.MultiTenancy {
+			childTable.AddMultitenancyWhere(multitenancyColumn, multitenancyVal)
+		}
+		query = query.Where(childTable.MultiTenancyWhere())
+	}
+
+	softDeleteColumn := childMetadata.GetSoftDeleteColumnName()
+	if softDeleteColumn != "" && !includeDeleted {
+		query = query.Where(squirrel.Eq{fmt.Sprintf(qp.AliasedField, childTable.Alias, softDeleteColumn): nil})
+	}
+
+	if fieldFilters != nil {
+		query = query.Where(fieldFilters.Apply(childTable, childMetadata))
+	}
+
+	return query, nil
+}
+
+// aggregateSubquery compares the result of a correlated subquery to a value
+type aggregateSubquery struct {
+	query    squirrel.SelectBuilder
+	operator string
+	value    interface{}
+	err      error
+}
+
+func (as aggregateSubquery) ToSql() (string, []interface{}, error) {
+	if as.err != nil {
+		return "", nil, as.err
+	}
+	sql, args, err := as.query.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("(%s) %s ?", sql, as.operator), append(args, as.value), nil
+}
+
+/*
+	DistanceFilter filters models by whether a PostGIS column is within a distance of a point
+
+FieldName must be tagged with `spatial=geography` or `spatial=geometry`. For geography columns Distance is in
+meters. For geometry columns the point takes the column's SRID, and Distance is in the units of that SRID.
+
+Example:
+
+	import "github.com/skuid/picard/tags"
+
+	// Stores within 5km of a point
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: StoreModel{},
+		FieldFilters: tags.DistanceFilter{
+			FieldName: "Location",
+			Longitude: -86.7816,
+			Latitude:  36.1627,
+			Distance:  5000,
+		},
+	})
+
+SQL translation in WHERE clause grouping:
+
+	ST_DWithin(t0.location, ST_MakePoint($1, $2)::geography, $3)
+*/
+type DistanceFilter struct {
+	FieldName string
+	Longitude float64
+	Latitude  float64
+	Distance  float64
+}
+
+// Apply applies the filter
+func (df DistanceFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	fieldMetadata := metadata.GetField(df.FieldName)
+	column := fmt.Sprintf(qp.AliasedField, table.Alias, fieldMetadata.GetColumnName())
+
+	var point string
+	switch fieldMetadata.GetSpatialType() {
+	case "geography":
+		point = "ST_MakePoint(?, ?)::geography"
+	case "geometry":
+		// ST_DWithin requires both geometries to have the same SRID
+		point = fmt.Sprintf("ST_SetSRID(ST_MakePoint(?, ?), ST_SRID(%s))", column)
+	default:
+		return invalidFilter{err: fmt.Errorf("field '%s' on table '%s' must be tagged with spatial=geography or spatial=geometry to filter by distance", df.FieldName, metadata.GetTableName())}
+	}
+
+	return squirrel.Expr(fmt.Sprintf("ST_DWithin(%s, %s, ?)", column, point), df.Longitude, df.Latitude, df.Distance)
+}
+
+/*
+	TextSearchFilter filters models by whether a column matches a full-text search
+
+The column's text is parsed with to_tsvector and the search with plainto_tsquery, both with the text search
+configuration named in Config, or english by default. The configuration is written into the query rather than
+passed as a parameter, so a GIN index on the same expression, like
+((to_tsvector('english', name))), can serve the filter.
+
+Example:
+
+	import "github.com/skuid/picard/tags"
+
+	// Products whose names mention both words
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: ProductModel{},
+		FieldFilters: tags.TextSearchFilter{
+			FieldName: "Name",
+			Query:     "red widget",
+		},
+	})
+
+SQL translation in WHERE clause grouping:
+
+	to_tsvector('english', t0.name) @@ plainto_tsquery('english', $1)
+*/
+type TextSearchFilter struct {
+	FieldName string
+	Query     string
+	Config    string
+}
+
+// Apply applies the filter
+func (tsf TextSearchFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	columnName := metadata.GetField(tsf.FieldName).GetColumnName()
+	if columnName == "" {
+		return invalidFilter{err: fmt.Errorf("field '%s' on table '%s' can't be searched, it isn't a column", tsf.FieldName, metadata.GetTableName())}
+	}
+	column := fmt.Sprintf(qp.AliasedField, table.Alias, columnName)
+
+	config := tsf.Config
+	if config == "" {
+		config = "english"
+	}
+	config = "'" + strings.ReplaceAll(config, "'", "''") + "'"
+
+	return squirrel.Expr(fmt.Sprintf("to_tsvector(%s, %s) @@ plainto_tsquery(%s, ?)", config, column, config), tsf.Query)
+}
+
+// invalidFilter is returned by filters that can't be applied, and fails the query with their error
+type invalidFilter struct {
+	err error
+}
+
+func (inf invalidFilter) ToSql() (string, []interface{}, error) {
+	return "", nil, inf.err
 }
 
 /*
-	FieldFilter defines an arbitrary filter on a FilterRequest
+	JunctionExistsFilter filters models by the existence of a row in a junction table, without loading it
 
-Specify the fields that should be added in a AndFilterGroup or a OrFilterGroup WHERE clause grouping.
+JunctionModel is a picard struct for the junction table. ParentKeyField is the field on the junction model
+that holds the filtered model's primary key, and ChildKeyField is the field that is compared to FilterValue.
+The junction rows are scoped to the same tenant as the filtered model.
 
 Example:
 
 	import "github.com/skuid/picard/tags"
 
-	tags.FieldFilter{
-		FieldName:   "FieldB",
-		FilterValue: "bar",
-	},
+	// Entities the user has access to through any permission set
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: EntityModel{},
+		FieldFilters: tags.JunctionExistsFilter{
+			JunctionModel:  EntityPermissionSetModel{},
+			ParentKeyField: "EntityID",
+			ChildKeyField:  "PermissionSetID",
+			FilterValue:    permissionSetID,
+		},
+	})
 
 SQL translation in WHERE clause grouping:
 
-	t0.field_B = "bar"
+	EXISTS (SELECT 1 FROM entity_permission_set AS t0_entity_permission_set
+	WHERE t0_entity_permission_set.entity_id = t0.id AND t0_entity_permission_set.permission_set_id = $1 AND ...)
 */
-type FieldFilter struct {
-	FieldName      string
+type JunctionExistsFilter struct {
+	JunctionModel  interface{}
+	ParentKeyField string
+	ChildKeyField  string
 	FilterValue    interface{}
-	FilterOperator string
 }
 
 // Apply applies the filter
-func (ff FieldFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
-	// Return early if no fieldname was provided in our filter
-	if ff.FieldName == "" {
-		return squirrel.Eq{}
+func (jef JunctionExistsFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	junctionType := reflect.TypeOf(jef.JunctionModel)
+	if junctionType != nil && junctionType.Kind() == reflect.Ptr {
+		junctionType = junctionType.Elem()
 	}
-	fieldMetadata := metadata.GetField(ff.FieldName)
-	columnName := fieldMetadata.GetColumnName()
-	expr := fmt.Sprintf(qp.AliasedField, table.Alias, columnName)
-	switch ff.FilterOperator {
-	case "<":
-		return squirrel.Lt{expr: ff.FilterValue}
-	case "<=":
-		return squirrel.LtOrEq{expr: ff.FilterValue}
-	case ">":
-		return squirrel.Gt{expr: ff.FilterValue}
-	case ">=":
-		return squirrel.GtOrEq{expr: ff.FilterValue}
-	default:
-		return squirrel.Eq{expr: ff.FilterValue}
+	if junctionType == nil || junctionType.Kind() != reflect.Struct {
+		return existsSubquery{err: errors.New("JunctionModel must be a struct")}
 	}
+	junctionMetadata := TableMetadataFromType(junctionType)
+
+	parentKeyColumn := junctionMetadata.GetField(jef.ParentKeyField).GetColumnName()
+	if parentKeyColumn == "" {
+		return existsSubquery{err: fmt.Errorf("no field '%s' defined on junction table '%s'", jef.ParentKeyField, junctionMetadata.GetTableName())}
+	}
+	childKeyColumn := junctionMetadata.GetField(jef.ChildKeyField).GetColumnName()
+	if childKeyColumn == "" {
+		return existsSubquery{err: fmt.Errorf("no field '%s' defined on junction table '%s'", jef.ChildKeyField, junctionMetadata.GetTableName())}
+	}
+
+	junctionTable := qp.NewAliased(junctionMetadata.GetTableName(), table.Alias+"_"+junctionMetadata.GetTableName(), "")
+
+	query := squirrel.Select("1").
+		From(fmt.Sprintf("%s AS %s", junctionTable.Name, junctionTable.Alias)).
+		Where(fmt.Sprintf(
+			"%s = %s",
+			fmt.Sprintf(qp.AliasedField, junctionTable.Alias, parentKeyColumn),
+			fmt.Sprintf(qp.AliasedField, table.Alias, metadata.GetPrimaryKeyColumnName()),
+		)).
+		Where(squirrel.Eq{fmt.Sprintf(qp.AliasedField, junctionTable.Alias, childKeyColumn): jef.FilterValue})
+
+	// Scope the subquery to the same tenant as the parent
+	multitenancyColumn := junctionMetadata.GetMultitenancyKeyColumnName()
+	if multitenancyColumn != "" && table.MultiTenancy != nil {
+		for _, multitenancyVal := range table.MultiTenancy {
+			junctionTable.AddMultitenancyWhere(multitenancyColumn, multitenancyVal)
+		}
+		query = query.Where(junctionTable.MultiTenancyWhere())
+	}
+
+	return existsSubquery{query: query}
 }
 
-// OrFilterGroup applies a group of filters using ors
-type OrFilterGroup []Filterable
+/*
+	ChildExistsFilter filters models by whether they have a child matching a filter, without loading children
+
+ChildName is the name of the field with the `child` tag, which must define a foreign_key. FieldFilters are
+applied to the child rows, and may be left empty to keep models with any children. The child rows are scoped
+to the same tenant as the filtered model, and soft-deleted children are ignored unless IncludeDeleted is set.
+
+Example:
+
+	import "github.com/skuid/picard/tags"
+
+	// Parents that have an active pet
+	p.FilterModel(picard.FilterRequest{
+		FilterModel: ParentModel{},
+		FieldFilters: tags.ChildExistsFilter{
+			ChildName: "Animals",
+			FieldFilters: tags.FieldFilter{
+				FieldName:   "IsActive",
+				FilterValue: true,
+			},
+		},
+	})
+
+SQL translation in WHERE clause grouping:
+
+	EXISTS (SELECT 1 FROM petmodel AS t0_animals WHERE t0_animals.parent_id = t0.id AND ...)
+*/
+type ChildExistsFilter struct {
+	ChildName      string
+	FieldFilters   Filterable
+	IncludeDeleted bool
+}
 
 // Apply applies the filter
-func (ofg OrFilterGroup) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
-	ors := squirrel.Or{}
-	for _, filter := range ofg {
-		ors = append(ors, filter.Apply(table, metadata))
+func (cef ChildExistsFilter) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
+	query, err := childSubquery(cef.ChildName, cef.FieldFilters, cef.IncludeDeleted, table, metadata, func(*qp.Table, *TableMetadata) (string, error) {
+		return "1", nil
+	})
+	if err != nil {
+		return existsSubquery{err: err}
 	}
-	return ors
+	return existsSubquery{query: query}
 }
 
-// AndFilterGroup applies a group of filters using ands
-type AndFilterGroup []Filterable
+// existsSubquery checks that a correlated subquery returns at least one row
+type existsSubquery struct {
+	query squirrel.SelectBuilder
+	err   error
+}
 
-// Apply applies the filter
-func (afg AndFilterGroup) Apply(table *qp.Table, metadata *TableMetadata) squirrel.Sqlizer {
-	ands := squirrel.And{}
-	for _, filter := range afg {
-		ands = append(ands, filter.Apply(table, metadata))
+func (es existsSubquery) ToSql() (string, []interface{}, error) {
+	if es.err != nil {
+		return "", nil, es.err
 	}
-	return ands
+	sql, args, err := es.query.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("EXISTS (%s)", sql), args, nil
 }
 
 // Filterable interface allows filters to be specified in Filter Requests
@@ -302,6 +1189,71 @@ type Lookup struct {
 	SubQuery            []Lookup
 	SubQueryForeignKey  string
 	SubQueryMetadata    *TableMetadata
+	// CastType is the type the column is cast to when matching lookup keys, varchar if empty
+	CastType string
+	// DisableCast matches lookup keys against the column without casting it
+	DisableCast bool
+	// IsUUID is set for lookups of uuid columns, which are compared as uuids when they're the only lookup
+	IsUUID bool
+}
+
+// castTypePattern matches the type names the cast tag accepts: an optionally schema qualified name of words,
+// like character varying, with an optional modifier and array brackets
+var castTypePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*( [A-Za-z_][A-Za-z0-9_$]*)*(\.[A-Za-z_][A-Za-z0-9_$]*( [A-Za-z_][A-Za-z0-9_$]*)*)?(\(\d+(, ?\d+)?\))?(\[\])*$`)
+
+// ValidateCastType returns an error if the lookup's cast type isn't a type name. The type is written into the
+// lookup query as is, so anything else is rejected.
+func (l Lookup) ValidateCastType() error {
+	if l.CastType == "" || l.DisableCast || castTypePattern.MatchString(l.CastType) {
+		return nil
+	}
+	return fmt.Errorf("invalid cast type '%s' for lookup column '%s'", l.CastType, l.MatchDBColumn)
+}
+
+// GetCastColumn returns the column cast to the type used when matching lookup keys
+func (l Lookup) GetCastColumn(column string) string {
+	if l.DisableCast {
+		return column
+	}
+	if l.CastType == "" {
+		return fmt.Sprintf("%v::\"varchar\"", column)
+	}
+	return fmt.Sprintf("%v::%v", column, l.CastType)
+}
+
+// IsCaseInsensitive reports whether lookup keys are matched regardless of case, for columns cast to citext
+func (l Lookup) IsCaseInsensitive() bool {
+	return strings.EqualFold(l.CastType, "citext")
+}
+
+// IsMatchedAsText reports whether lookup keys are matched against the column as text, with GetKeyExpression.
+// Uncast and citext lookups are matched against the column itself, with GetMatchExpression, so they keep the
+// column's own comparison and index.
+func (l Lookup) IsMatchedAsText() bool {
+	return !l.DisableCast && !l.IsCaseInsensitive()
+}
+
+// GetKeyExpression returns the text that lookup keys are matched against for the column. A changed cast is
+// coalesced as text, since an empty string isn't a value of every type.
+func (l Lookup) GetKeyExpression(column string) string {
+	castColumn := l.GetCastColumn(column)
+	if l.CastType != "" {
+		castColumn += "::text"
+	}
+	return fmt.Sprintf("COALESCE(%v,'')", castColumn)
+}
+
+// GetMatchExpression returns the condition matching the column to one lookup key. An empty key matches NULL,
+// and an empty string too for columns cast to citext.
+func (l Lookup) GetMatchExpression(column string, key string) squirrel.Sqlizer {
+	castColumn := l.GetCastColumn(column)
+	if key != "" {
+		return squirrel.Expr(castColumn+" = ?", key)
+	}
+	if l.DisableCast {
+		return squirrel.Expr(column + " IS NULL")
+	}
+	return squirrel.Expr(fmt.Sprintf("COALESCE(%v,'') = ''", castColumn))
 }
 
 // Child structure
@@ -313,7 +1265,20 @@ type Child struct {
 	KeyMapping       string
 	ValueMappings    map[string]string
 	GroupingCriteria map[string]string
-	DeleteOrphans    bool
+	// GroupingFields are the child fields of GroupingCriteria, in the order of the tag
+	GroupingFields []string
+	DeleteOrphans  bool
+	Junction       *Junction
+	// OrderedField names a slice field that a map child is also loaded into, in the order of its association
+	OrderedField string
+}
+
+// Junction describes the table that links a parent to the children of a many-to-many relationship
+type Junction struct {
+	TableName             string
+	ParentKeyColumn       string
+	ChildKeyColumn        string
+	MultitenancyKeyColumn string
 }
 
 // ForeignKey structure
@@ -335,8 +1300,23 @@ type FieldMetadata struct {
 	isPrimaryKey      bool
 	isMultitenancyKey bool
 	isJSONB           bool
+	jsonbCodec        string
+	discriminator     string
 	isEncrypted       bool
+	isDeterministic   bool
 	isFK              bool
+	isSoftDelete      bool
+	isReturning       bool
+	isXmin            bool
+	isVersion         bool
+	isRange           bool
+	rangeType         string
+	isPGArray         bool
+	spatialType       string
+	timestampType     string
+	isUUID            bool
+	check             string
+	joinRelation      string
 	relatedField      reflect.StructField
 	columnName        string
 	audit             string
@@ -345,7 +1325,7 @@ type FieldMetadata struct {
 
 // IncludeInUpdate function
 func (fm FieldMetadata) IncludeInUpdate() bool {
-	return !fm.isPrimaryKey && !fm.isMultitenancyKey && fm.audit != "created_at" && fm.audit != "created_by"
+	return !fm.isPrimaryKey && !fm.isMultitenancyKey && !fm.isXmin && fm.audit != "created_at" && fm.audit != "created_by"
 }
 
 // GetAudit function
@@ -358,6 +1338,17 @@ func (fm FieldMetadata) IsJSONB() bool {
 	return fm.isJSONB
 }
 
+// GetJSONBCodec returns the name of the jsonb codec set in a field's jsonb tag, like ordered for jsonb=ordered
+func (fm FieldMetadata) GetJSONBCodec() string {
+	return fm.jsonbCodec
+}
+
+// GetDiscriminator returns the name of the field whose value picks the concrete type a jsonb field with an
+// interface type is unmarshaled into, set in its discriminator tag
+func (fm FieldMetadata) GetDiscriminator() string {
+	return fm.discriminator
+}
+
 // IsPrimaryKey function
 func (fm FieldMetadata) IsPrimaryKey() bool {
 	return fm.isPrimaryKey
@@ -403,13 +1394,96 @@ func (fm FieldMetadata) IsEncrypted() bool {
 	return fm.isEncrypted
 }
 
+// IsDeterministic reports whether an encrypted field is tagged with encrypted=deterministic, so the same value
+// always encrypts to the same ciphertext and the field can be filtered by equality
+func (fm FieldMetadata) IsDeterministic() bool {
+	return fm.isDeterministic
+}
+
+// IsSoftDelete function
+func (fm FieldMetadata) IsSoftDelete() bool {
+	return fm.isSoftDelete
+}
+
+// IsReturning reports whether the column's value is set by the database and returned after inserts and updates.
+// It is true for columns tagged with either returning or generated, and for the audit timestamps of tables
+// tagged with db_audit.
+func (fm FieldMetadata) IsReturning() bool {
+	return fm.isReturning
+}
+
+// IsVersion reports whether the field is the table's version column, which guards updates against overwriting
+// changes made since the model was read
+func (fm FieldMetadata) IsVersion() bool {
+	return fm.isVersion
+}
+
+// IsXmin reports whether the field reads the row's xmin system column, the id of the transaction that last
+// wrote it. Xmin fields are never written.
+func (fm FieldMetadata) IsXmin() bool {
+	return fm.isXmin
+}
+
+// IsRange reports whether the field maps a Postgres range column, with a type from the ranges package
+func (fm FieldMetadata) IsRange() bool {
+	return fm.isRange
+}
+
+// IsPGArray reports whether the field maps a native Postgres array column, like text[], with a slice that is
+// written and read with pq.Array
+func (fm FieldMetadata) IsPGArray() bool {
+	return fm.isPGArray
+}
+
+// GetRangeType returns the Postgres type of a range column, like tstzrange, if it was set in the range tag
+func (fm FieldMetadata) GetRangeType() string {
+	return fm.rangeType
+}
+
+// GetCheck returns the boolean SQL expression of the field's check tag, like amount >= 0, or an empty string
+func (fm FieldMetadata) GetCheck() string {
+	return fm.check
+}
+
+// GetSpatialType returns geography or geometry for a PostGIS column tagged with spatial, or an empty string
+func (fm FieldMetadata) GetSpatialType() string {
+	return fm.spatialType
+}
+
+// GetTimestampType returns timestamp or timestamptz for a time field tagged with the type of its column, which
+// its values are cast to when they are written, or an empty string
+func (fm FieldMetadata) GetTimestampType() string {
+	return fm.timestampType
+}
+
+// IsUUID returns true for fields of uuid columns, tagged with uuid or of the type uuid.UUID
+func (fm FieldMetadata) IsUUID() bool {
+	return fm.isUUID
+}
+
+// GetJoinRelation returns the related field of the foreign key whose table a field tagged with join reads its
+// column from
+func (fm FieldMetadata) GetJoinRelation() string {
+	return fm.joinRelation
+}
+
 // TableMetadata structure
 type TableMetadata struct {
 	tableName            string
 	primaryKeyField      string
 	multitenancyKeyField string
+	softDeleteField      string
+	deleteFlagField      string
+	versionField         string
+	isMaterializedView   bool
+	isTenantExempt       bool
+	hasDBAudit           bool
+	conflictTarget       []string
 	fields               map[string]FieldMetadata
 	fieldOrder           []string
+	joinedFields         []FieldMetadata
+	aggregateFields      []FieldMetadata
+	computedFields       []FieldMetadata
 	lookups              []Lookup
 	foreignKeys          []ForeignKey
 	children             []Child
@@ -439,27 +1513,51 @@ func (tm TableMetadata) GetTableName() string {
 	return tm.tableName
 }
 
-// GetColumnNames gets the column names
+// IsMaterializedView reports whether the table is a materialized view, which can be read and refreshed but not written to
+func (tm TableMetadata) IsMaterializedView() bool {
+	return tm.isMaterializedView
+}
+
+// IsTenantExempt reports whether the table is shared by every tenant, so it isn't scoped by a multitenancy key
+func (tm TableMetadata) IsTenantExempt() bool {
+	return tm.isTenantExempt
+}
+
+// GetConflictTarget returns the columns of the unique index that inserts into the table upsert on with
+// ON CONFLICT, or nil if the table doesn't have a conflict_target tag
+func (tm TableMetadata) GetConflictTarget() []string {
+	return tm.conflictTarget
+}
+
+// HasDBAudit reports whether the table's audit timestamps are set by the database instead of picard
+func (tm TableMetadata) HasDBAudit() bool {
+	return tm.hasDBAudit
+}
+
+// GetColumnNames gets the column names in the order the fields are declared, which is the order columns are
+// written in inserts and updates
 func (tm TableMetadata) GetColumnNames() []string {
 	columnNames := []string{}
 	for _, field := range tm.GetFields() {
-		columnNames = append(columnNames, field.columnName)
+		if !field.isXmin {
+			columnNames = append(columnNames, field.columnName)
+		}
 	}
 	return columnNames
 }
 
-// GetColumnNamesWithoutPrimaryKey gets the columm names, but excludes the primary key
+// GetColumnNamesWithoutPrimaryKey gets the columm names in declaration order, but excludes the primary key
 func (tm TableMetadata) GetColumnNamesWithoutPrimaryKey() []string {
 	columnNames := []string{}
 	for _, field := range tm.GetFields() {
-		if !field.isPrimaryKey {
+		if !field.isPrimaryKey && !field.isXmin {
 			columnNames = append(columnNames, field.columnName)
 		}
 	}
 	return columnNames
 }
 
-// GetColumnNamesForUpdate gets the columm names, but excludes certain fields
+// GetColumnNamesForUpdate gets the columm names in declaration order, but excludes certain fields
 func (tm TableMetadata) GetColumnNamesForUpdate() []string {
 	columnNames := []string{}
 	for _, field := range tm.GetFields() {
@@ -522,6 +1620,68 @@ func (tm TableMetadata) GetEncryptedColumns() []string {
 	return columnNames
 }
 
+// GetDeterministicColumns gets the names of the encrypted columns tagged with encrypted=deterministic
+func (tm TableMetadata) GetDeterministicColumns() []string {
+	columnNames := []string{}
+	for _, field := range tm.GetFields() {
+		if field.isDeterministic {
+			columnNames = append(columnNames, field.columnName)
+		}
+	}
+	return columnNames
+}
+
+// EncryptDeterministic returns the base64 ciphertext stored for a string or byte slice value of a field tagged
+// with encrypted=deterministic, which can be compared to the column
+func EncryptDeterministic(value interface{}) (string, error) {
+	var valueAsBytes []byte
+	switch value := value.(type) {
+	case string:
+		valueAsBytes = []byte(value)
+	case []byte:
+		valueAsBytes = value
+	default:
+		return "", errors.New("can only encrypt values that can be converted to bytes")
+	}
+	encrypted, err := crypto.EncryptBytesDeterministic(valueAsBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// HasEncryptedColumns reports whether any of the table's columns are encrypted
+func (tm TableMetadata) HasEncryptedColumns() bool {
+	return len(tm.GetEncryptedColumns()) > 0
+}
+
+// GetAuditColumns gets the names of the columns tagged with audit, in the order they appear in the struct
+func (tm TableMetadata) GetAuditColumns() []string {
+	columnNames := []string{}
+	for _, field := range tm.GetFields() {
+		if field.audit != "" {
+			columnNames = append(columnNames, field.columnName)
+		}
+	}
+	return columnNames
+}
+
+// HasAuditFields reports whether any of the table's fields are tagged with audit
+func (tm TableMetadata) HasAuditFields() bool {
+	return len(tm.GetAuditColumns()) > 0
+}
+
+// GetReturningColumns gets the names of the columns that are set by the database
+func (tm TableMetadata) GetReturningColumns() []string {
+	columnNames := []string{}
+	for _, field := range tm.GetFields() {
+		if field.isReturning {
+			columnNames = append(columnNames, field.columnName)
+		}
+	}
+	return columnNames
+}
+
 // GetJSONBColumns function
 func (tm TableMetadata) GetJSONBColumns() []string {
 	columnNames := []string{}
@@ -533,6 +1693,17 @@ func (tm TableMetadata) GetJSONBColumns() []string {
 	return columnNames
 }
 
+// GetTimestampColumns maps the columns of fields tagged with timestamp or timestamptz to that type
+func (tm TableMetadata) GetTimestampColumns() map[string]string {
+	columns := map[string]string{}
+	for _, field := range tm.GetFields() {
+		if field.timestampType != "" {
+			columns[field.columnName] = field.timestampType
+		}
+	}
+	return columns
+}
+
 // GetPrimaryKeyMetadata function
 func (tm TableMetadata) GetPrimaryKeyMetadata() *FieldMetadata {
 	metadata, ok := tm.fields[tm.primaryKeyField]
@@ -569,6 +1740,32 @@ func (tm TableMetadata) GetPrimaryKeyFieldName() string {
 	return ""
 }
 
+// GetPrimaryKeyFieldNames returns the names of every field tagged primary_key, in struct order. Tables
+// with a composite primary key return more than one; GetPrimaryKeyFieldName returns the first.
+func (tm TableMetadata) GetPrimaryKeyFieldNames() []string {
+	fieldNames := []string{}
+	for _, fieldName := range tm.fieldOrder {
+		if tm.fields[fieldName].isPrimaryKey {
+			fieldNames = append(fieldNames, fieldName)
+		}
+	}
+	return fieldNames
+}
+
+// GetPrimaryKeyColumnNames returns the column names of every field tagged primary_key, in struct order
+func (tm TableMetadata) GetPrimaryKeyColumnNames() []string {
+	columnNames := []string{}
+	for _, fieldName := range tm.GetPrimaryKeyFieldNames() {
+		columnNames = append(columnNames, tm.fields[fieldName].columnName)
+	}
+	return columnNames
+}
+
+// HasCompositePrimaryKey returns true if more than one field is tagged primary_key
+func (tm TableMetadata) HasCompositePrimaryKey() bool {
+	return len(tm.GetPrimaryKeyFieldNames()) > 1
+}
+
 // GetMultitenancyKeyColumnName function
 func (tm TableMetadata) GetMultitenancyKeyColumnName() string {
 	metadata := tm.GetMultitenancyKeyMetadata()
@@ -578,6 +1775,44 @@ func (tm TableMetadata) GetMultitenancyKeyColumnName() string {
 	return ""
 }
 
+// GetSoftDeleteMetadata function
+func (tm TableMetadata) GetSoftDeleteMetadata() *FieldMetadata {
+	metadata, ok := tm.fields[tm.softDeleteField]
+	if ok {
+		return &metadata
+	}
+	return nil
+}
+
+// GetSoftDeleteColumnName function
+func (tm TableMetadata) GetSoftDeleteColumnName() string {
+	metadata := tm.GetSoftDeleteMetadata()
+	if metadata != nil {
+		return metadata.columnName
+	}
+	return ""
+}
+
+// HasSoftDelete reports whether the table has a soft_delete column
+func (tm TableMetadata) HasSoftDelete() bool {
+	return tm.GetSoftDeleteMetadata() != nil
+}
+
+// GetDeleteFlagFieldName returns the name of the delete_flag field, which marks models that Deploy should
+// delete, or an empty string if the table has none
+func (tm TableMetadata) GetDeleteFlagFieldName() string {
+	return tm.deleteFlagField
+}
+
+// GetVersionColumnName returns the name of the column of the version field, or an empty string if the table
+// has none
+func (tm TableMetadata) GetVersionColumnName() string {
+	if field, ok := tm.fields[tm.versionField]; ok {
+		return field.columnName
+	}
+	return ""
+}
+
 // GetFields returns the fields in the order they appear in the struct
 func (tm TableMetadata) GetFields() []FieldMetadata {
 	fields := []FieldMetadata{}
@@ -587,6 +1822,24 @@ func (tm TableMetadata) GetFields() []FieldMetadata {
 	return fields
 }
 
+// GetJoinedFields returns the fields tagged with join, which read a column of a belongs-to parent's table.
+// They aren't columns of this table, so they aren't included in GetFields.
+func (tm TableMetadata) GetJoinedFields() []FieldMetadata {
+	return tm.joinedFields
+}
+
+// GetAggregateFields returns the fields tagged with aggregate, which hold an aggregate over a model's children
+// when a filter request selects one into them. They aren't columns, so they aren't included in GetFields.
+func (tm TableMetadata) GetAggregateFields() []FieldMetadata {
+	return tm.aggregateFields
+}
+
+// GetComputedFields returns the fields tagged with computed, which hold the result of a SQL function when a
+// filter request selects one into them. They aren't columns, so they aren't included in GetFields.
+func (tm TableMetadata) GetComputedFields() []FieldMetadata {
+	return tm.computedFields
+}
+
 // GetField returns the fields in the order they appear in the struct
 func (tm TableMetadata) GetField(fieldName string) FieldMetadata {
 	return tm.fields[fieldName]
@@ -621,6 +1874,7 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 	children := []Child{}
 	lookups := []Lookup{}
 	foreignKeys := []ForeignKey{}
+	conflictTarget, hasConflictTarget := "", false
 
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
@@ -636,17 +1890,84 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 		_, isRequired := tagsMap["required"]
 		_, isForeignKey := tagsMap["foreign_key"]
 		// _, isReference := tagsMap["reference"]
-		_, isEncrypted := tagsMap["encrypted"]
-		_, isJSONB := tagsMap["jsonb"]
+		encryptionMode, isEncrypted := tagsMap["encrypted"]
+		jsonbCodec, isJSONB := tagsMap["jsonb"]
+		_, isSoftDelete := tagsMap["soft_delete"]
+		_, isMaterializedView := tagsMap["materialized_view"]
+		_, isTenantExempt := tagsMap["tenant_exempt"]
+		_, hasDBAudit := tagsMap["db_audit"]
+		_, isReturning := tagsMap["returning"]
+		// Generated and identity columns reject writes, so they are handled like returning columns
+		_, isGenerated := tagsMap["generated"]
+		// Xmin fields read the xmin system column, so they don't need a column tag
+		_, isXmin := tagsMap["xmin"]
+		rangeType, isRange := tagsMap["range"]
+		_, isPGArray := tagsMap["pgarray"]
+		spatialType := strings.ToLower(tagsMap["spatial"])
+		timestampType := ""
+		if _, isTimestamp := tagsMap["timestamp"]; isTimestamp {
+			timestampType = "timestamp"
+		} else if _, isTimestampTZ := tagsMap["timestamptz"]; isTimestampTZ {
+			timestampType = "timestamptz"
+		}
+		_, isUUID := tagsMap["uuid"]
+		isUUID = isUUID || isUUIDType(field.Type)
+		// Delete flags aren't stored, so they don't have a column tag
+		_, isDeleteFlag := tagsMap["delete_flag"]
+		_, isVersion := tagsMap["version"]
+		isVersion = isVersion && isIntegerKind(kind)
 		auditType := tagsMap["audit"]
+		joinRelation, isJoined := tagsMap["join"]
+		_, isAggregate := tagsMap["aggregate"]
+		_, isComputed := tagsMap["computed"]
 
 		if field.Type == reflect.TypeOf(metadata) {
 			if hasTableName {
 				tableMetadata.tableName = tagsMap["tablename"]
 			}
+			tableMetadata.isMaterializedView = isMaterializedView
+			tableMetadata.isTenantExempt = isTenantExempt
+			tableMetadata.hasDBAudit = hasDBAudit
+			conflictTarget, hasConflictTarget = tagsMap["conflict_target"]
+		}
+
+		if isXmin {
+			columnName, hasColumnName = qp.XminColumn, true
+		}
+
+		if isDeleteFlag && kind == reflect.Bool {
+			tableMetadata.deleteFlagField = field.Name
+		}
+
+		// Aggregate fields are only read, from a subquery selected by the filter request
+		if isAggregate && !hasColumnName {
+			tableMetadata.aggregateFields = append(tableMetadata.aggregateFields, FieldMetadata{
+				name:      field.Name,
+				fieldType: field.Type,
+			})
 		}
 
-		if hasColumnName {
+		// Computed fields are only read, from a function call selected by the filter request
+		if isComputed && !hasColumnName {
+			tableMetadata.computedFields = append(tableMetadata.computedFields, FieldMetadata{
+				name:      field.Name,
+				fieldType: field.Type,
+			})
+		}
+
+		// Joined fields read a column of a parent's table, so they are kept apart from this table's columns
+		if isJoined && hasColumnName {
+			tableMetadata.joinedFields = append(tableMetadata.joinedFields, FieldMetadata{
+				name:         field.Name,
+				isEncrypted:  isEncrypted,
+				isJSONB:      isJSONB,
+				jsonbCodec:   jsonbCodec,
+				isPGArray:    isPGArray,
+				joinRelation: joinRelation,
+				columnName:   columnName,
+				fieldType:    field.Type,
+			})
+		} else if hasColumnName {
 			var relatedField reflect.StructField
 			if isForeignKey {
 				relatedField, _ = t.FieldByName(tagsMap["related"])
@@ -655,10 +1976,24 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 			tableMetadata.fields[field.Name] = FieldMetadata{
 				name:              field.Name,
 				isEncrypted:       isEncrypted,
+				isDeterministic:   isEncrypted && encryptionMode == "deterministic",
 				isJSONB:           isJSONB,
+				jsonbCodec:        jsonbCodec,
+				discriminator:     tagsMap["discriminator"],
 				isMultitenancyKey: isMultitenancyKey,
 				isPrimaryKey:      isPrimaryKey,
 				isFK:              isForeignKey,
+				isSoftDelete:      isSoftDelete,
+				isReturning:       isReturning || isGenerated,
+				isXmin:            isXmin,
+				isVersion:         isVersion,
+				isRange:           isRange,
+				rangeType:         rangeType,
+				isPGArray:         isPGArray,
+				spatialType:       spatialType,
+				timestampType:     timestampType,
+				isUUID:            isUUID,
+				check:             tagsMap["check"],
 				relatedField:      relatedField,
 				columnName:        columnName,
 				audit:             auditType,
@@ -670,15 +2005,23 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 			if isMultitenancyKey {
 				tableMetadata.multitenancyKeyField = field.Name
 			}
-			if isPrimaryKey {
+			if isPrimaryKey && tableMetadata.primaryKeyField == "" {
 				tableMetadata.primaryKeyField = field.Name
 			}
+			if isSoftDelete {
+				tableMetadata.softDeleteField = field.Name
+			}
+			if isVersion {
+				tableMetadata.versionField = field.Name
+			}
 		}
 
-		if isChild && (kind == reflect.Slice || kind == reflect.Map) {
+		// A jsonb map or slice is stored whole in its column, even if it's also tagged as a child
+		if isChild && !isJSONB && (kind == reflect.Slice || kind == reflect.Map) {
 			var keyMapping string
 			var valueMappingMap map[string]string
 			var groupingCriteriaMap map[string]string
+			var groupingFields []string
 			keyMappingString := tagsMap["key_mapping"]
 			valueMappingString := tagsMap["value_mappings"]
 			groupingCriteriaString := tagsMap["grouping_criteria"]
@@ -690,6 +2033,7 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 				for _, groupingCriteria := range groupingCriteriaArray {
 					groupingCriteriaSplit := strings.Split(groupingCriteria, "->")
 					groupingCriteriaMap[groupingCriteriaSplit[0]] = groupingCriteriaSplit[1]
+					groupingFields = append(groupingFields, groupingCriteriaSplit[0])
 				}
 			}
 
@@ -706,6 +2050,16 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 				}
 			}
 
+			var junction *Junction
+			if junctionTable := tagsMap["junction"]; junctionTable != "" && kind == reflect.Slice {
+				junction = &Junction{
+					TableName:             junctionTable,
+					ParentKeyColumn:       tagsMap["junction_parent"],
+					ChildKeyColumn:        tagsMap["junction_child"],
+					MultitenancyKeyColumn: tagsMap["junction_multitenancy"],
+				}
+			}
+
 			children = append(children, Child{
 				FieldName:        field.Name,
 				FieldType:        field.Type,
@@ -714,15 +2068,22 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 				KeyMapping:       keyMapping,
 				ValueMappings:    valueMappingMap,
 				GroupingCriteria: groupingCriteriaMap,
+				GroupingFields:   groupingFields,
 				DeleteOrphans:    deleteOrphans,
+				Junction:         junction,
+				OrderedField:     tagsMap["ordered_field"],
 			})
 
 		}
 
 		if isLookup && !isForeignKey {
+			castType := tagsMap["cast"]
 			lookups = append(lookups, Lookup{
 				MatchDBColumn:       tagsMap["column"],
 				MatchObjectProperty: field.Name,
+				CastType:            castType,
+				DisableCast:         castType == "none",
+				IsUUID:              isUUID,
 			})
 		}
 
@@ -731,9 +2092,18 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 			relatedField, hasRelatedField := t.FieldByName(tagsMap["related"])
 
 			if hasRelatedField {
-				tableMetadata := TableMetadataFromType(relatedField.Type)
+				relatedType := relatedField.Type
+				if relatedType.Kind() == reflect.Ptr {
+					relatedType = relatedType.Elem()
+				}
+				// A model that references itself, through a pointer field, shares its own metadata instead
+				// of reading its type again without end
+				relatedMetadata := &tableMetadata
+				if relatedType != t {
+					relatedMetadata = TableMetadataFromType(relatedType)
+				}
 				foreignKeys = append(foreignKeys, ForeignKey{
-					TableMetadata:    tableMetadata,
+					TableMetadata:    relatedMetadata,
 					FieldName:        field.Name,
 					KeyColumn:        tagsMap["column"],
 					RelatedFieldName: relatedField.Name,
@@ -749,9 +2119,68 @@ func TableMetadataFromType(t reflect.Type) *TableMetadata {
 		tableMetadata.foreignKeys = foreignKeys
 	}
 
+	// Audit timestamps set by database triggers are read back like returning columns instead of being written
+	if tableMetadata.hasDBAudit {
+		for name, field := range tableMetadata.fields {
+			if field.audit == "created_at" || field.audit == "updated_at" {
+				field.isReturning = true
+				tableMetadata.fields[name] = field
+			}
+		}
+	}
+
+	// A field tagged multitenancy_key on a tenant exempt table is an ordinary column
+	if tableMetadata.isTenantExempt && tableMetadata.multitenancyKeyField != "" {
+		field := tableMetadata.fields[tableMetadata.multitenancyKeyField]
+		field.isMultitenancyKey = false
+		tableMetadata.fields[field.name] = field
+		tableMetadata.multitenancyKeyField = ""
+	}
+
+	if hasConflictTarget {
+		tableMetadata.conflictTarget = getConflictTarget(conflictTarget, &tableMetadata)
+	}
+
 	return &tableMetadata
 }
 
+// getConflictTarget splits the columns of a conflict_target tag, like conflict_target=organization_id&name.
+// A conflict_target tag without columns uses the lookup columns, scoped by the multitenancy key.
+func getConflictTarget(tagValue string, tableMetadata *TableMetadata) []string {
+	if tagValue != "" {
+		return strings.Split(tagValue, "&")
+	}
+
+	lookupColumnNames := []string{}
+	for _, foreignKey := range tableMetadata.foreignKeys {
+		if foreignKey.NeedsLookup {
+			lookupColumnNames = append(lookupColumnNames, foreignKey.KeyColumn)
+		}
+	}
+	for _, lookup := range tableMetadata.lookups {
+		lookupColumnNames = append(lookupColumnNames, lookup.MatchDBColumn)
+	}
+	if len(lookupColumnNames) == 0 {
+		return nil
+	}
+
+	if tableMetadata.multitenancyKeyField != "" {
+		multitenancyColumnName := tableMetadata.fields[tableMetadata.multitenancyKeyField].columnName
+		return append([]string{multitenancyColumnName}, lookupColumnNames...)
+	}
+	return lookupColumnNames
+}
+
+// isIntegerKind reports whether a field kind holds an integer, as version fields must
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
 // GetStructTagsMap generates a map of struct tag to values
 // Example
 //
@@ -767,7 +2196,8 @@ func GetStructTagsMap(field reflect.StructField, tagType string) map[string]stri
 	tagsMap := map[string]string{}
 
 	for _, v := range tags {
-		tagSplit := strings.Split(v, "=")
+		// Only the first = separates the key, so values like check expressions can contain comparisons
+		tagSplit := strings.SplitN(v, "=", 2)
 		tagKey := tagSplit[0]
 		tagValue := ""
 		if (len(tagSplit)) == 2 {
@@ -778,3 +2208,11 @@ func GetStructTagsMap(field reflect.StructField, tagType string) map[string]stri
 
 	return tagsMap
 }
+
+// isUUIDType reports whether a field's type is uuid.UUID, or a pointer to one
+func isUUIDType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == reflect.TypeOf(uuid.UUID{})
+}
@@ -3,8 +3,11 @@ package tags
 import (
 	"reflect"
 	"testing"
+	"time"
 
+	uuid "github.com/satori/go.uuid"
 	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -157,6 +160,12 @@ func TestGetStructTagsMap(t *testing.T) {
 			"testTag",
 			map[string]string{"testKeyOne": "", "testKeyTwo": "test_value_two"},
 		},
+		{
+			"should keep the rest of a value after its first equals sign",
+			`testTag:"column=amount,check=amount >= 0"`,
+			"testTag",
+			map[string]string{"column": "amount", "check": "amount >= 0"},
+		},
 		{
 			"should return nil map for missing tag",
 			`testTag:"testKeyOne=test_value_one"`,
@@ -175,3 +184,499 @@ func TestGetStructTagsMap(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCheck(t *testing.T) {
+	type checkStruct struct {
+		Metadata metadata.Metadata `picard:"tablename=check_table"`
+		Name     string            `picard:"column=name"`
+		Amount   int               `picard:"column=amount,check=amount >= 0 AND amount <= 100"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(checkStruct{}))
+	assert.Equal(t, "", tableMetadata.GetField("Name").GetCheck())
+	assert.Equal(t, "amount >= 0 AND amount <= 100", tableMetadata.GetField("Amount").GetCheck())
+}
+
+func TestLookupCastTypes(t *testing.T) {
+	type castStruct struct {
+		Metadata metadata.Metadata `picard:"tablename=cast_table"`
+		Name     string            `picard:"lookup,column=name"`
+		Email    string            `picard:"lookup,column=email,cast=citext"`
+		Status   string            `picard:"lookup,column=status,cast=none"`
+	}
+
+	lookups := TableMetadataFromType(reflect.TypeOf(castStruct{})).GetLookups()
+
+	assert.Equal(t, []Lookup{
+		{
+			MatchDBColumn:       "name",
+			MatchObjectProperty: "Name",
+		},
+		{
+			MatchDBColumn:       "email",
+			MatchObjectProperty: "Email",
+			CastType:            "citext",
+		},
+		{
+			MatchDBColumn:       "status",
+			MatchObjectProperty: "Status",
+			CastType:            "none",
+			DisableCast:         true,
+		},
+	}, lookups)
+
+	assert.Equal(t, `t0.name::"varchar"`, lookups[0].GetCastColumn("t0.name"))
+	assert.Equal(t, `t0.email::citext`, lookups[1].GetCastColumn("t0.email"))
+	assert.Equal(t, `t0.status`, lookups[2].GetCastColumn("t0.status"))
+
+	assert.True(t, lookups[0].IsMatchedAsText())
+	assert.False(t, lookups[1].IsMatchedAsText())
+	assert.False(t, lookups[2].IsMatchedAsText())
+
+	assert.Equal(t, `COALESCE(t0.name::"varchar",'')`, lookups[0].GetKeyExpression("t0.name"))
+
+	testCases := []struct {
+		description string
+		lookup      Lookup
+		key         string
+		wantSQL     string
+		wantArgs    []interface{}
+	}{
+		{"compares a citext key with the column", lookups[1], "kirk", "t0.email::citext = ?", []interface{}{"kirk"}},
+		{"matches an empty citext key to NULL or an empty string", lookups[1], "", "COALESCE(t0.email::citext,'') = ''", nil},
+		{"compares an uncast key with the column", lookups[2], "active", "t0.status = ?", []interface{}{"active"}},
+		{"matches an empty uncast key to NULL", lookups[2], "", "t0.status IS NULL", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			column := "t0." + tc.lookup.MatchDBColumn
+			sql, args, err := tc.lookup.GetMatchExpression(column, tc.key).ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantSQL, sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
+
+func TestValidateCastType(t *testing.T) {
+	testCases := []struct {
+		castType string
+		wantErr  bool
+	}{
+		{"", false},
+		{"citext", false},
+		{"character varying", false},
+		{"numeric(10, 2)", false},
+		{"public.status_enum", false},
+		{"text[]", false},
+		{`"varchar"`, true},
+		{"text); DROP TABLE x; --", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.castType, func(t *testing.T) {
+			err := Lookup{MatchDBColumn: "name", CastType: tc.castType}.ValidateCastType()
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
+
+func TestUUIDFields(t *testing.T) {
+	type uuidStruct struct {
+		Metadata metadata.Metadata `picard:"tablename=uuid_table"`
+		Serial   uuid.UUID         `picard:"lookup,column=serial"`
+		OwnerID  *uuid.UUID        `picard:"column=owner_id"`
+		HolderID string            `picard:"uuid,column=holder_id"`
+		Name     string            `picard:"column=name"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(uuidStruct{}))
+
+	assert.True(t, tableMetadata.GetField("Serial").IsUUID())
+	assert.True(t, tableMetadata.GetField("OwnerID").IsUUID())
+	assert.True(t, tableMetadata.GetField("HolderID").IsUUID())
+	assert.False(t, tableMetadata.GetField("Name").IsUUID())
+	assert.True(t, tableMetadata.GetLookups()[0].IsUUID)
+}
+
+func TestPGArrayFields(t *testing.T) {
+	type arrayStruct struct {
+		Metadata metadata.Metadata `picard:"tablename=array_table"`
+		Tags     []string          `picard:"pgarray,column=tags"`
+		Labels   []string          `picard:"jsonb,column=labels"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(arrayStruct{}))
+
+	assert.True(t, tableMetadata.GetField("Tags").IsPGArray())
+	assert.False(t, tableMetadata.GetField("Labels").IsPGArray())
+}
+
+func TestBuildAssociations(t *testing.T) {
+	testCases := []struct {
+		description string
+		givePaths   []string
+		want        []Association
+	}{
+		{
+			"builds a two level tree from paths",
+			[]string{"Children.Toys", "Animals", "Children.Pets"},
+			[]Association{
+				{
+					Name: "Children",
+					Associations: []Association{
+						{Name: "Toys"},
+						{Name: "Pets"},
+					},
+				},
+				{Name: "Animals"},
+			},
+		},
+		{
+			"merges a path with a longer path that follows it",
+			[]string{"Children", "Children.Toys.Parts"},
+			[]Association{
+				{
+					Name: "Children",
+					Associations: []Association{
+						{
+							Name: "Toys",
+							Associations: []Association{
+								{Name: "Parts"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			"ignores empty paths",
+			[]string{"", "Animals"},
+			[]Association{
+				{Name: "Animals"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.want, BuildAssociations(tc.givePaths))
+		})
+	}
+}
+
+func TestBuildFieldset(t *testing.T) {
+	testCases := []struct {
+		description      string
+		givePaths        []string
+		wantSelectFields []string
+		wantAssociations []Association
+		wantErr          string
+	}{
+		{
+			"builds select fields for nested children and parents",
+			[]string{"Name", "Children.Name", "Children.Toys.Name", "GrandParent.Age"},
+			[]string{"Name"},
+			[]Association{
+				{
+					Name:         "Children",
+					SelectFields: []string{"Name"},
+					Associations: []Association{
+						{
+							Name:         "Toys",
+							SelectFields: []string{"Name"},
+						},
+					},
+				},
+				{
+					Name:         "GrandParent",
+					SelectFields: []string{"Age"},
+				},
+			},
+			"",
+		},
+		{
+			"selects every field of an association named at the end of a path",
+			[]string{"Name", "Children", "Children.Name", "Animals"},
+			[]string{"Name"},
+			[]Association{
+				{Name: "Children"},
+				{Name: "Animals"},
+			},
+			"",
+		},
+		{
+			"selects every field of a model when only its associations are named",
+			[]string{"Children.Toys.Name", "Children.Toys.Name", ""},
+			nil,
+			[]Association{
+				{
+					Name: "Children",
+					Associations: []Association{
+						{
+							Name:         "Toys",
+							SelectFields: []string{"Name"},
+						},
+					},
+				},
+			},
+			"",
+		},
+		{
+			"fails for a name that isn't a field or association",
+			[]string{"Children.Nickname"},
+			nil,
+			nil,
+			"fieldset path 'Children.Nickname': 'Nickname' is not a field or association of 'childmodel'",
+		},
+		{
+			"fails for a path that continues past a field",
+			[]string{"Name.First"},
+			nil,
+			nil,
+			"fieldset path 'Name.First': 'Name' is a field of 'parentmodel', not an association",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			selectFields, associations, err := BuildFieldset(testdata.ParentModel{}, tc.givePaths)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantSelectFields, selectFields)
+			assert.Equal(t, tc.wantAssociations, associations)
+		})
+	}
+}
+
+func TestTableMetadataFeatures(t *testing.T) {
+	type auditedModel struct {
+		metadata.Metadata `picard:"tablename=audited"`
+
+		ID        string    `picard:"primary_key,column=id"`
+		Secret    string    `picard:"encrypted,column=secret"`
+		CreatedBy string    `picard:"column=created_by_id,audit=created_by"`
+		UpdatedAt time.Time `picard:"column=updated_at,audit=updated_at"`
+		DeletedAt time.Time `picard:"soft_delete,column=deleted_at"`
+	}
+
+	testCases := []struct {
+		description          string
+		giveType             reflect.Type
+		wantSoftDelete       bool
+		wantSoftDeleteColumn string
+		wantAuditColumns     []string
+		wantEncryptedColumns []string
+	}{
+		{
+			"model with soft delete, audit and encrypted fields",
+			reflect.TypeOf(auditedModel{}),
+			true,
+			"deleted_at",
+			[]string{"created_by_id", "updated_at"},
+			[]string{"secret"},
+		},
+		{
+			"model with encrypted fields only",
+			reflect.TypeOf(TagsTestStruct{}),
+			false,
+			"",
+			[]string{},
+			[]string{"test_column_one"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tableMetadata := TableMetadataFromType(tc.giveType)
+			assert.Equal(t, tc.wantSoftDelete, tableMetadata.HasSoftDelete())
+			assert.Equal(t, tc.wantSoftDeleteColumn, tableMetadata.GetSoftDeleteColumnName())
+			assert.Equal(t, len(tc.wantAuditColumns) > 0, tableMetadata.HasAuditFields())
+			assert.Equal(t, tc.wantAuditColumns, tableMetadata.GetAuditColumns())
+			assert.Equal(t, len(tc.wantEncryptedColumns) > 0, tableMetadata.HasEncryptedColumns())
+			assert.Equal(t, tc.wantEncryptedColumns, tableMetadata.GetEncryptedColumns())
+		})
+	}
+}
+
+func TestTableMetadataJSONBMap(t *testing.T) {
+	type jsonbMapModel struct {
+		metadata.Metadata `picard:"tablename=jsonb_map"`
+
+		ID         string                 `picard:"primary_key,column=id"`
+		Attributes map[string]interface{} `picard:"jsonb,column=attributes"`
+		Settings   map[string]interface{} `picard:"child,jsonb,column=settings"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(jsonbMapModel{}))
+	assert.Equal(t, []string{"attributes", "settings"}, tableMetadata.GetJSONBColumns())
+	assert.Empty(t, tableMetadata.GetChildren())
+	assert.Nil(t, tableMetadata.GetChildField("Settings"))
+}
+
+func TestTableMetadataTimestampColumns(t *testing.T) {
+	type timestampModel struct {
+		metadata.Metadata `picard:"tablename=timestamps"`
+
+		ID        string     `picard:"primary_key,column=id"`
+		ShippedAt time.Time  `picard:"timestamptz,column=shipped_at"`
+		DeliverBy *time.Time `picard:"timestamp,column=deliver_by"`
+		CreatedAt time.Time  `picard:"column=created_at"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(timestampModel{}))
+	assert.Equal(t, map[string]string{"shipped_at": "timestamptz", "deliver_by": "timestamp"}, tableMetadata.GetTimestampColumns())
+	assert.Equal(t, "timestamptz", tableMetadata.GetField("ShippedAt").GetTimestampType())
+	assert.Equal(t, "", tableMetadata.GetField("CreatedAt").GetTimestampType())
+}
+
+func TestTableMetadataConflictTarget(t *testing.T) {
+	type declaredModel struct {
+		metadata.Metadata `picard:"tablename=declared,conflict_target=organization_id&sku"`
+
+		ID             string `picard:"primary_key,column=id"`
+		OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+		SKU            string `picard:"column=sku"`
+	}
+	type impliedModel struct {
+		metadata.Metadata `picard:"tablename=implied,conflict_target"`
+
+		ID             string `picard:"primary_key,column=id"`
+		OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+		Name           string `picard:"lookup,column=name"`
+		Version        int    `picard:"lookup,column=version"`
+	}
+	type noLookupsModel struct {
+		metadata.Metadata `picard:"tablename=no_lookups,conflict_target"`
+
+		ID   string `picard:"primary_key,column=id"`
+		Name string `picard:"column=name"`
+	}
+
+	testCases := []struct {
+		description string
+		giveType    reflect.Type
+		wantTarget  []string
+	}{
+		{
+			"declared columns",
+			reflect.TypeOf(declaredModel{}),
+			[]string{"organization_id", "sku"},
+		},
+		{
+			"lookup columns scoped by the multitenancy key",
+			reflect.TypeOf(impliedModel{}),
+			[]string{"organization_id", "name", "version"},
+		},
+		{
+			"no target without lookups",
+			reflect.TypeOf(noLookupsModel{}),
+			nil,
+		},
+		{
+			"no target without the tag",
+			reflect.TypeOf(TagsTestStruct{}),
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.wantTarget, TableMetadataFromType(tc.giveType).GetConflictTarget())
+		})
+	}
+}
+
+func TestColumnNamesOrder(t *testing.T) {
+	type orderedModel struct {
+		metadata.Metadata `picard:"tablename=ordered"`
+
+		ID             string                 `picard:"primary_key,column=id"`
+		Zeta           string                 `picard:"column=zeta"`
+		OrganizationID string                 `picard:"multitenancy_key,column=organization_id"`
+		Alpha          string                 `picard:"lookup,column=alpha"`
+		Attributes     map[string]interface{} `picard:"jsonb,column=attributes"`
+		Mu             int                    `picard:"column=mu"`
+		CreatedByID    string                 `picard:"column=created_by_id,audit=created_by"`
+		Beta           string                 `picard:"encrypted,column=beta"`
+	}
+
+	for i := 0; i < 20; i++ {
+		tableMetadata := TableMetadataFromType(reflect.TypeOf(orderedModel{}))
+		assert.Equal(t, []string{"id", "zeta", "organization_id", "alpha", "attributes", "mu", "created_by_id", "beta"}, tableMetadata.GetColumnNames())
+		assert.Equal(t, []string{"zeta", "organization_id", "alpha", "attributes", "mu", "created_by_id", "beta"}, tableMetadata.GetColumnNamesWithoutPrimaryKey())
+		assert.Equal(t, []string{"zeta", "alpha", "attributes", "mu", "beta"}, tableMetadata.GetColumnNamesForUpdate())
+	}
+}
+
+func TestTableMetadataJoinedFields(t *testing.T) {
+	type parentModel struct {
+		metadata.Metadata `picard:"tablename=parent"`
+
+		ID   string `picard:"primary_key,column=id"`
+		Name string `picard:"column=name"`
+	}
+	type childModel struct {
+		metadata.Metadata `picard:"tablename=child"`
+
+		ID         string      `picard:"primary_key,column=id"`
+		Name       string      `picard:"column=name"`
+		ParentID   string      `picard:"foreign_key,related=Parent,column=parent_id"`
+		Parent     parentModel `validate:"-"`
+		ParentName string      `picard:"column=name,join=Parent"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(childModel{}))
+	assert.Equal(t, []string{"id", "name", "parent_id"}, tableMetadata.GetColumnNames())
+	assert.Equal(t, []string{"name", "parent_id"}, tableMetadata.GetColumnNamesForUpdate())
+
+	joinedFields := tableMetadata.GetJoinedFields()
+	assert.Len(t, joinedFields, 1)
+	assert.Equal(t, "ParentName", joinedFields[0].GetName())
+	assert.Equal(t, "name", joinedFields[0].GetColumnName())
+	assert.Equal(t, "Parent", joinedFields[0].GetJoinRelation())
+}
+
+func TestTableMetadataAggregateFields(t *testing.T) {
+	type childModel struct {
+		metadata.Metadata `picard:"tablename=child"`
+
+		ID       string `picard:"primary_key,column=id"`
+		ParentID string `picard:"column=parent_id"`
+	}
+	type parentModel struct {
+		metadata.Metadata `picard:"tablename=parent"`
+
+		ID         string       `picard:"primary_key,column=id"`
+		Children   []childModel `picard:"child,foreign_key=ParentID"`
+		ChildCount int          `picard:"aggregate"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(parentModel{}))
+	assert.Equal(t, []string{"id"}, tableMetadata.GetColumnNames())
+
+	aggregateFields := tableMetadata.GetAggregateFields()
+	assert.Len(t, aggregateFields, 1)
+	assert.Equal(t, "ChildCount", aggregateFields[0].GetName())
+	assert.Equal(t, reflect.TypeOf(0), aggregateFields[0].GetFieldType())
+}
+
+func TestTableMetadataComputedFields(t *testing.T) {
+	type personModel struct {
+		metadata.Metadata `picard:"tablename=person"`
+
+		ID          string `picard:"primary_key,column=id"`
+		FirstName   string `picard:"column=first_name"`
+		DisplayName string `picard:"computed"`
+	}
+
+	tableMetadata := TableMetadataFromType(reflect.TypeOf(personModel{}))
+	assert.Equal(t, []string{"id", "first_name"}, tableMetadata.GetColumnNames())
+
+	computedFields := tableMetadata.GetComputedFields()
+	assert.Len(t, computedFields, 1)
+	assert.Equal(t, "DisplayName", computedFields[0].GetName())
+	assert.Equal(t, reflect.TypeOf(""), computedFields[0].GetFieldType())
+}
@@ -0,0 +1,160 @@
+package picard
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/tags"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type globalFlagModel struct {
+	Metadata metadata.Metadata `picard:"tablename=feature_flag,tenant_exempt"`
+
+	ID      string `picard:"primary_key,column=id"`
+	Name    string `picard:"lookup,column=name"`
+	Enabled bool   `picard:"column=enabled"`
+}
+
+type currencyModel struct {
+	Metadata metadata.Metadata `picard:"tablename=currency,tenant_exempt"`
+
+	ID      string `picard:"primary_key,column=id"`
+	OwnerID string `picard:"multitenancy_key,column=owner_id"`
+	Code    string `picard:"column=code"`
+}
+
+func TestTenantExemptMetadata(t *testing.T) {
+	globalFlagMetadata := tags.TableMetadataFromType(reflect.TypeOf(globalFlagModel{}))
+	assert.True(t, globalFlagMetadata.IsTenantExempt())
+	assert.Equal(t, "", globalFlagMetadata.GetMultitenancyKeyColumnName())
+
+	currencyMetadata := tags.TableMetadataFromType(reflect.TypeOf(currencyModel{}))
+	assert.True(t, currencyMetadata.IsTenantExempt())
+	assert.Equal(t, "", currencyMetadata.GetMultitenancyKeyColumnName())
+	assert.False(t, currencyMetadata.GetField("OwnerID").IsMultitenancyKey())
+
+	personMetadata := tags.TableMetadataFromType(reflect.TypeOf(testdata.PersonModel{}))
+	assert.False(t, personMetadata.IsTenantExempt())
+	assert.Equal(t, "organization_id", personMetadata.GetMultitenancyKeyColumnName())
+}
+
+func TestFilterModelTenantExempt(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description string
+		giveModel   interface{}
+		wantSQL     string
+		wantArgs    []interface{}
+	}{
+		{
+			"omits the tenant predicate for a tenant exempt model",
+			globalFlagModel{Name: "dark_mode"},
+			`
+				SELECT t0.id AS "t0.id", t0.name AS "t0.name", t0.enabled AS "t0.enabled"
+				FROM feature_flag AS t0
+				WHERE t0.name = $1
+			`,
+			[]interface{}{"dark_mode"},
+		},
+		{
+			"filters a multitenancy_key field of a tenant exempt model like any other column",
+			currencyModel{Code: "USD"},
+			`
+				SELECT t0.id AS "t0.id", t0.owner_id AS "t0.owner_id", t0.code AS "t0.code"
+				FROM currency AS t0
+				WHERE t0.code = $1
+			`,
+			[]interface{}{"USD"},
+		},
+		{
+			"keeps the tenant predicate for other models",
+			testdata.PersonModel{Name: "Fred"},
+			`
+				SELECT t0.id AS "t0.id", t0.organization_id AS "t0.organization_id", t0.name AS "t0.name"
+				FROM personmodel AS t0
+				WHERE t0.organization_id = $1 AND t0.name = $2
+			`,
+			[]interface{}{orgID, "Fred"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			sql, args, err := p.FilterModelSQL(FilterRequest{
+				FilterModel: tc.giveModel,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, testdata.FmtSQL(tc.wantSQL), sql)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
+
+func TestSaveModelTenantExempt(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	flagID := "00000000-0000-0000-0000-000000000002"
+
+	testCases := []struct {
+		description         string
+		runFunction         func(ORM) error
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"inserts without the tenant column",
+			func(p ORM) error {
+				return p.CreateModel(&globalFlagModel{Name: "dark_mode", Enabled: true})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO feature_flag \(name,enabled\) VALUES \(\$1,\$2\) RETURNING "id"$`).
+					WithArgs("dark_mode", true).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(flagID))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"updates without the tenant predicate",
+			func(p ORM) error {
+				return p.SaveModel(&globalFlagModel{ID: flagID, Name: "dark_mode", Enabled: false})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^SELECT feature_flag.id FROM feature_flag WHERE feature_flag.id = \$1$`).
+					WithArgs(flagID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(flagID))
+				mock.ExpectExec(`^UPDATE feature_flag SET name = \$1, enabled = \$2 WHERE id = \$3$`).
+					WithArgs("dark_mode", false, flagID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			assert.NoError(t, tc.runFunction(New(orgID, sampleUserID)))
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
@@ -203,5 +203,7 @@ func FmtSQLRegex(sql string) string {
 	str = strings.Replace(str, "$", "\\$", -1)
 	str = strings.Replace(str, "(", "\\(", -1)
 	str = strings.Replace(str, ")", "\\)", -1)
+	str = strings.Replace(str, "*", "\\*", -1)
+	str = strings.Replace(str, "+", "\\+", -1)
 	return fmt.Sprintf("^%s$", str)
 }
@@ -167,12 +167,15 @@ func (eh ExpectationHelper) GetFixtureValue(fixtures interface{}, index int, fie
 	field := fixture.FieldByName(fieldName)
 	if fieldMetadata.IsJSONB() {
 		unserializedValue := field.Interface()
-		serializedValue, err := serializeJSONBColumn(unserializedValue)
+		serializedValue, err := serializeJSONBColumn(unserializedValue, fieldMetadata.GetJSONBCodec())
 		if err != nil {
 			return unserializedValue
 		}
 		return serializedValue
 	}
+	if fieldMetadata.IsPGArray() {
+		return pq.Array(field.Interface())
+	}
 	return field.Interface()
 }
 
@@ -0,0 +1,39 @@
+package picard
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// bindTimestamp converts a time value written to a column tagged with timestamp to UTC. A timestamp column drops
+// the time zone of the values written to it, so they are stored in UTC rather than in the zone they were made in.
+func bindTimestamp(value interface{}, timestampType string) interface{} {
+	if timestampType == "" {
+		return value
+	}
+	if pointer, ok := value.(*time.Time); ok {
+		if pointer == nil {
+			return value
+		}
+		value = *pointer
+	}
+	timeValue, ok := value.(time.Time)
+	if !ok {
+		return value
+	}
+	if timestampType == "timestamp" {
+		return timeValue.UTC()
+	}
+	return timeValue
+}
+
+// castTimestamp binds a time value written to a column tagged with timestamp or timestamptz and casts its
+// placeholder to the column's type, so Postgres doesn't infer the type of the parameter from the statement
+func castTimestamp(value interface{}, timestampType string) interface{} {
+	bound := bindTimestamp(value, timestampType)
+	if _, ok := bound.(time.Time); !ok || timestampType == "" {
+		return bound
+	}
+	return squirrel.Expr("?::"+timestampType, bound)
+}
@@ -0,0 +1,110 @@
+package picard
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type shipmentModel struct {
+	Metadata metadata.Metadata `picard:"tablename=shipment"`
+
+	ID             string     `picard:"primary_key,column=id"`
+	OrganizationID string     `picard:"multitenancy_key,column=organization_id"`
+	ShippedAt      time.Time  `picard:"timestamptz,column=shipped_at"`
+	DeliverBy      *time.Time `picard:"timestamp,column=deliver_by"`
+	Carrier        string     `picard:"column=carrier"`
+}
+
+func TestTimestampCasts(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	shipmentID := "00000000-0000-0000-0000-000000000002"
+	chicago := time.FixedZone("CST", -6*60*60)
+	shippedAt := time.Date(2021, 3, 1, 9, 30, 0, 0, chicago)
+	deliverBy := time.Date(2021, 3, 4, 17, 0, 0, 0, chicago)
+	deliverByUTC := time.Date(2021, 3, 4, 23, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		description         string
+		runFunction         func(ORM) error
+		expectationFunction func(sqlmock.Sqlmock)
+	}{
+		{
+			"casts time values inserted into timestamp and timestamptz columns",
+			func(p ORM) error {
+				return p.CreateModel(&shipmentModel{ShippedAt: shippedAt, DeliverBy: &deliverBy, Carrier: "UPS"})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO shipment (organization_id,shipped_at,deliver_by,carrier)
+					VALUES ($1,$2::timestamptz,$3::timestamp,$4)
+					RETURNING "id"
+				`)).
+					WithArgs(orgID, shippedAt, deliverByUTC, "UPS").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(shipmentID))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"doesn't cast nil time values",
+			func(p ORM) error {
+				return p.CreateModel(&shipmentModel{ShippedAt: shippedAt, Carrier: "UPS"})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					INSERT INTO shipment (organization_id,shipped_at,deliver_by,carrier)
+					VALUES ($1,$2::timestamptz,$3,$4)
+					RETURNING "id"
+				`)).
+					WithArgs(orgID, shippedAt, nil, "UPS").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(shipmentID))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			"casts time values updated in timestamp and timestamptz columns",
+			func(p ORM) error {
+				return p.SaveModel(&shipmentModel{ID: shipmentID, ShippedAt: shippedAt, DeliverBy: &deliverBy})
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT shipment.id FROM shipment WHERE shipment.id = $1 AND shipment.organization_id = $2
+				`)).
+					WithArgs(shipmentID, orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(shipmentID))
+				mock.ExpectExec(testdata.FmtSQLRegex(`
+					UPDATE shipment SET shipped_at = $1::timestamptz, deliver_by = $2::timestamp, carrier = $3
+					WHERE organization_id = $4 AND id = $5
+				`)).
+					WithArgs(shippedAt, deliverByUTC, "", orgID, shipmentID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			assert.NoError(t, tc.runFunction(New(orgID, sampleUserID)))
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
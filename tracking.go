@@ -0,0 +1,130 @@
+package picard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/stringutil"
+	"github.com/skuid/picard/tags"
+)
+
+// ChangeRecord describes the columns that were changed by an update to a single row
+type ChangeRecord struct {
+	TableName      string
+	PrimaryKey     interface{}
+	ChangedColumns []string
+}
+
+// ChangeListener receives a ChangeRecord for every updated row that had at least one changed column
+type ChangeListener func(ChangeRecord)
+
+/*
+WithChangeTracking returns a copy of the ORM that reports field-level changes to the listener.
+
+Before the updates performed by SaveModel or Deploy, the values being written are compared with the
+stored rows in the database, in one query for each batch of updates, and the listener is called with
+the changed columns once each update has run. The listener is called within the transaction, so a
+later rollback may discard the change.
+
+Audit fields are not reported. Encrypted values can't be compared, so encrypted columns are reported
+whenever they are written.
+*/
+func (p PersistenceORM) WithChangeTracking(listener ChangeListener) ORM {
+	p.changeListener = listener
+	return &p
+}
+
+// updateIndexColumn is the alias of the index of the update each row of the change tracking query compares
+const updateIndexColumn = "picard_update"
+
+// getChangedColumns asks the database which of the columns about to be updated hold different values, and returns
+// the changed columns of each update. The rows of every update are compared in a single query, a SELECT for each
+// row joined with UNION ALL, so each value is still compared with its column's type.
+func (p PersistenceORM) getChangedColumns(tableMetadata *tags.TableMetadata, updates []dbchange.Change, columnNames []string) ([][]string, error) {
+	tableName := tableMetadata.GetTableName()
+	multitenancyKeyColumnName := tableMetadata.GetMultitenancyKeyColumnName()
+	encryptedColumns := tableMetadata.GetEncryptedColumns()
+
+	auditColumns := []string{}
+	for _, field := range tableMetadata.GetFields() {
+		if field.GetAudit() != "" {
+			auditColumns = append(auditColumns, field.GetColumnName())
+		}
+	}
+
+	// Every SELECT of a UNION needs the same columns, so each one compares the columns any update writes
+	written := make([][]string, len(updates))
+	compared := []string{}
+	for index, update := range updates {
+		for _, columnName := range columnNames {
+			if _, ok := update.Changes[columnName]; !ok || stringutil.StringSliceContainsKey(auditColumns, columnName) {
+				continue
+			}
+			written[index] = append(written[index], columnName)
+			if !stringutil.StringSliceContainsKey(encryptedColumns, columnName) && !stringutil.StringSliceContainsKey(compared, columnName) {
+				compared = append(compared, columnName)
+			}
+		}
+	}
+
+	distinct := make([]map[string]interface{}, len(updates))
+	if len(compared) > 0 {
+		selects := []string{}
+		args := []interface{}{}
+		for index, update := range updates {
+			query := squirrel.Select(fmt.Sprintf("%d AS %s", index, updateIndexColumn)).From(tableName)
+			for _, columnName := range compared {
+				value, ok := update.Changes[columnName]
+				if !ok {
+					query = query.Column(fmt.Sprintf("FALSE AS %v", columnName))
+					continue
+				}
+				query = query.Column(fmt.Sprintf("%[1]v IS DISTINCT FROM ? AS %[1]v", columnName), value)
+			}
+			if multitenancyKeyColumnName != "" {
+				query = query.Where(squirrel.Eq{multitenancyKeyColumnName: p.multitenancyValue})
+			}
+			query = query.Where(primaryKeyWhere(tableMetadata, update.Changes))
+
+			sql, queryArgs, err := query.ToSql()
+			if err != nil {
+				return nil, err
+			}
+			selects = append(selects, sql)
+			args = append(args, queryArgs...)
+		}
+
+		sql, err := squirrel.Dollar.ReplacePlaceholders(strings.Join(selects, " UNION ALL "))
+		if err != nil {
+			return nil, err
+		}
+		rows, err := p.runner().Query(sql, args...)
+		if err != nil {
+			return nil, newQueryError(err, sql)
+		}
+
+		results, err := getQueryResults(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if index, ok := result[updateIndexColumn].(int64); ok && int(index) < len(updates) {
+				distinct[index] = result
+			}
+		}
+	}
+
+	changed := make([][]string, len(updates))
+	for index := range updates {
+		for _, columnName := range written[index] {
+			isDistinct, _ := distinct[index][columnName].(bool)
+			if isDistinct || stringutil.StringSliceContainsKey(encryptedColumns, columnName) {
+				changed[index] = append(changed[index], columnName)
+			}
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,216 @@
+package picard
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type trackedModel struct {
+	metadata.Metadata `picard:"tablename=test_tablename"`
+
+	PrimaryKeyField        string `picard:"primary_key,column=primary_key_column"`
+	TestMultitenancyColumn string `picard:"multitenancy_key,column=multitenancy_key_column"`
+	TestFieldOne           string `picard:"column=test_column_one"`
+	TestFieldTwo           string `picard:"column=test_column_two"`
+	UpdatedBy              string `picard:"column=updatedby,audit=updated_by"`
+}
+
+func TestWithChangeTracking(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	testPerformedByValue := "00000000-0000-0000-0000-000000000002"
+	testPrimaryKeyValue := "00000000-0000-0000-0000-000000000001"
+	testCases := []struct {
+		description         string
+		giveValue           interface{}
+		expectationFunction func(sqlmock.Sqlmock)
+		wantRecords         []ChangeRecord
+	}{
+		{
+			"reports the columns that changed after an update",
+			&trackedModel{
+				PrimaryKeyField: testPrimaryKeyValue,
+				TestFieldOne:    "new value one",
+				TestFieldTwo:    "same value two",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^SELECT test_tablename.primary_key_column FROM test_tablename WHERE test_tablename.primary_key_column = \$1 AND test_tablename.multitenancy_key_column = \$2$`).
+					WithArgs(testPrimaryKeyValue, testMultitenancyValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+					)
+				mock.ExpectQuery(`^SELECT 0 AS picard_update, test_column_one IS DISTINCT FROM \$1 AS test_column_one, test_column_two IS DISTINCT FROM \$2 AS test_column_two FROM test_tablename WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+					WithArgs("new value one", "same value two", testMultitenancyValue, testPrimaryKeyValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"picard_update", "test_column_one", "test_column_two"}).AddRow(int64(0), true, false),
+					)
+				mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2, updatedby = \$3 WHERE multitenancy_key_column = \$4 AND primary_key_column = \$5$`).
+					WithArgs("new value one", "same value two", testPerformedByValue, testMultitenancyValue, testPrimaryKeyValue).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			[]ChangeRecord{
+				{
+					TableName:      "test_tablename",
+					PrimaryKey:     testPrimaryKeyValue,
+					ChangedColumns: []string{"test_column_one"},
+				},
+			},
+		},
+		{
+			"does not report an update that changed nothing",
+			&trackedModel{
+				PrimaryKeyField: testPrimaryKeyValue,
+				TestFieldOne:    "same value one",
+				TestFieldTwo:    "same value two",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^SELECT test_tablename.primary_key_column FROM test_tablename WHERE test_tablename.primary_key_column = \$1 AND test_tablename.multitenancy_key_column = \$2$`).
+					WithArgs(testPrimaryKeyValue, testMultitenancyValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+					)
+				mock.ExpectQuery(`^SELECT 0 AS picard_update, test_column_one IS DISTINCT FROM \$1 AS test_column_one, test_column_two IS DISTINCT FROM \$2 AS test_column_two FROM test_tablename WHERE multitenancy_key_column = \$3 AND primary_key_column = \$4$`).
+					WithArgs("same value one", "same value two", testMultitenancyValue, testPrimaryKeyValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"picard_update", "test_column_one", "test_column_two"}).AddRow(int64(0), false, false),
+					)
+				mock.ExpectExec(`^UPDATE test_tablename SET test_column_one = \$1, test_column_two = \$2, updatedby = \$3 WHERE multitenancy_key_column = \$4 AND primary_key_column = \$5$`).
+					WithArgs("same value one", "same value two", testPerformedByValue, testMultitenancyValue, testPrimaryKeyValue).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			nil,
+		},
+		{
+			"does not report inserts",
+			&trackedModel{
+				TestFieldOne: "new value one",
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^INSERT INTO test_tablename \(multitenancy_key_column,test_column_one,test_column_two,updatedby\) VALUES \(\$1,\$2,\$3,\$4\) RETURNING "primary_key_column"$`).
+					WithArgs(testMultitenancyValue, "new value one", "", testPerformedByValue).
+					WillReturnRows(
+						sqlmock.NewRows([]string{"primary_key_column"}).AddRow(testPrimaryKeyValue),
+					)
+				mock.ExpectCommit()
+			},
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+			tc.expectationFunction(mock)
+
+			var records []ChangeRecord
+			p := PersistenceORM{
+				multitenancyValue: testMultitenancyValue,
+				performedBy:       testPerformedByValue,
+			}
+
+			err = p.WithChangeTracking(func(record ChangeRecord) {
+				records = append(records, record)
+			}).SaveModel(tc.giveValue)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantRecords, records)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDeployWithChangeTracking(t *testing.T) {
+	testMultitenancyValue := "00000000-0000-0000-0000-000000000005"
+	testPerformedByValue := "00000000-0000-0000-0000-000000000002"
+	firstID := "00000000-0000-0000-0000-000000000001"
+	secondID := "00000000-0000-0000-0000-000000000003"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT test_tablename.primary_key_column, test_tablename.primary_key_column as test_tablename_primary_key_column
+		FROM test_tablename
+		WHERE COALESCE(test_tablename.primary_key_column::"varchar",'') = ANY($1) AND test_tablename.multitenancy_key_column = $2
+	`)).
+		WithArgs(pq.Array([]string{firstID, secondID}), testMultitenancyValue).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"primary_key_column", "test_tablename_primary_key_column"}).
+				AddRow(firstID, firstID).
+				AddRow(secondID, secondID),
+		)
+	// Both rows are compared in one query before either is updated
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT 0 AS picard_update,
+			test_column_one IS DISTINCT FROM $1 AS test_column_one,
+			test_column_two IS DISTINCT FROM $2 AS test_column_two
+		FROM test_tablename WHERE multitenancy_key_column = $3 AND primary_key_column = $4
+		UNION ALL
+		SELECT 1 AS picard_update,
+			test_column_one IS DISTINCT FROM $5 AS test_column_one,
+			test_column_two IS DISTINCT FROM $6 AS test_column_two
+		FROM test_tablename WHERE multitenancy_key_column = $7 AND primary_key_column = $8
+	`)).
+		WithArgs(
+			"new value one", "same value two", testMultitenancyValue, firstID,
+			"same value one", "same value two", testMultitenancyValue, secondID,
+		).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"picard_update", "test_column_one", "test_column_two"}).
+				AddRow(int64(0), true, false).
+				AddRow(int64(1), false, false),
+		)
+	updateSQL := testdata.FmtSQLRegex(`
+		UPDATE test_tablename SET test_column_one = $1, test_column_two = $2, updatedby = $3
+		WHERE multitenancy_key_column = $4 AND primary_key_column = $5
+	`)
+	mock.ExpectExec(updateSQL).
+		WithArgs("new value one", "same value two", testPerformedByValue, testMultitenancyValue, firstID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateSQL).
+		WithArgs("same value one", "same value two", testPerformedByValue, testMultitenancyValue, secondID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var records []ChangeRecord
+	p := PersistenceORM{
+		multitenancyValue: testMultitenancyValue,
+		performedBy:       testPerformedByValue,
+	}
+
+	err = p.WithChangeTracking(func(record ChangeRecord) {
+		records = append(records, record)
+	}).Deploy([]trackedModel{
+		{PrimaryKeyField: firstID, TestFieldOne: "new value one", TestFieldTwo: "same value two"},
+		{PrimaryKeyField: secondID, TestFieldOne: "same value one", TestFieldTwo: "same value two"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ChangeRecord{
+		{TableName: "test_tablename", PrimaryKey: firstID, ChangedColumns: []string{"test_column_one"}},
+	}, records)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
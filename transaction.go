@@ -0,0 +1,120 @@
+package picard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultTransactionRetries is how many times RunInTransaction retries a failed transaction, unless the
+// TxOptions set MaxRetries
+const DefaultTransactionRetries = 3
+
+// DefaultTransactionBackoff is how long RunInTransaction waits before its first retry, unless the TxOptions set
+// Backoff. The wait doubles with every retry.
+const DefaultTransactionBackoff = 50 * time.Millisecond
+
+// Postgres error codes for transactions that can succeed if they are run again
+const (
+	serializationFailureCode pq.ErrorCode = "40001"
+	deadlockDetectedCode     pq.ErrorCode = "40P01"
+)
+
+// TxOptions configures the transactions started by RunInTransaction
+type TxOptions struct {
+	// Isolation is the isolation level of the transaction. sql.LevelDefault uses the database's default.
+	Isolation sql.IsolationLevel
+	// ReadOnly makes the transaction read-only
+	ReadOnly bool
+	// MaxRetries is how many times to retry after a serialization failure or deadlock. Zero uses
+	// DefaultTransactionRetries, and a negative number never retries.
+	MaxRetries int
+	// Backoff is the wait before the first retry, doubling with every retry. Zero uses DefaultTransactionBackoff.
+	Backoff time.Duration
+}
+
+/*
+RunInTransaction begins a transaction, passes fn an ORM that runs in it, and commits the transaction if fn returns
+nil, or rolls it back if it returns an error. When the transaction fails with a serialization failure or a
+deadlock, which Postgres reports for transactions that may succeed if they are run again, the transaction is
+rolled back and fn is run again in a new one, waiting longer before each retry. Any other error is returned
+without retrying.
+
+fn may run more than once, so it should not have effects outside of the transaction. Callbacks registered
+with AfterCommit on the ORM passed to fn only run once the transaction commits. Writes through that ORM use the
+transaction, and reads do when it is their Runner, which StartTransaction returns.
+
+Example:
+
+	err := p.RunInTransaction(func(tx picard.ORM) error {
+		runner, err := tx.StartTransaction()
+		if err != nil {
+			return err
+		}
+		results, err := tx.FilterModel(picard.FilterRequest{
+			FilterModel: TableA{Name: "counter"},
+			Runner:      runner,
+			ForUpdate:   true,
+		})
+		if err != nil {
+			return err
+		}
+		counter := results[0].(TableA)
+		counter.Count++
+		return tx.SaveModel(&counter)
+	}, picard.TxOptions{Isolation: sql.LevelSerializable})
+*/
+func (p PersistenceORM) RunInTransaction(fn func(ORM) error, opts TxOptions) error {
+	if p.transaction != nil {
+		return errors.New("a transaction has already been started")
+	}
+
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultTransactionRetries
+	}
+	backoff := opts.Backoff
+	if backoff == 0 {
+		backoff = DefaultTransactionBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := p.runTransactionOnce(fn, opts)
+		if err == nil || attempt >= maxRetries || !isRetryableTransactionError(err) {
+			return err
+		}
+		time.Sleep(backoff << uint(attempt))
+	}
+}
+
+// runTransactionOnce runs fn in a new transaction, and commits or rolls it back
+func (p PersistenceORM) runTransactionOnce(fn func(ORM) error, opts TxOptions) error {
+	tx, err := GetConnection().BeginTx(context.Background(), &sql.TxOptions{
+		Isolation: opts.Isolation,
+		ReadOnly:  opts.ReadOnly,
+	})
+	if err != nil {
+		return err
+	}
+	p.transaction = tx
+	p.afterCommit = &[]func(){}
+
+	if err := fn(&p); err != nil {
+		// fn may have ended the transaction already, so a failed rollback isn't reported
+		p.Rollback()
+		return err
+	}
+	return p.Commit()
+}
+
+// isRetryableTransactionError reports whether a transaction failed in a way that running it again may fix
+func isRetryableTransactionError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == serializationFailureCode || pqErr.Code == deadlockDetectedCode
+}
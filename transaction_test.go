@@ -0,0 +1,185 @@
+package picard
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunInTransaction(t *testing.T) {
+	serializationErr := &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	update := `^UPDATE counter SET count = count \+ 1$`
+
+	testCases := []struct {
+		description         string
+		giveOptions         TxOptions
+		expectationFunction func(sqlmock.Sqlmock)
+		wantAttempts        int
+		wantErr             error
+	}{
+		{
+			"should commit a transaction that succeeds",
+			TxOptions{Isolation: sql.LevelSerializable},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			1,
+			nil,
+		},
+		{
+			"should retry after a serialization failure",
+			TxOptions{Isolation: sql.LevelSerializable, Backoff: time.Nanosecond},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).WillReturnError(serializationErr)
+				mock.ExpectRollback()
+				mock.ExpectBegin()
+				mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			2,
+			nil,
+		},
+		{
+			"should retry when the commit fails to serialize",
+			TxOptions{Isolation: sql.LevelSerializable, Backoff: time.Nanosecond},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit().WillReturnError(serializationErr)
+				mock.ExpectBegin()
+				mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			2,
+			nil,
+		},
+		{
+			"should return the error once the retries run out",
+			TxOptions{Isolation: sql.LevelSerializable, MaxRetries: 1, Backoff: time.Nanosecond},
+			func(mock sqlmock.Sqlmock) {
+				for i := 0; i < 2; i++ {
+					mock.ExpectBegin()
+					mock.ExpectExec(update).WillReturnError(serializationErr)
+					mock.ExpectRollback()
+				}
+			},
+			2,
+			serializationErr,
+		},
+		{
+			"should not retry other errors",
+			TxOptions{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).WillReturnError(errors.New("some error"))
+				mock.ExpectRollback()
+			},
+			1,
+			errors.New("some error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			attempts := 0
+			err = New(sampleOrgID, sampleUserID).RunInTransaction(func(orm ORM) error {
+				attempts++
+				tx, err := orm.StartTransaction()
+				if err != nil {
+					return err
+				}
+				_, err = tx.Exec("UPDATE counter SET count = count + 1")
+				return err
+			}, tc.giveOptions)
+
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantAttempts, attempts)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+// txOptionsConn is a driver connection that records the options of the transactions begun on it
+type txOptionsConn struct {
+	began []driver.TxOptions
+}
+
+func (c *txOptionsConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *txOptionsConn) Driver() driver.Driver                        { return nil }
+func (c *txOptionsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *txOptionsConn) Close() error              { return nil }
+func (c *txOptionsConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *txOptionsConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.began = append(c.began, opts)
+	return c, nil
+}
+func (c *txOptionsConn) Commit() error   { return nil }
+func (c *txOptionsConn) Rollback() error { return nil }
+
+func TestTransactionOptions(t *testing.T) {
+	testCases := []struct {
+		description string
+		begin       func(*PersistenceORM) error
+		wantOptions driver.TxOptions
+	}{
+		{
+			"begins RunInTransaction transactions with their options",
+			func(p *PersistenceORM) error {
+				return p.RunInTransaction(func(ORM) error { return nil }, TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+			},
+			driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true},
+		},
+		{
+			"begins snapshot transactions as read only repeatable read",
+			func(p *PersistenceORM) error {
+				if _, err := p.StartSnapshotTransaction(); err != nil {
+					return err
+				}
+				return p.Commit()
+			},
+			driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelRepeatableRead), ReadOnly: true},
+		},
+		{
+			"begins dry runs as read only",
+			func(p *PersistenceORM) error {
+				_, err := p.DryRun([]Item{})
+				return err
+			},
+			driver.TxOptions{ReadOnly: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			recorder := &txOptionsConn{}
+			conn = sql.OpenDB(recorder)
+
+			p := New(sampleOrgID, sampleUserID).(*PersistenceORM)
+			assert.NoError(t, tc.begin(p))
+			assert.Equal(t, []driver.TxOptions{tc.wantOptions}, recorder.began)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateModel(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+
+	testCases := []struct {
+		description         string
+		giveModel           interface{}
+		giveMultitenancy    string
+		giveOptions         TruncateOptions
+		expectationFunction func(sqlmock.Sqlmock)
+		wantErr             string
+	}{
+		{
+			"truncates the table",
+			testdata.ToyModel{},
+			"",
+			TruncateOptions{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^TRUNCATE TABLE toymodel$`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"restarts identity and cascades",
+			&testdata.ToyModel{},
+			"",
+			TruncateOptions{RestartIdentity: true, Cascade: true},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^TRUNCATE TABLE toymodel RESTART IDENTITY CASCADE$`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"errors for an ORM scoped to a tenant",
+			testdata.ToyModel{},
+			orgID,
+			TruncateOptions{Cascade: true},
+			func(mock sqlmock.Sqlmock) {},
+			"cannot truncate 'toymodel' from an ORM scoped to tenant '00000000-0000-0000-0000-000000000001'",
+		},
+		{
+			"truncates from an ORM scoped to a tenant when all tenants are allowed",
+			testdata.ToyModel{},
+			orgID,
+			TruncateOptions{AllTenants: true},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^TRUNCATE TABLE toymodel$`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"errors for a materialized view",
+			viewModel{},
+			"",
+			TruncateOptions{},
+			func(mock sqlmock.Sqlmock) {},
+			"cannot write to materialized view 'test_view'",
+		},
+		{
+			"returns query errors",
+			testdata.ToyModel{},
+			"",
+			TruncateOptions{},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^TRUNCATE TABLE toymodel$`).
+					WillReturnError(errors.New("some error"))
+				mock.ExpectRollback()
+			},
+			"some error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: tc.giveMultitenancy,
+			}
+
+			err = p.WithTruncateOptions(tc.giveOptions).TruncateModel(tc.giveModel)
+
+			if tc.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
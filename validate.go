@@ -0,0 +1,185 @@
+package picard
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/skuid/picard/reflectutil"
+	"github.com/skuid/picard/tags"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+/*
+ValidateDeploy runs the checks that don't need the database against a deploy payload, and returns every
+problem it finds as a DeployValidationError, so they can all be reported before anything is deployed. The
+error is only set when the payload can't be deployed at all, like when it isn't a slice of picard structs.
+
+The models and their children are checked for:
+  - validate tags, on models that don't set their primary key. Deploy only validates models it inserts, so a
+    model that will update an existing row through its lookups may be reported when Deploy would accept it.
+  - required foreign keys with neither a key value nor a related model to look it up with.
+  - primary keys shared by more than one model, when the ORM's DuplicatePrimaryKeyPolicy is DuplicatePrimaryKeyError.
+  - children whose foreign key is set to a different key than their parent's primary key.
+
+Models marked with a delete_flag field are only checked for duplicate primary keys, since Deploy deletes them
+without writing their fields or children.
+
+Example:
+
+	problems, err := p.ValidateDeploy(models)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+*/
+func (p PersistenceORM) ValidateDeploy(data interface{}) ([]error, error) {
+	tableMetadata, err := tags.GetTableMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	dataValue := reflect.ValueOf(data)
+	if dataValue.Kind() != reflect.Slice {
+		return nil, errors.New("deploy data must be a slice of structs")
+	}
+	if err := checkWritable(tableMetadata); err != nil {
+		return nil, err
+	}
+
+	models := make([]reflect.Value, 0, dataValue.Len())
+	paths := make([]string, 0, dataValue.Len())
+	for i := 0; i < dataValue.Len(); i++ {
+		models = append(models, dataValue.Index(i))
+		paths = append(paths, fmt.Sprintf("[%d]", i))
+	}
+	return p.validateModels(models, paths, tableMetadata, nil), nil
+}
+
+// validateModels checks a set of models of one table, and their children. Children are passed the parent's
+// primary key and the child's foreign key, which Deploy fills in, so they aren't reported as missing.
+func (p PersistenceORM) validateModels(models []reflect.Value, paths []string, tableMetadata *tags.TableMetadata, parent *childParent) []error {
+	problems := []error{}
+	newProblem := func(path string, err error) {
+		problems = append(problems, &DeployValidationError{
+			Err:   err,
+			Table: tableMetadata.GetTableName(),
+			Path:  path,
+		})
+	}
+
+	primaryKeyFieldName := tableMetadata.GetPrimaryKeyFieldName()
+	seenKeys := map[string]bool{}
+
+	for i, model := range models {
+		path := paths[i]
+
+		key := ""
+		if primaryKeyFieldName != "" {
+			key = getObjectProperty(model, primaryKeyFieldName)
+		}
+		if key != "" && p.duplicatePolicy == DuplicatePrimaryKeyError {
+			if seenKeys[key] {
+				newProblem(path, fmt.Errorf("duplicate primary key '%s'", key))
+			}
+			seenKeys[key] = true
+		}
+
+		if isMarkedForDelete(model, tableMetadata) {
+			continue
+		}
+
+		if key == "" {
+			for _, err := range validateModelTags(model) {
+				newProblem(path, err)
+			}
+		}
+
+		for _, foreignKey := range tableMetadata.GetForeignKeys() {
+			if parent != nil && foreignKey.FieldName == parent.foreignKey {
+				continue
+			}
+			if foreignKey.Required &&
+				formatKeyValue(model.FieldByName(foreignKey.FieldName)) == "" &&
+				reflectutil.IsZeroValue(model.FieldByName(foreignKey.RelatedFieldName)) {
+				newProblem(path, fmt.Errorf("missing required foreign key '%s'", foreignKey.FieldName))
+			}
+		}
+
+		if parent != nil && parent.key != "" {
+			childKey := getObjectProperty(model, parent.foreignKey)
+			if childKey != "" && childKey != parent.key {
+				newProblem(path, fmt.Errorf(
+					"foreign key '%s' is '%s', but the parent's primary key is '%s'",
+					parent.foreignKey, childKey, parent.key,
+				))
+			}
+		}
+
+		for _, child := range tableMetadata.GetChildren() {
+			if child.Junction != nil {
+				continue
+			}
+			children, childPaths := childModels(model.FieldByName(child.FieldName), path+"."+child.FieldName)
+			if len(children) == 0 {
+				continue
+			}
+			childMetadata := tags.TableMetadataFromType(children[0].Type())
+			var childParentKey *childParent
+			if child.ForeignKey != "" {
+				childParentKey = &childParent{foreignKey: child.ForeignKey, key: key}
+			}
+			problems = append(problems, p.validateModels(children, childPaths, childMetadata, childParentKey)...)
+		}
+	}
+	return problems
+}
+
+// childParent describes the parent of the children being validated
+type childParent struct {
+	foreignKey string
+	key        string
+}
+
+// validateModelTags returns an error for each field that fails its validate tag
+func validateModelTags(model reflect.Value) []error {
+	err := validator.New().Struct(model.Interface())
+	if err == nil {
+		return nil
+	}
+	fieldErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []error{err}
+	}
+	errs := []error{}
+	for _, fieldError := range fieldErrors {
+		errs = append(errs, fmt.Errorf("field '%s' failed the '%s' validation", fieldError.Field(), fieldError.Tag()))
+	}
+	return errs
+}
+
+// childModels returns the models held by a child slice or map, with their paths. Map children are sorted by
+// key so problems are reported in a stable order.
+func childModels(field reflect.Value, path string) ([]reflect.Value, []string) {
+	models := []reflect.Value{}
+	paths := []string{}
+	switch field.Kind() {
+	case reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			models = append(models, field.Index(i))
+			paths = append(paths, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		keys := field.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			models = append(models, field.MapIndex(key))
+			paths = append(paths, fmt.Sprintf("%s[%v]", path, key.Interface()))
+		}
+	}
+	return models, paths
+}
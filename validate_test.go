@@ -0,0 +1,111 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeploy(t *testing.T) {
+	parentID := "00000000-0000-0000-0000-000000000002"
+	otherParentID := "00000000-0000-0000-0000-000000000003"
+
+	testCases := []struct {
+		description  string
+		givePolicy   DuplicatePrimaryKeyPolicy
+		giveData     interface{}
+		wantProblems []error
+		wantErr      string
+	}{
+		{
+			"should return every structural problem in the payload",
+			DuplicatePrimaryKeyError,
+			[]testdata.TestObject{
+				{},
+				{
+					ID:   parentID,
+					Name: "apple",
+					Children: []testdata.ChildTestObject{
+						{Name: "celery"},
+						{Name: "carrot", ParentID: otherParentID},
+					},
+				},
+				{ID: parentID, Name: "orange"},
+			},
+			[]error{
+				&DeployValidationError{
+					Err:   errors.New("field 'Name' failed the 'required' validation"),
+					Table: "testobject",
+					Path:  "[0]",
+				},
+				&DeployValidationError{
+					Err: errors.New(
+						"foreign key 'ParentID' is '00000000-0000-0000-0000-000000000003', " +
+							"but the parent's primary key is '00000000-0000-0000-0000-000000000002'",
+					),
+					Table: "childtest",
+					Path:  "[1].Children[1]",
+				},
+				&DeployValidationError{
+					Err:   errors.New("duplicate primary key '00000000-0000-0000-0000-000000000002'"),
+					Table: "testobject",
+					Path:  "[2]",
+				},
+			},
+			"",
+		},
+		{
+			"should not report duplicates the policy allows",
+			DuplicatePrimaryKeyMerge,
+			[]testdata.TestObject{
+				{ID: parentID, Name: "apple"},
+				{ID: parentID, Name: "orange"},
+			},
+			[]error{},
+			"",
+		},
+		{
+			"should report required foreign keys without a key or related model",
+			DuplicatePrimaryKeyError,
+			[]testdata.ChildTestObject{
+				{Name: "celery"},
+				{Name: "carrot", ParentID: parentID},
+				{Name: "zucchini", Parent: testdata.TestObject{Name: "apple"}},
+			},
+			[]error{
+				&DeployValidationError{
+					Err:   errors.New("missing required foreign key 'ParentID'"),
+					Table: "childtest",
+					Path:  "[0]",
+				},
+			},
+			"",
+		},
+		{
+			"should return an error for a payload that isn't a slice",
+			DuplicatePrimaryKeyError,
+			testdata.TestObject{},
+			nil,
+			"deploy data must be a slice of structs",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := PersistenceORM{
+				multitenancyValue: "00000000-0000-0000-0000-000000000001",
+				performedBy:       sampleUserID,
+				duplicatePolicy:   tc.givePolicy,
+			}
+			problems, err := p.ValidateDeploy(tc.giveData)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantProblems, problems)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package picard
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/jsonb"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type notificationPayload interface {
+	Recipient() string
+}
+
+type emailPayload struct {
+	Address string `json:"address"`
+	Subject string `json:"subject"`
+}
+
+func (p emailPayload) Recipient() string { return p.Address }
+
+type smsPayload struct {
+	Number string `json:"number"`
+}
+
+func (p *smsPayload) Recipient() string { return p.Number }
+
+type notificationModel struct {
+	Metadata metadata.Metadata `picard:"tablename=notification"`
+
+	ID             string              `picard:"primary_key,column=id"`
+	OrganizationID string              `picard:"multitenancy_key,column=organization_id"`
+	Payload        notificationPayload `picard:"jsonb,discriminator=Type,column=data"`
+	Type           string              `picard:"column=type"`
+}
+
+func TestFilterModelVariants(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	emailID := "00000000-0000-0000-0000-000000000002"
+	smsID := "00000000-0000-0000-0000-000000000003"
+
+	payloadType := reflect.TypeOf((*notificationPayload)(nil)).Elem()
+	jsonb.RegisterVariant(payloadType, "email", emailPayload{})
+	jsonb.RegisterVariant(payloadType, "sms", &smsPayload{})
+
+	expectSelect := func(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+		mock.ExpectQuery(testdata.FmtSQLRegex(`
+			SELECT
+				t0.id AS "t0.id",
+				t0.organization_id AS "t0.organization_id",
+				t0.data AS "t0.data",
+				t0.type AS "t0.type"
+			FROM notification AS t0
+			WHERE t0.organization_id = $1
+		`)).
+			WithArgs(orgID).
+			WillReturnRows(rows)
+	}
+	columns := []string{"t0.id", "t0.organization_id", "t0.data", "t0.type"}
+
+	testCases := []struct {
+		description         string
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []interface{}
+		wantErr             string
+	}{
+		{
+			"scans each row into the variant registered for its discriminator",
+			func(mock sqlmock.Sqlmock) {
+				expectSelect(mock, sqlmock.NewRows(columns).
+					AddRow(emailID, orgID, []byte(`{"address":"pops@example.com","subject":"Hi"}`), "email").
+					AddRow(smsID, orgID, []byte(`{"number":"555-0100"}`), "sms"),
+				)
+			},
+			[]interface{}{
+				notificationModel{
+					ID:             emailID,
+					OrganizationID: orgID,
+					Payload:        emailPayload{Address: "pops@example.com", Subject: "Hi"},
+					Type:           "email",
+				},
+				notificationModel{
+					ID:             smsID,
+					OrganizationID: orgID,
+					Payload:        &smsPayload{Number: "555-0100"},
+					Type:           "sms",
+				},
+			},
+			"",
+		},
+		{
+			"leaves the field nil for a NULL column",
+			func(mock sqlmock.Sqlmock) {
+				expectSelect(mock, sqlmock.NewRows(columns).AddRow(emailID, orgID, nil, "email"))
+			},
+			[]interface{}{
+				notificationModel{
+					ID:             emailID,
+					OrganizationID: orgID,
+					Type:           "email",
+				},
+			},
+			"",
+		},
+		{
+			"errors for a discriminator without a variant",
+			func(mock sqlmock.Sqlmock) {
+				expectSelect(mock, sqlmock.NewRows(columns).AddRow(emailID, orgID, []byte(`{}`), "fax"))
+			},
+			nil,
+			"no jsonb variant of picard.notificationPayload registered for discriminator 'fax' of field 'Payload'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: orgID,
+			}
+
+			results, err := p.FilterModel(FilterRequest{FilterModel: notificationModel{}})
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantResults, results)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
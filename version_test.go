@@ -0,0 +1,149 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/skuid/picard/dbchange"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type versionedModel struct {
+	Metadata metadata.Metadata `picard:"tablename=versioned"`
+
+	ID             string `picard:"primary_key,column=id"`
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"lookup,column=name"`
+	Title          string `picard:"column=title"`
+	Version        int    `picard:"version,column=version"`
+}
+
+func TestVersionConflicts(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	currentID := "00000000-0000-0000-0000-000000000002"
+	staleID := "00000000-0000-0000-0000-000000000003"
+	lookupSQL := testdata.FmtSQLRegex(`
+		SELECT versioned.id, versioned.name as versioned_name
+		FROM versioned
+		WHERE COALESCE(versioned.name::"varchar",'') = ANY($1) AND versioned.organization_id = $2
+	`)
+	updateSQL := testdata.FmtSQLRegex(`
+		UPDATE versioned SET name = $1, title = $2, version = version + 1
+		WHERE organization_id = $3 AND id = $4 AND version = $5
+		RETURNING "version"
+	`)
+	expectDeploy := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lookupSQL).
+			WithArgs(pq.Array([]string{"current", "stale"}), orgID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "versioned_name"}).
+					AddRow(currentID, "current").
+					AddRow(staleID, "stale"),
+			)
+		mock.ExpectQuery(updateSQL).
+			WithArgs("current", "Current", orgID, currentID, 4).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+		// The row was saved again since the stale model read version 2
+		mock.ExpectQuery(updateSQL).
+			WithArgs("stale", "Stale", orgID, staleID, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	}
+
+	testCases := []struct {
+		description         string
+		runFunction         func(ORM, []versionedModel) ([]DeployResult, error)
+		expectationFunction func(sqlmock.Sqlmock)
+		wantResults         []DeployResult
+		wantVersions        []int
+		wantErr             string
+	}{
+		{
+			"reports a stale update as a conflict in its deploy result",
+			func(p ORM, models []versionedModel) ([]DeployResult, error) {
+				return p.DeployWithResults(models)
+			},
+			func(mock sqlmock.Sqlmock) {
+				expectDeploy(mock)
+				mock.ExpectCommit()
+			},
+			[]DeployResult{
+				{Key: "current", PrimaryKey: currentID, Type: dbchange.Update},
+				{Key: "stale", PrimaryKey: staleID, Type: dbchange.Update, Conflict: true},
+			},
+			[]int{5, 2},
+			"",
+		},
+		{
+			"fails a deploy without results on a stale update",
+			func(p ORM, models []versionedModel) ([]DeployResult, error) {
+				return nil, p.Deploy(models)
+			},
+			func(mock sqlmock.Sqlmock) {
+				expectDeploy(mock)
+				mock.ExpectRollback()
+			},
+			nil,
+			[]int{5, 2},
+			"Version Conflict: Table 'versioned', Primary Keys '" + staleID + "'",
+		},
+		{
+			"fails saving a stale model",
+			func(p ORM, models []versionedModel) ([]DeployResult, error) {
+				model := versionedModel{ID: staleID, Name: "stale", Title: "Stale", Version: 2}
+				err := p.SaveModel(&model)
+				return nil, err
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(testdata.FmtSQLRegex(`
+					SELECT versioned.id FROM versioned WHERE versioned.id = $1 AND versioned.organization_id = $2
+				`)).
+					WithArgs(staleID, orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(staleID))
+				mock.ExpectQuery(updateSQL).
+					WithArgs("stale", "Stale", orgID, staleID, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectRollback()
+			},
+			nil,
+			[]int{4, 2},
+			"Version Conflict: Table 'versioned', Primary Keys '" + staleID + "'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			models := []versionedModel{
+				{Name: "current", Title: "Current", Version: 4},
+				{Name: "stale", Title: "Stale", Version: 2},
+			}
+			results, err := tc.runFunction(New(orgID, sampleUserID), models)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				var conflictErr *VersionConflictError
+				assert.True(t, errors.As(err, &conflictErr))
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantResults, results)
+			assert.Equal(t, tc.wantVersions, []int{models[0].Version, models[1].Version})
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
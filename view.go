@@ -0,0 +1,53 @@
+package picard
+
+import (
+	"fmt"
+
+	"github.com/skuid/picard/stringutil"
+	"github.com/skuid/picard/tags"
+)
+
+// RefreshMaterializedView refreshes the materialized view that the model maps to. The model's
+// metadata field must include the `materialized_view` tag. When concurrently is true, the view is
+// refreshed without locking out reads, which requires a unique index on the view.
+func (p PersistenceORM) RefreshMaterializedView(model interface{}, concurrently bool) error {
+	val, err := stringutil.GetStructValue(model)
+	if err != nil {
+		return err
+	}
+
+	tableMetadata := tags.TableMetadataFromType(val.Type())
+	if !tableMetadata.IsMaterializedView() {
+		return fmt.Errorf("type '%v' is not tagged as a 'materialized_view'", val.Type())
+	}
+
+	statement := "REFRESH MATERIALIZED VIEW "
+	if concurrently {
+		statement += "CONCURRENTLY "
+	}
+	statement += tableMetadata.GetTableName()
+
+	if p.transaction == nil {
+		tx, err := GetConnection().Begin()
+		if err != nil {
+			return err
+		}
+		p.transaction = tx
+		defer p.Commit()
+	}
+
+	if _, err := p.transaction.Exec(statement); err != nil {
+		p.Rollback()
+		return newQueryError(err, statement)
+	}
+
+	return nil
+}
+
+// checkWritable returns an error for tables that picard can only read from
+func checkWritable(tableMetadata *tags.TableMetadata) error {
+	if tableMetadata.IsMaterializedView() {
+		return fmt.Errorf("cannot write to materialized view '%s'", tableMetadata.GetTableName())
+	}
+	return nil
+}
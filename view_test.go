@@ -0,0 +1,178 @@
+package picard
+
+import (
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/skuid/picard/metadata"
+	"github.com/skuid/picard/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+type viewModel struct {
+	metadata.Metadata `picard:"tablename=test_view,materialized_view"`
+
+	OrganizationID string `picard:"multitenancy_key,column=organization_id"`
+	Name           string `picard:"column=name"`
+	Total          int    `picard:"column=total"`
+}
+
+func TestRefreshMaterializedView(t *testing.T) {
+	testCases := []struct {
+		description         string
+		giveModel           interface{}
+		giveConcurrently    bool
+		expectationFunction func(sqlmock.Sqlmock)
+		wantErr             string
+	}{
+		{
+			"refreshes the view",
+			viewModel{},
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^REFRESH MATERIALIZED VIEW test_view$`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"refreshes the view concurrently",
+			&viewModel{},
+			true,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^REFRESH MATERIALIZED VIEW CONCURRENTLY test_view$`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			"",
+		},
+		{
+			"rolls back when the refresh fails",
+			viewModel{},
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^REFRESH MATERIALIZED VIEW test_view$`).
+					WillReturnError(errors.New("some error"))
+				mock.ExpectRollback()
+			},
+			"some error",
+		},
+		{
+			"errors for a model that is not a materialized view",
+			testdata.ToyModel{},
+			false,
+			func(mock sqlmock.Sqlmock) {},
+			"type 'testdata.ToyModel' is not tagged as a 'materialized_view'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = db
+
+			tc.expectationFunction(mock)
+
+			p := PersistenceORM{
+				multitenancyValue: "00000000-0000-0000-0000-000000000001",
+			}
+
+			err = p.RefreshMaterializedView(tc.giveModel, tc.giveConcurrently)
+
+			if tc.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet sqlmock expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestFilterMaterializedView(t *testing.T) {
+	orgID := "00000000-0000-0000-0000-000000000001"
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	mock.ExpectQuery(testdata.FmtSQLRegex(`
+		SELECT
+			t0.organization_id AS "t0.organization_id",
+			t0.name AS "t0.name",
+			t0.total AS "t0.total"
+		FROM test_view AS t0
+		WHERE t0.organization_id = $1 AND t0.name = $2
+	`)).
+		WithArgs(orgID, "totals").
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"t0.organization_id",
+				"t0.name",
+				"t0.total",
+			}).
+				AddRow(orgID, "totals", 12),
+		)
+
+	p := PersistenceORM{
+		multitenancyValue: orgID,
+	}
+
+	results, err := p.FilterModel(FilterRequest{
+		FilterModel: viewModel{
+			Name: "totals",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		viewModel{
+			OrganizationID: orgID,
+			Name:           "totals",
+			Total:          12,
+		},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}
+
+func TestWriteMaterializedView(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = db
+
+	p := PersistenceORM{
+		multitenancyValue: "00000000-0000-0000-0000-000000000001",
+	}
+
+	wantErr := "cannot write to materialized view 'test_view'"
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	assert.EqualError(t, p.SaveModel(&viewModel{Name: "totals"}), wantErr)
+	assert.EqualError(t, p.Deploy([]viewModel{{Name: "totals"}}), wantErr)
+	_, err = p.DeleteModel(viewModel{Name: "totals"})
+	assert.EqualError(t, err, wantErr)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet sqlmock expectations: %s", err)
+	}
+}